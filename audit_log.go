@@ -0,0 +1,53 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/aws/amazon-eks-pod-identity-webhook/pkg/auditlog"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+// newAuditLogWriter returns the writer of the audit log file, or of the
+// CloudWatch Logs log group, whose client uses the credentials of the
+// webhook, e.g. given with IAM roles for service accounts. The log stream
+// defaults to the hostname, so that every replica writes its own.
+func newAuditLogWriter(file, logGroup, logStream, region string) (auditlog.Writer, error) {
+	if file != "" {
+		return auditlog.NewFileWriter(file)
+	}
+
+	if logStream == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("error getting hostname for the log stream: %v", err)
+		}
+		logStream = hostname
+	}
+	config := aws.NewConfig()
+	if region != "" {
+		config = config.WithRegion(region)
+	}
+	sess, err := session.NewSession(config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating session: %v", err)
+	}
+	return auditlog.NewCloudWatchWriter(cloudwatchlogs.New(sess), logGroup, logStream)
+}
@@ -0,0 +1,99 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"k8s.io/klog/v2"
+)
+
+// accountIdentity is the account the role ARNs are composed in
+type accountIdentity struct {
+	AccountID string
+	Partition string
+	Region    string
+}
+
+// lookupAccountIdentity returns the account, partition and region of the
+// webhook, read from the instance identity document with IMDSv2, or from the
+// STS GetCallerIdentity of the credentials of the webhook when the instance
+// metadata is not reachable, e.g. from pods on nodes with a hop limit of 1.
+// The region defaults to the one given, if any.
+func lookupAccountIdentity(region string) (*accountIdentity, error) {
+	config := aws.NewConfig()
+	if region != "" {
+		config = config.WithRegion(region)
+	}
+	sess, err := session.NewSession(config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating session: %v", err)
+	}
+
+	// Without fallback, the client fails fast instead of retrying with IMDSv1
+	// when the IMDSv2 token can not be fetched
+	metadataClient := ec2metadata.New(sess, aws.NewConfig().WithEC2MetadataEnableFallback(false))
+	document, metadataErr := metadataClient.GetInstanceIdentityDocument()
+	if metadataErr == nil {
+		return &accountIdentity{
+			AccountID: document.AccountID,
+			Partition: partitionForRegion(document.Region),
+			Region:    document.Region,
+		}, nil
+	}
+	klog.Warningf("Error getting instance identity document, falling back to STS GetCallerIdentity: %v", metadataErr)
+
+	stsConfig := aws.NewConfig()
+	if aws.StringValue(sess.Config.Region) == "" {
+		// The global endpoint of the aws partition
+		stsConfig = stsConfig.WithRegion("us-east-1")
+	}
+	output, err := sts.New(sess, stsConfig).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		return nil, fmt.Errorf("error getting instance identity document: %v, and caller identity: %v", metadataErr, err)
+	}
+	callerARN, err := arn.Parse(aws.StringValue(output.Arn))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing caller identity ARN: %v", err)
+	}
+	return &accountIdentity{
+		AccountID: aws.StringValue(output.Account),
+		Partition: callerARN.Partition,
+		Region:    aws.StringValue(sess.Config.Region),
+	}, nil
+}
+
+// partitionForRegion returns the partition of the AWS region
+func partitionForRegion(region string) string {
+	switch {
+	case strings.HasPrefix(region, "cn-"):
+		return "aws-cn"
+	case strings.HasPrefix(region, "us-gov-"):
+		return "aws-us-gov"
+	case strings.HasPrefix(region, "us-iso-"):
+		return "aws-iso"
+	case strings.HasPrefix(region, "us-isob-"):
+		return "aws-iso-b"
+	default:
+		return "aws"
+	}
+}
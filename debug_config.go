@@ -0,0 +1,123 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	configfile "github.com/aws/amazon-eks-pod-identity-webhook/pkg/config"
+	flag "github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+)
+
+// Sources of the flag values in the effective configuration
+const (
+	flagSourceDefault     = "default"
+	flagSourceCommandLine = "command-line"
+	flagSourceEnv         = "env"
+	flagSourceConfigFile  = "config-file"
+)
+
+// effectiveConfig is the configuration the webhook runs with, served at
+// /debug/alpha/config
+type effectiveConfig struct {
+	Flags                  []flagValue                  `json:"flags"`
+	AnnotationPrefixes     []string                     `json:"annotationPrefixes"`
+	DefaultAudience        string                       `json:"defaultAudience"`
+	DefaultTokenExpiration int64                        `json:"defaultTokenExpiration"`
+	ContainerCredentials   containerCredentialsSettings `json:"containerCredentials"`
+	Certificate            certificateSettings          `json:"certificate"`
+}
+
+// flagValue is the value of a flag and where it was set
+type flagValue struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// containerCredentialsSettings are the settings of the container credentials
+// method. Source is where its config is read from: "file", "configmap" or
+// "url", empty when the method is disabled.
+type containerCredentialsSettings struct {
+	Source          string `json:"source,omitempty"`
+	Location        string `json:"location,omitempty"`
+	Audience        string `json:"audience"`
+	MountPath       string `json:"mountPath"`
+	VolumeName      string `json:"volumeName"`
+	TokenPath       string `json:"tokenPath"`
+	TokenExpiration int64  `json:"tokenExpiration,omitempty"`
+	FullURI         string `json:"fullUri"`
+	RelativeURI     string `json:"relativeUri,omitempty"`
+}
+
+// certificateSettings describe where the serving certificate comes from:
+// "none" when serving on a unix socket, "csr", "acm-pca", "tls-secret" or
+// "files"
+type certificateSettings struct {
+	Source      string `json:"source"`
+	Secret      string `json:"secret,omitempty"`
+	LeaderElect bool   `json:"leaderElect,omitempty"`
+	CertFile    string `json:"certFile,omitempty"`
+	KeyFile     string `json:"keyFile,omitempty"`
+	AutoApprove bool   `json:"autoApprove,omitempty"`
+	ACMPCAArn   string `json:"acmPcaArn,omitempty"`
+	KeyType     string `json:"keyType,omitempty"`
+}
+
+// configDumper serves the effective configuration. The flags are read on each
+// request, as some are reloaded from the config file.
+type configDumper struct {
+	config      effectiveConfig
+	flags       *flag.FlagSet
+	commandLine sets.Set[string]
+	configFile  *configfile.File
+}
+
+// flagSource returns where the flag was set. Flags set from the environment
+// are changed like those of the command line, which were recorded before.
+func (d *configDumper) flagSource(f *flag.Flag) string {
+	switch {
+	case d.commandLine.Has(f.Name):
+		return flagSourceCommandLine
+	case f.Changed:
+		return flagSourceEnv
+	case d.configFile != nil && d.configFile.Sets(f.Name):
+		return flagSourceConfigFile
+	}
+	return flagSourceDefault
+}
+
+// Handle dumps the effective configuration as JSON
+func (d *configDumper) Handle(w http.ResponseWriter, r *http.Request) {
+	config := d.config
+	config.Flags = nil
+	d.flags.VisitAll(func(f *flag.Flag) {
+		config.Flags = append(config.Flags, flagValue{Name: f.Name, Value: f.Value.String(), Source: d.flagSource(f)})
+	})
+	contents, err := json.MarshalIndent(config, "", " ")
+	if err != nil {
+		klog.ErrorS(err, "Error encoding the effective configuration")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(contents); err != nil {
+		klog.ErrorS(err, "Can't write response")
+	}
+}
@@ -1,43 +1,65 @@
 package main
 
 import (
-	"crypto"
-	"crypto/rsa"
+	"context"
 	"crypto/x509"
-	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 
+	"github.com/aws/amazon-eks-pod-identity-webhook/pkg/oidc"
 	"github.com/go-jose/go-jose/v4"
 	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
-// copied from kubernetes/kubernetes#78502
-func keyIDFromPublicKey(publicKey interface{}) (string, error) {
-	publicKeyDERBytes, err := x509.MarshalPKIXPublicKey(publicKey)
-	if err != nil {
-		return "", fmt.Errorf("failed to serialize public key to DER format: %v", err)
-	}
-
-	hasher := crypto.SHA256.New()
-	hasher.Write(publicKeyDERBytes)
-	publicKeyDERHash := hasher.Sum(nil)
+// repeatedFlag is a flag.Value collecting the values of a repeated flag
+type repeatedFlag []string
 
-	keyID := base64.RawURLEncoding.EncodeToString(publicKeyDERHash)
+func (r *repeatedFlag) String() string {
+	return strings.Join(*r, ",")
+}
 
-	return keyID, nil
+func (r *repeatedFlag) Set(value string) error {
+	*r = append(*r, value)
+	return nil
 }
 
-type KeyResponse struct {
-	Keys []jose.JSONWebKey `json:"keys"`
+// expandKeyFiles returns the key files, replacing directories with the files
+// they contain, sorted by name
+func expandKeyFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, errors.WithMessage(err, "error reading file")
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error reading directory %s", path)
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+				files = append(files, filepath.Join(path, entry.Name()))
+			}
+		}
+	}
+	return files, nil
 }
 
-func readKey(filename string) ([]byte, error) {
-	var response []byte
+func readKey(filename string) (jose.JSONWebKey, error) {
+	var response jose.JSONWebKey
 	content, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return response, errors.WithMessage(err, "error reading file")
@@ -52,45 +74,137 @@ func readKey(filename string) ([]byte, error) {
 	if err != nil {
 		return response, errors.Wrapf(err, "Error parsing key content of %s", filename)
 	}
-	switch pubKey.(type) {
-	case *rsa.PublicKey:
-	default:
-		return response, errors.New("Public key was not RSA")
+	response, err = oidc.JSONWebKey(pubKey)
+	if err != nil {
+		return response, errors.Wrapf(err, "Error reading key %s", filename)
 	}
+	return response, nil
+}
 
-	var alg jose.SignatureAlgorithm
-	switch pubKey.(type) {
-	case *rsa.PublicKey:
-		alg = jose.RS256
-	default:
-		return response, fmt.Errorf("invalid public key type %T, must be *rsa.PrivateKey", pubKey)
+// readSecretKeys returns the public keys of the key of a Secret given as
+// [namespace/]name#key, in the namespace of the kubeconfig context by default.
+// The key can hold PEM encoded public or private keys, so that the signing key
+// given to the API server with --service-account-signing-key-file can be read
+// without extracting it to disk.
+func readSecretKeys(ctx context.Context, clientConfig clientcmd.ClientConfig, secret string) ([]jose.JSONWebKey, error) {
+	name, key, ok := strings.Cut(secret, "#")
+	if !ok || key == "" {
+		return nil, errors.Errorf("Secret %q must be given as [namespace/]name#key", secret)
 	}
-
-	kid, err := keyIDFromPublicKey(pubKey)
+	namespace, _, err := clientConfig.Namespace()
 	if err != nil {
-		return response, err
+		return nil, errors.WithMessage(err, "error reading kubeconfig namespace")
+	}
+	if ns, n, ok := strings.Cut(name, "/"); ok {
+		namespace, name = ns, n
 	}
+	config, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, errors.WithMessage(err, "error reading kubeconfig")
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, errors.WithMessage(err, "error creating clientset")
+	}
+	s, err := clientset.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error getting Secret %s/%s", namespace, name)
+	}
+	data, ok := s.Data[key]
+	if !ok {
+		return nil, errors.Errorf("Secret %s/%s has no key %s", namespace, name, key)
+	}
+	keys, err := oidc.ParsePublicKeys(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error reading key %s of Secret %s/%s", key, namespace, name)
+	}
+	return keys, nil
+}
 
-	var keys []jose.JSONWebKey
-	keys = append(keys, jose.JSONWebKey{
-		Key:       pubKey,
-		KeyID:     kid,
-		Algorithm: string(alg),
-		Use:       "sig",
-	})
+// uniqueKeys returns the keys, ignoring duplicate keys
+func uniqueKeys(keys []jose.JSONWebKey) []jose.JSONWebKey {
+	unique := []jose.JSONWebKey{}
+	seen := map[string]bool{}
+	for _, key := range keys {
+		if seen[key.KeyID] {
+			continue
+		}
+		seen[key.KeyID] = true
+		unique = append(unique, key)
+	}
+	return unique
+}
 
-	keyResponse := KeyResponse{Keys: keys}
-	return json.MarshalIndent(keyResponse, "", "    ")
+// discovery returns the discovery document of the issuer publishing the keys
+// at jwksURI, which defaults to keys.json under the issuer
+func discovery(issuer, jwksURI string, keys []jose.JSONWebKey) ([]byte, error) {
+	if jwksURI == "" {
+		jwksURI = oidc.IssuerURL(issuer, "/keys.json")
+	}
+	document, err := oidc.NewDiscovery(issuer, jwksURI, keys)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(document, "", "    ")
 }
 
 func main() {
-	keyFile := flag.String("key", "", "The public key input file in PKCS8 format")
+	var keyFlags, secretFlags repeatedFlag
+	flag.Var(&keyFlags, "key", "The public key input file in PKIX format, or a directory of them. RSA, ECDSA P-256, P-384 and P-521, and Ed25519 keys are supported. Can be repeated to publish several keys, e.g. while rotating the signing key")
+	flag.Var(&secretFlags, "from-secret", "A Secret holding PEM encoded public or private keys to publish, given as [namespace/]name#key. Can be repeated")
+	kubeconfig := flag.String("kubeconfig", "", "The kubeconfig file to read the Secrets of -from-secret with. Defaults to $KUBECONFIG or ~/.kube/config")
+	issuer := flag.String("issuer", "", "If set, the https URL of the service account issuer to also write the OIDC discovery document of")
+	jwksURI := flag.String("jwks-uri", "", "The URL the keys are published at. Defaults to keys.json under the issuer")
+	discoveryOutput := flag.String("discovery-output", "discovery.json", "The file the OIDC discovery document is written to, when issuer is set")
 	flag.Parse()
 
-	output, err := readKey(*keyFile)
+	files, err := expandKeyFiles(keyFlags)
+	if err == nil && len(files) == 0 && len(secretFlags) == 0 {
+		err = errors.New("No public key given with -key or -from-secret")
+	}
 	if err != nil {
 		fmt.Println(err.Error())
 		os.Exit(1)
 	}
+	keys := []jose.JSONWebKey{}
+	for _, file := range files {
+		key, err := readKey(file)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		keys = append(keys, key)
+	}
+	if len(secretFlags) > 0 {
+		loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+		loadingRules.ExplicitPath = *kubeconfig
+		clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{})
+		for _, secret := range secretFlags {
+			secretKeys, err := readSecretKeys(context.Background(), clientConfig, secret)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			keys = append(keys, secretKeys...)
+		}
+	}
+	keys = uniqueKeys(keys)
+	output, err := json.MarshalIndent(oidc.KeySet{Keys: keys}, "", "    ")
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	if *issuer != "" {
+		discoveryDocument, err := discovery(*issuer, *jwksURI, keys)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		if err := os.WriteFile(*discoveryOutput, append(discoveryDocument, '\n'), 0644); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	}
 	fmt.Println(string(output))
 }
@@ -16,30 +16,59 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	goflag "flag"
 	"fmt"
+	"math"
+	"net"
 	"net/http"
+	"net/http/pprof"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/aws/amazon-eks-pod-identity-webhook/pkg"
+	"github.com/aws/amazon-eks-pod-identity-webhook/pkg/auditlog"
 	"github.com/aws/amazon-eks-pod-identity-webhook/pkg/cache"
 	cachedebug "github.com/aws/amazon-eks-pod-identity-webhook/pkg/cache/debug"
 	"github.com/aws/amazon-eks-pod-identity-webhook/pkg/cert"
+	configfile "github.com/aws/amazon-eks-pod-identity-webhook/pkg/config"
 	"github.com/aws/amazon-eks-pod-identity-webhook/pkg/containercredentials"
+	"github.com/aws/amazon-eks-pod-identity-webhook/pkg/filesystem"
 	"github.com/aws/amazon-eks-pod-identity-webhook/pkg/handler"
-	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/amazon-eks-pod-identity-webhook/pkg/httppoller"
+	"github.com/aws/amazon-eks-pod-identity-webhook/pkg/oidc"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/acmpca"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	flag "github.com/spf13/pflag"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/client-go/informers"
 	v1 "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/certificate"
+	"k8s.io/client-go/util/retry"
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/controller-runtime/pkg/certwatcher"
 	"sigs.k8s.io/controller-runtime/pkg/manager/signals"
@@ -48,8 +77,18 @@ import (
 var webhookVersion = "v0.1.0"
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == verifyOIDCCommand {
+		os.Exit(verifyOIDC(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == waitForTokenCommand {
+		os.Exit(waitForToken(os.Args[2:]))
+	}
+
 	port := flag.Int("port", 443, "Port to listen on")
+	bindAddresses := flag.IPSlice("bind-address", nil, "Comma-separated list of IPv4 and IPv6 addresses to listen on with port, e.g. the pod IPs, instead of all IPv4 and IPv6 interfaces")
+	listenUnixSocket := flag.String("listen-unix-socket", "", "If set, the path of the unix domain socket to serve the webhook on in plain HTTP instead of port, e.g. behind a proxy terminating TLS. The webhook then manages no serving certificate")
 	metricsPort := flag.Int("metrics-port", 9999, "Port to listen on for metrics (http)")
+	metricsBindAddress := flag.String("metrics-bind-address", "", "If set, the host:port to listen on for metrics (http) instead of metrics-port on all interfaces, e.g. 127.0.0.1:9999 to only serve local clients")
 
 	// TODO Group in help text in-cluster/out-of-cluster/business logic flags
 	// out-of-cluster kubeconfig / TLS options
@@ -59,36 +98,149 @@ func main() {
 	apiURL := flag.String("kube-api", "", "(out-of-cluster) The url to the API server")
 	tlsKeyFile := flag.String("tls-key", "/etc/webhook/certs/tls.key", "(out-of-cluster) TLS key file path")
 	tlsCertFile := flag.String("tls-cert", "/etc/webhook/certs/tls.crt", "(out-of-cluster) TLS certificate file path")
+	watchTLSSecret := flag.String("watch-tls-secret", "", "(out-of-cluster) Name of the Secret holding the TLS serving cert to watch, in the namespace of the webhook or given as namespace/name, instead of reading the tls-cert and tls-key files")
 
 	// in-cluster TLS options
 	inCluster := flag.Bool("in-cluster", true, "Use in-cluster authentication and certificate request API")
 	serviceName := flag.String("service-name", "pod-identity-webhook", "(in-cluster) The service name fronting this webhook")
 	namespaceName := flag.String("namespace", "eks", "(in-cluster) The namespace name this webhook, the TLS secret, and configmap resides in")
 	tlsSecret := flag.String("tls-secret", "pod-identity-webhook", "(in-cluster) The secret name for storing the TLS serving cert")
+	csrSignerName := flag.String("csr-signer-name", cert.LegacyUnknownSignerName, "(in-cluster) The signer name of the CertificateSigningRequests for the TLS serving cert. The default signer is not available on Kubernetes 1.22 and later, use a third-party signer instead")
+	leaderElect := flag.Bool("leader-elect", false, "(in-cluster) Elect a leader among the replicas of the webhook to request the TLS serving cert and store it in the TLS secret, which all replicas watch")
+	acmPCAArn := flag.String("acm-pca-arn", "", "(in-cluster) If set, the ARN of the ACM Private CA to request the TLS serving cert from, instead of the certificate request API")
+	acmPCASigningAlgorithm := flag.String("acm-pca-signing-algorithm", acmpca.SigningAlgorithmSha256withrsa, "(in-cluster) The algorithm ACM Private CA signs the TLS serving cert with, which must match the key type of the CA")
+	acmPCATemplateArn := flag.String("acm-pca-template-arn", "", "(in-cluster) The ARN of the ACM Private CA template of the TLS serving cert. Defaults to the end entity certificate template")
+	acmPCAValidityDays := flag.Int64("acm-pca-validity-days", 30, "(in-cluster) The validity in days of the TLS serving cert issued by ACM Private CA")
+	certRenewalFraction := flag.Float64("cert-renewal-fraction", cert.DefaultRenewalFraction, "(in-cluster) The fraction of its lifetime after which the TLS serving cert issued by ACM Private CA is renewed, e.g. 0.5 to renew short-lived certs earlier. The certificate request API renews certs between 70% and 90% of their lifetime")
+	certRenewalJitter := flag.Float64("cert-renewal-jitter", cert.DefaultRenewalJitter, "(in-cluster) The maximum random fraction of its lifetime added to cert-renewal-fraction to renew the TLS serving cert issued by ACM Private CA, to spread the renewals")
+	tlsKeyType := flag.String("tls-key-type", cert.KeyTypeECDSAP256, fmt.Sprintf("(in-cluster) The type of the TLS serving key generated for ACM Private CA, one of %s. The certificate request API always uses %s keys", strings.Join(cert.KeyTypes, ", "), cert.KeyTypeECDSAP256))
+	csrAutoApprove := flag.Bool("csr-auto-approve", false, "(in-cluster) Approve the CertificateSigningRequests of the TLS serving cert created by the webhook, instead of waiting for them to be approved. Requires permission to approve requests for the csr-signer-name signer")
+	tlsSANDNSNames := flag.StringSlice("tls-san-dns", nil, "(in-cluster) Comma-separated list of additional DNS names of the TLS serving cert, e.g. when the API server reaches the webhook through another name than the service")
+	tlsSANIPs := flag.IPSlice("tls-san-ips", nil, "(in-cluster) Comma-separated list of IP addresses of the TLS serving cert, e.g. when the API server reaches the webhook by IP")
+
+	// webhook configuration options
+	webhookConfigName := flag.String("webhook-configuration-name", "", "If set, the name of the MutatingWebhookConfiguration whose webhooks' caBundle is kept up to date with the serving certificate")
+	webhookCABundleFile := flag.String("webhook-ca-bundle-file", "", "(with webhook-configuration-name) The CA bundle file to set as caBundle. Defaults to the last certificate of the serving certificate chain")
+	webhookCABundleSyncPeriod := flag.Duration("webhook-ca-bundle-sync-period", time.Minute, "(with webhook-configuration-name) How often the caBundle is compared with the serving certificate")
+
+	// OIDC issuer options
+	oidcIssuer := flag.String("oidc-issuer", "", "If set, the https URL of the service account issuer whose OIDC discovery document and keys are served by the webhook, under the path of the issuer, e.g. for self-hosted clusters without a public issuer. Requires oidc-signing-key-file or oidc-signing-key-secret")
+	oidcSigningKeyFile := flag.String("oidc-signing-key-file", "", "(with oidc-issuer) The file of the PEM encoded service account signing public keys, as given to the API server with --service-account-key-file. The file is watched for key rotations")
+	oidcSigningKeySecret := flag.String("oidc-signing-key-secret", "", "(with oidc-issuer) The Secret holding the PEM encoded service account signing public keys to watch, in the namespace of the webhook or given as namespace/name, followed by #key to select the key of the Secret. The key defaults to sa.pub")
+	verifyOIDCIssuer := flag.Bool("verify-oidc-issuer", false, "Verify at startup that the service account issuer of the cluster publishes its signing keys the way STS fetches them, and log the problems found. The same checks are run by the verify-oidc subcommand")
 
 	// annotation/volume configurations
-	annotationPrefix := flag.String("annotation-prefix", "eks.amazonaws.com", "The Service Account annotation to look for")
+	annotationPrefix := flag.String("annotation-prefix", "eks.amazonaws.com", "The Service Account and Pod annotation prefix to look for. A comma-separated list of prefixes can be given, in order of precedence, to read annotations with several prefixes, e.g. while migrating to a new one")
 	audience := flag.String("token-audience", "sts.amazonaws.com", "The default audience for tokens. Can be overridden by annotation")
 	mountPath := flag.String("token-mount-path", "/var/run/secrets/eks.amazonaws.com/serviceaccount", "The path to mount tokens")
+	tokenVolumeName := flag.String("token-volume-name", "aws-iam-token", "The name of the projected volume containing the injected service account token")
+	tokenFileName := flag.String("token-file-name", "token", "The file name of the injected service account token in token-mount-path")
 	tokenExpiration := flag.Int64("token-expiration", pkg.DefaultTokenExpiration, "The token expiration")
+	tokenWaitImage := flag.String("token-wait-image", "", "If set, the image of an init container injected into pods using IAM roles for service accounts, which waits until their token exists and STS accepts it by running the wait-for-token subcommand of the webhook, e.g. the image of the webhook")
+	tokenWaitTimeout := flag.Duration("token-wait-timeout", 2*time.Minute, "(with token-wait-image) The time the init container waits for the token to be accepted by STS before failing")
+	tokenExpirationJitter := flag.Int64("token-expiration-jitter", 0, "If set, a random number of seconds up to this value is subtracted from the token expiration of each mutated pod, to spread the token refreshes of pods created together. Expirations are not lowered below 600 seconds")
 	region := flag.String("aws-default-region", "", "If set, AWS_DEFAULT_REGION and AWS_REGION will be set to this value in mutated containers")
+	extraEnvVars := flag.StringArray("extra-env", nil, "An env variable to inject into mutated containers along with the AWS env variables, as NAME=value, e.g. HTTPS_PROXY=http://proxy:3128. Can be repeated. Takes precedence over extra-env-file")
+	sdkUAAppID := flag.String("sdk-ua-app-id", "", "If set, the template of the AWS_SDK_UA_APP_ID env variable injected into mutated containers, in which {namespace} and {serviceaccount} are replaced with those of the pod, e.g. {namespace}/{serviceaccount}. Can be overridden by service account or pod annotation")
+	extraEnvFile := flag.String("extra-env-file", "", "If set, a YAML or JSON file mapping the names of env variables to inject into mutated containers to their values, e.g. mounted from a ConfigMap. The file is watched for changes")
 	regionalSTS := flag.Bool("sts-regional-endpoint", false, "Whether to inject the AWS_STS_REGIONAL_ENDPOINTS=regional env var in mutated pods. Defaults to `false`.")
+	useFIPSEndpoint := flag.Bool("use-fips-endpoint", false, "Inject AWS_USE_FIPS_ENDPOINT=true along with AWS_STS_REGIONAL_ENDPOINTS=regional in mutated pods, so that the AWS SDKs call the FIPS endpoints, e.g. for GovCloud and FedRAMP workloads. Can be overridden by service account annotation")
+	configMapName := flag.String("config-map-name", cache.DefaultConfigMapName, "The name of the ConfigMap to read service accounts from when watching ConfigMaps")
+	configMapLabelSelector := flag.String("config-map-label-selector", "", "Label selector for additional ConfigMaps to read service accounts from when watching ConfigMaps")
+	roleAliasesConfigMap := flag.String("role-aliases-config-map", "", "If set, the name of the ConfigMap mapping the aliases of the role-alias service account annotation to role ARNs, in the namespace of the webhook or given as namespace/name. The ConfigMap is watched for changes")
 	watchConfigMap := flag.Bool("watch-config-map", false, "Enables watching serviceaccounts that are configured through the pod-identity-webhook configmap instead of using annotations")
-	composeRoleArn := flag.Bool("compose-role-arn", false, "If true, then the role name and path can be used instead of the fully qualified ARN in the `role-arn` annotation.  In this case, webhook will look up the partition and account ID using instance metadata.  Defaults to `false`.")
-	watchContainerCredentialsConfig := flag.String("watch-container-credentials-config", "", "Absolute path to the container credential config file to watch for")
+	composeRoleArn := flag.Bool("compose-role-arn", false, "If true, then the role name and path can be used instead of the fully qualified ARN in the `role-arn` annotation.  In this case, webhook will look up the partition and account ID using instance metadata or STS, unless given with aws-account-id and aws-partition.  Defaults to `false`.")
+	awsAccountID := flag.String("aws-account-id", "", "(with compose-role-arn) The account ID of the composed role ARNs. Defaults to the account ID of the instance metadata, or of the STS caller identity")
+	awsPartition := flag.String("aws-partition", "", "(with compose-role-arn) The partition of the composed role ARNs, e.g. aws-us-gov. Defaults to the partition of the region of the instance metadata, or of the STS caller identity")
+	watchContainerCredentialsConfig := flag.String("watch-container-credentials-config", "", "Absolute path to the container credential config file to watch for, or to a directory whose *.json config fragments are merged")
+	watchContainerCredentialsConfigMap := flag.String("watch-container-credentials-configmap", "", "Name of the ConfigMap to watch for the container credential config, in the namespace of the webhook or given as namespace/name, instead of watching a file")
+	containerCredentialsConfigURL := flag.String("container-credentials-config-url", "", "HTTPS URL to periodically fetch the container credential config from, instead of watching a file")
+	containerCredentialsConfigPollInterval := flag.Duration("container-credentials-config-poll-interval", 30*time.Second, "How often the container credential config is fetched from container-credentials-config-url")
+	containerCredentialsConfigCAFile := flag.String("container-credentials-config-ca-file", "", "CA certificates to verify the server of container-credentials-config-url with. Defaults to the system CA certificates")
+	containerCredentialsConfigCertFile := flag.String("container-credentials-config-client-cert-file", "", "Client certificate to authenticate to the server of container-credentials-config-url with. Read again on each connection so that it can be rotated")
+	containerCredentialsConfigKeyFile := flag.String("container-credentials-config-client-key-file", "", "Private key of container-credentials-config-client-cert-file")
+	credentialMethodPrecedence := flag.String("credential-method-precedence", handler.CredentialMethodPrecedenceContainerCredentials, "Which credential method is injected into pods whose service account has both a role ARN and a container credentials identity: \"container-credentials\", \"sts-web-identity\" or \"both\". Can be overridden by service account annotation")
 	containerCredentialsAudience := flag.String("container-credentials-audience", "pods.eks.amazonaws.com", "The audience for tokens used by the AWS Container Credentials method")
 	containerCredentialsMountPath := flag.String("container-credentials-token-mount-path", "/var/run/secrets/pods.eks.amazonaws.com/serviceaccount", "The path to mount tokens used by the AWS Container Credentials method")
 	containerCredentialsVolumeName := flag.String("container-credentials-token-volume-name", "eks-pod-identity-token", "The name of the projected volume containing the injected service account token. This is only used by the AWS Container Credentials method")
 	containerCredentialsTokenPath := flag.String("container-credentials-token-path", "eks-pod-identity-token", "The path of the injected service account token. This is only used by the AWS Container Credentials method")
-	containerCredentialsFullUri := flag.String("container-credentials-full-uri", "http://169.254.170.23/v1/credentials", "AWS_CONTAINER_CREDENTIALS_FULL_URI will be set to this value in mutated containers")
+	containerCredentialsTokenExpiration := flag.Int64("container-credentials-token-expiration", 0, "The token expiration for tokens used by the AWS Container Credentials method, unless overridden by the identity or pod annotation. Defaults to 0, which uses the token expiration of the service account")
+	containerCredentialsFullUri := flag.String("container-credentials-full-uri", "", "AWS_CONTAINER_CREDENTIALS_FULL_URI will be set to this value in mutated containers. For unix:// URIs, the directory of the socket is also mounted from the host. Defaults to "+containercredentials.DefaultFullUriIPv4+" or "+containercredentials.DefaultFullUriIPv6+", depending on container-credentials-ip-family")
+	envVarPosition := flag.String("env-var-position", handler.EnvVarPositionAppend, "Where the injected env variables are placed in the env of mutated containers: \"append\" after the env variables of the container, \"prepend\" before them, or \"before-reference\" before the first one referencing an injected variable with $(VAR). Can be overridden by pod annotation")
+	containerCredentialsURIMode := flag.String("container-credentials-uri-mode", handler.ContainerCredentialsURIModeFull, "Which container credentials URI env variables are injected in mutated containers: \"full\" for AWS_CONTAINER_CREDENTIALS_FULL_URI, \"relative\" for AWS_CONTAINER_CREDENTIALS_RELATIVE_URI, for SDKs and tools not supporting the full URI, or \"both\". Can be overridden by pod annotation")
+	containerCredentialsRelativeUri := flag.String("container-credentials-relative-uri", "", "AWS_CONTAINER_CREDENTIALS_RELATIVE_URI will be set to this value in mutated containers, depending on container-credentials-uri-mode. Defaults to the path of the full URI")
+	containerCredentialsIPFamily := flag.String("container-credentials-ip-family", "auto", "The IP family of the default container-credentials-full-uri: \"IPv4\", \"IPv6\" or \"auto\" to use the primary IP family of the cluster")
 
 	version := flag.Bool("version", false, "Display the version and exit")
+	configFile := flag.String("config", "", "Path to a YAML or JSON file mapping flag names to their values. Flags set on the command line or in the environment take precedence. The file is watched, and the v and vmodule flags reloaded when it changes")
 
-	debug := flag.Bool("enable-debugging-handlers", false, "Enable debugging handlers. Currently /debug/alpha/cache is supported")
+	debug := flag.Bool("enable-debugging-handlers", false, "Enable the /debug/alpha/ debugging handlers dumping the caches and the effective config, simulating the mutation of pods, and running the self-test")
+	enablePprof := flag.Bool("enable-pprof", false, "Enable /debug/pprof/ profiling handlers on the debug bind address, and Go runtime/metrics collection")
+	debugAuthorization := flag.Bool("debugging-handlers-authorization", true, "Authenticate requests to the debugging and profiling handlers with a TokenReview of their bearer token, and authorize them with a SubjectAccessReview of their path. Requires permission to create TokenReviews and SubjectAccessReviews")
+	debugAddress := flag.String("debug-bind-address", "127.0.0.1:9998", "The host:port to serve the debugging and profiling handlers on, by default only to local clients. If empty, they are served on the metrics port")
 
 	saLookupGracePeriod := flag.Duration("service-account-lookup-grace-period", 0, "The grace period for service account to be available in cache before not mutating a pod. Defaults to 0, what deactivates waiting. Carefully use values higher than a bunch of milliseconds as it may have significant impact on Kubernetes' pod scheduling performance.")
 
-	resyncPeriod := flag.Duration("resync-period", 60*time.Second, "The period to resync the SA informer cache, in seconds.")
+	failOnMissingServiceAccount := flag.Bool("fail-on-missing-service-account", false, "Deny admission of pods whose service account is not found in the cache after the grace period, instead of admitting them without credentials. Can be overridden by pod annotation")
+
+	skipInitContainers := flag.Bool("skip-init-containers", false, "Only mutate the containers of pods, not their initContainers, e.g. when initContainers run with node credentials. Can be overridden by pod annotation")
+	injectAWSConfigFile := flag.Bool("inject-aws-config-file", false, "Also inject an AWS config file with role_arn and web_identity_token_file in the token volume, and AWS_CONFIG_FILE pointing at it, for SDKs and tools ignoring the env variables. Can be overridden by pod annotation")
+	skipTokenVolume := flag.Bool("skip-token-volume", false, "Only inject the env variables into pods, not the token volume and volumeMount, for pods projecting the service account token themselves at the mount path. Can be overridden by service account annotation")
+	nativeSidecars := flag.String("native-sidecars", handler.NativeSidecarsContainer, "How initContainers with restartPolicy Always are treated: \"container\" to treat these native sidecars like containers, which skip-init-containers does not skip, or \"init-container\" to treat them like the other initContainers")
+
+	serviceAccountCacheMode := flag.String("service-account-cache-mode", "informer", "How service accounts are cached. \"informer\" watches all service accounts, \"lru\" fetches them from the API server when first used and keeps the most recently used ones")
+	serviceAccountCacheSize := flag.Int("service-account-cache-size", 10000, "(lru cache mode) Maximum number of service accounts kept in the cache")
+	serviceAccountCacheTTL := flag.Duration("service-account-cache-ttl", 5*time.Minute, "(lru cache mode) How long a service account is kept in the cache before being fetched again")
+
+	eventWorkers := flag.Int("service-account-event-workers", cache.DefaultEventWorkers, "Number of workers processing ServiceAccount informer events once the informers have synced, so that bursts of events, e.g. on resyncs, do not block the informers. 0 processes them in the informer callbacks")
+	fetchWorkers := flag.Int("service-account-fetch-workers", cache.DefaultFetchWorkers, "Number of workers fetching service accounts missing from the cache from the API server")
+	fetchTimeout := flag.Duration("service-account-fetch-timeout", cache.DefaultFetchTimeout, "Timeout of each attempt to fetch a service account from the API server")
+	fetchBackoffDuration := flag.Duration("service-account-fetch-backoff-duration", retry.DefaultBackoff.Duration, "Delay before retrying a service account fetch failing with a transient error, multiplied by 5 after each attempt")
+	fetchBackoffSteps := flag.Int("service-account-fetch-backoff-steps", retry.DefaultBackoff.Steps, "Maximum number of attempts to fetch a service account from the API server")
+	tenantFetchQPS := flag.Float64("service-account-fetch-tenant-qps", 0, "If set, the rate of fetches of service accounts missing from the cache allowed per tenant, so that a tenant churning pods with missing service accounts can't slow down the fetches of others. Pods whose fetch is rate limited are not mutated. Defaults to 0, which disables the limit")
+	tenantFetchBurst := flag.Int("service-account-fetch-tenant-burst", 5, "(with service-account-fetch-tenant-qps) The number of fetches a tenant can make at once")
+	tenantFetchKey := flag.String("service-account-fetch-tenant-key", cache.TenantKeyNamespace, "(with service-account-fetch-tenant-qps) What fetches are rate limited by: \"namespace\" or \"serviceaccount\"")
+	negativeCacheTTL := flag.Duration("service-account-negative-cache-ttl", 5*time.Second, "How long a service account the API server reported as not found is not fetched again. Set to 0 to disable negative caching")
+
+	snapshotPath := flag.String("cache-snapshot-path", "", "(informer cache mode) If set, the path of a file the caches are saved to on shutdown and loaded from on startup, so that the webhook is ready before its informers have synced")
+	snapshotMaxAge := flag.Duration("cache-snapshot-max-age", time.Hour, "The maximum age of a cache snapshot to load on startup, 0 for no limit")
+	reconcileInterval := flag.Duration("cache-reconcile-interval", 0, "(informer cache mode) If set, the period to re-list service accounts and watched ConfigMaps from the API server and fix cache entries out of date with them, e.g. due to missed watch events")
+	annotatedServiceAccountsOnly := flag.Bool("cache-annotated-service-accounts-only", false, "If true, only service accounts with annotations are kept in the service account cache. The informers still hold every service account, and the others are looked up in their stores when a pod uses them")
+
+	skipInvalidRoleArn := flag.Bool("skip-invalid-role-arn", false, "If true, service accounts whose role-arn annotation is not a valid IAM role ARN are treated as not annotated, and their pods are not mutated")
+	roleArnPattern := flag.String("role-arn-pattern", "", "If set, a regular expression role ARNs must match to be valid, on top of being well-formed IAM role ARNs, e.g. ^arn:aws:iam::(111122223333|444455556666):role/")
+	reinvocationMarker := flag.Bool("reinvocation-marker", false, "Annotate mutated pods with eks.amazonaws.com/pod-identity-injected, the hash of the names of their volumes, containers, env variables and volume mounts, so that reinvocations of the webhook with reinvocationPolicy IfNeeded admit pods no other mutator changed since as is")
+	rejectInvalidRoleArn := flag.Bool("reject-invalid-role-arn", false, "If true, invalid role ARNs are not injected into pods, which are admitted with a warning instead. Pods whose service account also has a container credentials identity get it instead")
+
+	maxConcurrentAdmissions := flag.Int("max-concurrent-admissions", 0, "Maximum number of admission requests served concurrently. Requests over the limit are rejected with 429. Defaults to 0, which disables the limit")
+
+	maxRequestBodyBytes := flag.Int64("max-request-body-bytes", handler.DefaultMaxRequestBodyBytes, "Maximum size in bytes of admission request bodies. Larger requests are rejected with 413. Set to 0 to disable the limit")
+	serverReadHeaderTimeout := flag.Duration("server-read-header-timeout", 10*time.Second, "Maximum duration for reading request headers, for both the webhook and metrics servers")
+	serverReadTimeout := flag.Duration("server-read-timeout", 10*time.Second, "Maximum duration for reading entire requests, for both the webhook and metrics servers")
+	serverWriteTimeout := flag.Duration("server-write-timeout", 30*time.Second, "Maximum duration before timing out writes of responses, for both the webhook and metrics servers. Must be longer than the service account lookup grace period")
+	serverIdleTimeout := flag.Duration("server-idle-timeout", 90*time.Second, "Maximum amount of time to wait for the next request on keep-alive connections, for both the webhook and metrics servers")
+	shutdownDelay := flag.Duration("shutdown-delay", 0, "Duration to keep serving after SIGTERM before shutting down gracefully, failing the readiness check meanwhile, for the pod to be removed from the endpoints of the Service. Must be shorter than the termination grace period of the pod")
+
+	emitEvents := flag.Bool("emit-events", true, "Emit Kubernetes Events on the service account when a pod can not be mutated, and on ConfigMaps with an invalid config")
+
+	auditLogFile := flag.String("audit-log-file", "", "If set, the file the admission decision of every pod is appended to, one JSON object per line")
+	auditLogGroup := flag.String("audit-log-cloudwatch-log-group", "", "If set, the CloudWatch Logs log group the admission decision of every pod is sent to, with the credentials of the webhook. The log group must exist")
+	auditLogStream := flag.String("audit-log-cloudwatch-log-stream", "", "(with audit-log-cloudwatch-log-group) The log stream the admission decisions are sent to, created if needed. Defaults to the hostname")
+
+	legacyLatencyMetrics := flag.Bool("enable-legacy-latency-metrics", true, "(Deprecated) Expose the http_request_duration_microseconds summary. Use the http_request_duration_seconds histogram instead")
+
+	loggingFormat := flag.String("logging-format", "text", "Sets the log format. Permitted formats: \"text\", \"json\"")
+
+	watchNamespaces := flag.StringSlice("watch-namespaces", nil, "Comma-separated list of namespaces to watch service accounts in and mutate pods in. Defaults to all namespaces")
+	namespaceLabelSelector := flag.String("namespace-label-selector", "", "Label selector restricting mutation to pods in namespaces whose labels match it. Defaults to all namespaces")
+	namespaceAudience := flag.Bool("namespace-audience", false, "If true, the audience annotation of namespaces is the default audience of their service accounts without one, over the namespaces of the ConfigMap and token-audience. Requires permission to list and watch namespaces")
+
+	kubeAPIQPS := flag.Float32("kube-api-qps", 50, "QPS to use while talking with the API server")
+	kubeAPIBurst := flag.Int("kube-api-burst", 50, "Burst to use while talking with the API server")
+	var resyncPeriod time.Duration
+	flag.DurationVar(&resyncPeriod, "informer-resync-period", 60*time.Second, "The period to resync the SA and ConfigMap informer caches. Set to 0 to disable resyncs")
+	flag.DurationVar(&resyncPeriod, "resync-period", 60*time.Second, "The period to resync the SA informer cache, in seconds.")
+	_ = flag.CommandLine.MarkDeprecated("resync-period", "use --informer-resync-period instead")
 
 	klog.InitFlags(goflag.CommandLine)
 	// Add klog CommandLine flags to pflag CommandLine
@@ -100,105 +252,493 @@ func main() {
 	// klog complains if its not been parsed
 	_ = goflag.CommandLine.Parse([]string{})
 
+	commandLineFlags := sets.New[string]()
+	flag.Visit(func(f *flag.Flag) { commandLineFlags.Insert(f.Name) })
+	if err := configfile.LoadEnv(flag.CommandLine, os.Environ()); err != nil {
+		klog.Fatalf("Error loading flags from the environment: %v", err)
+	}
+	var flagsFile *configfile.File
+	if *configFile != "" {
+		flagsFile = configfile.New(flag.CommandLine, "v", "vmodule")
+		content, err := os.ReadFile(*configFile)
+		if err != nil {
+			klog.Fatalf("Error reading config file %s: %v", *configFile, err)
+		}
+		if err := flagsFile.Load(content); err != nil {
+			klog.Fatalf("Error loading config file %s: %v", *configFile, err)
+		}
+	}
+
 	if *version {
 		fmt.Println(webhookVersion)
 		os.Exit(0)
 	}
 
+	switch *serviceAccountCacheMode {
+	case "informer":
+	case "lru":
+		if *watchConfigMap {
+			klog.Fatalf("The lru service account cache mode does not support watch-config-map")
+		}
+		if *reconcileInterval > 0 {
+			klog.Warningf("Ignoring cache-reconcile-interval, service accounts are not watched in lru cache mode")
+		}
+	default:
+		klog.Fatalf("Unsupported service account cache mode %q, expected \"informer\" or \"lru\"", *serviceAccountCacheMode)
+	}
+
+	switch *credentialMethodPrecedence {
+	case handler.CredentialMethodPrecedenceContainerCredentials, handler.CredentialMethodPrecedenceSTSWebIdentity, handler.CredentialMethodPrecedenceBoth:
+	default:
+		klog.Fatalf("Unsupported credential method precedence %q, expected %q, %q or %q", *credentialMethodPrecedence,
+			handler.CredentialMethodPrecedenceContainerCredentials, handler.CredentialMethodPrecedenceSTSWebIdentity, handler.CredentialMethodPrecedenceBoth)
+	}
+
+	switch *nativeSidecars {
+	case handler.NativeSidecarsContainer, handler.NativeSidecarsInitContainer:
+	default:
+		klog.Fatalf("Unsupported native sidecars mode %q, expected %q or %q", *nativeSidecars,
+			handler.NativeSidecarsContainer, handler.NativeSidecarsInitContainer)
+	}
+
+	if err := containercredentials.ValidateFullUri(*containerCredentialsFullUri); err != nil {
+		klog.Fatalf("Invalid container-credentials-full-uri: %v", err)
+	}
+
+	switch *envVarPosition {
+	case handler.EnvVarPositionAppend, handler.EnvVarPositionPrepend, handler.EnvVarPositionBeforeReference:
+	default:
+		klog.Fatalf("Unsupported env variable position %q, expected %q, %q or %q", *envVarPosition,
+			handler.EnvVarPositionAppend, handler.EnvVarPositionPrepend, handler.EnvVarPositionBeforeReference)
+	}
+
+	switch *containerCredentialsURIMode {
+	case handler.ContainerCredentialsURIModeFull, handler.ContainerCredentialsURIModeRelative, handler.ContainerCredentialsURIModeBoth:
+	default:
+		klog.Fatalf("Unsupported container credentials URI mode %q, expected %q, %q or %q", *containerCredentialsURIMode,
+			handler.ContainerCredentialsURIModeFull, handler.ContainerCredentialsURIModeRelative, handler.ContainerCredentialsURIModeBoth)
+	}
+
+	if errs := validation.IsDNS1123Label(*tokenVolumeName); len(errs) > 0 {
+		klog.Fatalf("Invalid token-volume-name %q: %s", *tokenVolumeName, strings.Join(errs, ", "))
+	}
+	if *tokenVolumeName == *containerCredentialsVolumeName {
+		klog.Fatalf("token-volume-name and container-credentials-token-volume-name must be different")
+	}
+	if *tokenFileName == "" || *tokenFileName == "." || *tokenFileName == ".." || strings.Contains(*tokenFileName, "/") {
+		klog.Fatalf("Invalid token-file-name %q, expected a file name", *tokenFileName)
+	}
+
+	if *inCluster && *watchTLSSecret != "" {
+		klog.Fatalf("watch-tls-secret can not be set with in-cluster, which manages the TLS Secret")
+	}
+	if *listenUnixSocket != "" && len(*bindAddresses) > 0 {
+		klog.Fatalf("bind-address can not be set with listen-unix-socket")
+	}
+	if *listenUnixSocket != "" && *webhookConfigName != "" && *webhookCABundleFile == "" {
+		klog.Fatalf("webhook-ca-bundle-file must be set with webhook-configuration-name and listen-unix-socket, as the webhook serves no certificate")
+	}
+	if *csrAutoApprove && *acmPCAArn != "" {
+		klog.Fatalf("csr-auto-approve can not be set with acm-pca-arn, which does not create CSRs")
+	}
+	if !slices.Contains(cert.KeyTypes, *tlsKeyType) {
+		klog.Fatalf("Unsupported tls-key-type %q, expected one of %s", *tlsKeyType, strings.Join(cert.KeyTypes, ", "))
+	}
+	if *certRenewalFraction <= 0 || *certRenewalJitter < 0 || *certRenewalFraction+*certRenewalJitter >= 1 {
+		klog.Fatalf("cert-renewal-fraction must be positive and cert-renewal-jitter not negative, with a sum lower than 1")
+	}
+	if (*certRenewalFraction != cert.DefaultRenewalFraction || *certRenewalJitter != cert.DefaultRenewalJitter) && *acmPCAArn == "" {
+		klog.Fatalf("cert-renewal-fraction and cert-renewal-jitter can only be set with acm-pca-arn, the certificate request API renews certs between 70%% and 90%% of their lifetime")
+	}
+	if *tlsKeyType != cert.KeyTypeECDSAP256 && *acmPCAArn == "" {
+		klog.Fatalf("tls-key-type can only be set with acm-pca-arn, the certificate request API uses %s keys", cert.KeyTypeECDSAP256)
+	}
+
+	if *oidcIssuer != "" && (*oidcSigningKeyFile == "") == (*oidcSigningKeySecret == "") {
+		klog.Fatalf("Exactly one of oidc-signing-key-file and oidc-signing-key-secret must be set with oidc-issuer")
+	}
+
+	var containerCredentialsConfigSource string
+	for _, source := range []string{*watchContainerCredentialsConfig, *watchContainerCredentialsConfigMap, *containerCredentialsConfigURL} {
+		if source == "" {
+			continue
+		}
+		if containerCredentialsConfigSource != "" {
+			klog.Fatalf("Only one of watch-container-credentials-config, watch-container-credentials-configmap and container-credentials-config-url can be set")
+		}
+		containerCredentialsConfigSource = source
+	}
+
+	switch *loggingFormat {
+	case "text":
+	case "json":
+		klog.SetLogger(newJSONLogger())
+	default:
+		klog.Fatalf("Unsupported logging format %q, expected \"text\" or \"json\"", *loggingFormat)
+	}
+
 	// setup signal handler
-	signalHandlerCtx := signals.SetupSignalHandler()
+	signalCtx := signals.SetupSignalHandler()
+	signalHandlerCtx := handler.DelayContext(signalCtx, *shutdownDelay)
+	if flagsFile != nil {
+		if err := filesystem.NewFileWatcher("config", *configFile, flagsFile.Reload).Watch(signalHandlerCtx); err != nil {
+			klog.Fatalf("Error watching config file %s: %v", *configFile, err)
+		}
+	}
 
 	config, err := clientcmd.BuildConfigFromFlags(*apiURL, *kubeconfig)
 	if err != nil {
 		klog.Fatalf("Error creating config: %v", err.Error())
 	}
 
-	config.QPS = 50
-	config.Burst = 50
+	config.QPS = *kubeAPIQPS
+	config.Burst = *kubeAPIBurst
 
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		klog.Fatalf("Error creating clientset: %v", err.Error())
 	}
-	informerFactory := informers.NewSharedInformerFactory(clientset, *resyncPeriod)
+
+	if *verifyOIDCIssuer {
+		go func() {
+			ctx, cancel := context.WithTimeout(signalHandlerCtx, time.Minute)
+			defer cancel()
+			verifyClusterIssuer(ctx, clientset)
+		}()
+	}
+
+	if *containerCredentialsFullUri == "" {
+		ipFamily := corev1.IPFamily(*containerCredentialsIPFamily)
+		if *containerCredentialsIPFamily == "auto" {
+			ipFamily = corev1.IPv4Protocol
+			if containerCredentialsConfigSource != "" {
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				detected, err := containercredentials.DetectIPFamily(ctx, clientset)
+				cancel()
+				if err != nil {
+					klog.Warningf("Error detecting the cluster IP family, using %s for the container credentials full URI: %v", ipFamily, err)
+				} else {
+					ipFamily = detected
+				}
+			}
+		}
+		*containerCredentialsFullUri, err = containercredentials.DefaultFullUri(ipFamily)
+		if err != nil {
+			klog.Fatalf("Invalid container-credentials-ip-family: %v", err)
+		}
+		klog.Infof("Using container credentials full URI %s for %s clusters", *containerCredentialsFullUri, ipFamily)
+	}
+	var informerFactories []informers.SharedInformerFactory
+	var saInformers []v1.ServiceAccountInformer
+	if len(*watchNamespaces) == 0 {
+		informerFactories = append(informerFactories, informers.NewSharedInformerFactory(clientset, resyncPeriod))
+	}
+	for _, ns := range *watchNamespaces {
+		informerFactories = append(informerFactories, informers.NewSharedInformerFactoryWithOptions(clientset, resyncPeriod, informers.WithNamespace(ns)))
+	}
+	if *serviceAccountCacheMode == "informer" {
+		for _, informerFactory := range informerFactories {
+			saInformers = append(saInformers, informerFactory.Core().V1().ServiceAccounts())
+		}
+		if len(*watchNamespaces) > 0 {
+			klog.Infof("Watching service accounts in namespaces %s", strings.Join(*watchNamespaces, ", "))
+		}
+	}
+
+	var namespaceInformer v1.NamespaceInformer
+	var namespaceLister corelisters.NamespaceLister
+	// Namespace labels are matched by the namespace label selector and by the
+	// namespace selectors of container credentials identities, and namespace
+	// annotations give the default audience of their service accounts
+	if *namespaceLabelSelector != "" || containerCredentialsConfigSource != "" || *namespaceAudience {
+		// Namespaces are cluster scoped, any of the factories can provide the informer
+		namespaceInformer = informerFactories[0].Core().V1().Namespaces()
+		namespaceLister = namespaceInformer.Lister()
+	}
+
+	var namespaceFilter func(namespace string) bool
+	if len(*watchNamespaces) > 0 || *namespaceLabelSelector != "" {
+		watched := sets.New(*watchNamespaces...)
+		var selector labels.Selector
+		if *namespaceLabelSelector != "" {
+			selector, err = labels.Parse(*namespaceLabelSelector)
+			if err != nil {
+				klog.Fatalf("Error parsing namespace label selector: %v", err.Error())
+			}
+		}
+		namespaceFilter = func(namespace string) bool {
+			if watched.Len() > 0 && !watched.Has(namespace) {
+				return false
+			}
+			if selector == nil {
+				return true
+			}
+			ns, err := namespaceLister.Get(namespace)
+			if err != nil {
+				return false
+			}
+			return selector.Matches(labels.Set(ns.Labels))
+		}
+	}
 
 	var cmInformer v1.ConfigMapInformer
 	var nsInformerFactory informers.SharedInformerFactory
 	if *watchConfigMap {
-		klog.Infof("Watching ConfigMap pod-identity-webhook in %s namespace", *namespaceName)
-		nsInformerFactory = informers.NewSharedInformerFactoryWithOptions(clientset, *resyncPeriod, informers.WithNamespace(*namespaceName))
+		klog.Infof("Watching ConfigMap %s in %s namespace", *configMapName, *namespaceName)
+		nsInformerFactory = informers.NewSharedInformerFactoryWithOptions(clientset, resyncPeriod, informers.WithNamespace(*namespaceName))
 		cmInformer = nsInformerFactory.Core().V1().ConfigMaps()
 	}
 
-	saInformer := informerFactory.Core().V1().ServiceAccounts()
+	var containerCredentialsCMInformer v1.ConfigMapInformer
+	var containerCredentialsCMName string
+	var containerCredentialsInformerFactory informers.SharedInformerFactory
+	if *watchContainerCredentialsConfigMap != "" {
+		containerCredentialsCMNamespace := *namespaceName
+		containerCredentialsCMName = *watchContainerCredentialsConfigMap
+		if namespace, name, ok := strings.Cut(containerCredentialsCMName, "/"); ok {
+			containerCredentialsCMNamespace, containerCredentialsCMName = namespace, name
+		}
+		klog.Infof("Watching container credentials config ConfigMap %s in %s namespace", containerCredentialsCMName, containerCredentialsCMNamespace)
+		containerCredentialsInformerFactory = informers.NewSharedInformerFactoryWithOptions(clientset, resyncPeriod,
+			informers.WithNamespace(containerCredentialsCMNamespace),
+			informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+				options.FieldSelector = fields.OneTermEqualSelector("metadata.name", containerCredentialsCMName).String()
+			}))
+		containerCredentialsCMInformer = containerCredentialsInformerFactory.Core().V1().ConfigMaps()
+	}
 
-	*tokenExpiration = pkg.ValidateMinTokenExpiration(*tokenExpiration)
+	var roleAliases *cache.RoleAliases
+	var roleAliasesInformerFactory informers.SharedInformerFactory
+	if *roleAliasesConfigMap != "" {
+		roleAliasesNamespace, roleAliasesName := *namespaceName, *roleAliasesConfigMap
+		if namespace, name, ok := strings.Cut(roleAliasesName, "/"); ok {
+			roleAliasesNamespace, roleAliasesName = namespace, name
+		}
+		klog.Infof("Watching role aliases ConfigMap %s in %s namespace", roleAliasesName, roleAliasesNamespace)
+		roleAliasesInformerFactory = informers.NewSharedInformerFactoryWithOptions(clientset, resyncPeriod,
+			informers.WithNamespace(roleAliasesNamespace),
+			informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+				options.FieldSelector = fields.OneTermEqualSelector("metadata.name", roleAliasesName).String()
+			}))
+		roleAliases = cache.NewRoleAliases()
+		if err := roleAliases.WatchConfigMap(roleAliasesInformerFactory.Core().V1().ConfigMaps(), roleAliasesName); err != nil {
+			klog.Fatalf("Error watching ConfigMap %v: %v", *roleAliasesConfigMap, err)
+		}
+	}
 
-	var identity ec2metadata.EC2InstanceIdentityDocument
-	var composeRoleArnCache cache.ComposeRoleArn
-	if *composeRoleArn {
-		sess, err := session.NewSession()
-		if err != nil {
-			klog.Fatalf("Error creating session: %v", err.Error())
+	if expiration := pkg.ValidateTokenExpiration(*tokenExpiration); expiration != *tokenExpiration {
+		klog.Warningf("token-expiration %d is out of range, using %d seconds", *tokenExpiration, expiration)
+		*tokenExpiration = expiration
+	}
+	if *tokenExpirationJitter < 0 {
+		klog.Fatalf("Invalid token-expiration-jitter %d, expected a number of seconds >= 0", *tokenExpirationJitter)
+	}
+	if *eventWorkers < 0 {
+		klog.Fatalf("Invalid service-account-event-workers %d, expected a number >= 0", *eventWorkers)
+	}
+	if *containerCredentialsTokenExpiration != 0 {
+		if expiration := pkg.ValidateTokenExpiration(*containerCredentialsTokenExpiration); expiration != *containerCredentialsTokenExpiration {
+			klog.Warningf("container-credentials-token-expiration %d is out of range, using %d seconds", *containerCredentialsTokenExpiration, expiration)
+			*containerCredentialsTokenExpiration = expiration
 		}
+	}
 
-		metadataClient := ec2metadata.New(sess)
-		identity, err = metadataClient.GetInstanceIdentityDocument()
-		if err != nil {
-			klog.Fatalf("Error getting instance identity document: %v", err.Error())
+	var annotationPrefixes []string
+	for _, prefix := range strings.Split(*annotationPrefix, ",") {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			annotationPrefixes = append(annotationPrefixes, prefix)
 		}
+	}
+	if len(annotationPrefixes) == 0 {
+		klog.Fatalf("Invalid --annotation-prefix %q, at least one prefix is required", *annotationPrefix)
+	}
 
-		region := identity.Region
-		var partition string
-		switch {
-		case strings.HasPrefix(region, "cn-"):
-			partition = "aws-cn"
-		case strings.HasPrefix(region, "us-gov-"):
-			partition = "aws-us-gov"
-		case strings.HasPrefix(region, "us-iso-"):
-			partition = "aws-iso"
-		case strings.HasPrefix(region, "us-isob-"):
-			partition = "aws-iso-b"
-		default:
-			partition = "aws"
+	if err := pkg.SetRoleARNPattern(*roleArnPattern); err != nil {
+		klog.Fatalf("Invalid role-arn-pattern: %v", err)
+	}
+
+	var namespaceAnnotations func(namespace string) (map[string]string, error)
+	if *namespaceAudience {
+		namespaceAnnotations = func(namespace string) (map[string]string, error) {
+			ns, err := namespaceLister.Get(namespace)
+			if err != nil {
+				return nil, err
+			}
+			return ns.Annotations, nil
+		}
+	}
+
+	var composeRoleArnCache cache.ComposeRoleArn
+	if *composeRoleArn {
+		identity := &accountIdentity{
+			AccountID: *awsAccountID,
+			Partition: *awsPartition,
+			Region:    *region,
+		}
+		// Only look up the values missing from the flags, which is not
+		// possible e.g. on Fargate
+		if *awsAccountID == "" || *awsPartition == "" {
+			identity, err = lookupAccountIdentity(*region)
+			if err != nil {
+				klog.Fatalf("Error looking up the account of composed role ARNs, set aws-account-id and aws-partition when neither the instance metadata nor STS are reachable: %v", err)
+			}
+			if *awsAccountID != "" {
+				identity.AccountID = *awsAccountID
+			}
+			if *awsPartition != "" {
+				identity.Partition = *awsPartition
+			}
+		}
+		if !pkg.ValidateAccountID(identity.AccountID) {
+			klog.Fatalf("Invalid AWS account ID %q, expected 12 digits", identity.AccountID)
 		}
 
 		composeRoleArnCache = cache.ComposeRoleArn{
 			Enabled: true,
 
 			AccountID: identity.AccountID,
-			Partition: partition,
+			Partition: identity.Partition,
 			Region:    identity.Region,
 		}
+		klog.Infof("Composing role ARNs in account %s of partition %s", identity.AccountID, identity.Partition)
+	} else if *awsAccountID != "" || *awsPartition != "" {
+		klog.Warningf("aws-account-id and aws-partition are ignored without compose-role-arn")
+	}
 
+	configMapSelector, err := labels.Parse(*configMapLabelSelector)
+	if err != nil {
+		klog.Fatalf("Error parsing ConfigMap label selector: %v", err.Error())
+	}
+
+	fetchBackoff := retry.DefaultBackoff
+	fetchBackoff.Duration = *fetchBackoffDuration
+	fetchBackoff.Steps = *fetchBackoffSteps
+
+	var recorder record.EventRecorder
+	if *emitEvents {
+		eventBroadcaster := record.NewBroadcaster()
+		eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
+		defer eventBroadcaster.Shutdown()
+		recorder = eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "pod-identity-webhook"})
+	}
+
+	reconcileConfig := cache.ReconcileConfig{
+		Interval:   *reconcileInterval,
+		Namespaces: *watchNamespaces,
+	}
+	if *watchConfigMap {
+		reconcileConfig.ConfigMapNamespace = *namespaceName
+	}
+
+	if *tenantFetchQPS > 0 {
+		if *tenantFetchKey != cache.TenantKeyNamespace && *tenantFetchKey != cache.TenantKeyServiceAccount {
+			klog.Fatalf("Invalid service-account-fetch-tenant-key %q, must be %q or %q", *tenantFetchKey, cache.TenantKeyNamespace, cache.TenantKeyServiceAccount)
+		}
+		if *tenantFetchBurst < 1 {
+			klog.Fatalf("Invalid service-account-fetch-tenant-burst %d, must be at least 1", *tenantFetchBurst)
+		}
+	}
+
+	var saCache cache.ServiceAccountCache
+	if *serviceAccountCacheMode == "lru" {
+		klog.Infof("Fetching service accounts on demand, caching up to %d for %s", *serviceAccountCacheSize, *serviceAccountCacheTTL)
+		saCache = cache.NewLRU(
+			*audience,
+			annotationPrefixes[0],
+			*regionalSTS,
+			*tokenExpiration,
+			composeRoleArnCache,
+			clientset.CoreV1(),
+			*serviceAccountCacheSize,
+			*serviceAccountCacheTTL,
+			cache.WithSkipInvalidRoleARN(*skipInvalidRoleArn),
+			cache.WithFallbackAnnotationPrefixes(annotationPrefixes[1:]...),
+			cache.WithNegativeCacheTTL(*negativeCacheTTL),
+			cache.WithFetchTimeout(*fetchTimeout),
+			cache.WithFetchBackoff(fetchBackoff),
+			cache.WithTenantFetchRateLimit(*tenantFetchKey, *tenantFetchQPS, *tenantFetchBurst),
+			cache.WithRoleAliases(roleAliases),
+			cache.WithNamespaceAnnotations(namespaceAnnotations),
+		)
+	} else {
+		saCache = cache.New(
+			*audience,
+			annotationPrefixes[0],
+			*regionalSTS,
+			*tokenExpiration,
+			saInformers,
+			cmInformer,
+			composeRoleArnCache,
+			clientset.CoreV1(),
+			cache.WithSkipInvalidRoleARN(*skipInvalidRoleArn),
+			cache.WithFallbackAnnotationPrefixes(annotationPrefixes[1:]...),
+			cache.WithAnnotatedOnly(*annotatedServiceAccountsOnly),
+			cache.WithConfigMapName(*configMapName),
+			cache.WithConfigMapSelector(configMapSelector),
+			cache.WithNegativeCacheTTL(*negativeCacheTTL),
+			cache.WithFetchWorkers(*fetchWorkers),
+			cache.WithEventWorkers(*eventWorkers),
+			cache.WithFetchTimeout(*fetchTimeout),
+			cache.WithFetchBackoff(fetchBackoff),
+			cache.WithTenantFetchRateLimit(*tenantFetchKey, *tenantFetchQPS, *tenantFetchBurst),
+			cache.WithEventRecorder(recorder),
+			cache.WithReconciliation(clientset, reconcileConfig),
+			cache.WithRoleAliases(roleAliases),
+			cache.WithNamespaceAnnotations(namespaceAnnotations),
+		)
+	}
+	var snapshotter cache.Snapshotter
+	if *snapshotPath != "" {
+		var ok bool
+		if snapshotter, ok = saCache.(cache.Snapshotter); !ok {
+			klog.Warningf("Ignoring cache-snapshot-path, snapshots are not supported in %s cache mode", *serviceAccountCacheMode)
+		} else if err := snapshotter.LoadSnapshot(*snapshotPath, *snapshotMaxAge); os.IsNotExist(err) {
+			klog.Infof("No cache snapshot found at %s", *snapshotPath)
+		} else if err != nil {
+			klog.Warningf("Not using cache snapshot: %v", err)
+		}
 	}
 
-	saCache := cache.New(
-		*audience,
-		*annotationPrefix,
-		*regionalSTS,
-		*tokenExpiration,
-		saInformer,
-		cmInformer,
-		composeRoleArnCache,
-		clientset.CoreV1(),
-	)
 	stop := make(chan struct{})
-	informerFactory.Start(stop)
+	for _, informerFactory := range informerFactories {
+		informerFactory.Start(stop)
+	}
 
 	if *watchConfigMap {
 		nsInformerFactory.Start(stop)
 	}
+	if roleAliasesInformerFactory != nil {
+		roleAliasesInformerFactory.Start(stop)
+	}
 
 	saCache.Start(stop)
-	defer close(stop)
+	// Stop the informers and let in-flight service account fetches complete
+	// while the servers shut down
+	go func() {
+		<-signalHandlerCtx.Done()
+		close(stop)
+	}()
 
+	containerCredentialsOpts := []containercredentials.FileConfigOpt{
+		containercredentials.WithTokenExpiration(*containerCredentialsTokenExpiration),
+		containercredentials.WithRelativeUri(*containerCredentialsRelativeUri),
+	}
+	if namespaceLister != nil {
+		containerCredentialsOpts = append(containerCredentialsOpts, containercredentials.WithNamespaceLabels(func(namespace string) (map[string]string, error) {
+			ns, err := namespaceLister.Get(namespace)
+			if err != nil {
+				return nil, err
+			}
+			return ns.Labels, nil
+		}))
+	}
 	containerCredentialsConfig := containercredentials.NewFileConfig(
 		*containerCredentialsAudience,
 		*containerCredentialsMountPath,
 		*containerCredentialsVolumeName,
 		*containerCredentialsTokenPath,
-		*containerCredentialsFullUri)
+		*containerCredentialsFullUri,
+		containerCredentialsOpts...)
 	if watchContainerCredentialsConfig != nil && *watchContainerCredentialsConfig != "" {
 		klog.Infof("Watching container credentials config file %s", *watchContainerCredentialsConfig)
 		err = containerCredentialsConfig.StartWatcher(signalHandlerCtx, *watchContainerCredentialsConfig)
@@ -206,22 +746,107 @@ func main() {
 			klog.Fatalf("Error starting watcher on file %v: %v", *watchContainerCredentialsConfig, err.Error())
 		}
 	}
+	if containerCredentialsCMInformer != nil {
+		err = containerCredentialsConfig.StartConfigMapWatcher(containerCredentialsCMInformer, containerCredentialsCMName)
+		if err != nil {
+			klog.Fatalf("Error watching ConfigMap %v: %v", *watchContainerCredentialsConfigMap, err.Error())
+		}
+		containerCredentialsInformerFactory.Start(stop)
+	}
+	if *containerCredentialsConfigURL != "" {
+		client, err := httppoller.NewClient(*containerCredentialsConfigCAFile, *containerCredentialsConfigCertFile, *containerCredentialsConfigKeyFile, *containerCredentialsConfigPollInterval)
+		if err != nil {
+			klog.Fatalf("Error creating client for %v: %v", *containerCredentialsConfigURL, err.Error())
+		}
+		klog.Infof("Polling container credentials config from %s every %s", *containerCredentialsConfigURL, *containerCredentialsConfigPollInterval)
+		containerCredentialsConfig.StartPoller(signalHandlerCtx, *containerCredentialsConfigURL, *containerCredentialsConfigPollInterval, client)
+	}
+
+	extraEnv, err := handler.NewExtraEnv(*extraEnvVars)
+	if err != nil {
+		klog.Fatalf("Invalid extra-env: %v", err)
+	}
+	if *extraEnvFile != "" {
+		klog.Infof("Watching extra env file %s", *extraEnvFile)
+		if err := filesystem.NewFileWatcher("extra-env", *extraEnvFile, extraEnv.Load).Watch(signalHandlerCtx); err != nil {
+			klog.Fatalf("Error watching extra env file %s: %v", *extraEnvFile, err)
+		}
+	}
+
+	var auditLogger *auditlog.Logger
+	if *auditLogFile != "" && *auditLogGroup != "" {
+		klog.Fatalf("Only one of audit-log-file and audit-log-cloudwatch-log-group can be set")
+	}
+	if *auditLogFile != "" || *auditLogGroup != "" {
+		writer, err := newAuditLogWriter(*auditLogFile, *auditLogGroup, *auditLogStream, *region)
+		if err != nil {
+			klog.Fatalf("Error creating audit log: %v", err)
+		}
+		auditLogger = auditlog.NewLogger(writer, auditlog.DefaultBufferSize)
+		auditLogger.Start()
+	}
 
 	mod := handler.NewModifier(
-		handler.WithAnnotationDomain(*annotationPrefix),
+		handler.WithAnnotationDomain(annotationPrefixes[0]),
+		handler.WithFallbackAnnotationDomains(annotationPrefixes[1:]...),
 		handler.WithMountPath(*mountPath),
+		handler.WithTokenVolumeName(*tokenVolumeName),
+		handler.WithTokenFileName(*tokenFileName),
+		handler.WithTokenExpirationJitter(*tokenExpirationJitter),
+		handler.WithTokenWaitInitContainer(*tokenWaitImage, *tokenWaitTimeout),
 		handler.WithServiceAccountCache(saCache),
 		handler.WithContainerCredentialsConfig(containerCredentialsConfig),
+		handler.WithCredentialMethodPrecedence(*credentialMethodPrecedence),
+		handler.WithContainerCredentialsURIMode(*containerCredentialsURIMode),
+		handler.WithEnvVarPosition(*envVarPosition),
 		handler.WithRegion(*region),
+		handler.WithExtraEnv(extraEnv),
+		handler.WithSDKUAAppID(*sdkUAAppID),
+		handler.WithUseFIPSEndpoint(*useFIPSEndpoint),
+		handler.WithRejectInvalidRoleARN(*rejectInvalidRoleArn),
+		handler.WithReinvocationMarker(*reinvocationMarker),
+		handler.WithAuditLogger(auditLogger),
 		handler.WithSALookupGraceTime(*saLookupGracePeriod),
+		handler.WithSynchronousSALookup(*serviceAccountCacheMode == "lru"),
+		handler.WithFailOnMissingServiceAccount(*failOnMissingServiceAccount),
+		handler.WithSkipInitContainers(*skipInitContainers),
+		handler.WithSkipTokenVolume(*skipTokenVolume),
+		handler.WithInjectAWSConfigFile(*injectAWSConfigFile),
+		handler.WithNativeSidecars(*nativeSidecars),
+		handler.WithVersion(webhookVersion),
+		handler.WithEventRecorder(recorder),
+		handler.WithMaxRequestBodyBytes(*maxRequestBodyBytes),
+		handler.WithNamespaceFilter(namespaceFilter),
 	)
 
-	addr := fmt.Sprintf(":%d", *port)
+	if *legacyLatencyMetrics {
+		handler.EnableLegacyLatencyMetrics()
+	}
+
+	addrs := []string{fmt.Sprintf(":%d", *port)}
+	if len(*bindAddresses) > 0 {
+		addrs = nil
+		for _, ip := range *bindAddresses {
+			addrs = append(addrs, net.JoinHostPort(ip.String(), strconv.Itoa(*port)))
+		}
+	}
 	metricsAddr := fmt.Sprintf(":%d", *metricsPort)
+	if *metricsBindAddress != "" {
+		if _, _, err := net.SplitHostPort(*metricsBindAddress); err != nil {
+			klog.Fatalf("Invalid metrics-bind-address: %v", err)
+		}
+		metricsAddr = *metricsBindAddress
+	}
+	if *debugAddress != "" {
+		if _, _, err := net.SplitHostPort(*debugAddress); err != nil {
+			klog.Fatalf("Invalid debug-bind-address: %v", err)
+		}
+	}
 	mux := http.NewServeMux()
 
 	baseHandler := handler.Apply(
 		http.HandlerFunc(mod.Handle),
+		handler.MaxInFlight(*maxConcurrentAdmissions),
 		handler.InstrumentRoute(),
 		handler.Logging(),
 	)
@@ -230,17 +855,106 @@ func main() {
 		fmt.Fprintf(w, "ok")
 	})
 
+	var oidcServer *oidc.Server
+	if *oidcIssuer != "" {
+		oidcServer, err = oidc.NewServer(*oidcIssuer)
+		if err != nil {
+			klog.Fatalf("Invalid oidc-issuer: %v", err)
+		}
+		if *oidcSigningKeyFile != "" {
+			klog.Infof("Watching service account signing keys file %s", *oidcSigningKeyFile)
+			if err := filesystem.NewFileWatcher("oidc-signing-keys", *oidcSigningKeyFile, oidcServer.Load).Watch(signalHandlerCtx); err != nil {
+				klog.Fatalf("Error watching service account signing keys file %s: %v", *oidcSigningKeyFile, err)
+			}
+		} else {
+			keySecretNamespace, keySecretName, keySecretKey := *namespaceName, *oidcSigningKeySecret, "sa.pub"
+			if name, key, ok := strings.Cut(keySecretName, "#"); ok {
+				keySecretName, keySecretKey = name, key
+			}
+			if namespace, name, ok := strings.Cut(keySecretName, "/"); ok {
+				keySecretNamespace, keySecretName = namespace, name
+			}
+			klog.Infof("Watching service account signing keys in key %s of Secret %s in %s namespace", keySecretKey, keySecretName, keySecretNamespace)
+			keySecretInformerFactory := informers.NewSharedInformerFactoryWithOptions(clientset, resyncPeriod,
+				informers.WithNamespace(keySecretNamespace),
+				informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+					options.FieldSelector = fields.OneTermEqualSelector("metadata.name", keySecretName).String()
+				}))
+			if err := oidcServer.WatchSecret(keySecretInformerFactory.Core().V1().Secrets(), keySecretName, keySecretKey); err != nil {
+				klog.Fatalf("Error watching Secret %v: %v", *oidcSigningKeySecret, err)
+			}
+			keySecretInformerFactory.Start(stop)
+		}
+		// Served with the TLS serving cert of the webhook
+		oidcServer.Register(mux)
+	}
+
 	metricsMux := http.NewServeMux()
 	metricsMux.Handle("/metrics", promhttp.Handler())
+	registerVersion(metricsMux)
+
+	debugMux := http.NewServeMux()
+	if *enablePprof {
+		registerProfiling(debugMux)
+	}
 
 	// Register debug endpoint only if flag is enabled
 	if *debug {
 		debugger := cachedebug.Dumper{
-			Cache: saCache,
+			Cache:                      saCache,
+			CredentialMethodPrecedence: *credentialMethodPrecedence,
 		}
-		// Reuse metrics port to avoid exposing a new port
-		metricsMux.HandleFunc("/debug/alpha/cache", debugger.Handle)
-		metricsMux.HandleFunc("/debug/alpha/cache/clear", debugger.Clear)
+		if containerCredentialsConfigSource != "" {
+			debugger.ContainerCredentialsConfig = containerCredentialsConfig
+		}
+		debugMux.HandleFunc("/debug/alpha/cache", debugger.Handle)
+		debugMux.HandleFunc("/debug/alpha/cache/all", debugger.HandleAll)
+		debugMux.HandleFunc("/debug/alpha/cache/effective", debugger.HandleEffective)
+		debugMux.HandleFunc("/debug/alpha/simulate", mod.Simulate)
+		debugMux.HandleFunc("/debug/alpha/selftest", mod.SelfTest)
+		debugMux.HandleFunc("/debug/alpha/cache/clear", debugger.Clear)
+		configDump := &configDumper{
+			config: effectiveConfig{
+				AnnotationPrefixes:     annotationPrefixes,
+				DefaultAudience:        *audience,
+				DefaultTokenExpiration: *tokenExpiration,
+				ContainerCredentials: containerCredentialsSettings{
+					Location:        containerCredentialsConfigSource,
+					Audience:        *containerCredentialsAudience,
+					MountPath:       *containerCredentialsMountPath,
+					VolumeName:      *containerCredentialsVolumeName,
+					TokenPath:       *containerCredentialsTokenPath,
+					TokenExpiration: *containerCredentialsTokenExpiration,
+					FullURI:         *containerCredentialsFullUri,
+					RelativeURI:     *containerCredentialsRelativeUri,
+				},
+			},
+			flags:       flag.CommandLine,
+			commandLine: commandLineFlags,
+			configFile:  flagsFile,
+		}
+		switch containerCredentialsConfigSource {
+		case "":
+		case *watchContainerCredentialsConfig:
+			configDump.config.ContainerCredentials.Source = "file"
+		case *watchContainerCredentialsConfigMap:
+			configDump.config.ContainerCredentials.Source = "configmap"
+		default:
+			configDump.config.ContainerCredentials.Source = "url"
+		}
+		switch {
+		case *listenUnixSocket != "":
+			configDump.config.Certificate = certificateSettings{Source: "none"}
+		case *inCluster && *acmPCAArn != "":
+			configDump.config.Certificate = certificateSettings{Source: "acm-pca", ACMPCAArn: *acmPCAArn, KeyType: *tlsKeyType, Secret: *tlsSecret, LeaderElect: *leaderElect}
+		case *inCluster:
+			configDump.config.Certificate = certificateSettings{Source: "csr", Secret: *tlsSecret, LeaderElect: *leaderElect, AutoApprove: *csrAutoApprove}
+		case *watchTLSSecret != "":
+			configDump.config.Certificate = certificateSettings{Source: "tls-secret", Secret: *watchTLSSecret}
+		default:
+			configDump.config.Certificate = certificateSettings{Source: "files", CertFile: *tlsCertFile, KeyFile: *tlsKeyFile}
+		}
+		debugMux.HandleFunc("/debug/alpha/config", configDump.Handle)
 		// Expose other debug paths
 		mux.Handle("/debug/alpha/deny", handler.Apply(
 			http.HandlerFunc(debugger.Deny),
@@ -254,9 +968,31 @@ func main() {
 		))
 	}
 
+	var debugHandler http.Handler = debugMux
+	if *debugAuthorization {
+		debugHandler = handler.Apply(debugHandler, handler.Authorize(clientset))
+	}
+	var debugServer *http.Server
+	if (*debug || *enablePprof) && *debugAddress == "" {
+		// Reuse metrics port to avoid exposing a new port
+		metricsMux.Handle("/debug/", debugHandler)
+	} else if *debug || *enablePprof {
+		debugServer = &http.Server{
+			Addr:              *debugAddress,
+			Handler:           debugHandler,
+			ReadHeaderTimeout: *serverReadHeaderTimeout,
+			ReadTimeout:       *serverReadTimeout,
+			WriteTimeout:      *serverWriteTimeout,
+			IdleTimeout:       *serverIdleTimeout,
+		}
+	}
+
 	tlsConfig := &tls.Config{}
 
-	if *inCluster {
+	if *listenUnixSocket != "" {
+		// TLS is terminated in front of the socket
+		tlsConfig = nil
+	} else if *inCluster {
 		csr := &x509.CertificateRequest{
 			Subject: pkix.Name{CommonName: fmt.Sprintf("%s.%s.svc", *serviceName, *namespaceName)},
 			DNSNames: []string{
@@ -265,31 +1001,118 @@ func main() {
 				fmt.Sprintf("%s.%s.svc", *serviceName, *namespaceName),
 				fmt.Sprintf("%s.%s.svc.cluster.local", *serviceName, *namespaceName),
 			},
-			/*
-				// TODO: SANIPs for service IP, but not pod IP
-				//IPAddresses: nil,
-			*/
+			IPAddresses: *tlsSANIPs,
 		}
+		csr.DNSNames = append(csr.DNSNames, *tlsSANDNSNames...)
 
-		certManager, err := cert.NewServerCertificateManager(
-			clientset,
-			*namespaceName,
-			*tlsSecret,
-			csr,
-		)
-		if err != nil {
-			klog.Fatalf("failed to initialize certificate manager: %v", err)
+		noCertificateErr := fmt.Errorf("no serving certificate available for the webhook, is the CSR approved?")
+		newCertManager := func() (certificate.Manager, error) {
+			return cert.NewServerCertificateManager(
+				clientset,
+				*namespaceName,
+				*tlsSecret,
+				*csrSignerName,
+				csr,
+			)
+		}
+		if *acmPCAArn != "" {
+			caArn, err := arn.Parse(*acmPCAArn)
+			if err != nil {
+				klog.Fatalf("Invalid ACM Private CA ARN %q: %v", *acmPCAArn, err)
+			}
+			sess, err := session.NewSession(aws.NewConfig().WithRegion(caArn.Region))
+			if err != nil {
+				klog.Fatalf("Error creating session: %v", err.Error())
+			}
+			acmPCAClient := acmpca.New(sess)
+			newCertManager = func() (certificate.Manager, error) {
+				return cert.NewACMPCACertificateManager(
+					acmPCAClient,
+					cert.ACMPCAConfig{
+						CertificateAuthorityARN: *acmPCAArn,
+						SigningAlgorithm:        *acmPCASigningAlgorithm,
+						TemplateARN:             *acmPCATemplateArn,
+						ValidityDays:            *acmPCAValidityDays,
+						KeyType:                 *tlsKeyType,
+						RenewalFraction:         *certRenewalFraction,
+						RenewalJitter:           *certRenewalJitter,
+					},
+					cert.NewSecretCertStore(*namespaceName, *tlsSecret, clientset),
+					csr,
+				)
+			}
+			noCertificateErr = fmt.Errorf("no serving certificate available for the webhook, has ACM Private CA %s issued it?", *acmPCAArn)
+		} else if *csrAutoApprove {
+			username, err := cert.CurrentUsername(signalHandlerCtx, clientset)
+			if err != nil {
+				klog.Fatalf("Error getting the username of the webhook: %v", err)
+			}
+			csrInformerFactory := informers.NewSharedInformerFactoryWithOptions(clientset, resyncPeriod,
+				informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+					options.FieldSelector = fields.OneTermEqualSelector("spec.signerName", *csrSignerName).String()
+				}))
+			_, err = cert.NewCSRApprover(clientset, csrInformerFactory.Certificates().V1().CertificateSigningRequests(), username, *csrSignerName, csr)
+			if err != nil {
+				klog.Fatalf("Error watching CSRs: %v", err)
+			}
+			klog.Infof("Approving CSRs created by %s for signer %s", username, *csrSignerName)
+			csrInformerFactory.Start(stop)
 		}
-		certManager.Start()
-		defer certManager.Stop()
 
-		tlsConfig.GetCertificate = func(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
-			certificate := certManager.Current()
-			if certificate == nil {
-				return nil, fmt.Errorf("no serving certificate available for the webhook, is the CSR approved?")
+		if *leaderElect {
+			identity, err := os.Hostname()
+			if err != nil {
+				klog.Fatalf("Error getting hostname: %v", err)
 			}
-			return certificate, nil
+			// All replicas serve the certificate stored in the TLS Secret
+			// by the leader
+			tlsSecretInformerFactory := informers.NewSharedInformerFactoryWithOptions(clientset, resyncPeriod,
+				informers.WithNamespace(*namespaceName),
+				informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+					options.FieldSelector = fields.OneTermEqualSelector("metadata.name", *tlsSecret).String()
+				}))
+			watcher, err := cert.NewSecretCertWatcher(tlsSecretInformerFactory.Core().V1().Secrets(), *tlsSecret)
+			if err != nil {
+				klog.Fatalf("Error watching TLS Secret %v: %v", *tlsSecret, err.Error())
+			}
+			tlsSecretInformerFactory.Start(stop)
+			go cert.RunLeaderElected(signalHandlerCtx, clientset, *namespaceName, *tlsSecret, identity, newCertManager)
+
+			tlsConfig.GetCertificate = watcher.GetCertificate
+		} else {
+			certManager, err := newCertManager()
+			if err != nil {
+				klog.Fatalf("failed to initialize certificate manager: %v", err)
+			}
+			certManager.Start()
+			defer certManager.Stop()
+
+			tlsConfig.GetCertificate = func(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+				certificate := certManager.Current()
+				if certificate == nil {
+					return nil, noCertificateErr
+				}
+				return certificate, nil
+			}
+		}
+	} else if *watchTLSSecret != "" {
+		tlsSecretNamespace, tlsSecretName := *namespaceName, *watchTLSSecret
+		if namespace, name, ok := strings.Cut(tlsSecretName, "/"); ok {
+			tlsSecretNamespace, tlsSecretName = namespace, name
+		}
+		klog.Infof("Watching TLS Secret %s in %s namespace", tlsSecretName, tlsSecretNamespace)
+		tlsSecretInformerFactory := informers.NewSharedInformerFactoryWithOptions(clientset, resyncPeriod,
+			informers.WithNamespace(tlsSecretNamespace),
+			informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+				options.FieldSelector = fields.OneTermEqualSelector("metadata.name", tlsSecretName).String()
+			}))
+		watcher, err := cert.NewSecretCertWatcher(tlsSecretInformerFactory.Core().V1().Secrets(), tlsSecretName)
+		if err != nil {
+			klog.Fatalf("Error watching TLS Secret %v: %v", *watchTLSSecret, err.Error())
 		}
+		tlsSecretInformerFactory.Start(stop)
+
+		tlsConfig.GetCertificate = watcher.GetCertificate
 	} else {
 		watcher, err := certwatcher.New(*tlsCertFile, *tlsKeyFile)
 		if err != nil {
@@ -305,32 +1128,196 @@ func main() {
 		tlsConfig.GetCertificate = watcher.GetCertificate
 	}
 
+	if *webhookConfigName != "" {
+		caBundle := cert.CABundleFromCertificate(func() (*tls.Certificate, error) {
+			return tlsConfig.GetCertificate(nil)
+		})
+		if *webhookCABundleFile != "" {
+			caBundle = cert.CABundleFromFile(*webhookCABundleFile)
+		}
+		go cert.NewCABundleUpdater(clientset, *webhookConfigName, caBundle, *webhookCABundleSyncPeriod).Run(signalHandlerCtx)
+	}
+
+	readinessChecks := []handler.HealthCheck{
+		{
+			Name: "shutdown",
+			Check: func() error {
+				if signalCtx.Err() != nil {
+					return fmt.Errorf("webhook is shutting down")
+				}
+				return nil
+			},
+		},
+		{
+			Name: "service-account-cache",
+			Check: func() error {
+				if !saCache.HasSynced() {
+					return fmt.Errorf("service account cache has not synced")
+				}
+				return nil
+			},
+		},
+	}
+	if tlsConfig != nil {
+		readinessChecks = append(readinessChecks, handler.HealthCheck{
+			Name: "serving-certificate",
+			Check: func() error {
+				certificate, err := tlsConfig.GetCertificate(nil)
+				if err != nil {
+					return err
+				}
+				if certificate == nil {
+					return fmt.Errorf("no serving certificate available")
+				}
+				return nil
+			},
+		})
+	}
+	if namespaceInformer != nil {
+		readinessChecks = append(readinessChecks, handler.HealthCheck{
+			Name: "namespace-informer",
+			Check: func() error {
+				if !namespaceInformer.Informer().HasSynced() {
+					return fmt.Errorf("namespace informer has not synced")
+				}
+				return nil
+			},
+		})
+	}
+	if containerCredentialsConfigSource != "" {
+		readinessChecks = append(readinessChecks, handler.HealthCheck{
+			Name: "container-credentials-config",
+			Check: func() error {
+				if !containerCredentialsConfig.Loaded() {
+					return fmt.Errorf("container credentials config %s has not been loaded", containerCredentialsConfigSource)
+				}
+				return nil
+			},
+		})
+	}
+	if roleAliases != nil {
+		readinessChecks = append(readinessChecks, handler.HealthCheck{
+			Name:  "role-aliases",
+			Check: roleAliases.Loaded,
+		})
+	}
+	if *extraEnvFile != "" {
+		readinessChecks = append(readinessChecks, handler.HealthCheck{
+			Name:  "extra-env",
+			Check: extraEnv.Loaded,
+		})
+	}
+	if oidcServer != nil {
+		readinessChecks = append(readinessChecks, handler.HealthCheck{
+			Name:  "oidc-signing-keys",
+			Check: oidcServer.Loaded,
+		})
+	}
+	mux.HandleFunc("/readyz", handler.HealthHandler(readinessChecks...))
+	mux.HandleFunc("/livez", handler.HealthHandler())
+
 	klog.Info("Creating server")
 	server := &http.Server{
-		Addr:      addr,
-		Handler:   mux,
-		TLSConfig: tlsConfig,
+		Addr:              addrs[0],
+		Handler:           mux,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: *serverReadHeaderTimeout,
+		ReadTimeout:       *serverReadTimeout,
+		WriteTimeout:      *serverWriteTimeout,
+		IdleTimeout:       *serverIdleTimeout,
 	}
 
 	handler.ShutdownFromContext(signalHandlerCtx, server, time.Duration(10)*time.Second)
 
 	metricsServer := &http.Server{
-		Addr:    metricsAddr,
-		Handler: metricsMux,
+		Addr:              metricsAddr,
+		Handler:           metricsMux,
+		ReadHeaderTimeout: *serverReadHeaderTimeout,
+		ReadTimeout:       *serverReadTimeout,
+		WriteTimeout:      *serverWriteTimeout,
+		IdleTimeout:       *serverIdleTimeout,
 	}
 
 	handler.ShutdownFromContext(signalHandlerCtx, metricsServer, time.Duration(10)*time.Second)
+	if debugServer != nil {
+		handler.ShutdownFromContext(signalHandlerCtx, debugServer, time.Duration(10)*time.Second)
+		go func() {
+			klog.Infof("Listening on %s for debugging handlers", *debugAddress)
+			if err := debugServer.ListenAndServe(); err != http.ErrServerClosed {
+				klog.Fatalf("Error listening: %q", err)
+			}
+		}()
+	}
 
-	go func() {
-		klog.Infof("Listening on %s", addr)
-		if err := server.ListenAndServeTLS("", ""); err != http.ErrServerClosed {
-			klog.Fatalf("Error listening: %q", err)
+	if *listenUnixSocket != "" {
+		listener, err := listenUnix(*listenUnixSocket)
+		if err != nil {
+			klog.Fatalf("Error listening on unix socket %s: %v", *listenUnixSocket, err)
 		}
-	}()
+		go func() {
+			klog.Infof("Listening on unix socket %s", *listenUnixSocket)
+			if err := server.Serve(listener); err != http.ErrServerClosed {
+				klog.Fatalf("Error listening: %q", err)
+			}
+		}()
+	} else {
+		// The server serves every listener, and closes them all on shutdown
+		for _, addr := range addrs {
+			listener, err := net.Listen("tcp", addr)
+			if err != nil {
+				klog.Fatalf("Error listening on %s: %v", addr, err)
+			}
+			go func(addr string) {
+				klog.Infof("Listening on %s", addr)
+				if err := server.ServeTLS(listener, "", ""); err != http.ErrServerClosed {
+					klog.Fatalf("Error listening: %q", err)
+				}
+			}(addr)
+		}
+	}
 
 	klog.Infof("Listening on %s for metrics", metricsAddr)
 	if err := metricsServer.ListenAndServe(); err != http.ErrServerClosed {
 		klog.Fatalf("Error listening: %q", err)
 	}
+	if snapshotter != nil {
+		if err := snapshotter.SaveSnapshot(*snapshotPath); err != nil {
+			klog.Errorf("Error saving cache snapshot to %s: %v", *snapshotPath, err)
+		} else {
+			klog.Infof("Saved cache snapshot to %s", *snapshotPath)
+		}
+	}
+	if auditLogger != nil {
+		if err := auditLogger.Close(); err != nil {
+			klog.Errorf("Error closing audit log: %v", err)
+		}
+	}
 	klog.Info("Graceflully closed")
 }
+
+// newJSONLogger returns a logger writing one JSON object per line to stderr.
+// Verbosity is left to klog, which only passes on the messages enabled by the
+// -v and -vmodule flags, so that they can be changed by reloading the config.
+func newJSONLogger() logr.Logger {
+	return funcr.NewJSON(func(obj string) {
+		fmt.Fprintln(os.Stderr, obj)
+	}, funcr.Options{
+		LogTimestamp: true,
+		Verbosity:    math.MaxInt32,
+	})
+}
+
+// registerProfiling exposes the pprof handlers on the given mux, and replaces
+// the default Go collector with one also exporting runtime/metrics.
+func registerProfiling(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	prometheus.Unregister(collectors.NewGoCollector())
+	prometheus.MustRegister(collectors.NewGoCollector(
+		collectors.WithGoCollections(collectors.GoRuntimeMemStatsCollection | collectors.GoRuntimeMetricsCollection),
+	))
+}
@@ -19,11 +19,76 @@ const (
 	AudienceAnnotation = "audience"
 	// Role ARN annotation
 	RoleARNAnnotation = "role-arn"
-	// A true/false value to add AWS_STS_REGIONAL_ENDPOINTS. Overrides any setting on the webhook
+	// An alias resolved to a role ARN with the role aliases ConfigMap, when the role ARN annotation is not set
+	RoleAliasAnnotation = "role-alias"
+	// A true/false value to add AWS_STS_REGIONAL_ENDPOINTS. Set on the service account or the pod, which takes precedence. Overrides any setting on the webhook
 	UseRegionalSTSAnnotation = "sts-regional-endpoints"
+	// A true/false value to add AWS_USE_FIPS_ENDPOINT, along with AWS_STS_REGIONAL_ENDPOINTS. Overrides any setting on the webhook
+	UseFIPSEndpointAnnotation = "use-fips-endpoint"
 	// Expiration in seconds for serviceAccountToken annotation
 	TokenExpirationAnnotation = "token-expiration"
 
 	// A comma-separated list of container names to skip adding environment variables and volumes to. Applies to `initContainers` and `containers`
 	SkipContainersAnnotation = "skip-containers"
+
+	// A true/false value to skip adding environment variables and volumes to all `initContainers`. Overrides any setting on the webhook
+	SkipInitContainersAnnotation = "skip-init-containers"
+
+	// A comma-separated list of the only container names to add environment variables and volumes to, as the inverse of SkipContainersAnnotation. Applies to `initContainers` and `containers`
+	InjectContainersAnnotation = "inject-containers"
+
+	// A true/false value to admit the pod without mutation, whatever its service account. Unlike skip-containers, applies to all containers
+	SkipPodIdentityAnnotation = "skip-pod-identity"
+
+	// A true/false value to deny admission of the pod when its service account is not found. Overrides any setting on the webhook
+	FailOnMissingServiceAccountAnnotation = "fail-on-missing-service-account"
+
+	// Which credential method is injected when the service account has both a role ARN and a container credentials identity. Overrides any setting on the webhook
+	CredentialMethodPrecedenceAnnotation = "credential-method-precedence"
+
+	// Which of the full and relative container credentials URI env variables are injected: full, relative or both. Overrides any setting on the webhook
+	ContainerCredentialsURIModeAnnotation = "container-credentials-uri-mode"
+
+	// A true/false value to only add the environment variables, and not the token volume and volumeMount, for pods projecting the token themselves. Overrides any setting on the webhook
+	SkipTokenVolumeAnnotation = "skip-token-volume"
+
+	// The application ID the AWS SDKs add to their User-Agent, injected as AWS_SDK_UA_APP_ID. Set on the service account or the pod, which takes precedence. Overrides any setting on the webhook
+	SDKUAAppIDAnnotation = "sdk-ua-app-id"
+
+	// A true/false value to not inject AWS_REGION and AWS_DEFAULT_REGION, for workloads resolving their region themselves. Set on the service account or the pod, which takes precedence
+	SkipRegionAnnotation = "skip-region"
+
+	// Where the environment variables are injected in the env of containers: append, prepend or before-reference. Overrides any setting on the webhook
+	EnvVarPositionAnnotation = "env-var-position"
+
+	// The file name of the projected token in its volume, for applications expecting a specific name under the mount path. Overrides any setting on the webhook
+	TokenFileNameAnnotation = "token-file-name"
+
+	// A true/false value to also inject an AWS config file with the role ARN and token file, and AWS_CONFIG_FILE, for SDKs and tools ignoring the env variables. Overrides any setting on the webhook
+	InjectAWSConfigFileAnnotation = "inject-aws-config-file"
+
+	// Set by the webhook to the content of the injected AWS config file, projected in the token volume with the downward API
+	AWSConfigAnnotation = "aws-config"
+
+	// Set by the webhook to the hash of the spec of the pods it mutated, so that reinvocations skip pods no other mutator changed since
+	InjectedAnnotation = "pod-identity-injected"
 )
+
+const (
+	// Pods with this label set to PodIdentityDisabled are admitted without mutation, whatever their service account
+	PodIdentityLabel = "pod-identity"
+	// The value of PodIdentityLabel opting a pod out of mutation
+	PodIdentityDisabled = "disabled"
+)
+
+// LookupAnnotation returns the value of the annotation with the given name and
+// the first of the given prefixes it is set with, along with its full key
+func LookupAnnotation(annotations map[string]string, name string, prefixes ...string) (key, value string, ok bool) {
+	for _, prefix := range prefixes {
+		key = prefix + "/" + name
+		if value, ok = annotations[key]; ok {
+			return key, value, true
+		}
+	}
+	return "", "", false
+}
@@ -0,0 +1,170 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+// Package auditlog records the admission decisions of the webhook to a
+// durable sink, e.g. for compliance teams needing a record of the identities
+// injected into pods.
+package auditlog
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// DefaultBufferSize is the default number of records buffered before
+	// records are dropped
+	DefaultBufferSize = 10000
+	// maxBatchSize is the maximum number of records written at once
+	maxBatchSize = 1000
+	// flushInterval is the maximum duration records are buffered for
+	flushInterval = time.Second
+)
+
+// Record is the audit record of the admission of a pod
+type Record struct {
+	Time           time.Time `json:"time"`
+	UID            string    `json:"uid"`
+	Namespace      string    `json:"namespace"`
+	Pod            string    `json:"pod"`
+	ServiceAccount string    `json:"serviceAccount"`
+	DryRun         bool      `json:"dryRun,omitempty"`
+	// Outcome is one of skipped, denied, mutated, unchanged or error
+	Outcome          string `json:"outcome"`
+	Reason           string `json:"reason,omitempty"`
+	CredentialMethod string `json:"credentialMethod,omitempty"`
+	RoleARN          string `json:"roleArn,omitempty"`
+	Audience         string `json:"audience,omitempty"`
+	// WebIdentityAudience and ContainerCredentialsAudience are set instead
+	// of Audience when both credential methods are injected
+	WebIdentityAudience          string `json:"webIdentityAudience,omitempty"`
+	ContainerCredentialsAudience string `json:"containerCredentialsAudience,omitempty"`
+	// Patch summarizes the JSON patch operations, without their values,
+	// e.g. "add /spec/volumes/0"
+	Patch    []string `json:"patch,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// Writer writes batches of records to a sink
+type Writer interface {
+	Write(records []Record) error
+	Close() error
+}
+
+var recordsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "pod_identity_webhook_audit_log_records_total",
+	Help: "Number of audit log records by result: written, dropped when the buffer is full, or failed to be written",
+}, []string{"result"})
+
+func init() {
+	prometheus.MustRegister(recordsCounter)
+}
+
+// Logger buffers records and writes them in batches with its Writer, so that
+// admissions do not wait for the sink. Records are dropped when the buffer is
+// full.
+type Logger struct {
+	writer  Writer
+	records chan Record
+	done    chan struct{}
+
+	mu     sync.RWMutex // guards closed, and sends on records
+	closed bool
+}
+
+// NewLogger returns a Logger buffering up to bufferSize records
+func NewLogger(writer Writer, bufferSize int) *Logger {
+	return &Logger{
+		writer:  writer,
+		records: make(chan Record, bufferSize),
+		done:    make(chan struct{}),
+	}
+}
+
+// Start starts writing the buffered records
+func (l *Logger) Start() {
+	go l.run()
+}
+
+// Log buffers the record. It does nothing on a nil Logger.
+func (l *Logger) Log(record Record) {
+	if l == nil {
+		return
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		return
+	}
+	select {
+	case l.records <- record:
+	default:
+		klog.InfoS("Audit log buffer is full, dropping the record of pod", "namespace", record.Namespace, "pod", record.Pod, "uid", record.UID)
+		recordsCounter.WithLabelValues("dropped").Inc()
+	}
+}
+
+// Close writes the buffered records, and closes the Writer. It must be called
+// after Start.
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	if !l.closed {
+		l.closed = true
+		close(l.records)
+	}
+	l.mu.Unlock()
+	<-l.done
+	return l.writer.Close()
+}
+
+func (l *Logger) run() {
+	defer close(l.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	var batch []Record
+	for {
+		select {
+		case record, ok := <-l.records:
+			if !ok {
+				l.write(batch)
+				return
+			}
+			batch = append(batch, record)
+			if len(batch) >= maxBatchSize {
+				l.write(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			l.write(batch)
+			batch = nil
+		}
+	}
+}
+
+func (l *Logger) write(batch []Record) {
+	if len(batch) == 0 {
+		return
+	}
+	if err := l.writer.Write(batch); err != nil {
+		klog.ErrorS(err, "Error writing audit log records", "count", len(batch))
+		recordsCounter.WithLabelValues("failed").Add(float64(len(batch)))
+		return
+	}
+	recordsCounter.WithLabelValues("written").Add(float64(len(batch)))
+}
@@ -0,0 +1,167 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package auditlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeWriter struct {
+	records []Record
+	err     error
+	closed  bool
+}
+
+func (w *fakeWriter) Write(records []Record) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.records = append(w.records, records...)
+	return nil
+}
+
+func (w *fakeWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+func TestLogger(t *testing.T) {
+	writer := &fakeWriter{}
+	logger := NewLogger(writer, 2)
+	written := testutil.ToFloat64(recordsCounter.WithLabelValues("written"))
+	dropped := testutil.ToFloat64(recordsCounter.WithLabelValues("dropped"))
+
+	// Records over the buffer size are dropped until the logger is started
+	for _, pod := range []string{"a", "b", "c"} {
+		logger.Log(Record{Namespace: "default", Pod: pod})
+	}
+	logger.Start()
+	assert.NoError(t, logger.Close())
+	logger.Log(Record{Namespace: "default", Pod: "d"})
+
+	assert.Equal(t, []Record{{Namespace: "default", Pod: "a"}, {Namespace: "default", Pod: "b"}}, writer.records)
+	assert.True(t, writer.closed)
+	assert.Equal(t, written+2, testutil.ToFloat64(recordsCounter.WithLabelValues("written")))
+	assert.Equal(t, dropped+1, testutil.ToFloat64(recordsCounter.WithLabelValues("dropped")))
+
+	var nilLogger *Logger
+	nilLogger.Log(Record{})
+}
+
+func TestLogger_WriteError(t *testing.T) {
+	logger := NewLogger(&fakeWriter{err: errors.New("unavailable")}, DefaultBufferSize)
+	failed := testutil.ToFloat64(recordsCounter.WithLabelValues("failed"))
+
+	logger.Start()
+	logger.Log(Record{Namespace: "default", Pod: "a"})
+	assert.NoError(t, logger.Close())
+	assert.Equal(t, failed+1, testutil.ToFloat64(recordsCounter.WithLabelValues("failed")))
+}
+
+func TestFileWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	records := []Record{
+		{Time: time.Unix(1700000000, 0).UTC(), Namespace: "default", Pod: "a", Outcome: "mutated", Patch: []string{"add /spec/volumes"}},
+		{Time: time.Unix(1700000001, 0).UTC(), Namespace: "default", Pod: "b", Outcome: "skipped"},
+	}
+	for _, record := range records {
+		writer, err := NewFileWriter(path)
+		if !assert.NoError(t, err) {
+			return
+		}
+		// Records are appended to the existing file
+		assert.NoError(t, writer.Write([]Record{record}))
+		assert.NoError(t, writer.Close())
+	}
+
+	file, err := os.Open(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer file.Close()
+	var read []Record
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record Record
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		read = append(read, record)
+	}
+	assert.Equal(t, records, read)
+}
+
+type fakeCloudWatchLogs struct {
+	cloudwatchlogsiface.CloudWatchLogsAPI
+	createErr error
+	puts      [][]*cloudwatchlogs.InputLogEvent
+}
+
+func (f *fakeCloudWatchLogs) CreateLogStream(input *cloudwatchlogs.CreateLogStreamInput) (*cloudwatchlogs.CreateLogStreamOutput, error) {
+	return &cloudwatchlogs.CreateLogStreamOutput{}, f.createErr
+}
+
+func (f *fakeCloudWatchLogs) PutLogEvents(input *cloudwatchlogs.PutLogEventsInput) (*cloudwatchlogs.PutLogEventsOutput, error) {
+	f.puts = append(f.puts, input.LogEvents)
+	return &cloudwatchlogs.PutLogEventsOutput{}, nil
+}
+
+func TestCloudWatchWriter(t *testing.T) {
+	_, err := NewCloudWatchWriter(&fakeCloudWatchLogs{createErr: errors.New("access denied")}, "audit", "webhook-0")
+	assert.Error(t, err)
+
+	client := &fakeCloudWatchLogs{createErr: awserr.New(cloudwatchlogs.ErrCodeResourceAlreadyExistsException, "exists", nil)}
+	writer, err := NewCloudWatchWriter(client, "audit", "webhook-0")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// Records are sorted chronologically, and split to fit the size limit
+	start := time.Unix(1700000000, 0)
+	reason := strings.Repeat("x", 400000)
+	assert.NoError(t, writer.Write([]Record{
+		{Time: start.Add(2 * time.Second), Pod: "c", Reason: reason},
+		{Time: start, Pod: "a", Reason: reason},
+		{Time: start.Add(time.Second), Pod: "b", Reason: reason},
+	}))
+	if !assert.Len(t, client.puts, 2) {
+		return
+	}
+	assert.Len(t, client.puts[0], 2)
+	assert.Len(t, client.puts[1], 1)
+	var pods []string
+	for _, events := range client.puts {
+		for _, event := range events {
+			var record Record
+			assert.NoError(t, json.Unmarshal([]byte(aws.StringValue(event.Message)), &record))
+			assert.Equal(t, record.Time.UnixMilli(), aws.Int64Value(event.Timestamp))
+			pods = append(pods, record.Pod)
+		}
+	}
+	assert.Equal(t, []string{"a", "b", "c"}, pods)
+}
@@ -0,0 +1,105 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package auditlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+)
+
+// Limits of a PutLogEvents call
+const (
+	maxPutLogEventsCount = 10000
+	maxPutLogEventsBytes = 1048576
+	// putLogEventOverhead is counted for every event towards maxPutLogEventsBytes
+	putLogEventOverhead = 26
+)
+
+// CloudWatchWriter sends records to a CloudWatch Logs log stream, one JSON
+// document per log event
+type CloudWatchWriter struct {
+	client cloudwatchlogsiface.CloudWatchLogsAPI
+	group  string
+	stream string
+}
+
+// NewCloudWatchWriter returns a CloudWatchWriter sending records to the log
+// stream of the log group, creating the log stream if needed. The log group
+// must exist.
+func NewCloudWatchWriter(client cloudwatchlogsiface.CloudWatchLogsAPI, group, stream string) (*CloudWatchWriter, error) {
+	_, err := client.CreateLogStream(&cloudwatchlogs.CreateLogStreamInput{
+		LogGroupName:  aws.String(group),
+		LogStreamName: aws.String(stream),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == cloudwatchlogs.ErrCodeResourceAlreadyExistsException {
+		err = nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error creating log stream %s of log group %s: %v", stream, group, err)
+	}
+	return &CloudWatchWriter{client: client, group: group, stream: stream}, nil
+}
+
+// Write sends the records, split into as many PutLogEvents calls as needed
+func (w *CloudWatchWriter) Write(records []Record) error {
+	events := make([]*cloudwatchlogs.InputLogEvent, 0, len(records))
+	for _, record := range records {
+		message, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		events = append(events, &cloudwatchlogs.InputLogEvent{
+			Message:   aws.String(string(message)),
+			Timestamp: aws.Int64(record.Time.UnixMilli()),
+		})
+	}
+	// The events of a PutLogEvents call must be in chronological order
+	sort.SliceStable(events, func(i, j int) bool {
+		return *events[i].Timestamp < *events[j].Timestamp
+	})
+
+	for len(events) > 0 {
+		count, size := 0, 0
+		for count < len(events) && count < maxPutLogEventsCount {
+			eventSize := len(*events[count].Message) + putLogEventOverhead
+			if count > 0 && size+eventSize > maxPutLogEventsBytes {
+				break
+			}
+			size += eventSize
+			count++
+		}
+		if _, err := w.client.PutLogEvents(&cloudwatchlogs.PutLogEventsInput{
+			LogGroupName:  aws.String(w.group),
+			LogStreamName: aws.String(w.stream),
+			LogEvents:     events[:count],
+		}); err != nil {
+			return fmt.Errorf("error putting %d log events to log stream %s of log group %s: %v", count, w.stream, w.group, err)
+		}
+		events = events[count:]
+	}
+	return nil
+}
+
+// Close does nothing, events are sent by Write
+func (w *CloudWatchWriter) Close() error {
+	return nil
+}
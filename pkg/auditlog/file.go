@@ -0,0 +1,56 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package auditlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+)
+
+// FileWriter appends records to a file, one JSON document per line
+type FileWriter struct {
+	file *os.File
+}
+
+// NewFileWriter opens the file records are appended to, creating it if needed
+func NewFileWriter(path string) (*FileWriter, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileWriter{file: file}, nil
+}
+
+// Write appends the records to the file, and syncs it
+func (w *FileWriter) Write(records []Record) error {
+	buf := bufio.NewWriter(w.file)
+	encoder := json.NewEncoder(buf)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+// Close closes the file
+func (w *FileWriter) Close() error {
+	return w.file.Close()
+}
@@ -0,0 +1,122 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package cache
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/aws/amazon-eks-pod-identity-webhook/pkg"
+	v1 "k8s.io/api/core/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// RoleAliases maps the aliases service accounts reference with the role-alias
+// annotation to role ARNs, so that the ARNs can be changed in one place. The
+// aliases are resolved when service accounts are looked up, so changes apply
+// to the pods created afterwards.
+type RoleAliases struct {
+	mu      sync.RWMutex
+	aliases map[string]string
+}
+
+// NewRoleAliases returns RoleAliases with no alias loaded
+func NewRoleAliases() *RoleAliases {
+	return &RoleAliases{}
+}
+
+// Load replaces the aliases with the given ones, mapping aliases to role ARNs.
+// Aliases with an invalid ARN are left out, and returned as errors.
+func (r *RoleAliases) Load(data map[string]string) error {
+	aliases := make(map[string]string, len(data))
+	var errs []error
+	for alias, arn := range data {
+		if !pkg.ValidateRoleARN(arn) {
+			errs = append(errs, fmt.Errorf("role alias %s has invalid role ARN %q", alias, arn))
+			continue
+		}
+		aliases[alias] = arn
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.aliases = aliases
+	klog.InfoS("Loaded role aliases", "count", len(aliases))
+	return utilerrors.NewAggregate(errs)
+}
+
+// WatchConfigMap loads the aliases from the data of the ConfigMap with the
+// given name, whenever it changes
+func (r *RoleAliases) WatchConfigMap(informer coreinformers.ConfigMapInformer, name string) error {
+	load := func(cm *v1.ConfigMap) {
+		if cm.Name != name {
+			return
+		}
+		if err := r.Load(cm.Data); err != nil {
+			utilruntime.HandleError(fmt.Errorf("error loading role aliases from ConfigMap %s/%s: %v", cm.Namespace, cm.Name, err))
+		}
+	}
+	_, err := informer.Informer().AddEventHandler(
+		cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				load(obj.(*v1.ConfigMap))
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				load(newObj.(*v1.ConfigMap))
+			},
+		},
+	)
+	return err
+}
+
+// Loaded returns an error until the aliases are loaded, and is meant to be
+// used as a readiness check
+func (r *RoleAliases) Loaded() error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.aliases == nil {
+		return fmt.Errorf("no role aliases loaded")
+	}
+	return nil
+}
+
+// Resolve returns the role ARN of the alias
+func (r *RoleAliases) Resolve(alias string) (string, bool) {
+	if r == nil {
+		return "", false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	arn, ok := r.aliases[alias]
+	return arn, ok
+}
+
+// roleARN returns the role ARN of the entry, resolving its role alias if it
+// has no role ARN
+func (r *RoleAliases) roleARN(entry *Entry) string {
+	if entry.RoleARN != "" || entry.RoleAlias == "" {
+		return entry.RoleARN
+	}
+	arn, ok := r.Resolve(entry.RoleAlias)
+	if !ok {
+		klog.InfoS("Role alias is not defined", "roleAlias", entry.RoleAlias)
+	}
+	return arn
+}
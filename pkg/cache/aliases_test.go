@@ -0,0 +1,130 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRoleAliases(t *testing.T) {
+	aliases := NewRoleAliases()
+	assert.Error(t, aliases.Loaded())
+
+	err := aliases.Load(map[string]string{
+		"payments-reader": "arn:aws:iam::111122223333:role/payments-reader",
+		"invalid":         "payments-reader",
+	})
+	assert.EqualError(t, err, `role alias invalid has invalid role ARN "payments-reader"`)
+	assert.NoError(t, aliases.Loaded())
+
+	arn, ok := aliases.Resolve("payments-reader")
+	assert.True(t, ok)
+	assert.Equal(t, "arn:aws:iam::111122223333:role/payments-reader", arn)
+	_, ok = aliases.Resolve("invalid")
+	assert.False(t, ok)
+
+	var nilAliases *RoleAliases
+	_, ok = nilAliases.Resolve("payments-reader")
+	assert.False(t, ok)
+}
+
+func TestRoleAliasResolution(t *testing.T) {
+	aliasSA := &v1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "payments",
+			Namespace: "default",
+			Annotations: map[string]string{
+				"eks.amazonaws.com/role-alias": "payments-reader",
+			},
+		},
+	}
+	arnSA := &v1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "orders",
+			Namespace: "default",
+			Annotations: map[string]string{
+				"eks.amazonaws.com/role-arn":   "arn:aws:iam::111122223333:role/orders",
+				"eks.amazonaws.com/role-alias": "payments-reader",
+			},
+		},
+	}
+	aliasCM := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "role-aliases",
+			Namespace: "eks",
+		},
+		Data: map[string]string{
+			"payments-reader": "arn:aws:iam::111122223333:role/payments-reader",
+		},
+	}
+
+	fakeClient := fake.NewSimpleClientset(aliasSA, arnSA, aliasCM)
+	informerFactory := informers.NewSharedInformerFactory(fakeClient, 0)
+	aliases := NewRoleAliases()
+	if err := aliases.WatchConfigMap(informerFactory.Core().V1().ConfigMaps(), "role-aliases"); err != nil {
+		t.Fatalf("Error watching ConfigMap: %v", err)
+	}
+
+	cache := New("sts.amazonaws.com",
+		"eks.amazonaws.com",
+		false,
+		86400,
+		[]coreinformers.ServiceAccountInformer{informerFactory.Core().V1().ServiceAccounts()},
+		nil,
+		ComposeRoleArn{},
+		fakeClient.CoreV1(),
+		WithRoleAliases(aliases),
+	)
+	stop := make(chan struct{})
+	defer close(stop)
+	informerFactory.Start(stop)
+	informerFactory.WaitForCacheSync(stop)
+	cache.Start(stop)
+
+	waitForRoleARN := func(name, expected string) {
+		var resp Response
+		err := wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, time.Second, true, func(context.Context) (bool, error) {
+			resp = cache.Get(Request{Name: name, Namespace: "default"})
+			return resp.RoleARN == expected, nil
+		})
+		assert.NoError(t, err, "expected role ARN %q for %s, got %q", expected, name, resp.RoleARN)
+	}
+	waitForRoleARN("payments", "arn:aws:iam::111122223333:role/payments-reader")
+	waitForRoleARN("orders", "arn:aws:iam::111122223333:role/orders")
+
+	aliasCM.Data["payments-reader"] = "arn:aws:iam::444455556666:role/payments-reader"
+	if _, err := fakeClient.CoreV1().ConfigMaps("eks").Update(context.Background(), aliasCM, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Error updating ConfigMap: %v", err)
+	}
+	waitForRoleARN("payments", "arn:aws:iam::444455556666:role/payments-reader")
+
+	delete(aliasCM.Data, "payments-reader")
+	if _, err := fakeClient.CoreV1().ConfigMaps("eks").Update(context.Background(), aliasCM, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Error updating ConfigMap: %v", err)
+	}
+	waitForRoleARN("payments", "")
+	assert.True(t, cache.Get(Request{Name: "payments", Namespace: "default"}).FoundInCache)
+}
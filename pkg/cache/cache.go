@@ -18,11 +18,13 @@ package cache
 import (
 	"context"
 	"encoding/json"
+	goerrors "errors"
 	"fmt"
-	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/aws/amazon-eks-pod-identity-webhook/pkg"
@@ -31,12 +33,19 @@ import (
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
 	coreinformers "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes"
 	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/retry"
+	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
 )
 
@@ -45,49 +54,245 @@ type Entry struct {
 	Audience        string
 	UseRegionalSTS  bool
 	TokenExpiration int64
+	// RoleAlias is the value of the role alias annotation, resolved to the
+	// role ARN on lookup when RoleARN is empty
+	RoleAlias string `json:",omitempty"`
+	// CredentialMethodPrecedence is the value of the credential method
+	// precedence annotation, empty when not set
+	CredentialMethodPrecedence string `json:",omitempty"`
+	// SkipTokenVolume is the value of the skip token volume annotation,
+	// empty when not set
+	SkipTokenVolume string `json:",omitempty"`
+	// SDKUAAppID is the value of the SDK user agent app ID annotation, empty
+	// when not set
+	SDKUAAppID string `json:",omitempty"`
+	// UseFIPSEndpoint is the value of the use FIPS endpoint annotation, empty
+	// when not set
+	UseFIPSEndpoint string `json:",omitempty"`
+	// SkipRegion is the value of the skip region annotation, empty when not
+	// set
+	SkipRegion string `json:",omitempty"`
+	// TokenFileName is the value of the token file name annotation, empty
+	// when not set
+	TokenFileName string `json:",omitempty"`
 }
 
 type Request struct {
 	Name                string
 	Namespace           string
 	RequestNotification bool
+	// Context bounds the fetches of caches looking service accounts up
+	// synchronously, such as the LRU cache. Defaults to context.Background().
+	Context context.Context
+}
+
+// requestContext returns the context of the request, or context.Background()
+func (r Request) requestContext() context.Context {
+	if r.Context == nil {
+		return context.Background()
+	}
+	return r.Context
 }
 
 func (r Request) CacheKey() string {
 	return r.Namespace + "/" + r.Name
 }
 
+// LogKeys returns the structured logging key/value pairs identifying the
+// service account of the request
+func (r Request) LogKeys() []interface{} {
+	return []interface{}{"namespace", r.Namespace, "serviceAccount", r.Name}
+}
+
+// keyLogKeys returns the structured logging key/value pairs identifying the
+// service account of a namespace/name cache key
+func keyLogKeys(key string) []interface{} {
+	namespace, name, _ := strings.Cut(key, "/")
+	return Request{Namespace: namespace, Name: name}.LogKeys()
+}
+
+const (
+	// SourceServiceAccount is the Source of responses read from service
+	// account annotations
+	SourceServiceAccount = "ServiceAccount"
+	// SourceConfigMap is the Source of responses read from the
+	// pod-identity-webhook ConfigMap
+	SourceConfigMap = "ConfigMap"
+)
+
 type Response struct {
-	RoleARN         string
-	Audience        string
-	UseRegionalSTS  bool
-	TokenExpiration int64
-	FoundInCache    bool
-	Notifier        <-chan struct{}
+	RoleARN                    string
+	Audience                   string
+	UseRegionalSTS             bool
+	TokenExpiration            int64
+	CredentialMethodPrecedence string
+	SkipTokenVolume            string
+	SDKUAAppID                 string
+	UseFIPSEndpoint            string
+	SkipRegion                 string
+	TokenFileName              string
+	FoundInCache               bool
+	Notifier                   <-chan struct{}
+	// Source is where the role ARN was read from, SourceServiceAccount or
+	// SourceConfigMap, empty when there is none
+	Source string
 }
 
 type ServiceAccountCache interface {
 	Start(stop chan struct{})
 	Get(request Request) Response
 	GetCommonConfigurations(name, namespace string) (useRegionalSTS bool, tokenExpiration int64)
+	// HasSynced returns true once the informers backing the cache have synced
+	HasSynced() bool
 	// ToJSON returns cache contents as JSON string
 	ToJSON() string
 	Clear()
 }
 
+// ConfigMapDumper is implemented by ServiceAccountCaches reading service
+// accounts from the pod-identity-webhook ConfigMap
+type ConfigMapDumper interface {
+	// ConfigMapToJSON returns the service accounts read from the ConfigMaps
+	// as JSON string
+	ConfigMapToJSON() string
+}
+
 type serviceAccountCache struct {
 	mu                     sync.RWMutex // guards cache
 	saCache                map[string]*Entry
 	cmCache                map[string]*Entry
+	cmSources              map[string]map[string]*Entry // entries of each ConfigMap by name, merged into cmCache
+	cmNamespaceSources     map[string]map[string]string // namespace audiences of each ConfigMap by name, merged into cmNamespaceAudiences
+	cmNamespaceAudiences   map[string]string
+	namespaceAnnotations   func(namespace string) (map[string]string, error)
+	configMapName          string
+	configMapSelector      labels.Selector
 	hasSynced              cache.InformerSynced
 	clientset              kubernetes.Interface
 	annotationPrefix       string
+	fallbackPrefixes       []string
 	defaultAudience        string
 	defaultRegionalSTS     bool
 	composeRoleArn         ComposeRoleArn
 	defaultTokenExpiration int64
 	webhookUsage           prometheus.Gauge
 	notifications          *notifications
+	skipInvalidRoleARN     bool
+	annotatedOnly          bool
+	negativeCache          *negativeCache
+	saGetter               corev1.ServiceAccountsGetter
+	fetchRequests          chan *Request
+	fetchWorkers           int
+	fetchTimeout           time.Duration
+	fetchBackoff           wait.Backoff
+	eventWorkers           int
+	eventQueue             workqueue.TypedRateLimitingInterface[string]
+	saIndexers             []cache.Indexer
+	tenantLimiter          *tenantLimiter
+	saListers              []corelisters.ServiceAccountLister
+	recorder               record.EventRecorder
+	reconcileConfig        ReconcileConfig
+	snapshotLoaded         atomic.Bool
+	snapshotSAs            sets.Set[string] // keys loaded from the snapshot and not added since
+	snapshotCMs            sets.Set[string] // ConfigMaps loaded from the snapshot and not read since
+	roleAliases            *RoleAliases
+}
+
+// Option is an option type for setting up a ServiceAccountCache
+type Option func(*serviceAccountCache)
+
+// WithAnnotatedOnly sets whether only service accounts carrying annotations
+// with the webhook's prefix are kept in the cache. Other service accounts are
+// looked up in the informers' stores when requested, which still hold every
+// service account, so this only saves the cache entries.
+func WithAnnotatedOnly(annotatedOnly bool) Option {
+	return func(c *serviceAccountCache) { c.annotatedOnly = annotatedOnly }
+}
+
+// WithNegativeCacheTTL sets for how long service accounts the API server
+// reported as not found are not fetched again, 0 disables negative caching
+func WithNegativeCacheTTL(ttl time.Duration) Option {
+	return func(c *serviceAccountCache) { c.negativeCache = newNegativeCache(ttl) }
+}
+
+// WithConfigMapName sets the name of the ConfigMap service accounts are read
+// from, which takes precedence over ConfigMaps matching the selector
+func WithConfigMapName(name string) Option {
+	return func(c *serviceAccountCache) { c.configMapName = name }
+}
+
+// WithConfigMapSelector sets a label selector for additional ConfigMaps
+// service accounts are read from
+func WithConfigMapSelector(selector labels.Selector) Option {
+	return func(c *serviceAccountCache) { c.configMapSelector = selector }
+}
+
+// WithFetchWorkers sets the number of workers fetching service accounts missing
+// from the cache from the API server
+func WithFetchWorkers(workers int) Option {
+	return func(c *serviceAccountCache) { c.fetchWorkers = workers }
+}
+
+// WithEventWorkers sets the number of workers processing the events of the
+// service account informers once they have synced. With 0 workers, events are
+// processed in the informer callbacks.
+func WithEventWorkers(workers int) Option {
+	return func(c *serviceAccountCache) { c.eventWorkers = workers }
+}
+
+// WithFetchTimeout sets the timeout of each attempt to fetch a service account
+// from the API server
+func WithFetchTimeout(timeout time.Duration) Option {
+	return func(c *serviceAccountCache) { c.fetchTimeout = timeout }
+}
+
+// WithFetchBackoff sets the backoff between attempts to fetch a service account
+// from the API server after a transient error
+func WithFetchBackoff(backoff wait.Backoff) Option {
+	return func(c *serviceAccountCache) { c.fetchBackoff = backoff }
+}
+
+// WithTenantFetchRateLimit rate limits the fetches of missing service accounts
+// of every tenant, keyed by TenantKeyNamespace or TenantKeyServiceAccount, to
+// qps with the given burst. Pods whose service account fetch is rate limited
+// are not mutated, without waiting for the lookup grace period. A qps of 0
+// disables the limit.
+func WithTenantFetchRateLimit(key string, qps float64, burst int) Option {
+	return func(c *serviceAccountCache) {
+		c.tenantLimiter = nil
+		if qps > 0 {
+			c.tenantLimiter = newTenantLimiter(key, qps, burst)
+		}
+	}
+}
+
+// WithSkipInvalidRoleARN sets whether role ARNs failing validation are left out of the cache
+func WithSkipInvalidRoleARN(skip bool) Option {
+	return func(c *serviceAccountCache) { c.skipInvalidRoleARN = skip }
+}
+
+// WithFallbackAnnotationPrefixes sets the annotation prefixes, in order of
+// precedence, with which annotations missing with the primary prefix are read
+func WithFallbackAnnotationPrefixes(prefixes ...string) Option {
+	return func(c *serviceAccountCache) { c.fallbackPrefixes = prefixes }
+}
+
+// WithEventRecorder sets the recorder of the Events emitted on ConfigMaps with
+// an invalid config
+func WithEventRecorder(recorder record.EventRecorder) Option {
+	return func(c *serviceAccountCache) { c.recorder = recorder }
+}
+
+// WithRoleAliases sets the aliases the role-alias annotation is resolved with
+func WithRoleAliases(aliases *RoleAliases) Option {
+	return func(c *serviceAccountCache) { c.roleAliases = aliases }
+}
+
+// WithNamespaceAnnotations sets the function returning the annotations of a
+// namespace, whose audience annotation is the default audience of its service
+// accounts
+func WithNamespaceAnnotations(namespaceAnnotations func(namespace string) (map[string]string, error)) Option {
+	return func(c *serviceAccountCache) { c.namespaceAnnotations = namespaceAnnotations }
 }
 
 type ComposeRoleArn struct {
@@ -98,6 +303,17 @@ type ComposeRoleArn struct {
 	Region    string
 }
 
+const (
+	// DefaultConfigMapName is the default name of the ConfigMap service accounts are read from
+	DefaultConfigMapName = "pod-identity-webhook"
+	// DefaultFetchWorkers is the default number of workers fetching service accounts from the API server
+	DefaultFetchWorkers = 10
+	// DefaultEventWorkers is the default number of workers processing service account events
+	DefaultEventWorkers = 2
+	// DefaultFetchTimeout is the default timeout of each attempt to fetch a service account
+	DefaultFetchTimeout = time.Second
+)
+
 // We need a way to know if the webhook is used in a cluster.
 // There are multiple ways to achieve that.
 // We could keep track of the number of annotated service accounts, however we need some additional logic and refactoring to make sure the metric doesn't grow unbounded due to resync.
@@ -108,8 +324,63 @@ var webhookUsage = prometheus.NewGauge(prometheus.GaugeOpts{
 	Help: "Indicator to know pod identity webhook is used",
 })
 
+var (
+	saCacheSize = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "pod_identity_webhook_service_account_cache_entries",
+		Help: "Number of service accounts in the service account cache",
+	})
+	cmCacheSize = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "pod_identity_webhook_config_map_cache_entries",
+		Help: "Number of service accounts in the pod-identity-webhook ConfigMap cache",
+	})
+	cmConflicts = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "pod_identity_webhook_config_map_conflicting_entries",
+		Help: "Number of service accounts configured differently in several ConfigMaps",
+	})
+	cmConfigErrors = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pod_identity_webhook_config_map_config_errors",
+		Help: "Number of errors found in the config of a ConfigMap when it was last parsed",
+	}, []string{"config_map"})
+	reconcileDivergences = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pod_identity_webhook_cache_reconcile_divergences_total",
+		Help: "Number of cache entries found out of date with the API server and fixed by reconciliation",
+	}, []string{"cache", "kind"})
+	invalidRoleARNCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pod_identity_webhook_invalid_role_arn_total",
+		Help: "Number of times a service account was observed with an invalid role-arn annotation",
+	}, []string{"namespace"})
+	// Only incremented when a service account becomes annotated, so that
+	// informer resyncs do not count the same service account again.
+	annotatedSACounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pod_identity_webhook_annotated_service_accounts_total",
+		Help: "Number of service accounts observed with a role-arn annotation",
+	})
+	negativeCacheSize = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "pod_identity_webhook_service_account_negative_cache_entries",
+		Help: "Number of service accounts recently reported as not found by the API server",
+	})
+	tenantFetchesRateLimited = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pod_identity_webhook_service_account_fetches_rate_limited_total",
+		Help: "Number of fetches of missing service accounts not made as their tenant exceeded its rate limit",
+	})
+	negativeCacheHits = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pod_identity_webhook_service_account_negative_cache_hits_total",
+		Help: "Number of service account fetches skipped because the service account was recently not found",
+	})
+)
+
 func init() {
 	prometheus.MustRegister(webhookUsage)
+	prometheus.MustRegister(saCacheSize)
+	prometheus.MustRegister(cmCacheSize)
+	prometheus.MustRegister(cmConflicts)
+	prometheus.MustRegister(cmConfigErrors)
+	prometheus.MustRegister(reconcileDivergences)
+	prometheus.MustRegister(annotatedSACounter)
+	prometheus.MustRegister(invalidRoleARNCounter)
+	prometheus.MustRegister(negativeCacheSize)
+	prometheus.MustRegister(negativeCacheHits)
+	prometheus.MustRegister(tenantFetchesRateLimited)
 }
 
 // Get will return the cached configuration of the given ServiceAccount.
@@ -120,18 +391,29 @@ func (c *serviceAccountCache) Get(req Request) Response {
 	result := Response{
 		TokenExpiration: pkg.DefaultTokenExpiration,
 	}
-	klog.V(5).Infof("Fetching sa %s from cache", req.CacheKey())
+	klog.V(5).InfoS("Fetching service account from cache", req.LogKeys()...)
 	{
 		var entry *Entry
 		entry, result.Notifier = c.getSA(req)
+		var roleARN string
 		if entry != nil {
 			result.FoundInCache = true
+			// also applies to the container credentials method, which
+			// service accounts without a role ARN use
+			result.SkipTokenVolume = entry.SkipTokenVolume
+			roleARN = c.roleAliases.roleARN(entry)
 		}
-		if entry != nil && entry.RoleARN != "" {
-			result.RoleARN = entry.RoleARN
-			result.Audience = entry.Audience
+		if roleARN != "" {
+			result.RoleARN = roleARN
+			result.Audience = c.audience(req.Namespace, entry.Audience, c.defaultAudience)
 			result.UseRegionalSTS = entry.UseRegionalSTS
 			result.TokenExpiration = entry.TokenExpiration
+			result.CredentialMethodPrecedence = entry.CredentialMethodPrecedence
+			result.SDKUAAppID = entry.SDKUAAppID
+			result.UseFIPSEndpoint = entry.UseFIPSEndpoint
+			result.SkipRegion = entry.SkipRegion
+			result.TokenFileName = entry.TokenFileName
+			result.Source = SourceServiceAccount
 			return result
 		}
 	}
@@ -143,13 +425,18 @@ func (c *serviceAccountCache) Get(req Request) Response {
 		if entry != nil {
 			result.FoundInCache = true
 			result.RoleARN = entry.RoleARN
-			result.Audience = entry.Audience
+			// ConfigMap entries without an audience are projected with the
+			// API server audiences, unless their namespace has a default one
+			result.Audience = c.audience(req.Namespace, entry.Audience, "")
 			result.UseRegionalSTS = entry.UseRegionalSTS
 			result.TokenExpiration = entry.TokenExpiration
+			if entry.RoleARN != "" {
+				result.Source = SourceConfigMap
+			}
 			return result
 		}
 	}
-	klog.V(5).Infof("Service account %s not found in cache", req.CacheKey())
+	klog.V(5).InfoS("Service account not found in cache", req.LogKeys()...)
 	return result
 }
 
@@ -165,13 +452,33 @@ func (c *serviceAccountCache) GetCommonConfigurations(name, namespace string) (u
 	return false, pkg.DefaultTokenExpiration
 }
 
+// closedNotifier is returned to callers requesting a notification for a
+// service account known not to exist, so they stop waiting immediately.
+var closedNotifier = func() <-chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}()
+
 func (c *serviceAccountCache) getSA(req Request) (*Entry, <-chan struct{}) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 	entry, ok := c.saCache[req.CacheKey()]
+	if !ok && c.annotatedOnly {
+		// Service accounts without annotations are only kept in the informers' stores
+		for _, lister := range c.saListers {
+			if sa, err := lister.ServiceAccounts(req.Namespace).Get(req.Name); err == nil {
+				return c.newEntry(sa), nil
+			}
+		}
+	}
+	if !ok && req.RequestNotification && c.negativeCache.has(req.CacheKey()) {
+		// Don't fetch again, and let the caller know right away it won't be found
+		return nil, closedNotifier
+	}
 	if !ok && req.RequestNotification {
-		klog.V(5).Infof("Service Account %s not found in cache, adding notification handler", req.CacheKey())
-		return nil, c.notifications.create(req)
+		klog.V(5).InfoS("Service account not found in cache, adding notification handler", req.LogKeys()...)
+		return nil, c.notifications.create(req, c.tenantLimiter)
 	}
 	return entry, nil
 }
@@ -186,18 +493,35 @@ func (c *serviceAccountCache) getCM(name, namespace string) *Entry {
 	return entry
 }
 
-func (c *serviceAccountCache) popSA(name, namespace string) {
-	klog.V(5).Infof("Removing SA %s/%s from SA cache", namespace, name)
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	delete(c.saCache, namespace+"/"+name)
+// audience returns the audience of a service account of the namespace: its
+// own if set, else the default audience of the namespace, read from its
+// annotation then from the ConfigMaps, else the given fallback
+func (c *serviceAccountCache) audience(namespace, audience, fallback string) string {
+	if audience != "" {
+		return audience
+	}
+	if c.namespaceAnnotations != nil {
+		annotations, err := c.namespaceAnnotations(namespace)
+		if err != nil {
+			klog.V(4).InfoS("Error getting annotations of namespace", "namespace", namespace, "err", err)
+		} else if _, value, ok := pkg.LookupAnnotation(annotations, pkg.AudienceAnnotation, c.annotationPrefixes()...); ok && value != "" {
+			return value
+		}
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if value, ok := c.cmNamespaceAudiences[namespace]; ok {
+		return value
+	}
+	return fallback
 }
 
-func (c *serviceAccountCache) popCM(name, namespace string) {
-	klog.V(5).Infof("Removing SA %s/%s from CM cache", namespace, name)
+func (c *serviceAccountCache) popSA(name, namespace string) {
+	klog.V(5).InfoS("Removing service account from SA cache", "namespace", namespace, "serviceAccount", name)
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	delete(c.cmCache, namespace+"/"+name)
+	delete(c.saCache, namespace+"/"+name)
+	saCacheSize.Set(float64(len(c.saCache)))
 }
 
 // Log cache contents for debugginqg
@@ -206,56 +530,136 @@ func (c *serviceAccountCache) ToJSON() string {
 	defer c.mu.RUnlock()
 	contents, err := json.MarshalIndent(c.saCache, "", " ")
 	if err != nil {
-		klog.Errorf("Json marshal error: %v", err.Error())
+		klog.ErrorS(err, "Json marshal error")
+		return ""
+	}
+	return string(contents)
+}
+
+// ConfigMapToJSON returns the merged ConfigMap cache contents as JSON string
+func (c *serviceAccountCache) ConfigMapToJSON() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	contents, err := json.MarshalIndent(c.cmCache, "", " ")
+	if err != nil {
+		klog.ErrorS(err, "Json marshal error")
 		return ""
 	}
 	return string(contents)
 }
 
 func (c *serviceAccountCache) addSA(sa *v1.ServiceAccount) {
+	c.setSA(sa.Name, sa.Namespace, c.newEntry(sa))
+}
+
+// annotationPrefixes returns the prefixes annotations are read with, in order
+// of precedence
+func (c *serviceAccountCache) annotationPrefixes() []string {
+	return append([]string{c.annotationPrefix}, c.fallbackPrefixes...)
+}
+
+// annotation returns the value of the service account annotation with the
+// given name, read with the first annotation prefix it is set with
+func (c *serviceAccountCache) annotation(sa *v1.ServiceAccount, name string) (string, bool) {
+	_, value, ok := pkg.LookupAnnotation(sa.Annotations, name, c.annotationPrefixes()...)
+	return value, ok
+}
+
+// hasAnnotations returns true if the service account has any annotation with
+// one of the webhook's prefixes
+func (c *serviceAccountCache) hasAnnotations(sa *v1.ServiceAccount) bool {
+	prefixes := c.annotationPrefixes()
+	for key := range sa.Annotations {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(key, prefix+"/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// handleInformerSA adds a service account received from the informer to the
+// cache. When only annotated service accounts are cached, service accounts
+// without annotations are removed instead, and any pending lookups are woken
+// up to find them through the informer's store.
+func (c *serviceAccountCache) handleInformerSA(sa *v1.ServiceAccount) {
+	if !c.annotatedOnly || c.hasAnnotations(sa) {
+		c.addSA(sa)
+		return
+	}
+	c.popSA(sa.Name, sa.Namespace)
+	c.notifications.broadcast(sa.Namespace + "/" + sa.Name)
+}
+
+func (c *serviceAccountCache) newEntry(sa *v1.ServiceAccount) *Entry {
 	entry := &Entry{}
 
-	arn, ok := sa.Annotations[c.annotationPrefix+"/"+pkg.RoleARNAnnotation]
+	arn, ok := c.annotation(sa, pkg.RoleARNAnnotation)
 	if ok {
 		if !strings.Contains(arn, "arn:") && c.composeRoleArn.Enabled {
 			arn = fmt.Sprintf("arn:%s:iam::%s:role/%s", c.composeRoleArn.Partition, c.composeRoleArn.AccountID, arn)
 		}
 
-		matched, err := regexp.Match(`^arn:aws[a-z0-9-]*:iam::\d{12}:role\/[\w-\/.@+=,]+$`, []byte(arn))
-		if err != nil {
-			klog.Errorf("Regex error: %v", err)
-		} else if !matched {
-			klog.Warningf("arn is invalid: %s", arn)
+		if !pkg.ValidateRoleARN(arn) {
+			klog.InfoS("Ignoring invalid role ARN", "namespace", sa.Namespace, "serviceAccount", sa.Name, "roleARN", arn)
+			invalidRoleARNCounter.WithLabelValues(sa.Namespace).Inc()
+			if c.skipInvalidRoleARN {
+				arn = ""
+			}
 		}
 		entry.RoleARN = arn
+	} else if alias, ok := c.annotation(sa, pkg.RoleAliasAnnotation); ok {
+		entry.RoleAlias = alias
 	}
 
-	entry.Audience = c.defaultAudience
-	if audience, ok := sa.Annotations[c.annotationPrefix+"/"+pkg.AudienceAnnotation]; ok {
+	// The audience is defaulted on lookup, as the default audience of the
+	// namespace may change
+	if audience, ok := c.annotation(sa, pkg.AudienceAnnotation); ok {
 		entry.Audience = audience
 	}
 
 	entry.UseRegionalSTS = c.defaultRegionalSTS
-	if useRegionalStr, ok := sa.Annotations[c.annotationPrefix+"/"+pkg.UseRegionalSTSAnnotation]; ok {
+	if useRegionalStr, ok := c.annotation(sa, pkg.UseRegionalSTSAnnotation); ok {
 		useRegional, err := strconv.ParseBool(useRegionalStr)
 		if err != nil {
-			klog.V(4).Infof("Ignoring service account %s/%s invalid value for disable-regional-sts annotation", sa.Namespace, sa.Name)
+			klog.V(4).InfoS("Ignoring invalid value for service account annotation", "namespace", sa.Namespace, "serviceAccount", sa.Name, "annotation", pkg.UseRegionalSTSAnnotation, "err", err)
 		} else {
 			entry.UseRegionalSTS = useRegional
 		}
 	}
 
 	entry.TokenExpiration = c.defaultTokenExpiration
-	if tokenExpirationStr, ok := sa.Annotations[c.annotationPrefix+"/"+pkg.TokenExpirationAnnotation]; ok {
+	if tokenExpirationStr, ok := c.annotation(sa, pkg.TokenExpirationAnnotation); ok {
 		if tokenExpiration, err := strconv.ParseInt(tokenExpirationStr, 10, 64); err != nil {
-			klog.V(4).Infof("Found invalid value for token expiration, using %d seconds as default: %v", entry.TokenExpiration, err)
+			klog.V(4).InfoS("Ignoring invalid value for service account annotation", "namespace", sa.Namespace, "serviceAccount", sa.Name, "annotation", pkg.TokenExpirationAnnotation, "default", entry.TokenExpiration, "err", err)
 		} else {
-			entry.TokenExpiration = pkg.ValidateMinTokenExpiration(tokenExpiration)
+			// Out of range values are clamped and reported by the handler
+			entry.TokenExpiration = tokenExpiration
 		}
 	}
+
+	if precedence, ok := c.annotation(sa, pkg.CredentialMethodPrecedenceAnnotation); ok {
+		entry.CredentialMethodPrecedence = precedence
+	}
+	if skipTokenVolume, ok := c.annotation(sa, pkg.SkipTokenVolumeAnnotation); ok {
+		entry.SkipTokenVolume = skipTokenVolume
+	}
+	if appID, ok := c.annotation(sa, pkg.SDKUAAppIDAnnotation); ok {
+		entry.SDKUAAppID = appID
+	}
+	if useFIPS, ok := c.annotation(sa, pkg.UseFIPSEndpointAnnotation); ok {
+		entry.UseFIPSEndpoint = useFIPS
+	}
+	if skipRegion, ok := c.annotation(sa, pkg.SkipRegionAnnotation); ok {
+		entry.SkipRegion = skipRegion
+	}
+	if tokenFileName, ok := c.annotation(sa, pkg.TokenFileNameAnnotation); ok {
+		entry.TokenFileName = tokenFileName
+	}
 	c.webhookUsage.Set(1)
 
-	c.setSA(sa.Name, sa.Namespace, entry)
+	return entry
 }
 
 func (c *serviceAccountCache) setSA(name, namespace string, entry *Entry) {
@@ -263,38 +667,42 @@ func (c *serviceAccountCache) setSA(name, namespace string, entry *Entry) {
 	defer c.mu.Unlock()
 
 	key := namespace + "/" + name
-	klog.V(5).Infof("Adding SA %q to SA cache: %+v", key, entry)
+	klog.V(5).InfoS("Adding service account to SA cache", "namespace", namespace, "serviceAccount", name, "entry", entry)
+	c.snapshotSAs.Delete(key)
+	if old, ok := c.saCache[key]; entry.RoleARN != "" && (!ok || old.RoleARN == "") {
+		annotatedSACounter.Inc()
+	}
 	c.saCache[key] = entry
+	saCacheSize.Set(float64(len(c.saCache)))
+	c.negativeCache.remove(key)
 
 	c.notifications.broadcast(key)
 }
 
-func (c *serviceAccountCache) setCM(name, namespace string, entry *Entry) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	klog.V(5).Infof("Adding SA %s/%s to CM cache: %+v", namespace, name, entry)
-	c.cmCache[namespace+"/"+name] = entry
-}
-
 func New(defaultAudience,
 	prefix string,
 	defaultRegionalSTS bool,
 	defaultTokenExpiration int64,
-	saInformer coreinformers.ServiceAccountInformer,
+	saInformers []coreinformers.ServiceAccountInformer,
 	cmInformer coreinformers.ConfigMapInformer,
 	composeRoleArn ComposeRoleArn,
 	SAGetter corev1.ServiceAccountsGetter,
+	opts ...Option,
 ) ServiceAccountCache {
 	hasSynced := func() bool {
+		for _, saInformer := range saInformers {
+			if !saInformer.Informer().HasSynced() {
+				return false
+			}
+		}
 		if cmInformer != nil {
-			return saInformer.Informer().HasSynced() && cmInformer.Informer().HasSynced()
-		} else {
-			return saInformer.Informer().HasSynced()
+			return cmInformer.Informer().HasSynced()
 		}
+		return true
 	}
 
 	// Allocate capacity large enough to not block writers (sync path in pod mutation).
-	// Rate limiting is done in the consumer side below.
+	// Rate limiting is done by the fetch workers.
 	saFetchRequests := make(chan *Request, 1000)
 	c := &serviceAccountCache{
 		saCache:                map[string]*Entry{},
@@ -307,88 +715,173 @@ func New(defaultAudience,
 		hasSynced:              hasSynced,
 		webhookUsage:           webhookUsage,
 		notifications:          newNotifications(saFetchRequests),
+		saGetter:               SAGetter,
+		fetchRequests:          saFetchRequests,
+		fetchWorkers:           DefaultFetchWorkers,
+		fetchTimeout:           DefaultFetchTimeout,
+		fetchBackoff:           retry.DefaultBackoff,
 	}
-
-	// Rate limiting at 10 requests per second with burst to 20.
-	// In case the requests are queued in the channel for period longer than the service-account-lookup-grace-period,
-	// the pod will not be mutated if the service account is also not synced by informer cache before service-account-lookup-grace-period.
-	// This is to avoid adding unlimited latency to the pod mutation time. The maximum latency would be service-account-lookup-grace-period.
-	rl := rate.NewLimiter(rate.Every(100*time.Millisecond), 20)
-	go func() {
-		for req := range saFetchRequests {
-			go func() {
-				// Do rate limiting inside go routine, the goal is to consume the channel as fast as possible to
-				// avoid writer being blocked but still rate limit the requests sent to the API server.
-				_ = rl.Wait(context.Background())
-				sa, err := fetchFromAPI(SAGetter, req)
-				if err != nil {
-					klog.Errorf("fetching SA: %s, but got error from API: %v", req.CacheKey(), err)
-					return
-				}
-				c.addSA(sa)
-			}()
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.annotatedOnly {
+		for _, saInformer := range saInformers {
+			c.saListers = append(c.saListers, saInformer.Lister())
+		}
+	}
+	if c.eventWorkers > 0 && len(saInformers) > 0 {
+		c.eventQueue = newEventQueue()
+		for _, saInformer := range saInformers {
+			c.saIndexers = append(c.saIndexers, saInformer.Informer().GetIndexer())
 		}
-	}()
+	}
 
-	saInformer.Informer().AddEventHandler(
-		cache.ResourceEventHandlerFuncs{
-			AddFunc: func(obj interface{}) {
-				sa := obj.(*v1.ServiceAccount)
-				c.addSA(sa)
-			},
-			DeleteFunc: func(obj interface{}) {
-				sa, ok := obj.(*v1.ServiceAccount)
-				if !ok {
-					tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
-					if !ok {
-						utilruntime.HandleError(fmt.Errorf("couldn't get object from tombstone %+v", obj))
-						return
-					}
-					sa, ok = tombstone.Obj.(*v1.ServiceAccount)
+	for _, saInformer := range saInformers {
+		if err := saInformer.Informer().SetTransform(transformServiceAccount); err != nil {
+			klog.ErrorS(err, "Failed to set ServiceAccount informer transform")
+		}
+		saInformer.Informer().AddEventHandler(
+			cache.ResourceEventHandlerFuncs{
+				AddFunc: func(obj interface{}) {
+					sa := obj.(*v1.ServiceAccount)
+					c.handleInformerEvent(sa, false)
+				},
+				DeleteFunc: func(obj interface{}) {
+					sa, ok := obj.(*v1.ServiceAccount)
 					if !ok {
-						utilruntime.HandleError(fmt.Errorf("tombstone contained object that is not a ServiceAccount %#v", obj))
-						return
+						tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+						if !ok {
+							utilruntime.HandleError(fmt.Errorf("couldn't get object from tombstone %+v", obj))
+							return
+						}
+						sa, ok = tombstone.Obj.(*v1.ServiceAccount)
+						if !ok {
+							utilruntime.HandleError(fmt.Errorf("tombstone contained object that is not a ServiceAccount %#v", obj))
+							return
+						}
 					}
-				}
-				c.popSA(sa.Name, sa.Namespace)
-			},
-			UpdateFunc: func(oldObj, newObj interface{}) {
-				sa := newObj.(*v1.ServiceAccount)
-				c.addSA(sa)
+					c.handleInformerEvent(sa, true)
+				},
+				UpdateFunc: func(oldObj, newObj interface{}) {
+					sa := newObj.(*v1.ServiceAccount)
+					c.handleInformerEvent(sa, false)
+				},
 			},
-		},
-	)
+		)
+	}
 	if cmInformer != nil {
 		cmInformer.Informer().AddEventHandler(
 			cache.ResourceEventHandlerFuncs{
 				AddFunc: func(obj interface{}) {
-					err := c.populateCacheFromCM(nil, obj.(*v1.ConfigMap))
+					err := c.populateCacheFromCM(obj.(*v1.ConfigMap))
 					if err != nil {
 						utilruntime.HandleError(err)
 					}
 				},
 				UpdateFunc: func(oldObj, newObj interface{}) {
-					err := c.populateCacheFromCM(oldObj.(*v1.ConfigMap), newObj.(*v1.ConfigMap))
+					err := c.populateCacheFromCM(newObj.(*v1.ConfigMap))
 					if err != nil {
 						utilruntime.HandleError(err)
 					}
 				},
+				DeleteFunc: func(obj interface{}) {
+					cm, ok := obj.(*v1.ConfigMap)
+					if !ok {
+						tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+						if !ok {
+							utilruntime.HandleError(fmt.Errorf("couldn't get object from tombstone %+v", obj))
+							return
+						}
+						cm, ok = tombstone.Obj.(*v1.ConfigMap)
+						if !ok {
+							utilruntime.HandleError(fmt.Errorf("tombstone contained object that is not a ConfigMap %#v", obj))
+							return
+						}
+					}
+					c.removeCMSource(cm.Name)
+				},
 			},
 		)
 	}
 	return c
 }
 
-func fetchFromAPI(getter corev1.ServiceAccountsGetter, req *Request) (*v1.ServiceAccount, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*1)
-	defer cancel()
+// transformServiceAccount strips ServiceAccounts down to the fields the cache
+// reads before the informer stores them, dropping secrets, image pull secrets,
+// labels and managed fields.
+func transformServiceAccount(obj interface{}) (interface{}, error) {
+	sa, ok := obj.(*v1.ServiceAccount)
+	if !ok {
+		return obj, nil
+	}
+	return &v1.ServiceAccount{
+		TypeMeta: sa.TypeMeta,
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            sa.Name,
+			Namespace:       sa.Namespace,
+			UID:             sa.UID,
+			ResourceVersion: sa.ResourceVersion,
+			Annotations:     sa.Annotations,
+		},
+	}, nil
+}
+
+// fetchWorker fetches the service accounts requested through notifications
+// until ctx is cancelled. A fetch in progress when ctx is cancelled is
+// completed.
+func (c *serviceAccountCache) fetchWorker(ctx context.Context, limiter *rate.Limiter) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case req := <-c.fetchRequests:
+			if err := limiter.Wait(ctx); err != nil {
+				return
+			}
+			c.fetch(ctx, req)
+		}
+	}
+}
+
+func (c *serviceAccountCache) fetch(ctx context.Context, req *Request) {
+	sa, err := c.fetchFromAPI(ctx, c.saGetter, req)
+	if errors.IsNotFound(err) {
+		klog.V(4).InfoS("Fetched service account was not found", req.LogKeys()...)
+		c.negativeCache.add(req.CacheKey())
+		c.notifications.broadcast(req.CacheKey())
+		return
+	}
+	if err != nil {
+		klog.ErrorS(err, "Error fetching service account", req.LogKeys()...)
+		// release the waiters and drop the handler so the next lookup retries
+		c.notifications.broadcast(req.CacheKey())
+		return
+	}
+	c.addSA(sa)
+}
 
-	klog.V(5).Infof("fetching SA: %s", req.CacheKey())
+// isRetriableFetchError returns true for errors worth retrying a fetch for
+func isRetriableFetchError(err error) bool {
+	return errors.IsServerTimeout(err) ||
+		errors.IsTimeout(err) ||
+		errors.IsTooManyRequests(err) ||
+		errors.IsServiceUnavailable(err) ||
+		errors.IsInternalError(err) ||
+		goerrors.Is(err, context.DeadlineExceeded)
+}
+
+// fetchFromAPI gets the service account from the API server, retrying
+// transient errors until ctx is done
+func (c *serviceAccountCache) fetchFromAPI(ctx context.Context, getter corev1.ServiceAccountsGetter, req *Request) (*v1.ServiceAccount, error) {
+	klog.V(5).InfoS("Fetching service account", req.LogKeys()...)
 
 	var sa *v1.ServiceAccount
-	err := retry.OnError(retry.DefaultBackoff, func(err error) bool {
-		return errors.IsServerTimeout(err)
-	}, func() error {
+	retriable := func(err error) bool {
+		return ctx.Err() == nil && isRetriableFetchError(err)
+	}
+	err := retry.OnError(c.fetchBackoff, retriable, func() error {
+		ctx, cancel := context.WithTimeout(ctx, c.fetchTimeout)
+		defer cancel()
 		res, err := getter.ServiceAccounts(req.Namespace).Get(ctx, req.Name, metav1.GetOptions{})
 		if err != nil {
 			return err
@@ -400,56 +893,204 @@ func fetchFromAPI(getter corev1.ServiceAccountsGetter, req *Request) (*v1.Servic
 	return sa, err
 }
 
-func (c *serviceAccountCache) populateCacheFromCM(oldCM, newCM *v1.ConfigMap) error {
-	if newCM.Name != "pod-identity-webhook" {
+func (c *serviceAccountCache) primaryConfigMapName() string {
+	if c.configMapName == "" {
+		return DefaultConfigMapName
+	}
+	return c.configMapName
+}
+
+// isConfigMapSource returns true if service accounts are read from the ConfigMap
+func (c *serviceAccountCache) isConfigMapSource(cm *v1.ConfigMap) bool {
+	if cm.Name == c.primaryConfigMapName() {
+		return true
+	}
+	return c.configMapSelector != nil && !c.configMapSelector.Empty() && c.configMapSelector.Matches(labels.Set(cm.Labels))
+}
+
+// removeCMSource removes the entries of a deleted ConfigMap, or of one that no
+// longer matches the selector
+func (c *serviceAccountCache) removeCMSource(name string) {
+	cmConfigErrors.DeleteLabelValues(name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.cmSources[name]; !ok {
+		return
+	}
+	klog.InfoS("Removing service accounts of ConfigMap from CM cache", "configMap", name)
+	delete(c.cmSources, name)
+	delete(c.cmNamespaceSources, name)
+	c.mergeCMSourcesLocked()
+}
+
+func (c *serviceAccountCache) populateCacheFromCM(cm *v1.ConfigMap) error {
+	if !c.isConfigMapSource(cm) {
+		c.removeCMSource(cm.Name)
 		return nil
 	}
-	newConfig := newCM.Data["config"]
-	sas := make(map[string]*Entry)
-	err := json.Unmarshal([]byte(newConfig), &sas)
-	if err != nil {
-		return fmt.Errorf("failed to unmarshal new config %q: %v", newConfig, err)
+	sas, namespaceAudiences, errs := parseConfig(cm.Data["config"], c.defaultTokenExpiration)
+	cmConfigErrors.WithLabelValues(cm.Name).Set(float64(len(errs)))
+	if len(errs) > 0 {
+		c.recordConfigErrorEvent(cm, errs)
 	}
-	for key, entry := range sas {
-		parts := strings.Split(key, "/")
-		if entry.TokenExpiration == 0 {
-			entry.TokenExpiration = c.defaultTokenExpiration
-		}
-		c.setCM(parts[1], parts[0], entry)
+	if sas == nil {
+		return fmt.Errorf("failed to parse config of ConfigMap %s, keeping its previous entries: %v", cm.Name, utilerrors.NewAggregate(errs))
 	}
 
-	if oldCM != nil {
-		oldConfig := oldCM.Data["config"]
-		oldCache := make(map[string]*Entry)
-		err := json.Unmarshal([]byte(oldConfig), &oldCache)
-		if err != nil {
-			return fmt.Errorf("failed to unmarshal old config %q: %v", oldConfig, err)
+	c.mu.Lock()
+	if c.cmSources == nil {
+		c.cmSources = map[string]map[string]*Entry{}
+	}
+	c.cmSources[cm.Name] = sas
+	if c.cmNamespaceSources == nil {
+		c.cmNamespaceSources = map[string]map[string]string{}
+	}
+	c.cmNamespaceSources[cm.Name] = namespaceAudiences
+	c.snapshotCMs.Delete(cm.Name)
+	c.mergeCMSourcesLocked()
+	c.mu.Unlock()
+
+	if len(errs) > 0 {
+		return fmt.Errorf("ignored %d invalid entries of ConfigMap %s: %v", len(errs), cm.Name, utilerrors.NewAggregate(errs))
+	}
+	return nil
+}
+
+// recordConfigErrorEvent emits a warning Event on the ConfigMap so that
+// operators learn its config is broken without reading the webhook's logs
+func (c *serviceAccountCache) recordConfigErrorEvent(cm *v1.ConfigMap, errs []error) {
+	if c.recorder == nil {
+		return
+	}
+	c.recorder.Eventf(cm, v1.EventTypeWarning, "InvalidConfig", "Ignoring invalid config: %v", utilerrors.NewAggregate(errs))
+}
+
+// mergeCMSourcesLocked rebuilds cmCache from the entries of every ConfigMap.
+// When a service account is configured in several ConfigMaps, the entry of
+// the ConfigMap named by WithConfigMapName wins, then the one of the ConfigMap
+// whose name sorts first.
+func (c *serviceAccountCache) mergeCMSourcesLocked() {
+	primary := c.primaryConfigMapName()
+	names := make([]string, 0, len(c.cmSources))
+	for name := range c.cmSources {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if names[i] == primary || names[j] == primary {
+			return names[i] == primary
 		}
-		for key := range oldCache {
-			if _, found := sas[key]; !found {
-				parts := strings.Split(key, "/")
-				c.popCM(parts[1], parts[0])
+		return names[i] < names[j]
+	})
+
+	merged := map[string]*Entry{}
+	owners := map[string]string{}
+	conflicts := 0
+	for _, name := range names {
+		for key, entry := range c.cmSources[name] {
+			if owner, found := owners[key]; found {
+				if *merged[key] != *entry {
+					conflicts++
+					klog.InfoS("Service account is configured differently in several ConfigMaps", append(keyLogKeys(key), "configMaps", []string{owner, name}, "using", owner)...)
+				}
+				continue
 			}
+			merged[key] = entry
+			owners[key] = name
 		}
 	}
-	return nil
+	c.cmCache = merged
+
+	namespaceAudiences := map[string]string{}
+	namespaceOwners := map[string]string{}
+	for _, name := range names {
+		for namespace, audience := range c.cmNamespaceSources[name] {
+			if owner, found := namespaceOwners[namespace]; found {
+				if namespaceAudiences[namespace] != audience {
+					conflicts++
+					klog.InfoS("Namespace is configured differently in several ConfigMaps", "namespace", namespace, "configMaps", []string{owner, name}, "using", owner)
+				}
+				continue
+			}
+			namespaceAudiences[namespace] = audience
+			namespaceOwners[namespace] = name
+		}
+	}
+	c.cmNamespaceAudiences = namespaceAudiences
+
+	cmCacheSize.Set(float64(len(c.cmCache)))
+	cmConflicts.Set(float64(conflicts))
 }
 
 func (c *serviceAccountCache) start(stop chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Rate limiting at 10 requests per second with burst to 20.
+	// In case the requests are queued in the channel for period longer than the service-account-lookup-grace-period,
+	// the pod will not be mutated if the service account is also not synced by informer cache before service-account-lookup-grace-period.
+	// This is to avoid adding unlimited latency to the pod mutation time. The maximum latency would be service-account-lookup-grace-period.
+	limiter := rate.NewLimiter(rate.Every(100*time.Millisecond), 20)
+	var workers sync.WaitGroup
+	for i := 0; i < c.fetchWorkers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			c.fetchWorker(ctx, limiter)
+		}()
+	}
+
+	if c.eventQueue != nil {
+		for i := 0; i < c.eventWorkers; i++ {
+			workers.Add(1)
+			go func() {
+				defer workers.Done()
+				c.eventWorker()
+			}()
+		}
+		go func() {
+			<-stop
+			c.eventQueue.ShutDown()
+		}()
+	}
 
 	if !cache.WaitForCacheSync(stop, c.hasSynced) {
 		klog.Fatal("unable to sync serviceaccount cache!")
 		return
 	}
+	c.dropSnapshotEntries()
+
+	if c.clientset != nil && c.reconcileConfig.Interval > 0 {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			c.reconcileLoop(ctx)
+		}()
+	}
 
 	<-stop
+	cancel()
+	workers.Wait()
+	klog.V(4).InfoS("Service account fetch workers stopped")
 }
 
 func (c *serviceAccountCache) Start(stop chan struct{}) {
 	go c.start(stop)
 }
 
+// HasSynced returns true once the informers have synced, or a snapshot has
+// been loaded
+func (c *serviceAccountCache) HasSynced() bool {
+	return c.snapshotLoaded.Load() || c.hasSynced()
+}
+
 func (c *serviceAccountCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.saCache = map[string]*Entry{}
 	c.cmCache = map[string]*Entry{}
+	c.cmSources = map[string]map[string]*Entry{}
+	c.cmNamespaceSources = map[string]map[string]string{}
+	c.cmNamespaceAudiences = map[string]string{}
+	saCacheSize.Set(0)
+	cmCacheSize.Set(0)
 }
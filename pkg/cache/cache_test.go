@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"context"
 	"fmt"
 	"strconv"
 	"sync"
@@ -10,12 +11,20 @@ import (
 	"github.com/aws/amazon-eks-pod-identity-webhook/pkg"
 	awsarn "github.com/aws/aws-sdk-go/aws/arn"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/informers"
+	coreinformers "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 )
 
@@ -56,6 +65,193 @@ func TestSaCache(t *testing.T) {
 	assert.Equal(t, int64(3600), resp.TokenExpiration, "Expected token expiration to be 3600, got %d", resp.TokenExpiration)
 }
 
+func TestCacheMetrics(t *testing.T) {
+	cache := &serviceAccountCache{
+		saCache:          map[string]*Entry{},
+		cmCache:          map[string]*Entry{},
+		annotationPrefix: "eks.amazonaws.com",
+		webhookUsage:     prometheus.NewGauge(prometheus.GaugeOpts{}),
+		notifications:    newNotifications(make(chan *Request, 10)),
+	}
+	cache.Clear()
+	annotated := testutil.ToFloat64(annotatedSACounter)
+
+	annotatedSA := &v1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "annotated",
+			Namespace: "default",
+			Annotations: map[string]string{
+				"eks.amazonaws.com/role-arn": "arn:aws:iam::111122223333:role/s3-reader",
+			},
+		},
+	}
+	cache.addSA(annotatedSA)
+	// a resync of the same service account must not be counted twice
+	cache.addSA(annotatedSA)
+	cache.addSA(&v1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "plain", Namespace: "default"}})
+	assert.NoError(t, cache.populateCacheFromCM(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod-identity-webhook"},
+		Data:       map[string]string{"config": `{"default/cm":{"RoleARN":"arn:aws:iam::111122223333:role/s3-reader"}}`},
+	}))
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(saCacheSize))
+	assert.Equal(t, float64(1), testutil.ToFloat64(cmCacheSize))
+	assert.Equal(t, annotated+1, testutil.ToFloat64(annotatedSACounter))
+
+	cache.popSA("plain", "default")
+	cache.removeCMSource("pod-identity-webhook")
+	assert.Equal(t, float64(1), testutil.ToFloat64(saCacheSize))
+	assert.Equal(t, float64(0), testutil.ToFloat64(cmCacheSize))
+}
+
+func TestFallbackAnnotationPrefixes(t *testing.T) {
+	cache := &serviceAccountCache{
+		saCache:                map[string]*Entry{},
+		annotationPrefix:       "eks.amazonaws.com",
+		fallbackPrefixes:       []string{"iam.example.com"},
+		defaultAudience:        "sts.amazonaws.com",
+		defaultTokenExpiration: pkg.DefaultTokenExpiration,
+		webhookUsage:           prometheus.NewGauge(prometheus.GaugeOpts{}),
+		notifications:          newNotifications(make(chan *Request, 10)),
+	}
+
+	cache.addSA(&v1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "migrating",
+			Namespace: "default",
+			Annotations: map[string]string{
+				"eks.amazonaws.com/role-arn": "arn:aws:iam::111122223333:role/new",
+				"iam.example.com/role-arn":   "arn:aws:iam::111122223333:role/old",
+				"iam.example.com/audience":   "example.com",
+			},
+		},
+	})
+	cache.addSA(&v1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "legacy",
+			Namespace:   "default",
+			Annotations: map[string]string{"iam.example.com/role-arn": "arn:aws:iam::111122223333:role/old"},
+		},
+	})
+
+	resp := cache.Get(Request{Name: "migrating", Namespace: "default"})
+	assert.Equal(t, "arn:aws:iam::111122223333:role/new", resp.RoleARN, "the primary prefix should take precedence")
+	assert.Equal(t, "example.com", resp.Audience)
+
+	resp = cache.Get(Request{Name: "legacy", Namespace: "default"})
+	assert.Equal(t, "arn:aws:iam::111122223333:role/old", resp.RoleARN)
+	assert.Equal(t, "sts.amazonaws.com", resp.Audience)
+	assert.True(t, cache.hasAnnotations(&v1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{"iam.example.com/audience": "example.com"}},
+	}))
+}
+
+func TestNamespaceAudience(t *testing.T) {
+	namespaces := map[string]map[string]string{
+		"tenant-a": {"eks.amazonaws.com/audience": "tenant-a.example.com"},
+		"tenant-b": {},
+	}
+	cache := &serviceAccountCache{
+		saCache:                map[string]*Entry{},
+		annotationPrefix:       "eks.amazonaws.com",
+		defaultAudience:        "sts.amazonaws.com",
+		defaultTokenExpiration: pkg.DefaultTokenExpiration,
+		webhookUsage:           prometheus.NewGauge(prometheus.GaugeOpts{}),
+		notifications:          newNotifications(make(chan *Request, 10)),
+		namespaceAnnotations: func(namespace string) (map[string]string, error) {
+			annotations, ok := namespaces[namespace]
+			if !ok {
+				return nil, fmt.Errorf("namespace %s not found", namespace)
+			}
+			return annotations, nil
+		},
+	}
+	for _, namespace := range []string{"tenant-a", "tenant-b", "tenant-c"} {
+		cache.addSA(&v1.ServiceAccount{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "default",
+				Namespace:   namespace,
+				Annotations: map[string]string{"eks.amazonaws.com/role-arn": "arn:aws:iam::111122223333:role/" + namespace},
+			},
+		})
+	}
+	cache.addSA(&v1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "explicit",
+			Namespace: "tenant-a",
+			Annotations: map[string]string{
+				"eks.amazonaws.com/role-arn": "arn:aws:iam::111122223333:role/explicit",
+				"eks.amazonaws.com/audience": "example.com",
+			},
+		},
+	})
+
+	assert.NoError(t, cache.populateCacheFromCM(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod-identity-webhook"},
+		Data: map[string]string{"config": `{"version":"v2","serviceAccounts":{"tenant-c/cm":{"roleArn":"arn:aws:iam::111122223333:role/cm"}},` +
+			`"namespaces":{"tenant-a":{"audience":"cm-a.example.com"},"tenant-b":{"audience":"cm-b.example.com"},"tenant-c":{"audience":"cm-c.example.com"}}}`},
+	}))
+
+	for _, c := range []struct {
+		namespace, name, audience string
+	}{
+		{"tenant-a", "explicit", "example.com"},
+		{"tenant-a", "default", "tenant-a.example.com"},
+		{"tenant-b", "default", "cm-b.example.com"},
+		{"tenant-c", "default", "cm-c.example.com"},
+		{"tenant-c", "cm", "cm-c.example.com"},
+	} {
+		assert.Equal(t, c.audience, cache.Get(Request{Name: c.name, Namespace: c.namespace}).Audience, "%s/%s", c.namespace, c.name)
+	}
+
+	cache.removeCMSource("pod-identity-webhook")
+	assert.Equal(t, "sts.amazonaws.com", cache.Get(Request{Name: "default", Namespace: "tenant-b"}).Audience)
+
+	err := cache.populateCacheFromCM(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod-identity-webhook"},
+		Data:       map[string]string{"config": `{"version":"v2","serviceAccounts":{},"namespaces":{"tenant-b":{},"tenant-c":{"audience":"cm-c.example.com"}}}`},
+	})
+	assert.EqualError(t, err, `ignored 1 invalid entries of ConfigMap pod-identity-webhook: namespace "tenant-b": audience is required`)
+	assert.Equal(t, "sts.amazonaws.com", cache.Get(Request{Name: "default", Namespace: "tenant-b"}).Audience)
+	assert.Equal(t, "cm-c.example.com", cache.Get(Request{Name: "default", Namespace: "tenant-c"}).Audience)
+}
+
+func TestSkipInvalidRoleARN(t *testing.T) {
+	testSA := &v1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "invalid",
+			Namespace: "default",
+			Annotations: map[string]string{
+				"eks.amazonaws.com/role-arn": "arn:aws:iam::role/missing-account",
+			},
+		},
+	}
+
+	for _, skip := range []bool{false, true} {
+		t.Run(fmt.Sprintf("skip=%t", skip), func(t *testing.T) {
+			cache := &serviceAccountCache{
+				saCache:            map[string]*Entry{},
+				annotationPrefix:   "eks.amazonaws.com",
+				webhookUsage:       prometheus.NewGauge(prometheus.GaugeOpts{}),
+				notifications:      newNotifications(make(chan *Request, 10)),
+				skipInvalidRoleARN: skip,
+			}
+			invalid := testutil.ToFloat64(invalidRoleARNCounter.WithLabelValues("default"))
+
+			cache.addSA(testSA)
+
+			resp := cache.Get(Request{Name: "invalid", Namespace: "default"})
+			assert.True(t, resp.FoundInCache)
+			if skip {
+				assert.Empty(t, resp.RoleARN)
+			} else {
+				assert.Equal(t, "arn:aws:iam::role/missing-account", resp.RoleARN)
+			}
+			assert.Equal(t, invalid+1, testutil.ToFloat64(invalidRoleARNCounter.WithLabelValues("default")))
+		})
+	}
+}
+
 func TestNotification(t *testing.T) {
 	reqWithNotification := Request{
 		Name:                "foo",
@@ -154,6 +350,40 @@ func TestNotification(t *testing.T) {
 	})
 }
 
+func TestNotificationFetchQueueFull(t *testing.T) {
+	fetchRequests := make(chan *Request, 1)
+	cache := &serviceAccountCache{
+		saCache:       map[string]*Entry{},
+		notifications: newNotifications(fetchRequests),
+	}
+
+	cache.Get(Request{Name: "foo", Namespace: "default", RequestNotification: true})
+	assert.Len(t, fetchRequests, 1)
+
+	// the fetch queue is full
+	req := Request{Name: "bar", Namespace: "default", RequestNotification: true}
+	select {
+	case <-cache.Get(req).Notifier:
+	default:
+		t.Fatal("expected an already closed notifier when the fetch queue is full")
+	}
+	assert.NotContains(t, cache.notifications.handlers, req.CacheKey())
+
+	<-fetchRequests
+	resp := cache.Get(req)
+	select {
+	case queued := <-fetchRequests:
+		assert.Equal(t, req.CacheKey(), queued.CacheKey(), "expected the second lookup to queue a fetch")
+	default:
+		t.Fatal("expected the second lookup to queue a fetch")
+	}
+	select {
+	case <-resp.Notifier:
+		t.Fatal("expected the notifier to wait for the fetch")
+	default:
+	}
+}
+
 func TestFetchFromAPIServer(t *testing.T) {
 	testSA := &v1.ServiceAccount{
 		ObjectMeta: metav1.ObjectMeta{
@@ -177,7 +407,7 @@ func TestFetchFromAPIServer(t *testing.T) {
 		"eks.amazonaws.com",
 		true,
 		86400,
-		emptyInformer,
+		[]coreinformers.ServiceAccountInformer{emptyInformer},
 		nil,
 		ComposeRoleArn{},
 		fakeSAClient.CoreV1(),
@@ -300,7 +530,7 @@ func TestNonRegionalSTS(t *testing.T) {
 				"eks.amazonaws.com",
 				tc.defaultRegionalSTS,
 				86400,
-				informer,
+				[]coreinformers.ServiceAccountInformer{informer},
 				nil,
 				testComposeRoleArn,
 				fakeClient.CoreV1(),
@@ -367,7 +597,7 @@ func TestPopulateCacheFromCM(t *testing.T) {
 	}
 
 	{
-		err := c.populateCacheFromCM(nil, cm)
+		err := c.populateCacheFromCM(cm)
 		if err != nil {
 			t.Errorf("failed to build cache: %v", err)
 		}
@@ -379,7 +609,7 @@ func TestPopulateCacheFromCM(t *testing.T) {
 	}
 
 	{
-		err := c.populateCacheFromCM(cm, cm)
+		err := c.populateCacheFromCM(cm)
 		if err != nil {
 			t.Errorf("failed to build cache: %v", err)
 		}
@@ -391,7 +621,7 @@ func TestPopulateCacheFromCM(t *testing.T) {
 	}
 
 	{
-		err := c.populateCacheFromCM(cm, cm2)
+		err := c.populateCacheFromCM(cm2)
 		if err != nil {
 			t.Errorf("failed to build cache: %v", err)
 		}
@@ -402,6 +632,15 @@ func TestPopulateCacheFromCM(t *testing.T) {
 		}
 	}
 
+	{
+		c.removeCMSource(cm2.Name)
+
+		resp := c.Get(Request{Name: "mysa", Namespace: "myns"})
+		if resp.RoleARN != "" {
+			t.Errorf("found entry of a deleted ConfigMap")
+		}
+		assert.Equal(t, float64(0), testutil.ToFloat64(cmCacheSize))
+	}
 }
 
 func TestPopulateCacheFromCMWithWildcard(t *testing.T) {
@@ -427,7 +666,7 @@ func TestPopulateCacheFromCMWithWildcard(t *testing.T) {
 	}
 
 	{
-		err := c.populateCacheFromCM(nil, cm)
+		err := c.populateCacheFromCM(cm)
 		if err != nil {
 			t.Errorf("failed to build cache: %v", err)
 		}
@@ -439,7 +678,7 @@ func TestPopulateCacheFromCMWithWildcard(t *testing.T) {
 	}
 
 	{
-		err := c.populateCacheFromCM(cm, cm)
+		err := c.populateCacheFromCM(cm)
 		if err != nil {
 			t.Errorf("failed to build cache: %v", err)
 		}
@@ -451,7 +690,7 @@ func TestPopulateCacheFromCMWithWildcard(t *testing.T) {
 	}
 
 	{
-		err := c.populateCacheFromCM(cm, cm2)
+		err := c.populateCacheFromCM(cm2)
 		if err != nil {
 			t.Errorf("failed to build cache: %v", err)
 		}
@@ -550,7 +789,7 @@ func TestCachePrecedence(t *testing.T) {
 
 	{
 		c.addSA(sa)
-		err := c.populateCacheFromCM(nil, cm)
+		err := c.populateCacheFromCM(cm)
 		if err != nil {
 			t.Errorf("failed to build cache: %v", err)
 		}
@@ -563,10 +802,13 @@ func TestCachePrecedence(t *testing.T) {
 		if resp.TokenExpiration != int64(saTokenExpiration) {
 			t.Errorf("expected tokenExpiration %d, got %d", saTokenExpiration, resp.TokenExpiration)
 		}
+		if resp.Source != SourceServiceAccount {
+			t.Errorf("expected source %s, got %s", SourceServiceAccount, resp.Source)
+		}
 	}
 
 	{
-		err := c.populateCacheFromCM(cm, cm2)
+		err := c.populateCacheFromCM(cm2)
 		if err != nil {
 			t.Errorf("failed to build cache: %v", err)
 		}
@@ -597,7 +839,7 @@ func TestCachePrecedence(t *testing.T) {
 	{
 		klog.Info("CM")
 		// Adding CM back in. This time only the CM entry exists.
-		err := c.populateCacheFromCM(nil, cm)
+		err := c.populateCacheFromCM(cm)
 		if err != nil {
 			t.Errorf("failed to build cache: %v", err)
 		}
@@ -610,6 +852,9 @@ func TestCachePrecedence(t *testing.T) {
 		if resp.TokenExpiration != pkg.DefaultTokenExpiration {
 			t.Errorf("expected tokenExpiration %d, got %d", pkg.DefaultTokenExpiration, resp.TokenExpiration)
 		}
+		if resp.Source != SourceConfigMap {
+			t.Errorf("expected source %s, got %s", SourceConfigMap, resp.Source)
+		}
 	}
 
 }
@@ -648,7 +893,7 @@ func TestRoleArnComposition(t *testing.T) {
 		"eks.amazonaws.com",
 		true,
 		86400,
-		informer,
+		[]coreinformers.ServiceAccountInformer{informer},
 		nil,
 		testComposeRoleArn,
 		fakeClient.CoreV1(),
@@ -758,7 +1003,7 @@ func TestGetCommonConfigurations(t *testing.T) {
 				cache.addSA(tc.serviceAccount)
 			}
 			if tc.configMap != nil {
-				cache.populateCacheFromCM(nil, tc.configMap)
+				cache.populateCacheFromCM(tc.configMap)
 			}
 
 			useRegionalSTS, tokenExpiration := cache.GetCommonConfigurations(tc.requestServiceAccount, tc.requestNamespace)
@@ -767,3 +1012,433 @@ func TestGetCommonConfigurations(t *testing.T) {
 		})
 	}
 }
+
+func TestTransformServiceAccount(t *testing.T) {
+	sa := &v1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "default",
+			Namespace:       "default",
+			UID:             "d0c9a1f6-7c3c-4c1e-8b8e-3f1f6a0e7b11",
+			ResourceVersion: "42",
+			Labels:          map[string]string{"app": "test"},
+			Annotations:     map[string]string{"eks.amazonaws.com/role-arn": "arn:aws:iam::111122223333:role/s3-reader"},
+			ManagedFields:   []metav1.ManagedFieldsEntry{{Manager: "kubectl"}},
+		},
+		Secrets:          []v1.ObjectReference{{Name: "default-token"}},
+		ImagePullSecrets: []v1.LocalObjectReference{{Name: "registry"}},
+	}
+
+	obj, err := transformServiceAccount(sa)
+	assert.NoError(t, err)
+	assert.Equal(t, &v1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "default",
+			Namespace:       "default",
+			UID:             "d0c9a1f6-7c3c-4c1e-8b8e-3f1f6a0e7b11",
+			ResourceVersion: "42",
+			Annotations:     map[string]string{"eks.amazonaws.com/role-arn": "arn:aws:iam::111122223333:role/s3-reader"},
+		},
+	}, obj)
+
+	cm := &v1.ConfigMap{}
+	obj, err = transformServiceAccount(cm)
+	assert.NoError(t, err)
+	assert.Same(t, cm, obj)
+}
+
+func TestEventWorkers(t *testing.T) {
+	testSA := &v1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "default",
+			Namespace: "default",
+			Annotations: map[string]string{
+				"eks.amazonaws.com/role-arn": "arn:aws:iam::111122223333:role/s3-reader",
+			},
+		},
+	}
+
+	fakeClient := fake.NewSimpleClientset(testSA)
+	informerFactory := informers.NewSharedInformerFactory(fakeClient, 0)
+	informer := informerFactory.Core().V1().ServiceAccounts()
+
+	c := New(
+		"sts.amazonaws.com",
+		"eks.amazonaws.com",
+		false,
+		86400,
+		[]coreinformers.ServiceAccountInformer{informer},
+		nil,
+		ComposeRoleArn{},
+		fakeClient.CoreV1(),
+		WithEventWorkers(2),
+	)
+	stop := make(chan struct{})
+	informerFactory.Start(stop)
+	informerFactory.WaitForCacheSync(stop)
+	c.Start(stop)
+	defer close(stop)
+
+	roleARN := func() string {
+		return c.Get(Request{Name: "default", Namespace: "default"}).RoleARN
+	}
+	assert.Eventually(t, func() bool { return roleARN() == "arn:aws:iam::111122223333:role/s3-reader" }, time.Second, 10*time.Millisecond)
+
+	// Events after the informers synced are processed by the workers
+	updated := testSA.DeepCopy()
+	updated.Annotations["eks.amazonaws.com/role-arn"] = "arn:aws:iam::111122223333:role/s3-writer"
+	_, err := fakeClient.CoreV1().ServiceAccounts("default").Update(context.Background(), updated, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool { return roleARN() == "arn:aws:iam::111122223333:role/s3-writer" }, time.Second, 10*time.Millisecond)
+
+	err = fakeClient.CoreV1().ServiceAccounts("default").Delete(context.Background(), "default", metav1.DeleteOptions{})
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return !c.Get(Request{Name: "default", Namespace: "default"}).FoundInCache
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestEventRetries(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	c := &serviceAccountCache{
+		saCache:          map[string]*Entry{},
+		annotationPrefix: "eks.amazonaws.com",
+		webhookUsage:     prometheus.NewGauge(prometheus.GaugeOpts{}),
+		notifications:    newNotifications(make(chan *Request, 10)),
+		saIndexers:       []cache.Indexer{indexer},
+		eventQueue:       newEventQueue(),
+	}
+	defer c.eventQueue.ShutDown()
+
+	// An object of another type under the key of the service account fails
+	// the sync, which is retried
+	assert.NoError(t, indexer.Add(&v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "default"}}))
+	c.eventQueue.Add("default/default")
+	assert.True(t, c.processNextEvent())
+	assert.Equal(t, 1, c.eventQueue.NumRequeues("default/default"))
+
+	assert.NoError(t, indexer.Update(&v1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+		Name:        "default",
+		Namespace:   "default",
+		Annotations: map[string]string{"eks.amazonaws.com/role-arn": "arn:aws:iam::111122223333:role/s3-reader"},
+	}}))
+	assert.True(t, c.processNextEvent())
+	assert.Equal(t, 0, c.eventQueue.NumRequeues("default/default"))
+	assert.Equal(t, "arn:aws:iam::111122223333:role/s3-reader", c.Get(Request{Name: "default", Namespace: "default"}).RoleARN)
+
+	// Events are dropped after maxEventRetries failures
+	assert.NoError(t, indexer.Update(&v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "default"}}))
+	c.eventQueue.Add("default/default")
+	for i := 0; i <= maxEventRetries; i++ {
+		assert.True(t, c.processNextEvent())
+	}
+	assert.Equal(t, 0, c.eventQueue.NumRequeues("default/default"))
+	assert.Equal(t, 0, c.eventQueue.Len())
+}
+
+func TestAnnotatedOnly(t *testing.T) {
+	annotatedSA := &v1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "annotated",
+			Namespace: "default",
+			Annotations: map[string]string{
+				"eks.amazonaws.com/role-arn": "arn:aws:iam::111122223333:role/s3-reader",
+			},
+		},
+	}
+	plainSA := &v1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "plain",
+			Namespace: "default",
+		},
+	}
+
+	fakeClient := fake.NewSimpleClientset(annotatedSA, plainSA)
+	informerFactory := informers.NewSharedInformerFactory(fakeClient, 0)
+	informer := informerFactory.Core().V1().ServiceAccounts()
+
+	c := New(
+		"sts.amazonaws.com",
+		"eks.amazonaws.com",
+		false,
+		86400,
+		[]coreinformers.ServiceAccountInformer{informer},
+		nil,
+		ComposeRoleArn{},
+		fakeClient.CoreV1(),
+		WithAnnotatedOnly(true),
+	)
+	stop := make(chan struct{})
+	informerFactory.Start(stop)
+	informerFactory.WaitForCacheSync(stop)
+	c.Start(stop)
+	defer close(stop)
+
+	err := wait.ExponentialBackoff(wait.Backoff{Duration: 10 * time.Millisecond, Factor: 1.0, Steps: 3}, func() (bool, error) {
+		return len(c.(*serviceAccountCache).saCache) != 0, nil
+	})
+	if err != nil {
+		t.Fatalf("cache never called addSA: %v", err)
+	}
+
+	sac := c.(*serviceAccountCache)
+	sac.mu.RLock()
+	_, annotatedCached := sac.saCache["default/annotated"]
+	_, plainCached := sac.saCache["default/plain"]
+	sac.mu.RUnlock()
+	assert.True(t, annotatedCached, "annotated service account should be cached")
+	assert.False(t, plainCached, "service account without annotations should not be cached")
+
+	resp := c.Get(Request{Name: "annotated", Namespace: "default"})
+	assert.True(t, resp.FoundInCache)
+	assert.Equal(t, "arn:aws:iam::111122223333:role/s3-reader", resp.RoleARN)
+
+	resp = c.Get(Request{Name: "plain", Namespace: "default", RequestNotification: true})
+	assert.True(t, resp.FoundInCache, "service account without annotations should be found through the informer")
+	assert.Empty(t, resp.RoleARN)
+	assert.Nil(t, resp.Notifier)
+
+	resp = c.Get(Request{Name: "missing", Namespace: "default"})
+	assert.False(t, resp.FoundInCache)
+}
+
+func TestNegativeCache(t *testing.T) {
+	fakeClient := fake.NewSimpleClientset()
+	informerFactory := informers.NewSharedInformerFactory(fakeClient, 0)
+	informer := informerFactory.Core().V1().ServiceAccounts()
+
+	c := New(
+		"sts.amazonaws.com",
+		"eks.amazonaws.com",
+		false,
+		86400,
+		[]coreinformers.ServiceAccountInformer{informer},
+		nil,
+		ComposeRoleArn{},
+		fakeClient.CoreV1(),
+		WithNegativeCacheTTL(time.Minute),
+	)
+	stop := make(chan struct{})
+	informerFactory.Start(stop)
+	informerFactory.WaitForCacheSync(stop)
+	c.Start(stop)
+	defer close(stop)
+
+	req := Request{Name: "missing", Namespace: "default", RequestNotification: true}
+	resp := c.Get(req)
+	assert.False(t, resp.FoundInCache)
+	select {
+	case <-resp.Notifier:
+	case <-time.After(time.Second):
+		t.Fatal("notifier was not closed after the service account was not found")
+	}
+
+	hits := testutil.ToFloat64(negativeCacheHits)
+	resp = c.Get(req)
+	assert.False(t, resp.FoundInCache)
+	select {
+	case <-resp.Notifier:
+	default:
+		t.Fatal("expected an already closed notifier for a negatively cached service account")
+	}
+	assert.Equal(t, hits+1, testutil.ToFloat64(negativeCacheHits))
+	assert.Equal(t, 1, countGets(fakeClient), "negatively cached service account should not be fetched again")
+
+	c.(*serviceAccountCache).addSA(&v1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "missing", Namespace: "default"}})
+	assert.False(t, c.(*serviceAccountCache).negativeCache.has("default/missing"), "adding the service account should clear its negative cache entry")
+}
+
+func TestTenantFetchRateLimit(t *testing.T) {
+	fakeClient := fake.NewSimpleClientset()
+	informerFactory := informers.NewSharedInformerFactory(fakeClient, 0)
+	informer := informerFactory.Core().V1().ServiceAccounts()
+
+	c := New(
+		"sts.amazonaws.com",
+		"eks.amazonaws.com",
+		false,
+		86400,
+		[]coreinformers.ServiceAccountInformer{informer},
+		nil,
+		ComposeRoleArn{},
+		fakeClient.CoreV1(),
+		WithTenantFetchRateLimit(TenantKeyNamespace, 0.001, 2),
+	)
+	stop := make(chan struct{})
+	informerFactory.Start(stop)
+	informerFactory.WaitForCacheSync(stop)
+	c.Start(stop)
+	defer close(stop)
+
+	waitNotified := func(req Request) {
+		select {
+		case <-c.Get(req).Notifier:
+		case <-time.After(time.Second):
+			t.Fatalf("notifier of %s was not closed", req.CacheKey())
+		}
+	}
+	limited := testutil.ToFloat64(tenantFetchesRateLimited)
+	for _, name := range []string{"a", "b", "c", "d"} {
+		waitNotified(Request{Name: name, Namespace: "noisy", RequestNotification: true})
+	}
+	waitNotified(Request{Name: "a", Namespace: "quiet", RequestNotification: true})
+
+	assert.Equal(t, 3, countGets(fakeClient), "fetches over the burst of a namespace should not be made")
+	assert.Equal(t, limited+2, testutil.ToFloat64(tenantFetchesRateLimited))
+}
+
+func TestTenantLimiter(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	limiter := newTenantLimiter(TenantKeyServiceAccount, 1, 1)
+	limiter.now = func() time.Time { return now }
+
+	assert.True(t, limiter.allow(Request{Name: "a", Namespace: "default"}))
+	assert.False(t, limiter.allow(Request{Name: "a", Namespace: "default"}))
+	assert.True(t, limiter.allow(Request{Name: "b", Namespace: "default"}), "service accounts should have their own bucket")
+	now = now.Add(time.Second)
+	assert.True(t, limiter.allow(Request{Name: "a", Namespace: "default"}), "the bucket should be refilled")
+
+	// Idle limiters are purged once over the threshold
+	for i := 0; i < tenantLimiterPurgeThreshold; i++ {
+		limiter.allow(Request{Name: fmt.Sprintf("sa-%d", i), Namespace: "default"})
+	}
+	now = now.Add(time.Second)
+	limiter.allow(Request{Name: "new", Namespace: "default"})
+	assert.Len(t, limiter.limiters, 1)
+
+	var nilLimiter *tenantLimiter
+	assert.True(t, nilLimiter.allow(Request{Name: "a", Namespace: "default"}))
+}
+
+func TestFetchFromAPIRetries(t *testing.T) {
+	fakeClient := fake.NewSimpleClientset(&v1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "my-sa", Namespace: "default"}})
+	failures := 0
+	fakeClient.PrependReactor("get", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if failures > 0 {
+			failures--
+			return true, nil, apierrors.NewServiceUnavailable("unavailable")
+		}
+		return false, nil, nil
+	})
+	c := &serviceAccountCache{
+		fetchTimeout: time.Second,
+		fetchBackoff: wait.Backoff{Duration: time.Millisecond, Factor: 2, Steps: 3},
+	}
+	req := &Request{Name: "my-sa", Namespace: "default"}
+
+	failures = 2
+	sa, err := c.fetchFromAPI(context.TODO(), fakeClient.CoreV1(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, "my-sa", sa.Name)
+	assert.Equal(t, 3, countGets(fakeClient))
+
+	failures = 3
+	_, err = c.fetchFromAPI(context.TODO(), fakeClient.CoreV1(), req)
+	assert.True(t, apierrors.IsServiceUnavailable(err), "expected the last error once retries are exhausted, got %v", err)
+	assert.Equal(t, 6, countGets(fakeClient))
+
+	_, err = c.fetchFromAPI(context.TODO(), fakeClient.CoreV1(), &Request{Name: "missing", Namespace: "default"})
+	assert.True(t, apierrors.IsNotFound(err))
+	assert.Equal(t, 7, countGets(fakeClient), "not found errors should not be retried")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	failures = 3
+	_, err = c.fetchFromAPI(ctx, fakeClient.CoreV1(), req)
+	assert.True(t, apierrors.IsServiceUnavailable(err))
+	assert.Equal(t, 8, countGets(fakeClient), "fetches should not be retried once the context is done")
+}
+
+func TestPopulateCacheFromMultipleCMs(t *testing.T) {
+	newCM := func(name string, cmLabels map[string]string, config string) *v1.ConfigMap {
+		return &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: cmLabels},
+			Data:       map[string]string{"config": config},
+		}
+	}
+	teamLabels := map[string]string{"pod-identity-webhook/config": "true"}
+
+	c := serviceAccountCache{
+		cmCache:           make(map[string]*Entry),
+		configMapName:     "primary",
+		configMapSelector: labels.SelectorFromSet(teamLabels),
+	}
+
+	assert.NoError(t, c.populateCacheFromCM(newCM("team-b", teamLabels,
+		`{"ns/shared":{"RoleARN":"arn:aws:iam::111122223333:role/team-b"},"ns/b":{"RoleARN":"arn:aws:iam::111122223333:role/b"}}`)))
+	assert.NoError(t, c.populateCacheFromCM(newCM("team-a", teamLabels,
+		`{"ns/shared":{"RoleARN":"arn:aws:iam::111122223333:role/team-a"}}`)))
+	assert.NoError(t, c.populateCacheFromCM(newCM("unrelated", nil,
+		`{"ns/unrelated":{"RoleARN":"arn:aws:iam::111122223333:role/unrelated"}}`)))
+
+	assert.Equal(t, "arn:aws:iam::111122223333:role/team-a", c.Get(Request{Name: "shared", Namespace: "ns"}).RoleARN,
+		"the ConfigMap whose name sorts first should win")
+	assert.Equal(t, "arn:aws:iam::111122223333:role/b", c.Get(Request{Name: "b", Namespace: "ns"}).RoleARN)
+	assert.Empty(t, c.Get(Request{Name: "unrelated", Namespace: "ns"}).RoleARN)
+	assert.Equal(t, float64(1), testutil.ToFloat64(cmConflicts))
+
+	assert.NoError(t, c.populateCacheFromCM(newCM("primary", nil,
+		`{"ns/shared":{"RoleARN":"arn:aws:iam::111122223333:role/primary"}}`)))
+	assert.Equal(t, "arn:aws:iam::111122223333:role/primary", c.Get(Request{Name: "shared", Namespace: "ns"}).RoleARN,
+		"the named ConfigMap should take precedence")
+
+	// team-b no longer matches the selector
+	assert.NoError(t, c.populateCacheFromCM(newCM("team-b", nil,
+		`{"ns/b":{"RoleARN":"arn:aws:iam::111122223333:role/b"}}`)))
+	assert.Empty(t, c.Get(Request{Name: "b", Namespace: "ns"}).RoleARN)
+}
+
+func TestPopulateCacheFromCMValidation(t *testing.T) {
+	newCM := func(config string) *v1.ConfigMap {
+		return &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod-identity-webhook"},
+			Data:       map[string]string{"config": config},
+		}
+	}
+	recorder := record.NewFakeRecorder(10)
+	c := serviceAccountCache{
+		cmCache:                make(map[string]*Entry),
+		defaultTokenExpiration: 86400,
+		recorder:               recorder,
+	}
+
+	assert.NoError(t, c.populateCacheFromCM(newCM(
+		`{"version":"v2","serviceAccounts":{"ns/sa":{"roleArn":"arn:aws:iam::111122223333:role/sa","audience":"sts.amazonaws.com","useRegionalSTS":true}}}`)))
+	resp := c.Get(Request{Name: "sa", Namespace: "ns"})
+	assert.Equal(t, "arn:aws:iam::111122223333:role/sa", resp.RoleARN)
+	assert.Equal(t, "sts.amazonaws.com", resp.Audience)
+	assert.True(t, resp.UseRegionalSTS)
+	assert.Equal(t, int64(86400), resp.TokenExpiration)
+	assert.Equal(t, float64(0), testutil.ToFloat64(cmConfigErrors.WithLabelValues("pod-identity-webhook")))
+
+	// Invalid entries are rejected, valid ones are kept
+	err := c.populateCacheFromCM(newCM(`{"version":"v2","serviceAccounts":{` +
+		`"ns/valid":{"roleArn":"arn:aws:iam::111122223333:role/valid"},` +
+		`"ns/unknown-field":{"roleArn":"arn:aws:iam::111122223333:role/sa","expiration":3600},` +
+		`"ns/bad-arn":{"roleArn":"not-an-arn"},` +
+		`"no-slash":{"roleArn":"arn:aws:iam::111122223333:role/sa"},` +
+		`"ns/negative":{"roleArn":"arn:aws:iam::111122223333:role/sa","tokenExpiration":-1}}}`))
+	assert.ErrorContains(t, err, "ignored 4 invalid entries")
+	assert.ErrorContains(t, err, `service account "ns/unknown-field": json: unknown field "expiration"`)
+	assert.ErrorContains(t, err, `service account "ns/bad-arn": invalid role ARN "not-an-arn"`)
+	assert.ErrorContains(t, err, `service account "no-slash": key must be of the form namespace/name`)
+	assert.ErrorContains(t, err, `service account "ns/negative": token expiration must not be negative`)
+	assert.Equal(t, "arn:aws:iam::111122223333:role/valid", c.Get(Request{Name: "valid", Namespace: "ns"}).RoleARN)
+	assert.Empty(t, c.Get(Request{Name: "sa", Namespace: "ns"}).RoleARN)
+	assert.Empty(t, c.Get(Request{Name: "bad-arn", Namespace: "ns"}).RoleARN)
+	assert.Equal(t, float64(4), testutil.ToFloat64(cmConfigErrors.WithLabelValues("pod-identity-webhook")))
+	assert.Contains(t, <-recorder.Events, "Warning InvalidConfig")
+
+	// A document that can't be parsed leaves the previous entries in place
+	assert.ErrorContains(t, c.populateCacheFromCM(newCM(`{"version":"v3","serviceAccounts":{}}`)), `unsupported config version "v3"`)
+	assert.ErrorContains(t, c.populateCacheFromCM(newCM(`{"version":"v2","accounts":{}}`)), `unknown field "accounts"`)
+	assert.ErrorContains(t, c.populateCacheFromCM(newCM(`{`)), "failed to parse config")
+	assert.Equal(t, "arn:aws:iam::111122223333:role/valid", c.Get(Request{Name: "valid", Namespace: "ns"}).RoleARN)
+
+	// v1 entries are validated too
+	err = c.populateCacheFromCM(newCM(`{"ns/v1":{"RoleARN":"arn:aws:iam::111122223333:role/v1"},"ns/null":null}`))
+	assert.ErrorContains(t, err, `service account "ns/null": role ARN is required`)
+	assert.Equal(t, "arn:aws:iam::111122223333:role/v1", c.Get(Request{Name: "v1", Namespace: "ns"}).RoleARN)
+
+	c.removeCMSource("pod-identity-webhook")
+	assert.False(t, cmConfigErrors.DeleteLabelValues("pod-identity-webhook"), "the error gauge should be removed with the ConfigMap")
+}
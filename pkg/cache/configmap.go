@@ -0,0 +1,162 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/amazon-eks-pod-identity-webhook/pkg"
+)
+
+// configVersionV2 is the version of the config key schema with a version
+// field. Documents without it are read with the original v1 schema, a map of
+// "namespace/name" keys to Entry.
+const configVersionV2 = "v2"
+
+// configV2 is the v2 schema of the config key of the ConfigMap, e.g.
+//
+//	{"version":"v2","serviceAccounts":{"default/my-sa":{"roleArn":"arn:aws:iam::111122223333:role/my-role"}}}
+//
+// Unknown fields are rejected. Namespaces optionally maps namespaces to the
+// defaults of their service accounts.
+type configV2 struct {
+	Version         string                     `json:"version"`
+	ServiceAccounts map[string]json.RawMessage `json:"serviceAccounts"`
+	Namespaces      map[string]json.RawMessage `json:"namespaces,omitempty"`
+}
+
+type namespaceV2 struct {
+	Audience string `json:"audience"`
+}
+
+type entryV2 struct {
+	RoleARN         string `json:"roleArn"`
+	Audience        string `json:"audience,omitempty"`
+	UseRegionalSTS  bool   `json:"useRegionalSTS,omitempty"`
+	TokenExpiration int64  `json:"tokenExpiration,omitempty"`
+}
+
+// parseConfig parses the config key of a ConfigMap, and the default audiences
+// of namespaces of the v2 schema. Invalid entries are left out and reported in
+// errs, sorted by key. entries is nil when the document itself can't be
+// parsed.
+func parseConfig(config string, defaultTokenExpiration int64) (entries map[string]*Entry, namespaceAudiences map[string]string, errs []error) {
+	var document map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(config), &document); err != nil {
+		return nil, nil, []error{err}
+	}
+
+	decodeEntry := decodeEntryV1
+	raw := document
+	if _, ok := document["version"]; ok {
+		var v2 configV2
+		if err := decodeStrict([]byte(config), &v2); err != nil {
+			return nil, nil, []error{err}
+		}
+		if v2.Version != configVersionV2 {
+			return nil, nil, []error{fmt.Errorf("unsupported config version %q, expected %q", v2.Version, configVersionV2)}
+		}
+		decodeEntry = decodeEntryV2
+		raw = v2.ServiceAccounts
+		namespaceAudiences, errs = parseNamespacesV2(v2.Namespaces)
+	}
+
+	keys := make([]string, 0, len(raw))
+	for key := range raw {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	entries = make(map[string]*Entry, len(raw))
+	for _, key := range keys {
+		entry, err := decodeEntry(raw[key])
+		if err == nil {
+			err = validateEntry(key, entry)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("service account %q: %v", key, err))
+			continue
+		}
+		if entry.TokenExpiration == 0 {
+			entry.TokenExpiration = defaultTokenExpiration
+		}
+		entries[key] = entry
+	}
+	return entries, namespaceAudiences, errs
+}
+
+// parseNamespacesV2 returns the default audiences of the namespaces of the v2
+// schema. Invalid namespaces are left out and reported in errs, sorted by name.
+func parseNamespacesV2(raw map[string]json.RawMessage) (namespaceAudiences map[string]string, errs []error) {
+	names := make([]string, 0, len(raw))
+	for name := range raw {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	namespaceAudiences = make(map[string]string, len(raw))
+	for _, name := range names {
+		var namespace namespaceV2
+		err := decodeStrict(raw[name], &namespace)
+		if err == nil && (name == "" || strings.Contains(name, "/")) {
+			err = fmt.Errorf("invalid namespace name")
+		}
+		if err == nil && namespace.Audience == "" {
+			err = fmt.Errorf("audience is required")
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("namespace %q: %v", name, err))
+			continue
+		}
+		namespaceAudiences[name] = namespace.Audience
+	}
+	return namespaceAudiences, errs
+}
+
+// decodeEntryV1 decodes an entry of the v1 schema, which for compatibility
+// ignores unknown fields and matches field names case-insensitively
+func decodeEntryV1(raw json.RawMessage) (*Entry, error) {
+	entry := &Entry{}
+	if err := json.Unmarshal(raw, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func decodeEntryV2(raw json.RawMessage) (*Entry, error) {
+	var entry entryV2
+	if err := decodeStrict(raw, &entry); err != nil {
+		return nil, err
+	}
+	return &Entry{
+		RoleARN:         entry.RoleARN,
+		Audience:        entry.Audience,
+		UseRegionalSTS:  entry.UseRegionalSTS,
+		TokenExpiration: entry.TokenExpiration,
+	}, nil
+}
+
+func decodeStrict(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+func validateEntry(key string, entry *Entry) error {
+	namespace, name, ok := strings.Cut(key, "/")
+	if !ok || namespace == "" || name == "" || strings.Contains(name, "/") {
+		return fmt.Errorf("key must be of the form namespace/name")
+	}
+	if entry.RoleARN == "" {
+		return fmt.Errorf("role ARN is required")
+	}
+	if !pkg.ValidateRoleARN(entry.RoleARN) {
+		return fmt.Errorf("invalid role ARN %q", entry.RoleARN)
+	}
+	if entry.TokenExpiration < 0 {
+		return fmt.Errorf("token expiration must not be negative")
+	}
+	return nil
+}
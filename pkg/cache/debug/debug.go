@@ -4,25 +4,163 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/aws/amazon-eks-pod-identity-webhook/pkg/cache"
+	"github.com/aws/amazon-eks-pod-identity-webhook/pkg/containercredentials"
+	"github.com/aws/amazon-eks-pod-identity-webhook/pkg/handler"
 	"k8s.io/api/admission/v1beta1"
 	"k8s.io/klog/v2"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type Dumper struct {
 	Cache cache.ServiceAccountCache
+	// ContainerCredentialsConfig is the container credentials config, nil
+	// when none is configured
+	ContainerCredentialsConfig containercredentials.Config
+	// CredentialMethodPrecedence is the credential method precedence of
+	// service accounts not overriding it
+	CredentialMethodPrecedence string
 }
 
+// Caches holds the contents of all the caches the webhook mutates pods with
+type Caches struct {
+	// ServiceAccounts are the service accounts read from their annotations
+	ServiceAccounts json.RawMessage
+	// ConfigMaps are the service accounts read from the ConfigMaps, when the
+	// cache watches ConfigMaps
+	ConfigMaps json.RawMessage `json:",omitempty"`
+	// ContainerCredentials is the container credentials config, when set
+	ContainerCredentials json.RawMessage `json:",omitempty"`
+}
+
+// Effective is the configuration a pod of a service account is mutated with
+type Effective struct {
+	// RoleARN is the role ARN of the service account, and RoleARNSource where
+	// it was read from, cache.SourceServiceAccount or cache.SourceConfigMap
+	RoleARN       string `json:",omitempty"`
+	RoleARNSource string `json:",omitempty"`
+	// ContainerCredentials is the container credentials identity of the
+	// service account
+	ContainerCredentials *containercredentials.PatchConfig `json:",omitempty"`
+	// CredentialMethods are the credential methods injected into the pods
+	CredentialMethods []string
+}
+
+// continueHeader is the response header holding the key to pass as the
+// continue parameter to get the next page of cache entries
+const continueHeader = "X-Continue"
+
+// Handle dumps the cache entries, keyed by namespace/name. The entries can be
+// filtered with the namespace and name query parameters, and paginated with
+// the limit and continue parameters: when more entries match, the
+// X-Continue response header holds the value of continue for the next page.
 func (c *Dumper) Handle(w http.ResponseWriter, r *http.Request) {
 	res := c.Cache.ToJSON()
+	query := r.URL.Query()
+	if query.Has("namespace") || query.Has("name") || query.Has("limit") || query.Has("continue") {
+		filtered, next, err := filterEntries(res, query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if next != "" {
+			w.Header().Set(continueHeader, next)
+		}
+		res = filtered
+	}
 	if _, err := w.Write([]byte(res)); err != nil {
-		klog.Errorf("Can't dump cache contents: %v", err)
+		klog.ErrorS(err, "Can't dump cache contents")
 		http.Error(w, fmt.Sprintf("could not write response: %v", err), http.StatusInternalServerError)
 	}
 }
 
+// HandleAll dumps the contents of all the caches
+func (c *Dumper) HandleAll(w http.ResponseWriter, r *http.Request) {
+	caches := Caches{
+		ServiceAccounts: rawJSON(c.Cache.ToJSON()),
+	}
+	if dumper, ok := c.Cache.(cache.ConfigMapDumper); ok {
+		caches.ConfigMaps = rawJSON(dumper.ConfigMapToJSON())
+	}
+	if dumper, ok := c.ContainerCredentialsConfig.(interface{ ToJSON() string }); ok {
+		caches.ContainerCredentials = rawJSON(dumper.ToJSON())
+	}
+	c.writeJSON(w, caches)
+}
+
+// HandleEffective returns the Effective configuration of the service account
+// given by the namespace and name query parameters, showing which of the
+// caches it is read from
+func (c *Dumper) HandleEffective(w http.ResponseWriter, r *http.Request) {
+	namespace, name := r.URL.Query().Get("namespace"), r.URL.Query().Get("name")
+	if namespace == "" || name == "" {
+		http.Error(w, "namespace and name query parameters are required", http.StatusBadRequest)
+		return
+	}
+	c.writeJSON(w, c.effective(namespace, name))
+}
+
+func (c *Dumper) effective(namespace, name string) Effective {
+	response := c.Cache.Get(cache.Request{Namespace: namespace, Name: name})
+	effective := Effective{
+		RoleARN:           response.RoleARN,
+		RoleARNSource:     response.Source,
+		CredentialMethods: []string{},
+	}
+	if c.ContainerCredentialsConfig != nil {
+		effective.ContainerCredentials = c.ContainerCredentialsConfig.Get(namespace, name)
+	}
+
+	// Same precedence as the Modifier
+	switch {
+	case effective.ContainerCredentials != nil && response.RoleARN != "":
+		precedence := c.CredentialMethodPrecedence
+		switch response.CredentialMethodPrecedence {
+		case handler.CredentialMethodPrecedenceContainerCredentials, handler.CredentialMethodPrecedenceSTSWebIdentity, handler.CredentialMethodPrecedenceBoth:
+			precedence = response.CredentialMethodPrecedence
+		}
+		switch precedence {
+		case handler.CredentialMethodPrecedenceSTSWebIdentity:
+			effective.CredentialMethods = []string{handler.CredentialMethodPrecedenceSTSWebIdentity}
+		case handler.CredentialMethodPrecedenceBoth:
+			effective.CredentialMethods = []string{handler.CredentialMethodPrecedenceContainerCredentials, handler.CredentialMethodPrecedenceSTSWebIdentity}
+		default:
+			effective.CredentialMethods = []string{handler.CredentialMethodPrecedenceContainerCredentials}
+		}
+	case effective.ContainerCredentials != nil:
+		effective.CredentialMethods = []string{handler.CredentialMethodPrecedenceContainerCredentials}
+	case response.RoleARN != "":
+		effective.CredentialMethods = []string{handler.CredentialMethodPrecedenceSTSWebIdentity}
+	}
+	return effective
+}
+
+// rawJSON returns the JSON contents of a cache, which are empty when they
+// could not be marshalled
+func rawJSON(contents string) json.RawMessage {
+	if contents == "" {
+		return nil
+	}
+	return json.RawMessage(contents)
+}
+
+func (c *Dumper) writeJSON(w http.ResponseWriter, v interface{}) {
+	resp, err := json.MarshalIndent(v, "", " ")
+	if err != nil {
+		klog.ErrorS(err, "Can't encode response")
+		http.Error(w, fmt.Sprintf("could not encode response: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if _, err := w.Write(resp); err != nil {
+		klog.ErrorS(err, "Can't write response")
+	}
+}
+
 func (c *Dumper) Clear(w http.ResponseWriter, r *http.Request) {
 	c.Cache.Clear()
 }
@@ -42,11 +180,60 @@ func (c *Dumper) Deny(w http.ResponseWriter, r *http.Request) {
 	}
 	resp, err := json.Marshal(admissionReview)
 	if err != nil {
-		klog.Errorf("Can't encode response: %v", err)
+		klog.ErrorS(err, "Can't encode response")
 		http.Error(w, fmt.Sprintf("could not encode response: %v", err), http.StatusInternalServerError)
 	}
 	if _, err := w.Write(resp); err != nil {
-		klog.Errorf("Can't write response: %v", err)
+		klog.ErrorS(err, "Can't write response")
 		http.Error(w, fmt.Sprintf("could not write response: %v", err), http.StatusInternalServerError)
 	}
 }
+
+// filterEntries returns the JSON of the cache entries matching the query,
+// sorted by key, and the key to continue from when the page is full
+func filterEntries(contents string, query url.Values) (string, string, error) {
+	limit := 0
+	if value := query.Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			return "", "", fmt.Errorf("invalid limit %q", value)
+		}
+	}
+	entries := map[string]json.RawMessage{}
+	if contents != "" {
+		if err := json.Unmarshal([]byte(contents), &entries); err != nil {
+			return "", "", fmt.Errorf("could not decode cache contents: %v", err)
+		}
+	}
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		namespace, name, _ := strings.Cut(key, "/")
+		if query.Has("namespace") && namespace != query.Get("namespace") {
+			continue
+		}
+		if query.Has("name") && name != query.Get("name") {
+			continue
+		}
+		if key <= query.Get("continue") {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	next := ""
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+		next = keys[limit-1]
+	}
+	page := make(map[string]json.RawMessage, len(keys))
+	for _, key := range keys {
+		page[key] = entries[key]
+	}
+	filtered, err := json.MarshalIndent(page, "", " ")
+	if err != nil {
+		return "", "", err
+	}
+	return string(filtered), next, nil
+}
@@ -3,11 +3,13 @@ package debug
 import (
 	"encoding/json"
 	"github.com/aws/amazon-eks-pod-identity-webhook/pkg/cache"
+	"github.com/aws/amazon-eks-pod-identity-webhook/pkg/containercredentials"
 	"io"
 	"io/ioutil"
 	corev1 "k8s.io/api/core/v1"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strconv"
 	"testing"
 )
@@ -97,3 +99,148 @@ func TestLister(t *testing.T) {
 		})
 	}
 }
+
+func TestListerFilter(t *testing.T) {
+	accounts := generateServiceAccounts(10)
+	accounts[0].Namespace = "other"
+	debugger := Dumper{
+		Cache: cache.NewFakeServiceAccountCache(accounts...),
+	}
+	ts := httptest.NewServer(
+		http.HandlerFunc(debugger.Handle),
+	)
+	defer ts.Close()
+
+	cases := []struct {
+		caseName         string
+		query            string
+		expectedKeys     []string
+		expectedContinue string
+		expectedStatus   int
+	}{
+		{"namespace", "?namespace=other", []string{"other/test-sa-0"}, "", http.StatusOK},
+		{"name", "?name=test-sa-1", []string{"default/test-sa-1"}, "", http.StatusOK},
+		{"namespace and name", "?namespace=default&name=test-sa-0", []string{}, "", http.StatusOK},
+		{"first page", "?namespace=default&limit=2", []string{"default/test-sa-1", "default/test-sa-2"}, "default/test-sa-2", http.StatusOK},
+		{"next page", "?namespace=default&limit=2&continue=default/test-sa-2", []string{"default/test-sa-3", "default/test-sa-4"}, "default/test-sa-4", http.StatusOK},
+		{"last page", "?namespace=default&limit=2&continue=default/test-sa-7", []string{"default/test-sa-8", "default/test-sa-9"}, "", http.StatusOK},
+		{"invalid limit", "?limit=many", nil, "", http.StatusBadRequest},
+	}
+
+	for _, c := range cases {
+		t.Run(c.caseName, func(t *testing.T) {
+			resp, err := http.Get(ts.URL + c.query)
+			if err != nil {
+				t.Fatalf("Failed to make request: %v", err)
+			}
+			responseBytes, err := ioutil.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			if err != nil {
+				t.Fatalf("Failed to read response: %v", err)
+			}
+			if resp.StatusCode != c.expectedStatus {
+				t.Fatalf("Unexpected status %d, expected %d", resp.StatusCode, c.expectedStatus)
+			}
+			if c.expectedStatus != http.StatusOK {
+				return
+			}
+			m := map[string]cache.Entry{}
+			if err := json.Unmarshal(responseBytes, &m); err != nil {
+				t.Fatalf("Failed to unmarshal: %v", err)
+			}
+			if len(m) != len(c.expectedKeys) {
+				t.Errorf("Unexpected entries %v, expected %v", m, c.expectedKeys)
+			}
+			for _, key := range c.expectedKeys {
+				if _, ok := m[key]; !ok {
+					t.Errorf("Missing entry %s", key)
+				}
+			}
+			if next := resp.Header.Get(continueHeader); next != c.expectedContinue {
+				t.Errorf("Unexpected continue %q, expected %q", next, c.expectedContinue)
+			}
+		})
+	}
+}
+
+func TestHandleAll(t *testing.T) {
+	debugger := Dumper{
+		Cache: cache.NewFakeServiceAccountCache(generateServiceAccounts(2)...),
+		ContainerCredentialsConfig: containercredentials.NewFileConfig(
+			"pods.eks.amazonaws.com", "/var/run/secrets/pods.eks.amazonaws.com/serviceaccount", "eks-pod-identity-token", "eks-pod-identity-token", "http://169.254.170.23/v1/credentials"),
+	}
+	rec := httptest.NewRecorder()
+	debugger.HandleAll(rec, httptest.NewRequest(http.MethodGet, "/debug/alpha/cache/all", nil))
+
+	caches := struct {
+		ServiceAccounts      map[string]cache.Entry
+		ContainerCredentials *containercredentials.IdentityConfigObject
+	}{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &caches); err != nil {
+		t.Fatalf("Failed to unmarshal: %v", err)
+	}
+	if len(caches.ServiceAccounts) != 2 {
+		t.Errorf("Unexpected service accounts %v", caches.ServiceAccounts)
+	}
+	if caches.ContainerCredentials != nil {
+		t.Errorf("Unexpected container credentials config %v before it is loaded", caches.ContainerCredentials)
+	}
+}
+
+func TestHandleEffective(t *testing.T) {
+	accounts := generateServiceAccounts(3)
+	accounts[1].Annotations["eks.amazonaws.com/credential-method-precedence"] = "both"
+	debugger := Dumper{
+		Cache: cache.NewFakeServiceAccountCache(accounts...),
+		ContainerCredentialsConfig: &containercredentials.FakeConfig{
+			Identities: map[containercredentials.Identity]bool{
+				{Namespace: "default", ServiceAccount: "test-sa-0"}: true,
+				{Namespace: "default", ServiceAccount: "test-sa-1"}: true,
+				{Namespace: "default", ServiceAccount: "other"}:     true,
+			},
+		},
+		CredentialMethodPrecedence: "container-credentials",
+	}
+
+	cases := []struct {
+		caseName          string
+		query             string
+		expectedStatus    int
+		expectedSource    string
+		expectedContainer bool
+		expectedMethods   []string
+	}{
+		{"both identities", "?namespace=default&name=test-sa-0", http.StatusOK, cache.SourceServiceAccount, true, []string{"container-credentials"}},
+		{"precedence override", "?namespace=default&name=test-sa-1", http.StatusOK, cache.SourceServiceAccount, true, []string{"container-credentials", "sts-web-identity"}},
+		{"role ARN only", "?namespace=default&name=test-sa-2", http.StatusOK, cache.SourceServiceAccount, false, []string{"sts-web-identity"}},
+		{"container credentials only", "?namespace=default&name=other", http.StatusOK, "", true, []string{"container-credentials"}},
+		{"unknown", "?namespace=default&name=unknown", http.StatusOK, "", false, []string{}},
+		{"missing name", "?namespace=default", http.StatusBadRequest, "", false, nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.caseName, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			debugger.HandleEffective(rec, httptest.NewRequest(http.MethodGet, "/debug/alpha/cache/effective"+c.query, nil))
+			if rec.Code != c.expectedStatus {
+				t.Fatalf("Unexpected status %d, expected %d", rec.Code, c.expectedStatus)
+			}
+			if c.expectedStatus != http.StatusOK {
+				return
+			}
+			effective := Effective{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &effective); err != nil {
+				t.Fatalf("Failed to unmarshal: %v", err)
+			}
+			if effective.RoleARNSource != c.expectedSource {
+				t.Errorf("Unexpected role ARN source %q, expected %q", effective.RoleARNSource, c.expectedSource)
+			}
+			if (effective.ContainerCredentials != nil) != c.expectedContainer {
+				t.Errorf("Unexpected container credentials %v", effective.ContainerCredentials)
+			}
+			if !reflect.DeepEqual(effective.CredentialMethods, c.expectedMethods) {
+				t.Errorf("Unexpected credential methods %v, expected %v", effective.CredentialMethods, c.expectedMethods)
+			}
+		})
+	}
+}
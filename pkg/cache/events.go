@@ -0,0 +1,113 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package cache
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+)
+
+// maxEventRetries is the number of times a service account event is retried
+// before it is dropped
+const maxEventRetries = 5
+
+// newEventQueue returns the rate limited queue of the keys of the service
+// accounts changed in the informers
+func newEventQueue() workqueue.TypedRateLimitingInterface[string] {
+	return workqueue.NewTypedRateLimitingQueueWithConfig(
+		workqueue.DefaultTypedControllerRateLimiter[string](),
+		workqueue.TypedRateLimitingQueueConfig[string]{Name: "serviceaccounts"},
+	)
+}
+
+// handleInformerEvent processes an event of a service account informer. Once
+// the informers have synced, the key of the service account is queued for the
+// event workers, so that bursts of events, e.g. on resyncs, neither block the
+// informers nor hold the cache lock in a row. Events of the same service
+// account queued in the meantime are processed once.
+func (c *serviceAccountCache) handleInformerEvent(sa *v1.ServiceAccount, deleted bool) {
+	if c.eventQueue == nil || !c.hasSynced() {
+		if deleted {
+			c.popSA(sa.Name, sa.Namespace)
+		} else {
+			c.handleInformerSA(sa)
+		}
+		return
+	}
+	c.eventQueue.Add(sa.Namespace + "/" + sa.Name)
+}
+
+// eventWorker processes queued service account events until the queue is
+// shut down
+func (c *serviceAccountCache) eventWorker() {
+	for c.processNextEvent() {
+	}
+}
+
+// processNextEvent syncs the next queued service account, and retries it with
+// backoff on error. It returns false once the queue is shut down.
+func (c *serviceAccountCache) processNextEvent() bool {
+	key, shutdown := c.eventQueue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.eventQueue.Done(key)
+
+	err := c.syncSA(key)
+	switch {
+	case err == nil:
+		c.eventQueue.Forget(key)
+	case c.eventQueue.NumRequeues(key) < maxEventRetries:
+		klog.V(4).InfoS("Retrying service account event", append(keyLogKeys(key), "err", err)...)
+		c.eventQueue.AddRateLimited(key)
+	default:
+		c.eventQueue.Forget(key)
+		klog.ErrorS(err, "Dropping service account event after retries", keyLogKeys(key)...)
+	}
+	return true
+}
+
+// syncSA updates the cache with the service account of the key as currently
+// stored by the informers, or removes it when it was deleted
+func (c *serviceAccountCache) syncSA(key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		// Not retried, the key will not become valid
+		utilruntime.HandleError(err)
+		return nil
+	}
+	for _, indexer := range c.saIndexers {
+		obj, exists, err := indexer.GetByKey(key)
+		if err != nil {
+			return fmt.Errorf("error getting service account %s from the informer: %v", key, err)
+		}
+		if exists {
+			sa, ok := obj.(*v1.ServiceAccount)
+			if !ok {
+				return fmt.Errorf("unexpected object of type %T for service account %s in the informer", obj, key)
+			}
+			c.handleInformerSA(sa)
+			return nil
+		}
+	}
+	c.popSA(name, namespace)
+	return nil
+}
@@ -34,6 +34,12 @@ func NewFakeServiceAccountCache(accounts ...*v1.ServiceAccount) *FakeServiceAcco
 		}
 
 		c.Add(sa.Name, sa.Namespace, arn, audience, regionalSTS, tokenExpiration)
+		c.cache[sa.Namespace+"/"+sa.Name].CredentialMethodPrecedence = sa.Annotations["eks.amazonaws.com/credential-method-precedence"]
+		c.cache[sa.Namespace+"/"+sa.Name].SkipTokenVolume = sa.Annotations["eks.amazonaws.com/skip-token-volume"]
+		c.cache[sa.Namespace+"/"+sa.Name].SDKUAAppID = sa.Annotations["eks.amazonaws.com/sdk-ua-app-id"]
+		c.cache[sa.Namespace+"/"+sa.Name].UseFIPSEndpoint = sa.Annotations["eks.amazonaws.com/use-fips-endpoint"]
+		c.cache[sa.Namespace+"/"+sa.Name].SkipRegion = sa.Annotations["eks.amazonaws.com/skip-region"]
+		c.cache[sa.Namespace+"/"+sa.Name].TokenFileName = sa.Annotations["eks.amazonaws.com/token-file-name"]
 	}
 	return c
 }
@@ -43,6 +49,9 @@ var _ ServiceAccountCache = &FakeServiceAccountCache{}
 // Start does nothing
 func (f *FakeServiceAccountCache) Start(chan struct{}) {}
 
+// HasSynced always returns true
+func (f *FakeServiceAccountCache) HasSynced() bool { return true }
+
 // Get gets a service account from the cache
 func (f *FakeServiceAccountCache) Get(req Request) Response {
 	f.mu.RLock()
@@ -51,12 +60,23 @@ func (f *FakeServiceAccountCache) Get(req Request) Response {
 	if !ok {
 		return Response{TokenExpiration: pkg.DefaultTokenExpiration}
 	}
+	source := ""
+	if resp.RoleARN != "" {
+		source = SourceServiceAccount
+	}
 	return Response{
-		RoleARN:         resp.RoleARN,
-		Audience:        resp.Audience,
-		UseRegionalSTS:  resp.UseRegionalSTS,
-		TokenExpiration: resp.TokenExpiration,
-		FoundInCache:    true,
+		RoleARN:                    resp.RoleARN,
+		Audience:                   resp.Audience,
+		UseRegionalSTS:             resp.UseRegionalSTS,
+		TokenExpiration:            resp.TokenExpiration,
+		CredentialMethodPrecedence: resp.CredentialMethodPrecedence,
+		SkipTokenVolume:            resp.SkipTokenVolume,
+		SDKUAAppID:                 resp.SDKUAAppID,
+		UseFIPSEndpoint:            resp.UseFIPSEndpoint,
+		SkipRegion:                 resp.SkipRegion,
+		TokenFileName:              resp.TokenFileName,
+		FoundInCache:               true,
+		Source:                     source,
 	}
 }
 
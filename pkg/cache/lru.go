@@ -0,0 +1,246 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/aws/amazon-eks-pod-identity-webhook/pkg"
+	"golang.org/x/time/rate"
+	"k8s.io/apimachinery/pkg/api/errors"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+)
+
+// lruServiceAccountCache is a ServiceAccountCache that does not watch service
+// accounts. They are fetched from the API server the first time a pod uses
+// them, and kept in a bounded LRU for up to ttl.
+type lruServiceAccountCache struct {
+	mu       sync.Mutex // guards entries, order and inflight
+	entries  map[string]*list.Element
+	order    *list.List
+	inflight map[string]chan struct{}
+	size     int
+	ttl      time.Duration
+	now      func() time.Time
+	limiter  *rate.Limiter
+	getter   corev1.ServiceAccountsGetter
+	// builder parses service account annotations the same way the informer
+	// backed cache does
+	builder *serviceAccountCache
+}
+
+type lruItem struct {
+	key     string
+	entry   *Entry
+	expires time.Time
+}
+
+// NewLRU returns a ServiceAccountCache that fetches service accounts from the
+// API server on demand instead of watching them, keeping at most size of them
+// for ttl.
+func NewLRU(defaultAudience,
+	prefix string,
+	defaultRegionalSTS bool,
+	defaultTokenExpiration int64,
+	composeRoleArn ComposeRoleArn,
+	SAGetter corev1.ServiceAccountsGetter,
+	size int,
+	ttl time.Duration,
+	opts ...Option,
+) ServiceAccountCache {
+	builder := &serviceAccountCache{
+		defaultAudience:        defaultAudience,
+		annotationPrefix:       prefix,
+		defaultRegionalSTS:     defaultRegionalSTS,
+		composeRoleArn:         composeRoleArn,
+		defaultTokenExpiration: defaultTokenExpiration,
+		webhookUsage:           webhookUsage,
+		fetchTimeout:           DefaultFetchTimeout,
+		fetchBackoff:           retry.DefaultBackoff,
+	}
+	for _, opt := range opts {
+		opt(builder)
+	}
+	return &lruServiceAccountCache{
+		entries:  map[string]*list.Element{},
+		order:    list.New(),
+		inflight: map[string]chan struct{}{},
+		size:     size,
+		ttl:      ttl,
+		now:      time.Now,
+		// Same rate limit as the notification path of the informer backed cache
+		limiter: rate.NewLimiter(rate.Every(100*time.Millisecond), 20),
+		getter:  SAGetter,
+		builder: builder,
+	}
+}
+
+func (c *lruServiceAccountCache) Start(stop chan struct{}) {}
+
+// HasSynced always returns true, there is no informer to wait for
+func (c *lruServiceAccountCache) HasSynced() bool {
+	return true
+}
+
+func (c *lruServiceAccountCache) Get(req Request) Response {
+	result := Response{
+		TokenExpiration: pkg.DefaultTokenExpiration,
+	}
+	entry := c.lookup(req)
+	if entry == nil {
+		klog.V(5).InfoS("Service account not found", req.LogKeys()...)
+		return result
+	}
+	result.FoundInCache = true
+	result.SkipTokenVolume = entry.SkipTokenVolume
+	if roleARN := c.builder.roleAliases.roleARN(entry); roleARN != "" {
+		result.RoleARN = roleARN
+		result.Audience = c.builder.audience(req.Namespace, entry.Audience, c.builder.defaultAudience)
+		result.UseRegionalSTS = entry.UseRegionalSTS
+		result.TokenExpiration = entry.TokenExpiration
+		result.CredentialMethodPrecedence = entry.CredentialMethodPrecedence
+		result.SDKUAAppID = entry.SDKUAAppID
+		result.UseFIPSEndpoint = entry.UseFIPSEndpoint
+		result.SkipRegion = entry.SkipRegion
+		result.TokenFileName = entry.TokenFileName
+		result.Source = SourceServiceAccount
+	}
+	return result
+}
+
+func (c *lruServiceAccountCache) GetCommonConfigurations(name, namespace string) (useRegionalSTS bool, tokenExpiration int64) {
+	if entry := c.lookup(Request{Name: name, Namespace: namespace}); entry != nil {
+		return entry.UseRegionalSTS, entry.TokenExpiration
+	}
+	return false, pkg.DefaultTokenExpiration
+}
+
+// lookup returns the cached entry for the service account, fetching it from
+// the API server if it is missing or expired. Concurrent lookups of the same
+// service account share a single fetch. Lookups stop waiting for the fetch
+// when the context of the request is done.
+func (c *lruServiceAccountCache) lookup(req Request) *Entry {
+	key := req.CacheKey()
+	ctx := req.requestContext()
+
+	c.mu.Lock()
+	if entry, ok := c.getLocked(key); ok {
+		c.mu.Unlock()
+		return entry
+	}
+	if done, ok := c.inflight[key]; ok {
+		c.mu.Unlock()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			klog.InfoS("Gave up waiting for the fetch of service account", append(req.LogKeys(), "err", ctx.Err())...)
+			return nil
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		entry, _ := c.getLocked(key)
+		return entry
+	}
+	done := make(chan struct{})
+	c.inflight[key] = done
+	c.mu.Unlock()
+
+	entry := c.fetch(ctx, &req)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry != nil {
+		c.addLocked(key, entry)
+	}
+	delete(c.inflight, key)
+	close(done)
+	return entry
+}
+
+func (c *lruServiceAccountCache) fetch(ctx context.Context, req *Request) *Entry {
+	if c.builder.negativeCache.has(req.CacheKey()) {
+		return nil
+	}
+	if !c.builder.tenantLimiter.allow(*req) {
+		klog.InfoS("Too many fetches of missing service accounts in namespace, not fetching", req.LogKeys()...)
+		return nil
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if err := c.limiter.Wait(waitCtx); err != nil {
+		klog.ErrorS(err, "Rate limited fetching service account", req.LogKeys()...)
+		return nil
+	}
+	sa, err := c.builder.fetchFromAPI(ctx, c.getter, req)
+	if errors.IsNotFound(err) {
+		c.builder.negativeCache.add(req.CacheKey())
+		return nil
+	}
+	if err != nil {
+		klog.ErrorS(err, "Error fetching service account", req.LogKeys()...)
+		return nil
+	}
+	return c.builder.newEntry(sa)
+}
+
+func (c *lruServiceAccountCache) getLocked(key string) (*Entry, bool) {
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	item := elem.Value.(*lruItem)
+	if c.now().After(item.expires) {
+		c.removeLocked(elem)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return item.entry, true
+}
+
+func (c *lruServiceAccountCache) addLocked(key string, entry *Entry) {
+	if elem, ok := c.entries[key]; ok {
+		c.removeLocked(elem)
+	}
+	c.entries[key] = c.order.PushFront(&lruItem{key: key, entry: entry, expires: c.now().Add(c.ttl)})
+	for c.size > 0 && c.order.Len() > c.size {
+		c.removeLocked(c.order.Back())
+	}
+	saCacheSize.Set(float64(len(c.entries)))
+}
+
+func (c *lruServiceAccountCache) removeLocked(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*lruItem).key)
+	saCacheSize.Set(float64(len(c.entries)))
+}
+
+// ToJSON returns the unexpired cache contents as JSON string
+func (c *lruServiceAccountCache) ToJSON() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	entries := make(map[string]*Entry, len(c.entries))
+	for key, elem := range c.entries {
+		if item := elem.Value.(*lruItem); !now.After(item.expires) {
+			entries[key] = item.entry
+		}
+	}
+	contents, err := json.MarshalIndent(entries, "", " ")
+	if err != nil {
+		klog.ErrorS(err, "Json marshal error")
+		return ""
+	}
+	return string(contents)
+}
+
+func (c *lruServiceAccountCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = map[string]*list.Element{}
+	c.order.Init()
+	saCacheSize.Set(0)
+}
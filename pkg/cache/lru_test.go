@@ -0,0 +1,103 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestServiceAccount(name string) *v1.ServiceAccount {
+	return &v1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Annotations: map[string]string{
+				"eks.amazonaws.com/role-arn":         "arn:aws:iam::111122223333:role/" + name,
+				"eks.amazonaws.com/token-expiration": "3600",
+			},
+		},
+	}
+}
+
+func countGets(client *fake.Clientset) int {
+	count := 0
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "get" && action.GetResource().Resource == "serviceaccounts" {
+			count++
+		}
+	}
+	return count
+}
+
+func TestLRUGet(t *testing.T) {
+	fakeClient := fake.NewSimpleClientset(newTestServiceAccount("s3-reader"))
+	c := NewLRU("sts.amazonaws.com", "eks.amazonaws.com", false, 86400, ComposeRoleArn{}, fakeClient.CoreV1(), 10, time.Minute)
+
+	resp := c.Get(Request{Name: "s3-reader", Namespace: "default"})
+	assert.True(t, resp.FoundInCache)
+	assert.Equal(t, "arn:aws:iam::111122223333:role/s3-reader", resp.RoleARN)
+	assert.Equal(t, "sts.amazonaws.com", resp.Audience)
+	assert.Equal(t, int64(3600), resp.TokenExpiration)
+
+	resp = c.Get(Request{Name: "s3-reader", Namespace: "default"})
+	assert.True(t, resp.FoundInCache)
+	assert.Equal(t, 1, countGets(fakeClient), "second lookup should be served from the cache")
+
+	resp = c.Get(Request{Name: "missing", Namespace: "default"})
+	assert.False(t, resp.FoundInCache)
+	assert.Empty(t, resp.RoleARN)
+}
+
+func TestLRUEviction(t *testing.T) {
+	fakeClient := fake.NewSimpleClientset(
+		newTestServiceAccount("first"),
+		newTestServiceAccount("second"),
+	)
+	c := NewLRU("sts.amazonaws.com", "eks.amazonaws.com", false, 86400, ComposeRoleArn{}, fakeClient.CoreV1(), 1, time.Minute)
+	lru := c.(*lruServiceAccountCache)
+
+	c.Get(Request{Name: "first", Namespace: "default"})
+	c.Get(Request{Name: "second", Namespace: "default"})
+	assert.Len(t, lru.entries, 1)
+	assert.Contains(t, lru.entries, "default/second")
+
+	c.Get(Request{Name: "first", Namespace: "default"})
+	assert.Equal(t, 3, countGets(fakeClient), "evicted service account should be fetched again")
+}
+
+func TestLRUExpiry(t *testing.T) {
+	fakeClient := fake.NewSimpleClientset(newTestServiceAccount("s3-reader"))
+	c := NewLRU("sts.amazonaws.com", "eks.amazonaws.com", false, 86400, ComposeRoleArn{}, fakeClient.CoreV1(), 10, time.Minute)
+	lru := c.(*lruServiceAccountCache)
+	now := time.Now()
+	lru.now = func() time.Time { return now }
+
+	c.Get(Request{Name: "s3-reader", Namespace: "default"})
+	now = now.Add(30 * time.Second)
+	c.Get(Request{Name: "s3-reader", Namespace: "default"})
+	assert.Equal(t, 1, countGets(fakeClient))
+
+	now = now.Add(time.Minute)
+	resp := c.Get(Request{Name: "s3-reader", Namespace: "default"})
+	assert.True(t, resp.FoundInCache)
+	assert.Equal(t, 2, countGets(fakeClient), "expired service account should be fetched again")
+}
+
+func TestLRUGetContextDone(t *testing.T) {
+	fakeClient := fake.NewSimpleClientset(newTestServiceAccount("s3-reader"))
+	c := NewLRU("sts.amazonaws.com", "eks.amazonaws.com", false, 86400, ComposeRoleArn{}, fakeClient.CoreV1(), 10, time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	resp := c.Get(Request{Name: "s3-reader", Namespace: "default", Context: ctx})
+	assert.False(t, resp.FoundInCache)
+	assert.Equal(t, 0, countGets(fakeClient), "service account should not be fetched once the context is done")
+
+	resp = c.Get(Request{Name: "s3-reader", Namespace: "default"})
+	assert.True(t, resp.FoundInCache)
+}
@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// negativeCachePurgeThreshold is the number of entries above which expired
+// entries are dropped when adding a new one, so that names which are never
+// looked up again don't accumulate.
+const negativeCachePurgeThreshold = 1000
+
+// negativeCache remembers service accounts the API server reported as not
+// found, so that pods using them don't each trigger a fetch until ttl has
+// passed. A nil negativeCache or a ttl of 0 disables it.
+type negativeCache struct {
+	mu      sync.Mutex
+	entries map[string]time.Time
+	ttl     time.Duration
+	now     func() time.Time
+}
+
+func newNegativeCache(ttl time.Duration) *negativeCache {
+	return &negativeCache{
+		entries: map[string]time.Time{},
+		ttl:     ttl,
+		now:     time.Now,
+	}
+}
+
+func (n *negativeCache) add(key string) {
+	if n == nil || n.ttl <= 0 {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	now := n.now()
+	if len(n.entries) >= negativeCachePurgeThreshold {
+		for k, expires := range n.entries {
+			if now.After(expires) {
+				delete(n.entries, k)
+			}
+		}
+	}
+	n.entries[key] = now.Add(n.ttl)
+	negativeCacheSize.Set(float64(len(n.entries)))
+}
+
+// has returns true if the service account was not found less than ttl ago
+func (n *negativeCache) has(key string) bool {
+	if n == nil || n.ttl <= 0 {
+		return false
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	expires, ok := n.entries[key]
+	if !ok {
+		return false
+	}
+	if n.now().After(expires) {
+		delete(n.entries, key)
+		negativeCacheSize.Set(float64(len(n.entries)))
+		return false
+	}
+	negativeCacheHits.Inc()
+	return true
+}
+
+func (n *negativeCache) remove(key string) {
+	if n == nil || n.ttl <= 0 {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, ok := n.entries[key]; ok {
+		delete(n.entries, key)
+		negativeCacheSize.Set(float64(len(n.entries)))
+	}
+}
@@ -19,16 +19,33 @@ func newNotifications(saFetchRequests chan<- *Request) *notifications {
 	}
 }
 
-func (n *notifications) create(req Request) <-chan struct{} {
+// create returns the channel closed when the service account is added to the
+// cache or reported as not found, and requests its fetch unless one is already
+// pending. If the tenant of the request is rate limited, or the fetch queue is
+// full, the returned channel is already closed.
+func (n *notifications) create(req Request, limiter *tenantLimiter) <-chan struct{} {
 	n.mu.Lock()
 	defer n.mu.Unlock()
 
 	// deduplicate requests to SA with same namespace/name to single request
-	notifier, found := n.handlers[req.CacheKey()]
+	key := req.CacheKey()
+	notifier, found := n.handlers[key]
 	if !found {
+		if !limiter.allow(req) {
+			klog.InfoS("Too many fetches of missing service accounts in namespace, not fetching", req.LogKeys()...)
+			return closedNotifier
+		}
 		notifier = make(chan struct{})
-		n.handlers[req.CacheKey()] = notifier
-		n.fetchRequests <- &req
+		select {
+		case n.fetchRequests <- &req:
+			n.handlers[key] = notifier
+		default:
+			// don't block the pod mutation path when fetch workers fall behind,
+			// and don't keep a handler no fetch will ever close so that the
+			// next lookup requests the fetch again
+			klog.InfoS("Too many pending service account fetches, not fetching", req.LogKeys()...)
+			return closedNotifier
+		}
 	}
 	return notifier
 }
@@ -37,7 +54,7 @@ func (n *notifications) broadcast(key string) {
 	n.mu.Lock()
 	defer n.mu.Unlock()
 	if handler, found := n.handlers[key]; found {
-		klog.V(5).Infof("Notifying handlers for %q", key)
+		klog.V(5).InfoS("Notifying handlers", keyLogKeys(key)...)
 		close(handler)
 		delete(n.handlers, key)
 	}
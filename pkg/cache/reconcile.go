@@ -0,0 +1,238 @@
+package cache
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/pager"
+	"k8s.io/klog/v2"
+)
+
+// reconcileListPageSize is the number of service accounts listed per request
+// when reconciling, to bound the size of responses in large clusters
+const reconcileListPageSize = 500
+
+// Kinds of divergences found by reconciliation
+const (
+	divergenceMissing = "missing"
+	divergenceStale   = "stale"
+	divergenceExtra   = "extra"
+)
+
+// ReconcileConfig configures the periodic reconciliation of the cache with the
+// API server
+type ReconcileConfig struct {
+	// Interval between reconciliations, 0 disables reconciliation
+	Interval time.Duration
+	// Namespaces service accounts are listed in, all namespaces when empty
+	Namespaces []string
+	// ConfigMapNamespace is the namespace ConfigMaps are listed in, ConfigMaps
+	// are not reconciled when empty
+	ConfigMapNamespace string
+}
+
+// WithReconciliation enables the periodic reconciliation of the cache with the
+// service accounts and ConfigMaps listed through clientset, which fixes
+// entries left out of date by missed watch events
+func WithReconciliation(clientset kubernetes.Interface, config ReconcileConfig) Option {
+	return func(c *serviceAccountCache) {
+		c.clientset = clientset
+		c.reconcileConfig = config
+	}
+}
+
+// reconcileLoop reconciles the cache every interval until ctx is cancelled
+func (c *serviceAccountCache) reconcileLoop(ctx context.Context) {
+	ticker := time.NewTicker(c.reconcileConfig.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.reconcile(ctx)
+		}
+	}
+}
+
+func (c *serviceAccountCache) reconcile(ctx context.Context) {
+	start := time.Now()
+	if err := c.reconcileServiceAccounts(ctx); err != nil {
+		klog.ErrorS(err, "Error reconciling service account cache")
+	}
+	if c.reconcileConfig.ConfigMapNamespace != "" {
+		if err := c.reconcileConfigMaps(ctx); err != nil {
+			klog.ErrorS(err, "Error reconciling ConfigMap cache")
+		}
+	}
+	klog.V(4).InfoS("Reconciled cache", "duration", time.Since(start))
+}
+
+// reconcileServiceAccounts lists service accounts and fixes the cache entries
+// that differ from them. Entries changed by the informer while listing are
+// left alone, as they are more recent than the list.
+func (c *serviceAccountCache) reconcileServiceAccounts(ctx context.Context) error {
+	c.mu.RLock()
+	snapshot := make(map[string]*Entry, len(c.saCache))
+	for key, entry := range c.saCache {
+		snapshot[key] = entry
+	}
+	c.mu.RUnlock()
+
+	namespaces := c.reconcileConfig.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+	// expected holds a nil entry for service accounts that must not be cached
+	expected := make(map[string]*Entry, len(snapshot))
+	for _, namespace := range namespaces {
+		p := pager.New(func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+			return c.clientset.CoreV1().ServiceAccounts(namespace).List(ctx, opts)
+		})
+		p.PageSize = reconcileListPageSize
+		err := p.EachListItem(ctx, metav1.ListOptions{}, func(obj runtime.Object) error {
+			sa := obj.(*v1.ServiceAccount)
+			key := sa.Namespace + "/" + sa.Name
+			if c.annotatedOnly && !c.hasAnnotations(sa) {
+				expected[key] = nil
+				return nil
+			}
+			expected[key] = c.newEntry(sa)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for key, entry := range expected {
+		old := snapshot[key]
+		switch {
+		case entry == nil && old != nil:
+			c.reconcileSA(key, old, nil, divergenceExtra)
+		case entry == nil:
+			// not cached, as expected
+		case old == nil:
+			c.reconcileSA(key, old, entry, divergenceMissing)
+		case *old != *entry:
+			c.reconcileSA(key, old, entry, divergenceStale)
+		}
+	}
+	reconciled := sets.New(c.reconcileConfig.Namespaces...)
+	for key, old := range snapshot {
+		namespace, _, _ := strings.Cut(key, "/")
+		if _, found := expected[key]; found || (reconciled.Len() > 0 && !reconciled.Has(namespace)) {
+			continue
+		}
+		c.reconcileSA(key, old, nil, divergenceExtra)
+	}
+	return nil
+}
+
+// reconcileSA replaces the cache entry of the service account with entry, or
+// removes it when entry is nil, unless it is no longer old
+func (c *serviceAccountCache) reconcileSA(key string, old, entry *Entry, kind string) {
+	c.mu.Lock()
+	if c.saCache[key] != old {
+		c.mu.Unlock()
+		return
+	}
+	if entry == nil {
+		delete(c.saCache, key)
+	} else {
+		if entry.RoleARN != "" && (old == nil || old.RoleARN == "") {
+			annotatedSACounter.Inc()
+		}
+		c.saCache[key] = entry
+	}
+	saCacheSize.Set(float64(len(c.saCache)))
+	c.mu.Unlock()
+
+	klog.InfoS("Reconciliation fixed cache entry of service account", append(keyLogKeys(key), "divergence", kind)...)
+	reconcileDivergences.WithLabelValues("service_account", kind).Inc()
+	if entry != nil {
+		c.negativeCache.remove(key)
+		c.notifications.broadcast(key)
+	}
+}
+
+// reconcileConfigMaps lists ConfigMaps and re-reads the ones whose entries
+// differ from the cache, and removes the entries of deleted ones
+func (c *serviceAccountCache) reconcileConfigMaps(ctx context.Context) error {
+	c.mu.RLock()
+	snapshot := make(map[string]map[string]*Entry, len(c.cmSources))
+	for name, entries := range c.cmSources {
+		snapshot[name] = entries
+	}
+	namespaceSnapshot := make(map[string]map[string]string, len(c.cmNamespaceSources))
+	for name, namespaceAudiences := range c.cmNamespaceSources {
+		namespaceSnapshot[name] = namespaceAudiences
+	}
+	c.mu.RUnlock()
+
+	list, err := c.clientset.CoreV1().ConfigMaps(c.reconcileConfig.ConfigMapNamespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	listed := sets.New[string]()
+	for i := range list.Items {
+		cm := &list.Items[i]
+		if !c.isConfigMapSource(cm) {
+			continue
+		}
+		listed.Insert(cm.Name)
+		entries, namespaceAudiences, _ := parseConfig(cm.Data["config"], c.defaultTokenExpiration)
+		old, found := snapshot[cm.Name]
+		// An invalid config keeps the previous entries, which are reported
+		// when the ConfigMap is read by the informer
+		if entries == nil || (found && reflect.DeepEqual(old, entries) && equalNamespaceAudiences(namespaceSnapshot[cm.Name], namespaceAudiences)) {
+			continue
+		}
+		kind := divergenceStale
+		if !found {
+			kind = divergenceMissing
+		}
+		if !c.cmSourceUnchanged(cm.Name, old, found) {
+			continue
+		}
+		klog.InfoS("Reconciliation fixed cache entries of ConfigMap", "configMap", cm.Name, "divergence", kind)
+		reconcileDivergences.WithLabelValues("config_map", kind).Inc()
+		if err := c.populateCacheFromCM(cm); err != nil {
+			klog.ErrorS(err, "Error reconciling ConfigMap", "configMap", cm.Name)
+		}
+	}
+	for name, old := range snapshot {
+		if listed.Has(name) || !c.cmSourceUnchanged(name, old, true) {
+			continue
+		}
+		klog.InfoS("Reconciliation fixed cache entries of ConfigMap", "configMap", name, "divergence", divergenceExtra)
+		reconcileDivergences.WithLabelValues("config_map", divergenceExtra).Inc()
+		c.removeCMSource(name)
+	}
+	return nil
+}
+
+// equalNamespaceAudiences returns true if both have the same namespace
+// audiences, a nil map being equal to an empty one
+func equalNamespaceAudiences(a, b map[string]string) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// cmSourceUnchanged returns true if the entries of the ConfigMap are still
+// the ones found before listing
+func (c *serviceAccountCache) cmSourceUnchanged(name string, old map[string]*Entry, found bool) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	current, ok := c.cmSources[name]
+	return ok == found && reflect.DeepEqual(current, old)
+}
@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReconcileServiceAccounts(t *testing.T) {
+	stale := newTestServiceAccount("stale")
+	fakeClient := fake.NewSimpleClientset(
+		newTestServiceAccount("missing"),
+		stale,
+		newTestServiceAccount("up-to-date"),
+	)
+	c := &serviceAccountCache{
+		saCache:                map[string]*Entry{},
+		annotationPrefix:       "eks.amazonaws.com",
+		defaultAudience:        "sts.amazonaws.com",
+		defaultTokenExpiration: 86400,
+		webhookUsage:           prometheus.NewGauge(prometheus.GaugeOpts{}),
+		notifications:          newNotifications(make(chan *Request, 10)),
+	}
+	WithReconciliation(fakeClient, ReconcileConfig{})(c)
+
+	c.addSA(newTestServiceAccount("up-to-date"))
+	staleSA := stale.DeepCopy()
+	staleSA.Annotations["eks.amazonaws.com/role-arn"] = "arn:aws:iam::111122223333:role/old"
+	c.addSA(staleSA)
+	c.addSA(newTestServiceAccount("deleted"))
+	upToDate := c.saCache["default/up-to-date"]
+
+	missingBefore := testutil.ToFloat64(reconcileDivergences.WithLabelValues("service_account", divergenceMissing))
+	staleBefore := testutil.ToFloat64(reconcileDivergences.WithLabelValues("service_account", divergenceStale))
+	extraBefore := testutil.ToFloat64(reconcileDivergences.WithLabelValues("service_account", divergenceExtra))
+
+	assert.NoError(t, c.reconcileServiceAccounts(context.Background()))
+
+	assert.Equal(t, "arn:aws:iam::111122223333:role/missing", c.Get(Request{Name: "missing", Namespace: "default"}).RoleARN)
+	assert.Equal(t, "arn:aws:iam::111122223333:role/stale", c.Get(Request{Name: "stale", Namespace: "default"}).RoleARN)
+	assert.False(t, c.Get(Request{Name: "deleted", Namespace: "default"}).FoundInCache)
+	assert.Same(t, upToDate, c.saCache["default/up-to-date"], "entries up to date should be left alone")
+	assert.Equal(t, missingBefore+1, testutil.ToFloat64(reconcileDivergences.WithLabelValues("service_account", divergenceMissing)))
+	assert.Equal(t, staleBefore+1, testutil.ToFloat64(reconcileDivergences.WithLabelValues("service_account", divergenceStale)))
+	assert.Equal(t, extraBefore+1, testutil.ToFloat64(reconcileDivergences.WithLabelValues("service_account", divergenceExtra)))
+
+	// An entry updated by the informer after the snapshot is more recent than the list
+	c.reconcileSA("default/up-to-date", nil, &Entry{RoleARN: "arn:aws:iam::111122223333:role/other"}, divergenceMissing)
+	assert.Same(t, upToDate, c.saCache["default/up-to-date"])
+}
+
+func TestReconcileConfigMaps(t *testing.T) {
+	fakeClient := fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod-identity-webhook", Namespace: "kube-system"},
+		Data:       map[string]string{"config": `{"ns/sa":{"RoleARN":"arn:aws:iam::111122223333:role/new"}}`},
+	})
+	c := &serviceAccountCache{
+		cmCache:                map[string]*Entry{},
+		defaultTokenExpiration: 86400,
+	}
+	WithReconciliation(fakeClient, ReconcileConfig{ConfigMapNamespace: "kube-system"})(c)
+
+	assert.NoError(t, c.populateCacheFromCM(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod-identity-webhook"},
+		Data:       map[string]string{"config": `{"ns/sa":{"RoleARN":"arn:aws:iam::111122223333:role/old"}}`},
+	}))
+	c.cmSources["deleted"] = map[string]*Entry{"ns/deleted": {RoleARN: "arn:aws:iam::111122223333:role/deleted"}}
+	c.mergeCMSourcesLocked()
+
+	assert.NoError(t, c.reconcileConfigMaps(context.Background()))
+	assert.Equal(t, "arn:aws:iam::111122223333:role/new", c.Get(Request{Name: "sa", Namespace: "ns"}).RoleARN)
+	assert.Empty(t, c.Get(Request{Name: "deleted", Namespace: "ns"}).RoleARN)
+	assert.NotContains(t, c.cmSources, "deleted")
+}
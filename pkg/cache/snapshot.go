@@ -0,0 +1,138 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+)
+
+// snapshotVersion is the version of the snapshot file format
+const snapshotVersion = 1
+
+// Snapshotter is implemented by ServiceAccountCaches whose contents can be
+// saved to a file and loaded back when the webhook restarts, so that it can
+// answer admissions before its informers have synced
+type Snapshotter interface {
+	// SaveSnapshot writes the cache contents to path
+	SaveSnapshot(path string) error
+	// LoadSnapshot fills the cache from the snapshot at path, unless it is
+	// older than maxAge, and must be called before Start. The cache is then
+	// synced until its informers sync, at which point the loaded entries the
+	// informers did not confirm are removed.
+	LoadSnapshot(path string, maxAge time.Duration) error
+}
+
+type snapshot struct {
+	Version         int                          `json:"version"`
+	Time            time.Time                    `json:"time"`
+	ServiceAccounts map[string]*Entry            `json:"serviceAccounts"`
+	ConfigMaps      map[string]map[string]*Entry `json:"configMaps"`
+}
+
+func (c *serviceAccountCache) SaveSnapshot(path string) error {
+	c.mu.RLock()
+	contents, err := json.Marshal(snapshot{
+		Version:         snapshotVersion,
+		Time:            time.Now(),
+		ServiceAccounts: c.saCache,
+		ConfigMaps:      c.cmSources,
+	})
+	c.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file renamed over the snapshot, so that a crash
+	// while writing leaves the previous snapshot intact
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(contents); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (c *serviceAccountCache) LoadSnapshot(path string, maxAge time.Duration) error {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var s snapshot
+	if err := json.Unmarshal(contents, &s); err != nil {
+		return fmt.Errorf("failed to parse snapshot %s: %v", path, err)
+	}
+	if s.Version != snapshotVersion {
+		return fmt.Errorf("unsupported version %d of snapshot %s, expected %d", s.Version, path, snapshotVersion)
+	}
+	if age := time.Since(s.Time); maxAge > 0 && age > maxAge {
+		return fmt.Errorf("snapshot %s is %s old, more than %s", path, age.Round(time.Second), maxAge)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Entries added by the informers since they started are more recent
+	c.snapshotSAs = sets.New[string]()
+	for key, entry := range s.ServiceAccounts {
+		if _, ok := c.saCache[key]; ok || entry == nil {
+			continue
+		}
+		c.saCache[key] = entry
+		c.snapshotSAs.Insert(key)
+	}
+	saCacheSize.Set(float64(len(c.saCache)))
+
+	c.snapshotCMs = sets.New[string]()
+	if c.cmSources == nil {
+		c.cmSources = map[string]map[string]*Entry{}
+	}
+	for name, entries := range s.ConfigMaps {
+		if _, ok := c.cmSources[name]; ok {
+			continue
+		}
+		for key, entry := range entries {
+			if entry == nil {
+				delete(entries, key)
+			}
+		}
+		c.cmSources[name] = entries
+		c.snapshotCMs.Insert(name)
+	}
+	c.mergeCMSourcesLocked()
+
+	c.snapshotLoaded.Store(true)
+	klog.InfoS("Loaded cache snapshot", "path", path, "time", s.Time.Format(time.RFC3339), "serviceAccounts", c.snapshotSAs.Len(), "configMaps", c.snapshotCMs.Len())
+	return nil
+}
+
+// dropSnapshotEntries removes the entries loaded from the snapshot that the
+// informers did not add again once synced, as their objects no longer exist
+func (c *serviceAccountCache) dropSnapshotEntries() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.snapshotSAs.Len() > 0 || c.snapshotCMs.Len() > 0 {
+		klog.InfoS("Removing service accounts and ConfigMaps of the snapshot that no longer exist", "serviceAccounts", c.snapshotSAs.Len(), "configMaps", c.snapshotCMs.Len())
+	}
+	for key := range c.snapshotSAs {
+		delete(c.saCache, key)
+	}
+	saCacheSize.Set(float64(len(c.saCache)))
+	for name := range c.snapshotCMs {
+		delete(c.cmSources, name)
+	}
+	if c.snapshotCMs.Len() > 0 {
+		c.mergeCMSourcesLocked()
+	}
+	c.snapshotSAs, c.snapshotCMs = nil, nil
+}
@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newSnapshotTestCache(synced *bool) *serviceAccountCache {
+	return &serviceAccountCache{
+		saCache:                map[string]*Entry{},
+		cmCache:                map[string]*Entry{},
+		annotationPrefix:       "eks.amazonaws.com",
+		defaultAudience:        "sts.amazonaws.com",
+		defaultTokenExpiration: 86400,
+		hasSynced:              func() bool { return *synced },
+		webhookUsage:           prometheus.NewGauge(prometheus.GaugeOpts{}),
+		notifications:          newNotifications(make(chan *Request, 10)),
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	synced := false
+
+	saved := newSnapshotTestCache(&synced)
+	saved.addSA(newTestServiceAccount("kept"))
+	saved.addSA(newTestServiceAccount("deleted"))
+	assert.NoError(t, saved.populateCacheFromCM(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod-identity-webhook"},
+		Data:       map[string]string{"config": `{"ns/cm":{"RoleARN":"arn:aws:iam::111122223333:role/cm"}}`},
+	}))
+	assert.NoError(t, saved.SaveSnapshot(path))
+
+	c := newSnapshotTestCache(&synced)
+	assert.False(t, c.HasSynced())
+	assert.NoError(t, c.LoadSnapshot(path, time.Hour))
+	assert.True(t, c.HasSynced(), "the cache should be synced once a snapshot is loaded")
+	assert.Equal(t, "arn:aws:iam::111122223333:role/kept", c.Get(Request{Name: "kept", Namespace: "default"}).RoleARN)
+	assert.Equal(t, "arn:aws:iam::111122223333:role/deleted", c.Get(Request{Name: "deleted", Namespace: "default"}).RoleARN)
+	assert.Equal(t, "arn:aws:iam::111122223333:role/cm", c.Get(Request{Name: "cm", Namespace: "ns"}).RoleARN)
+
+	// The informers only find "kept" once synced
+	updated := newTestServiceAccount("kept")
+	updated.Annotations["eks.amazonaws.com/role-arn"] = "arn:aws:iam::111122223333:role/updated"
+	c.addSA(updated)
+	synced = true
+	c.dropSnapshotEntries()
+	assert.Equal(t, "arn:aws:iam::111122223333:role/updated", c.Get(Request{Name: "kept", Namespace: "default"}).RoleARN)
+	assert.False(t, c.Get(Request{Name: "deleted", Namespace: "default"}).FoundInCache)
+	assert.Empty(t, c.Get(Request{Name: "cm", Namespace: "ns"}).RoleARN)
+}
+
+func TestLoadSnapshotErrors(t *testing.T) {
+	dir := t.TempDir()
+	synced := false
+	c := newSnapshotTestCache(&synced)
+
+	err := c.LoadSnapshot(filepath.Join(dir, "missing.json"), time.Hour)
+	assert.True(t, os.IsNotExist(err))
+
+	old := filepath.Join(dir, "old.json")
+	assert.NoError(t, os.WriteFile(old, []byte(`{"version":1,"time":"2020-01-01T00:00:00Z","serviceAccounts":{"default/sa":{"RoleARN":"arn:aws:iam::111122223333:role/sa"}}}`), 0600))
+	assert.ErrorContains(t, c.LoadSnapshot(old, time.Hour), "more than 1h0m0s")
+
+	unsupported := filepath.Join(dir, "unsupported.json")
+	assert.NoError(t, os.WriteFile(unsupported, []byte(`{"version":2}`), 0600))
+	assert.ErrorContains(t, c.LoadSnapshot(unsupported, 0), "unsupported version 2")
+
+	assert.False(t, c.HasSynced())
+	assert.Empty(t, c.saCache)
+}
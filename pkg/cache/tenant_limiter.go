@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Keys tenants are rate limited by
+const (
+	TenantKeyNamespace      = "namespace"
+	TenantKeyServiceAccount = "serviceaccount"
+)
+
+// tenantLimiterPurgeThreshold is the number of limiters above which the ones
+// with a full bucket are dropped when adding a new one, as they are the same
+// as new ones
+const tenantLimiterPurgeThreshold = 1000
+
+// tenantLimiter rate limits the fetches of missing service accounts of every
+// tenant, a namespace or a service account, so that a tenant churning pods
+// with missing service accounts can't use up the fetch workers and the global
+// rate limit. A nil tenantLimiter allows every fetch.
+type tenantLimiter struct {
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+	limit    rate.Limit
+	burst    int
+	key      string
+	now      func() time.Time
+}
+
+func newTenantLimiter(key string, qps float64, burst int) *tenantLimiter {
+	return &tenantLimiter{
+		limiters: map[string]*rate.Limiter{},
+		limit:    rate.Limit(qps),
+		burst:    burst,
+		key:      key,
+		now:      time.Now,
+	}
+}
+
+// tenant returns the tenant of the request, its namespace unless keyed by
+// service account
+func (l *tenantLimiter) tenant(req Request) string {
+	if l.key == TenantKeyServiceAccount {
+		return req.CacheKey()
+	}
+	return req.Namespace
+}
+
+// allow returns true if the tenant of the request may fetch a service
+// account now, consuming a token of its bucket
+func (l *tenantLimiter) allow(req Request) bool {
+	if l == nil {
+		return true
+	}
+	tenant := l.tenant(req)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	limiter, ok := l.limiters[tenant]
+	if !ok {
+		if len(l.limiters) >= tenantLimiterPurgeThreshold {
+			for t, idle := range l.limiters {
+				if idle.TokensAt(now) >= float64(l.burst) {
+					delete(l.limiters, t)
+				}
+			}
+		}
+		limiter = rate.NewLimiter(l.limit, l.burst)
+		l.limiters[tenant] = limiter
+	}
+	if !limiter.AllowN(now, 1) {
+		tenantFetchesRateLimited.Inc()
+		return false
+	}
+	return true
+}
@@ -0,0 +1,244 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package cert
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/rand"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/acmpca"
+	"github.com/aws/aws-sdk-go/service/acmpca/acmpcaiface"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/certificate"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// acmpcaSyncPeriod is how often the rotation deadline is checked, and
+	// failed certificate requests are retried
+	acmpcaSyncPeriod = time.Minute
+	// acmpcaRequestTimeout bounds the time to issue and fetch a certificate
+	acmpcaRequestTimeout = 5 * time.Minute
+
+	// DefaultRenewalFraction and DefaultRenewalJitter renew certificates
+	// between 70% and 90% of their lifetime, like the Kubernetes certificate
+	// manager
+	DefaultRenewalFraction = 0.7
+	DefaultRenewalJitter   = 0.2
+)
+
+// Compile time check that acmpcaCertificateManager implements the certificate.Manager interface
+var _ certificate.Manager = &acmpcaCertificateManager{}
+
+// ACMPCAConfig configures the certificates requested from ACM Private CA
+type ACMPCAConfig struct {
+	// CertificateAuthorityARN is the ARN of the private CA issuing the certificates
+	CertificateAuthorityARN string
+	// SigningAlgorithm is the algorithm the private CA signs certificates
+	// with, which must match its key type
+	SigningAlgorithm string
+	// TemplateARN is the optional ARN of the certificate template
+	TemplateARN string
+	// ValidityDays is the validity of the certificates, in days
+	ValidityDays int64
+	// KeyType is the type of the generated TLS keys, one of KeyTypes.
+	// Defaults to ECDSA P-256.
+	KeyType string
+	// RenewalFraction is the fraction of their lifetime after which
+	// certificates are renewed, and RenewalJitter the maximum random fraction
+	// of their lifetime added to it. Defaults to DefaultRenewalFraction and
+	// DefaultRenewalJitter if RenewalFraction is 0.
+	RenewalFraction float64
+	RenewalJitter   float64
+}
+
+// Validate returns an error if the config is invalid
+func (c ACMPCAConfig) Validate() error {
+	if c.CertificateAuthorityARN == "" {
+		return errors.New("a certificate authority ARN is required")
+	}
+	if !slices.Contains(acmpca.SigningAlgorithm_Values(), c.SigningAlgorithm) {
+		return fmt.Errorf("unsupported signing algorithm %q, expected one of %v", c.SigningAlgorithm, acmpca.SigningAlgorithm_Values())
+	}
+	if c.ValidityDays < 1 {
+		return fmt.Errorf("validity must be at least 1 day, got %d", c.ValidityDays)
+	}
+	if c.KeyType != "" && !slices.Contains(KeyTypes, c.KeyType) {
+		return fmt.Errorf("unsupported key type %q, expected one of %v", c.KeyType, KeyTypes)
+	}
+	if c.RenewalFraction < 0 || c.RenewalJitter < 0 || c.RenewalFraction+c.RenewalJitter >= 1 {
+		return fmt.Errorf("renewal fraction %v and jitter %v must not be negative, and their sum must be lower than 1", c.RenewalFraction, c.RenewalJitter)
+	}
+	return nil
+}
+
+type acmpcaCertificateManager struct {
+	client   acmpcaiface.ACMPCAAPI
+	config   ACMPCAConfig
+	template *x509.CertificateRequest
+	store    certificate.Store
+	cancel   context.CancelFunc
+
+	mu          sync.RWMutex
+	certificate *tls.Certificate
+	deadline    time.Time
+	healthy     bool
+}
+
+// NewACMPCACertificateManager returns a certificate manager that requests
+// certificates from ACM Private CA, and stores TLS keys in the given store.
+// Certificates are renewed at a random time between the renewal fraction of
+// their lifetime and the renewal fraction plus jitter.
+func NewACMPCACertificateManager(client acmpcaiface.ACMPCAAPI, config ACMPCAConfig, store certificate.Store, csr *x509.CertificateRequest) (certificate.Manager, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	if config.RenewalFraction == 0 {
+		config.RenewalFraction = DefaultRenewalFraction
+		config.RenewalJitter = DefaultRenewalJitter
+	}
+	m := &acmpcaCertificateManager{
+		client:   client,
+		config:   config,
+		template: csr,
+		store:    store,
+	}
+	current, err := store.Current()
+	if err != nil {
+		var noKeyErr *certificate.NoCertKeyError
+		if !errors.As(err, &noKeyErr) {
+			return nil, fmt.Errorf("could not get current certificate: %v", err)
+		}
+	}
+	if current != nil {
+		m.setCertificate(current)
+	}
+	return m, nil
+}
+
+func (m *acmpcaCertificateManager) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	go wait.UntilWithContext(ctx, m.rotateIfNeeded, acmpcaSyncPeriod)
+}
+
+func (m *acmpcaCertificateManager) Stop() {
+	if m.cancel != nil {
+		m.cancel()
+	}
+}
+
+func (m *acmpcaCertificateManager) Current() *tls.Certificate {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.certificate
+}
+
+func (m *acmpcaCertificateManager) ServerHealthy() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.healthy
+}
+
+func (m *acmpcaCertificateManager) setCertificate(certificate *tls.Certificate) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.certificate = certificate
+	lifetime := certificate.Leaf.NotAfter.Sub(certificate.Leaf.NotBefore)
+	m.deadline = certificate.Leaf.NotBefore.Add(time.Duration(float64(lifetime) * (m.config.RenewalFraction + m.config.RenewalJitter*rand.Float64())))
+	klog.InfoS("Serving certificate loaded", "notAfter", certificate.Leaf.NotAfter, "rotationDeadline", m.deadline)
+}
+
+func (m *acmpcaCertificateManager) rotateIfNeeded(ctx context.Context) {
+	m.mu.RLock()
+	rotate := m.certificate == nil || time.Now().After(m.deadline)
+	m.mu.RUnlock()
+	if !rotate {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, acmpcaRequestTimeout)
+	defer cancel()
+	err := m.rotate(ctx)
+	m.mu.Lock()
+	m.healthy = err == nil
+	m.mu.Unlock()
+	if err != nil {
+		klog.ErrorS(err, "Failed to rotate the serving certificate with ACM Private CA", "certificateAuthority", m.config.CertificateAuthorityARN)
+	}
+}
+
+func (m *acmpcaCertificateManager) rotate(ctx context.Context) error {
+	privateKey, keyPEM, err := generatePrivateKey(m.config.KeyType)
+	if err != nil {
+		return fmt.Errorf("unable to generate a private key: %v", err)
+	}
+	template := *m.template
+	template.SignatureAlgorithm = signatureAlgorithm(m.config.KeyType)
+	csrDER, err := x509.CreateCertificateRequest(cryptorand.Reader, &template, privateKey)
+	if err != nil {
+		return fmt.Errorf("unable to create a certificate request: %v", err)
+	}
+
+	input := &acmpca.IssueCertificateInput{
+		CertificateAuthorityArn: aws.String(m.config.CertificateAuthorityARN),
+		Csr:                     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER}),
+		SigningAlgorithm:        aws.String(m.config.SigningAlgorithm),
+		Validity: &acmpca.Validity{
+			Type:  aws.String(acmpca.ValidityPeriodTypeDays),
+			Value: aws.Int64(m.config.ValidityDays),
+		},
+	}
+	if m.config.TemplateARN != "" {
+		input.TemplateArn = aws.String(m.config.TemplateARN)
+	}
+	issued, err := m.client.IssueCertificateWithContext(ctx, input)
+	if err != nil {
+		return fmt.Errorf("unable to issue certificate: %v", err)
+	}
+	klog.InfoS("Requested certificate", "certificate", aws.StringValue(issued.CertificateArn))
+
+	getInput := &acmpca.GetCertificateInput{
+		CertificateArn:          issued.CertificateArn,
+		CertificateAuthorityArn: aws.String(m.config.CertificateAuthorityARN),
+	}
+	if err := m.client.WaitUntilCertificateIssuedWithContext(ctx, getInput); err != nil {
+		return fmt.Errorf("certificate %s was not issued: %v", aws.StringValue(issued.CertificateArn), err)
+	}
+	output, err := m.client.GetCertificateWithContext(ctx, getInput)
+	if err != nil {
+		return fmt.Errorf("unable to get certificate %s: %v", aws.StringValue(issued.CertificateArn), err)
+	}
+
+	// Serve the chain so that clients trusting the root CA can verify the
+	// certificate of a subordinate CA
+	certPEM := []byte(aws.StringValue(output.Certificate) + "\n" + aws.StringValue(output.CertificateChain))
+	certificate, err := m.store.Update(certPEM, keyPEM)
+	if err != nil {
+		return fmt.Errorf("unable to store certificate %s: %v", aws.StringValue(issued.CertificateArn), err)
+	}
+	m.setCertificate(certificate)
+	return nil
+}
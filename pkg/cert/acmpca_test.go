@@ -0,0 +1,267 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package cert
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/acmpca"
+	"github.com/aws/aws-sdk-go/service/acmpca/acmpcaiface"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+)
+
+const testCAArn = "arn:aws:acm-pca:us-west-2:111122223333:certificate-authority/11111111-2222-3333-4444-555555555555"
+
+type fakeACMPCA struct {
+	acmpcaiface.ACMPCAAPI
+	caKey  *ecdsa.PrivateKey
+	caCert *x509.Certificate
+	issued map[string]string
+	err    error
+}
+
+func newFakeACMPCA(t *testing.T) *fakeACMPCA {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating CA key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(cryptorand.Reader, template, template, caKey.Public(), caKey)
+	if err != nil {
+		t.Fatalf("Error creating CA certificate: %v", err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatalf("Error parsing CA certificate: %v", err)
+	}
+	return &fakeACMPCA{caKey: caKey, caCert: caCert, issued: map[string]string{}}
+}
+
+func (f *fakeACMPCA) IssueCertificateWithContext(_ aws.Context, input *acmpca.IssueCertificateInput, _ ...request.Option) (*acmpca.IssueCertificateOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	block, _ := pem.Decode(input.Csr)
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(int64(len(f.issued) + 2)),
+		Subject:      csr.Subject,
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Duration(aws.Int64Value(input.Validity.Value)) * 24 * time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(cryptorand.Reader, template, f.caCert, csr.PublicKey, f.caKey)
+	if err != nil {
+		return nil, err
+	}
+	arn := fmt.Sprintf("%s/certificate/%d", testCAArn, len(f.issued))
+	f.issued[arn] = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	return &acmpca.IssueCertificateOutput{CertificateArn: aws.String(arn)}, nil
+}
+
+func (f *fakeACMPCA) WaitUntilCertificateIssuedWithContext(_ aws.Context, input *acmpca.GetCertificateInput, _ ...request.WaiterOption) error {
+	if _, ok := f.issued[aws.StringValue(input.CertificateArn)]; !ok {
+		return fmt.Errorf("certificate %s not found", aws.StringValue(input.CertificateArn))
+	}
+	return nil
+}
+
+func (f *fakeACMPCA) GetCertificateWithContext(_ aws.Context, input *acmpca.GetCertificateInput, _ ...request.Option) (*acmpca.GetCertificateOutput, error) {
+	return &acmpca.GetCertificateOutput{
+		Certificate:      aws.String(f.issued[aws.StringValue(input.CertificateArn)]),
+		CertificateChain: aws.String(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: f.caCert.Raw}))),
+	}, nil
+}
+
+func TestACMPCACertificateManager(t *testing.T) {
+	client := newFakeACMPCA(t)
+	store := NewSecretCertStore("default", "iam-for-pods", fakeclientset.NewSimpleClientset())
+	config := ACMPCAConfig{
+		CertificateAuthorityARN: testCAArn,
+		SigningAlgorithm:        acmpca.SigningAlgorithmSha256withecdsa,
+		ValidityDays:            1,
+	}
+	csr := &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "pod-identity-webhook.default.svc"},
+		DNSNames: []string{"pod-identity-webhook.default.svc"},
+	}
+
+	manager, err := NewACMPCACertificateManager(client, config, store, csr)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	m := manager.(*acmpcaCertificateManager)
+	if m.Current() != nil {
+		t.Fatalf("Unexpected certificate before the first rotation")
+	}
+
+	client.err = fmt.Errorf("access denied")
+	m.rotateIfNeeded(context.TODO())
+	if m.Current() != nil || m.ServerHealthy() {
+		t.Errorf("Unexpected certificate or healthy manager after a failed request")
+	}
+
+	client.err = nil
+	m.rotateIfNeeded(context.TODO())
+	current := m.Current()
+	if current == nil || !m.ServerHealthy() {
+		t.Fatalf("Expected a certificate after a successful request")
+	}
+	if current.Leaf.Subject.CommonName != "pod-identity-webhook.default.svc" {
+		t.Errorf("Unexpected subject %s", current.Leaf.Subject)
+	}
+	if len(current.Certificate) != 2 {
+		t.Errorf("Expected the certificate to be served with its chain, got %d certificates", len(current.Certificate))
+	}
+
+	// The certificate is not renewed before the rotation deadline
+	m.rotateIfNeeded(context.TODO())
+	if len(client.issued) != 1 {
+		t.Errorf("Unexpected certificate request before the rotation deadline")
+	}
+
+	// The certificate stored in the Secret is reused on restart
+	manager, err = NewACMPCACertificateManager(client, config, store, csr)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if restarted := manager.Current(); restarted == nil || !restarted.Leaf.Equal(current.Leaf) {
+		t.Errorf("Expected the stored certificate to be loaded")
+	}
+}
+
+func TestACMPCACertificateManagerRenewal(t *testing.T) {
+	client := newFakeACMPCA(t)
+	store := NewSecretCertStore("default", "iam-for-pods", fakeclientset.NewSimpleClientset())
+	config := ACMPCAConfig{
+		CertificateAuthorityARN: testCAArn,
+		SigningAlgorithm:        acmpca.SigningAlgorithmSha256withecdsa,
+		ValidityDays:            1,
+		RenewalFraction:         0.5,
+	}
+	csr := &x509.CertificateRequest{Subject: pkix.Name{CommonName: "pod-identity-webhook.default.svc"}}
+	manager, err := NewACMPCACertificateManager(client, config, store, csr)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	m := manager.(*acmpcaCertificateManager)
+	m.rotateIfNeeded(context.TODO())
+	leaf := m.Current().Leaf
+	expected := leaf.NotBefore.Add(leaf.NotAfter.Sub(leaf.NotBefore) / 2)
+	if !m.deadline.Equal(expected) {
+		t.Errorf("Unexpected rotation deadline %v, expected %v", m.deadline, expected)
+	}
+}
+
+func TestACMPCAConfigValidate(t *testing.T) {
+	cases := []struct {
+		caseName  string
+		config    ACMPCAConfig
+		expectErr bool
+	}{
+		{"Valid", ACMPCAConfig{CertificateAuthorityARN: testCAArn, SigningAlgorithm: acmpca.SigningAlgorithmSha256withrsa, ValidityDays: 30}, false},
+		{"MissingARN", ACMPCAConfig{SigningAlgorithm: acmpca.SigningAlgorithmSha256withrsa, ValidityDays: 30}, true},
+		{"InvalidSigningAlgorithm", ACMPCAConfig{CertificateAuthorityARN: testCAArn, SigningAlgorithm: "MD5WITHRSA", ValidityDays: 30}, true},
+		{"InvalidValidity", ACMPCAConfig{CertificateAuthorityARN: testCAArn, SigningAlgorithm: acmpca.SigningAlgorithmSha256withrsa}, true},
+		{"RSAKey", ACMPCAConfig{CertificateAuthorityARN: testCAArn, SigningAlgorithm: acmpca.SigningAlgorithmSha256withrsa, ValidityDays: 30, KeyType: KeyTypeRSA3072}, false},
+		{"InvalidKeyType", ACMPCAConfig{CertificateAuthorityARN: testCAArn, SigningAlgorithm: acmpca.SigningAlgorithmSha256withrsa, ValidityDays: 30, KeyType: "dsa-1024"}, true},
+		{"RenewalWindow", ACMPCAConfig{CertificateAuthorityARN: testCAArn, SigningAlgorithm: acmpca.SigningAlgorithmSha256withrsa, ValidityDays: 30, RenewalFraction: 0.5, RenewalJitter: 0.1}, false},
+		{"NegativeRenewalJitter", ACMPCAConfig{CertificateAuthorityARN: testCAArn, SigningAlgorithm: acmpca.SigningAlgorithmSha256withrsa, ValidityDays: 30, RenewalFraction: 0.5, RenewalJitter: -0.1}, true},
+		{"RenewalWindowPastExpiration", ACMPCAConfig{CertificateAuthorityARN: testCAArn, SigningAlgorithm: acmpca.SigningAlgorithmSha256withrsa, ValidityDays: 30, RenewalFraction: 0.9, RenewalJitter: 0.1}, true},
+	}
+	for _, c := range cases {
+		t.Run(c.caseName, func(t *testing.T) {
+			err := c.config.Validate()
+			if c.expectErr != (err != nil) {
+				t.Errorf("Unexpected error. Got %v, expected error: %t", err, c.expectErr)
+			}
+		})
+	}
+}
+
+func TestACMPCACertificateManagerKeyTypes(t *testing.T) {
+	cases := []struct {
+		keyType            string
+		publicKeyAlgorithm x509.PublicKeyAlgorithm
+		bits               int
+	}{
+		{KeyTypeECDSAP256, x509.ECDSA, 256},
+		{KeyTypeECDSAP384, x509.ECDSA, 384},
+		{KeyTypeRSA2048, x509.RSA, 2048},
+		{KeyTypeRSA3072, x509.RSA, 3072},
+	}
+	for _, c := range cases {
+		t.Run(c.keyType, func(t *testing.T) {
+			client := newFakeACMPCA(t)
+			store := NewSecretCertStore("default", "iam-for-pods", fakeclientset.NewSimpleClientset())
+			config := ACMPCAConfig{
+				CertificateAuthorityARN: testCAArn,
+				SigningAlgorithm:        acmpca.SigningAlgorithmSha256withecdsa,
+				ValidityDays:            1,
+				KeyType:                 c.keyType,
+			}
+			csr := &x509.CertificateRequest{Subject: pkix.Name{CommonName: "pod-identity-webhook.default.svc"}}
+			manager, err := NewACMPCACertificateManager(client, config, store, csr)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			m := manager.(*acmpcaCertificateManager)
+			m.rotateIfNeeded(context.TODO())
+			current := m.Current()
+			if current == nil {
+				t.Fatalf("Expected a certificate after a successful request")
+			}
+			if current.Leaf.PublicKeyAlgorithm != c.publicKeyAlgorithm {
+				t.Errorf("Unexpected public key algorithm %s", current.Leaf.PublicKeyAlgorithm)
+			}
+			var bits int
+			switch key := current.Leaf.PublicKey.(type) {
+			case *ecdsa.PublicKey:
+				bits = key.Curve.Params().BitSize
+			case *rsa.PublicKey:
+				bits = key.N.BitLen()
+			}
+			if bits != c.bits {
+				t.Errorf("Unexpected key size %d, expected %d", bits, c.bits)
+			}
+		})
+	}
+}
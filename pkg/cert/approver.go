@@ -0,0 +1,143 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package cert
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"slices"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	certificates "k8s.io/api/certificates/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	certinformers "k8s.io/client-go/informers/certificates/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// CurrentUsername returns the username the client authenticates as
+func CurrentUsername(ctx context.Context, kubeClient clientset.Interface) (string, error) {
+	review, err := kubeClient.AuthenticationV1().SelfSubjectReviews().Create(ctx, &authenticationv1.SelfSubjectReview{}, metav1.CreateOptions{})
+	if err != nil {
+		return "", err
+	}
+	return review.Status.UserInfo.Username, nil
+}
+
+// CSRApprover approves the CertificateSigningRequests of the webhook serving
+// certificate, so that they do not wait for a human to approve them. Only the
+// requests created by the given user for the given signer, with the expected
+// usages, subject and SANs, are approved.
+type CSRApprover struct {
+	kubeClient clientset.Interface
+	username   string
+	signerName string
+	usages     []certificates.KeyUsage
+	template   *x509.CertificateRequest
+}
+
+// NewCSRApprover returns a CSRApprover approving the requests matching the
+// template watched by the informer, which must be started by the caller.
+func NewCSRApprover(kubeClient clientset.Interface, informer certinformers.CertificateSigningRequestInformer, username, signerName string, template *x509.CertificateRequest) (*CSRApprover, error) {
+	usages, err := ServerCertificateUsages(signerName)
+	if err != nil {
+		return nil, err
+	}
+	a := &CSRApprover{
+		kubeClient: kubeClient,
+		username:   username,
+		signerName: signerName,
+		usages:     usages,
+		template:   template,
+	}
+	_, err = informer.Informer().AddEventHandler(
+		cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				a.approve(obj.(*certificates.CertificateSigningRequest))
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				a.approve(newObj.(*certificates.CertificateSigningRequest))
+			},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *CSRApprover) approve(csr *certificates.CertificateSigningRequest) {
+	if csr.Spec.Username != a.username || csr.Spec.SignerName != a.signerName {
+		return
+	}
+	for _, condition := range csr.Status.Conditions {
+		if condition.Type == certificates.CertificateApproved || condition.Type == certificates.CertificateDenied {
+			return
+		}
+	}
+	if err := a.validate(csr); err != nil {
+		klog.InfoS("Not approving CSR", "csr", csr.Name, "err", err)
+		return
+	}
+	csr = csr.DeepCopy()
+	csr.Status.Conditions = append(csr.Status.Conditions, certificates.CertificateSigningRequestCondition{
+		Type:    certificates.CertificateApproved,
+		Status:  v1.ConditionTrue,
+		Reason:  "AutoApproved",
+		Message: "Auto approving the pod identity webhook serving certificate",
+	})
+	if _, err := a.kubeClient.CertificatesV1().CertificateSigningRequests().UpdateApproval(context.TODO(), csr.Name, csr, metav1.UpdateOptions{}); err != nil {
+		klog.ErrorS(err, "Error approving CSR", "csr", csr.Name)
+		return
+	}
+	klog.InfoS("Approved CSR", "csr", csr.Name)
+}
+
+func (a *CSRApprover) validate(csr *certificates.CertificateSigningRequest) error {
+	if !sets.New(csr.Spec.Usages...).Equal(sets.New(a.usages...)) {
+		return fmt.Errorf("unexpected usages %v", csr.Spec.Usages)
+	}
+	block, _ := pem.Decode(csr.Spec.Request)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return fmt.Errorf("no certificate request found")
+	}
+	request, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return err
+	}
+	if err := request.CheckSignature(); err != nil {
+		return err
+	}
+	if request.Subject.CommonName != a.template.Subject.CommonName {
+		return fmt.Errorf("unexpected common name %q", request.Subject.CommonName)
+	}
+	if !sets.New(request.DNSNames...).Equal(sets.New(a.template.DNSNames...)) {
+		return fmt.Errorf("unexpected DNS names %v", request.DNSNames)
+	}
+	if !slices.EqualFunc(request.IPAddresses, a.template.IPAddresses, net.IP.Equal) {
+		return fmt.Errorf("unexpected IP addresses %v", request.IPAddresses)
+	}
+	if len(request.EmailAddresses) > 0 || len(request.URIs) > 0 {
+		return fmt.Errorf("unexpected email addresses or URIs")
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package cert
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"net"
+	"testing"
+
+	certificates "k8s.io/api/certificates/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCSRApprover(t *testing.T) {
+	const (
+		username   = "system:serviceaccount:default:pod-identity-webhook"
+		signerName = "example.com/serving"
+	)
+	template := &x509.CertificateRequest{
+		Subject:     pkix.Name{CommonName: "pod-identity-webhook.default.svc"},
+		DNSNames:    []string{"pod-identity-webhook", "pod-identity-webhook.default.svc"},
+		IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	newRequest := func(template *x509.CertificateRequest) []byte {
+		der, err := x509.CreateCertificateRequest(cryptorand.Reader, template, key)
+		if err != nil {
+			t.Fatalf("Error creating certificate request: %v", err)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+	}
+	otherSANs := *template
+	otherSANs.DNSNames = []string{"kubernetes.default.svc"}
+	usages := []certificates.KeyUsage{certificates.UsageDigitalSignature, certificates.UsageServerAuth}
+
+	cases := []struct {
+		caseName   string
+		username   string
+		signerName string
+		usages     []certificates.KeyUsage
+		request    []byte
+		approved   bool
+	}{
+		{"Matching", username, signerName, usages, newRequest(template), true},
+		{"OtherUser", "system:serviceaccount:default:other", signerName, usages, newRequest(template), false},
+		{"OtherSigner", username, "example.com/other", usages, newRequest(template), false},
+		{"OtherUsages", username, signerName, []certificates.KeyUsage{certificates.UsageClientAuth}, newRequest(template), false},
+		{"OtherSANs", username, signerName, usages, newRequest(&otherSANs), false},
+		{"InvalidRequest", username, signerName, usages, []byte("invalid"), false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.caseName, func(t *testing.T) {
+			csr := &certificates.CertificateSigningRequest{
+				ObjectMeta: metav1.ObjectMeta{Name: "csr"},
+				Spec: certificates.CertificateSigningRequestSpec{
+					Request:    c.request,
+					SignerName: c.signerName,
+					Usages:     c.usages,
+					Username:   c.username,
+				},
+			}
+			client := fakeclientset.NewSimpleClientset(csr)
+			informerFactory := informers.NewSharedInformerFactory(client, 0)
+			a, err := NewCSRApprover(client, informerFactory.Certificates().V1().CertificateSigningRequests(), username, signerName, template)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			a.approve(csr)
+			updated, err := client.CertificatesV1().CertificateSigningRequests().Get(context.TODO(), "csr", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			approved := false
+			for _, condition := range updated.Status.Conditions {
+				if condition.Type == certificates.CertificateApproved {
+					approved = true
+				}
+			}
+			if approved != c.approved {
+				t.Errorf("Unexpected approval. Got %t, wanted %t", approved, c.approved)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package cert
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// CABundleFunc returns the PEM encoded CA bundle the API server must use to
+// verify the webhook serving certificate
+type CABundleFunc func() ([]byte, error)
+
+// CABundleFromFile returns a CABundleFunc reading the CA bundle from the given
+// file, so that a rotated CA is picked up
+func CABundleFromFile(path string) CABundleFunc {
+	return func() ([]byte, error) {
+		return os.ReadFile(path)
+	}
+}
+
+// CABundleFromCertificate returns a CABundleFunc returning the last certificate
+// of the serving certificate chain, i.e. the serving certificate itself when it
+// is self-signed or served without its issuers.
+func CABundleFromCertificate(getCertificate func() (*tls.Certificate, error)) CABundleFunc {
+	return func() ([]byte, error) {
+		certificate, err := getCertificate()
+		if err != nil {
+			return nil, err
+		}
+		if certificate == nil || len(certificate.Certificate) == 0 {
+			return nil, fmt.Errorf("no serving certificate available")
+		}
+		chain := certificate.Certificate
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: chain[len(chain)-1]}), nil
+	}
+}
+
+// CABundleUpdater keeps the caBundle of all the webhooks of a
+// MutatingWebhookConfiguration up to date with the serving certificate
+type CABundleUpdater struct {
+	client            clientset.Interface
+	webhookConfigName string
+	caBundle          CABundleFunc
+	interval          time.Duration
+}
+
+// NewCABundleUpdater creates a CABundleUpdater
+func NewCABundleUpdater(client clientset.Interface, webhookConfigName string, caBundle CABundleFunc, interval time.Duration) *CABundleUpdater {
+	return &CABundleUpdater{
+		client:            client,
+		webhookConfigName: webhookConfigName,
+		caBundle:          caBundle,
+		interval:          interval,
+	}
+}
+
+// Run updates the caBundle right away and then every interval, until ctx is
+// cancelled. Errors are logged and the update is retried at the next interval.
+func (u *CABundleUpdater) Run(ctx context.Context) {
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := u.update(ctx); err != nil {
+			klog.ErrorS(err, "Error updating the caBundle of MutatingWebhookConfiguration", "mutatingWebhookConfiguration", u.webhookConfigName)
+		}
+	}, u.interval)
+}
+
+func (u *CABundleUpdater) update(ctx context.Context) error {
+	caBundle, err := u.caBundle()
+	if err != nil {
+		return err
+	}
+	if len(caBundle) == 0 {
+		return fmt.Errorf("empty CA bundle")
+	}
+	webhookConfig, err := u.client.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(ctx, u.webhookConfigName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	changed := false
+	for i := range webhookConfig.Webhooks {
+		if !bytes.Equal(webhookConfig.Webhooks[i].ClientConfig.CABundle, caBundle) {
+			webhookConfig.Webhooks[i].ClientConfig.CABundle = caBundle
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	if _, err := u.client.AdmissionregistrationV1().MutatingWebhookConfigurations().Update(ctx, webhookConfig, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	klog.InfoS("Updated the caBundle of MutatingWebhookConfiguration", "mutatingWebhookConfiguration", u.webhookConfigName)
+	return nil
+}
@@ -0,0 +1,85 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package cert
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"fmt"
+	"testing"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCABundleUpdater(t *testing.T) {
+	webhookConfig := &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod-identity-webhook"},
+		Webhooks: []admissionregistrationv1.MutatingWebhook{
+			{Name: "pod-identity-webhook.amazonaws.com"},
+			{Name: "pod-identity-webhook-other.amazonaws.com", ClientConfig: admissionregistrationv1.WebhookClientConfig{CABundle: []byte("stale")}},
+		},
+	}
+	client := fakeclientset.NewSimpleClientset(webhookConfig)
+
+	testCertificate, err := loadX509KeyPairData(testCert, testKey)
+	if err != nil {
+		t.Fatalf("Error parsing test key: %v", err)
+	}
+	expected := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: testCertificate.Certificate[0]})
+	current := testCertificate
+	u := NewCABundleUpdater(client, "pod-identity-webhook", CABundleFromCertificate(func() (*tls.Certificate, error) {
+		return current, nil
+	}), time.Minute)
+
+	if err := u.update(context.TODO()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	updated, err := client.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.TODO(), "pod-identity-webhook", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, webhook := range updated.Webhooks {
+		if !bytes.Equal(webhook.ClientConfig.CABundle, expected) {
+			t.Errorf("Unexpected caBundle for webhook %s. Got %q wanted %q", webhook.Name, webhook.ClientConfig.CABundle, expected)
+		}
+	}
+
+	// Unchanged bundles are not updated
+	client.ClearActions()
+	if err := u.update(context.TODO()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "update" {
+			t.Errorf("Unexpected update of an up to date caBundle")
+		}
+	}
+
+	current = nil
+	if err := u.update(context.TODO()); err == nil {
+		t.Errorf("Expected an error without serving certificate")
+	}
+
+	u = NewCABundleUpdater(client, "missing", func() ([]byte, error) { return nil, fmt.Errorf("no CA") }, time.Minute)
+	if err := u.update(context.TODO()); err == nil {
+		t.Errorf("Expected an error when the CA bundle can't be read")
+	}
+}
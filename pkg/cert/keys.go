@@ -0,0 +1,83 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package cert
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+)
+
+// Types of the TLS serving keys generated by the webhook
+const (
+	KeyTypeECDSAP256 = "ecdsa-p256"
+	KeyTypeECDSAP384 = "ecdsa-p384"
+	KeyTypeRSA2048   = "rsa-2048"
+	KeyTypeRSA3072   = "rsa-3072"
+	KeyTypeRSA4096   = "rsa-4096"
+)
+
+// KeyTypes are the supported TLS serving key types
+var KeyTypes = []string{KeyTypeECDSAP256, KeyTypeECDSAP384, KeyTypeRSA2048, KeyTypeRSA3072, KeyTypeRSA4096}
+
+// generatePrivateKey returns a new private key of the given type, and its PEM
+// encoding. An empty type generates an ECDSA P-256 key.
+func generatePrivateKey(keyType string) (crypto.Signer, []byte, error) {
+	switch keyType {
+	case "", KeyTypeECDSAP256, KeyTypeECDSAP384:
+		curve := elliptic.P256()
+		if keyType == KeyTypeECDSAP384 {
+			curve = elliptic.P384()
+		}
+		key, err := ecdsa.GenerateKey(curve, cryptorand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		return key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+	case KeyTypeRSA2048, KeyTypeRSA3072, KeyTypeRSA4096:
+		bits := map[string]int{KeyTypeRSA2048: 2048, KeyTypeRSA3072: 3072, KeyTypeRSA4096: 4096}[keyType]
+		key, err := rsa.GenerateKey(cryptorand.Reader, bits)
+		if err != nil {
+			return nil, nil, err
+		}
+		der := x509.MarshalPKCS1PrivateKey(key)
+		return key, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: der}), nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported key type %q, expected one of %v", keyType, KeyTypes)
+	}
+}
+
+// signatureAlgorithm returns the algorithm certificate requests are signed
+// with by keys of the given type
+func signatureAlgorithm(keyType string) x509.SignatureAlgorithm {
+	switch keyType {
+	case KeyTypeECDSAP384:
+		return x509.ECDSAWithSHA384
+	case KeyTypeRSA2048, KeyTypeRSA3072, KeyTypeRSA4096:
+		return x509.SHA256WithRSA
+	default:
+		return x509.ECDSAWithSHA256
+	}
+}
@@ -0,0 +1,81 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package cert
+
+import (
+	"context"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/util/certificate"
+	"k8s.io/klog/v2"
+)
+
+// NewManagerFunc creates a certificate manager
+type NewManagerFunc func() (certificate.Manager, error)
+
+// RunLeaderElected runs a certificate manager created by newManager only while
+// holding the Lease with the given name, so that a single replica of the webhook
+// requests certificates and updates the TLS Secret, until ctx is cancelled. The
+// manager is created again whenever leadership is acquired, to load the
+// certificate stored by the previous leader.
+func RunLeaderElected(ctx context.Context, kubeClient clientset.Interface, namespace, leaseName, identity string, newManager NewManagerFunc) {
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      leaseName,
+		},
+		Client: kubeClient.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: identity,
+		},
+	}
+	// Campaign again after losing leadership
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			LeaseDuration:   15 * time.Second,
+			RenewDeadline:   10 * time.Second,
+			RetryPeriod:     2 * time.Second,
+			ReleaseOnCancel: true,
+			Name:            leaseName,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(ctx context.Context) {
+					klog.InfoS("Acquired Lease, managing the serving certificate", "namespace", namespace, "lease", leaseName)
+					m, err := newManager()
+					if err != nil {
+						klog.Fatalf("failed to initialize certificate manager: %v", err)
+					}
+					m.Start()
+					<-ctx.Done()
+					m.Stop()
+				},
+				OnStoppedLeading: func() {
+					klog.InfoS("Lost Lease, no longer managing the serving certificate", "namespace", namespace, "lease", leaseName)
+				},
+				OnNewLeader: func(leader string) {
+					if leader != identity {
+						klog.InfoS("The serving certificate is managed by another replica", "leader", leader)
+					}
+				},
+			},
+		})
+	}, time.Second)
+}
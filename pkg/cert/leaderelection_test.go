@@ -0,0 +1,71 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package cert
+
+import (
+	"context"
+	"crypto/tls"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/util/certificate"
+)
+
+type fakeManager struct {
+	started chan struct{}
+	stopped chan struct{}
+}
+
+func (m *fakeManager) Start()                    { close(m.started) }
+func (m *fakeManager) Stop()                     { close(m.stopped) }
+func (m *fakeManager) Current() *tls.Certificate { return nil }
+func (m *fakeManager) ServerHealthy() bool       { return true }
+
+func TestRunLeaderElected(t *testing.T) {
+	client := fakeclientset.NewSimpleClientset()
+	m := &fakeManager{started: make(chan struct{}), stopped: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		RunLeaderElected(ctx, client, "default", "pod-identity-webhook", "replica-1", func() (certificate.Manager, error) {
+			return m, nil
+		})
+		close(done)
+	}()
+
+	select {
+	case <-m.started:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("The certificate manager was not started after acquiring the Lease")
+	}
+	lease, err := client.CoordinationV1().Leases("default").Get(context.TODO(), "pod-identity-webhook", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if holder := lease.Spec.HolderIdentity; holder == nil || *holder != "replica-1" {
+		t.Errorf("Unexpected Lease holder %v", holder)
+	}
+
+	cancel()
+	select {
+	case <-m.stopped:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("The certificate manager was not stopped")
+	}
+	<-done
+}
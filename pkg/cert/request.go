@@ -19,6 +19,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"strings"
 
 	"github.com/prometheus/client_golang/prometheus"
 	certificates "k8s.io/api/certificates/v1"
@@ -26,31 +27,37 @@ import (
 	"k8s.io/client-go/util/certificate"
 )
 
-// NewServerCertificateManager returns a certificate manager that stores TLS keys in Kubernetes Secrets
-func NewServerCertificateManager(kubeClient clientset.Interface, namespace, secretName string, csr *x509.CertificateRequest) (certificate.Manager, error) {
-	clientsetFn := func(_ *tls.Certificate) (clientset.Interface, error) {
-		return kubeClient, nil
-	}
-
-	certificateStore := NewSecretCertStore(
-		namespace,
-		secretName,
-		kubeClient,
-	)
+var certificateRotation = prometheus.NewHistogram(
+	prometheus.HistogramOpts{
+		Subsystem: "certificate_manager",
+		Name:      "server_rotation_seconds",
+		Help:      "Histogram of the lifetime of a certificate. The value is the time in seconds the certificate lived before getting rotated",
+	},
+)
 
-	var certificateRotation = prometheus.NewHistogram(
-		prometheus.HistogramOpts{
-			Subsystem: "certificate_manager",
-			Name:      "server_rotation_seconds",
-			Help:      "Histogram of the lifetime of a certificate. The value is the time in seconds the certificate lived before getting rotated",
-		},
-	)
+func init() {
+	// Registered once, as the certificate manager is created again when
+	// leadership is acquired again
 	prometheus.MustRegister(certificateRotation)
+}
 
-	m, err := certificate.NewManager(&certificate.Config{
-		ClientsetFn: clientsetFn,
-		Template:    csr,
-		Usages: []certificates.KeyUsage{
+// LegacyUnknownSignerName is the signer historically used by the webhook. It is
+// no longer available in certificates/v1, and CSRs using it are not signed by
+// Kubernetes 1.22 and later.
+// https://kubernetes.io/docs/reference/access-authn-authz/certificate-signing-requests/#kubernetes-signers
+const LegacyUnknownSignerName = "kubernetes.io/legacy-unknown"
+
+// ServerCertificateUsages returns the key usages to request from the given
+// signer for a serving certificate, or an error if the signer can not issue
+// one.
+func ServerCertificateUsages(signerName string) ([]certificates.KeyUsage, error) {
+	domain, path, found := strings.Cut(signerName, "/")
+	if !found || domain == "" || path == "" {
+		return nil, fmt.Errorf("invalid signer name %q, must be of the form <domain>/<path>", signerName)
+	}
+	switch signerName {
+	case LegacyUnknownSignerName:
+		return []certificates.KeyUsage{
 			// https://tools.ietf.org/html/rfc5280#section-4.2.1.3
 			//
 			// Digital signature allows the certificate to be used to verify
@@ -63,10 +70,44 @@ func NewServerCertificateManager(kubeClient clientset.Interface, namespace, secr
 			// ServerAuth allows the cert to be used by a TLS server to
 			// authenticate itself to a TLS client.
 			certificates.UsageServerAuth,
-		},
-		// Hard coding this since LegacyUnknownSignerName is no longer available in certificates/v1
-		// https://kubernetes.io/docs/reference/access-authn-authz/certificate-signing-requests/#kubernetes-signers.
-		SignerName:          "kubernetes.io/legacy-unknown",
+		}, nil
+	case certificates.KubeAPIServerClientSignerName, certificates.KubeAPIServerClientKubeletSignerName:
+		return nil, fmt.Errorf("signer %s only issues client certificates", signerName)
+	case certificates.KubeletServingSignerName:
+		return nil, fmt.Errorf("signer %s only issues kubelet serving certificates", signerName)
+	}
+	// Third-party signers, e.g. cert-manager. The certificate manager
+	// generates ECDSA keys, which can not be used for key encipherment, so it
+	// is not requested as stricter signers would reject it.
+	return []certificates.KeyUsage{
+		certificates.UsageDigitalSignature,
+		certificates.UsageServerAuth,
+	}, nil
+}
+
+// NewServerCertificateManager returns a certificate manager that stores TLS keys in Kubernetes Secrets,
+// and requests certificates from the given signer
+func NewServerCertificateManager(kubeClient clientset.Interface, namespace, secretName, signerName string, csr *x509.CertificateRequest) (certificate.Manager, error) {
+	usages, err := ServerCertificateUsages(signerName)
+	if err != nil {
+		return nil, err
+	}
+
+	clientsetFn := func(_ *tls.Certificate) (clientset.Interface, error) {
+		return kubeClient, nil
+	}
+
+	certificateStore := NewSecretCertStore(
+		namespace,
+		secretName,
+		kubeClient,
+	)
+
+	m, err := certificate.NewManager(&certificate.Config{
+		ClientsetFn:         clientsetFn,
+		Template:            csr,
+		Usages:              usages,
+		SignerName:          signerName,
 		CertificateStore:    certificateStore,
 		CertificateRotation: certificateRotation,
 	})
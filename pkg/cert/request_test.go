@@ -21,6 +21,7 @@ import (
 	"reflect"
 	"testing"
 
+	certificates "k8s.io/api/certificates/v1"
 	"k8s.io/api/core/v1"
 	clientset "k8s.io/client-go/kubernetes"
 	fakeclientset "k8s.io/client-go/kubernetes/fake"
@@ -193,3 +194,32 @@ func TestSecretStore(t *testing.T) {
 		})
 	}
 }
+
+func TestServerCertificateUsages(t *testing.T) {
+	cases := []struct {
+		signerName string
+		usages     []certificates.KeyUsage
+		expectErr  bool
+	}{
+		{LegacyUnknownSignerName, []certificates.KeyUsage{certificates.UsageDigitalSignature, certificates.UsageKeyEncipherment, certificates.UsageServerAuth}, false},
+		{"cert-manager.io/clusterissuers.pod-identity-webhook", []certificates.KeyUsage{certificates.UsageDigitalSignature, certificates.UsageServerAuth}, false},
+		{certificates.KubeletServingSignerName, nil, true},
+		{certificates.KubeAPIServerClientSignerName, nil, true},
+		{certificates.KubeAPIServerClientKubeletSignerName, nil, true},
+		{"", nil, true},
+		{"example.com", nil, true},
+		{"/example", nil, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.signerName, func(t *testing.T) {
+			usages, err := ServerCertificateUsages(c.signerName)
+			if c.expectErr != (err != nil) {
+				t.Errorf("Unexpected error. Got %v, expected error: %t", err, c.expectErr)
+			}
+			if !reflect.DeepEqual(usages, c.usages) {
+				t.Errorf("Unexpected usages. Got %v wanted %v", usages, c.usages)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package cert
+
+import (
+	"crypto/tls"
+	"fmt"
+	"sync"
+
+	"k8s.io/api/core/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// SecretCertWatcher serves the TLS certificate of a Secret managed by another
+// controller. The Secret is watched with an informer, so that a rewritten
+// certificate is served right away rather than when the kubelet updates the
+// files of a mounted Secret.
+type SecretCertWatcher struct {
+	name string
+
+	mu          sync.RWMutex
+	certificate *tls.Certificate
+}
+
+// NewSecretCertWatcher returns a SecretCertWatcher loading the certificate from
+// the Secret with the given name watched by the informer, which must be
+// started by the caller. The last valid certificate keeps being served when the
+// Secret is deleted or updated with an invalid certificate.
+func NewSecretCertWatcher(informer coreinformers.SecretInformer, name string) (*SecretCertWatcher, error) {
+	w := &SecretCertWatcher{name: name}
+	_, err := informer.Informer().AddEventHandler(
+		cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				w.load(obj.(*v1.Secret))
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				w.load(newObj.(*v1.Secret))
+			},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *SecretCertWatcher) load(secret *v1.Secret) {
+	if secret.Name != w.name {
+		return
+	}
+	certificate, err := loadX509KeyPairData(secret.Data[v1.TLSCertKey], secret.Data[v1.TLSPrivateKeyKey])
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("error loading serving certificate from Secret %s/%s: %v", secret.Namespace, secret.Name, err))
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.certificate = certificate
+	klog.InfoS("Loaded serving certificate from Secret", "namespace", secret.Namespace, "secret", secret.Name, "notAfter", certificate.Leaf.NotAfter)
+}
+
+// GetCertificate returns the current certificate, and is meant to be used as
+// tls.Config.GetCertificate
+func (w *SecretCertWatcher) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.certificate == nil {
+		return nil, fmt.Errorf("no serving certificate loaded from Secret %s", w.name)
+	}
+	return w.certificate, nil
+}
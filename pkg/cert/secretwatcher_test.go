@@ -0,0 +1,98 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package cert
+
+import (
+	"context"
+	"crypto/tls"
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSecretCertWatcher(t *testing.T) {
+	newSecret := func(name string, cert, key []byte) *v1.Secret {
+		return &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Data: map[string][]byte{
+				v1.TLSCertKey:       cert,
+				v1.TLSPrivateKeyKey: key,
+			},
+			Type: v1.SecretTypeTLS,
+		}
+	}
+	testCertificate, err := loadX509KeyPairData(testCert, testKey)
+	if err != nil {
+		t.Fatalf("Error parsing test key: %v", err)
+	}
+	testUpdateCertificate, err := loadX509KeyPairData(testUpdateCert, testUpdateKey)
+	if err != nil {
+		t.Fatalf("Error parsing test key: %v", err)
+	}
+
+	client := fakeclientset.NewSimpleClientset(newSecret("other", testUpdateCert, testUpdateKey))
+	informerFactory := informers.NewSharedInformerFactory(client, 0)
+	w, err := NewSecretCertWatcher(informerFactory.Core().V1().Secrets(), "iam-for-pods")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	informerFactory.Start(stop)
+	informerFactory.WaitForCacheSync(stop)
+
+	if _, err := w.GetCertificate(nil); err == nil {
+		t.Errorf("Expected an error before the Secret is created")
+	}
+
+	waitForCertificate := func(expected *tls.Certificate) {
+		t.Helper()
+		err := wait.PollUntilContextTimeout(context.TODO(), 10*time.Millisecond, 5*time.Second, true, func(context.Context) (bool, error) {
+			certificate, _ := w.GetCertificate(nil)
+			return reflect.DeepEqual(certificate, expected), nil
+		})
+		if err != nil {
+			t.Fatalf("Certificate was not loaded: %v", err)
+		}
+	}
+
+	secrets := client.CoreV1().Secrets("default")
+	if _, err := secrets.Create(context.TODO(), newSecret("iam-for-pods", testCert, testKey), metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	waitForCertificate(testCertificate)
+
+	if _, err := secrets.Update(context.TODO(), newSecret("iam-for-pods", testUpdateCert, testUpdateKey), metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	waitForCertificate(testUpdateCertificate)
+
+	// Invalid certificates are ignored
+	if _, err := secrets.Update(context.TODO(), newSecret("iam-for-pods", []byte("invalid-cert"), []byte("invalid-key")), metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := secrets.Delete(context.TODO(), "iam-for-pods", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	waitForCertificate(testUpdateCertificate)
+}
@@ -57,10 +57,10 @@ func (s *secretCertStore) Current() (*tls.Certificate, error) {
 			s.namespace,
 			s.secretName))
 	if err != nil {
-		klog.Errorf("Error fetching secret: %v", err.Error())
+		klog.ErrorS(err, "Error fetching secret", "namespace", s.namespace, "secret", s.secretName)
 		return nil, &noKeyErr
 	}
-	klog.Infof("Fetched secret: %s/%s", s.namespace, s.secretName)
+	klog.InfoS("Fetched secret", "namespace", s.namespace, "secret", s.secretName)
 	keyBytes, ok := secret.Data[v1.TLSPrivateKeyKey]
 	if !ok {
 		return nil, &noKeyErr
@@ -91,7 +91,7 @@ func (s *secretCertStore) Update(cert, key []byte) (*tls.Certificate, error) {
 		secret.Type = v1.SecretTypeTLS
 		_, err = s.clientset.CoreV1().Secrets(s.namespace).Create(context.TODO(), secret, metav1.CreateOptions{})
 		if err != nil {
-			klog.Errorf("Error creating secret: %v", err.Error())
+			klog.ErrorS(err, "Error creating secret", "namespace", s.namespace, "secret", s.secretName)
 			return nil, err
 		}
 		return loadX509KeyPairData(cert, key)
@@ -102,7 +102,7 @@ func (s *secretCertStore) Update(cert, key []byte) (*tls.Certificate, error) {
 	}
 	_, err = s.clientset.CoreV1().Secrets(s.namespace).Update(context.TODO(), secret, metav1.UpdateOptions{})
 	if err != nil {
-		klog.Errorf("Error updating secret: %v", err.Error())
+		klog.ErrorS(err, "Error updating secret", "namespace", s.namespace, "secret", s.secretName)
 		return nil, err
 	}
 	return loadX509KeyPairData(cert, key)
@@ -111,7 +111,7 @@ func (s *secretCertStore) Update(cert, key []byte) (*tls.Certificate, error) {
 func loadX509KeyPairData(cert, key []byte) (*tls.Certificate, error) {
 	tlsCert, err := tls.X509KeyPair(cert, key)
 	if err != nil {
-		klog.Errorf("Error parsing bytes: %v", err.Error())
+		klog.ErrorS(err, "Error parsing certificate and key")
 		return nil, err
 	}
 	certs, err := x509.ParseCertificates(tlsCert.Certificate[0])
@@ -0,0 +1,190 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// File sets flags from a YAML or JSON config file mapping flag names to their
+// values, e.g.
+//
+//	token-expiration: 3600
+//	watch-namespaces: [default, kube-system]
+//
+// Flags set on the command line take precedence over the file.
+type File struct {
+	mu    sync.Mutex // guards values
+	flags *pflag.FlagSet
+	// commandLine are the flags set on the command line, which the file
+	// does not override
+	commandLine sets.Set[string]
+	// reloadable are the flags applied again when the file is reloaded
+	reloadable sets.Set[string]
+	// values are the flag values of the last loaded file
+	values map[string]string
+}
+
+// New returns a File setting the flags of the parsed flag set. When the file
+// is reloaded, only the reloadable flags are set again, the others only take
+// effect after a restart.
+func New(flags *pflag.FlagSet, reloadable ...string) *File {
+	f := &File{
+		flags:       flags,
+		commandLine: sets.New[string](),
+		reloadable:  sets.New(reloadable...),
+		values:      map[string]string{},
+	}
+	flags.Visit(func(flag *pflag.Flag) {
+		f.commandLine.Insert(flag.Name)
+	})
+	return f
+}
+
+// Load sets the flags not set on the command line to their value in the file
+func (f *File) Load(content []byte) error {
+	values, err := f.parse(content)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, name := range sets.List(sets.KeySet(values)) {
+		if f.commandLine.Has(name) {
+			continue
+		}
+		if err := f.set(name, values[name]); err != nil {
+			return err
+		}
+	}
+	f.values = values
+	return nil
+}
+
+// Reload sets the reloadable flags whose value changed in the file, and
+// reverts those removed from the file to their default. Changes to the other
+// flags are logged, as they require a restart.
+func (f *File) Reload(content []byte) error {
+	values, err := f.parse(content)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	names := sets.KeySet(values).Union(sets.KeySet(f.values))
+	for _, name := range sets.List(names) {
+		value, ok := values[name]
+		if f.commandLine.Has(name) || (ok && value == f.values[name]) {
+			continue
+		}
+		if !f.reloadable.Has(name) {
+			klog.InfoS("Flag changed in the config file, restart the webhook to apply it", "flag", name)
+			continue
+		}
+		if !ok {
+			value = f.flags.Lookup(name).DefValue
+		}
+		if err := f.set(name, value); err != nil {
+			return err
+		}
+		klog.InfoS("Reloaded flag from the config file", "flag", name)
+	}
+	f.values = values
+	return nil
+}
+
+// Sets returns whether the flag is set by the last loaded file, rather than
+// the command line or its default
+func (f *File) Sets(name string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.values[name]
+	return ok && !f.commandLine.Has(name)
+}
+
+func (f *File) set(name, value string) error {
+	flag := f.flags.Lookup(name)
+	if sliceValue, ok := flag.Value.(pflag.SliceValue); ok {
+		var items []string
+		if value != "" {
+			items = strings.Split(value, ",")
+		}
+		if err := sliceValue.Replace(items); err != nil {
+			return fmt.Errorf("invalid value %q for flag %s: %v", value, name, err)
+		}
+		return nil
+	}
+	if err := flag.Value.Set(value); err != nil {
+		return fmt.Errorf("invalid value %q for flag %s: %v", value, name, err)
+	}
+	return nil
+}
+
+// parse returns the values of the flags in the file, as they would be given
+// on the command line
+func (f *File) parse(content []byte) (map[string]string, error) {
+	raw := map[string]interface{}{}
+	if err := yaml.Unmarshal(content, &raw); err != nil {
+		return nil, fmt.Errorf("error parsing config file: %v", err)
+	}
+	values := make(map[string]string, len(raw))
+	for name, value := range raw {
+		if f.flags.Lookup(name) == nil {
+			return nil, fmt.Errorf("unknown flag %s in config file", name)
+		}
+		s, err := flagValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for flag %s: %v", name, err)
+		}
+		values[name] = s
+	}
+	return values, nil
+}
+
+// flagValue formats a value of the config file as a flag value. Lists are
+// joined with commas, like the values of slice flags.
+func flagValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := flagValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported value %v", value)
+	}
+}
@@ -0,0 +1,120 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+)
+
+func newFlagSet(t *testing.T, args ...string) *pflag.FlagSet {
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.String("token-audience", "sts.amazonaws.com", "")
+	flags.Int64("token-expiration", 86400, "")
+	flags.Bool("in-cluster", true, "")
+	flags.Duration("informer-resync-period", time.Minute, "")
+	flags.StringSlice("watch-namespaces", nil, "")
+	flags.Int("v", 0, "")
+	if err := flags.Parse(args); err != nil {
+		t.Fatalf("Error parsing flags: %v", err)
+	}
+	return flags
+}
+
+func TestFileLoad(t *testing.T) {
+	flags := newFlagSet(t, "--token-audience=sts.example.com")
+	f := New(flags)
+	err := f.Load([]byte(`
+token-audience: ignored
+token-expiration: 3600
+in-cluster: false
+informer-resync-period: 5m
+watch-namespaces: [default, kube-system]
+`))
+	assert.NoError(t, err)
+
+	audience, _ := flags.GetString("token-audience")
+	assert.Equal(t, "sts.example.com", audience, "the command line takes precedence")
+	expiration, _ := flags.GetInt64("token-expiration")
+	assert.Equal(t, int64(3600), expiration)
+	inCluster, _ := flags.GetBool("in-cluster")
+	assert.False(t, inCluster)
+	resync, _ := flags.GetDuration("informer-resync-period")
+	assert.Equal(t, 5*time.Minute, resync)
+	namespaces, _ := flags.GetStringSlice("watch-namespaces")
+	assert.Equal(t, []string{"default", "kube-system"}, namespaces)
+}
+
+func TestFileSets(t *testing.T) {
+	flags := newFlagSet(t, "--token-audience=sts.example.com")
+	f := New(flags)
+	assert.NoError(t, f.Load([]byte("token-audience: ignored\ntoken-expiration: 3600\n")))
+	assert.False(t, f.Sets("token-audience"), "the command line takes precedence")
+	assert.True(t, f.Sets("token-expiration"))
+	assert.False(t, f.Sets("in-cluster"))
+}
+
+func TestFileLoadJSON(t *testing.T) {
+	flags := newFlagSet(t)
+	assert.NoError(t, New(flags).Load([]byte(`{"token-expiration": 7200, "watch-namespaces": "default"}`)))
+	expiration, _ := flags.GetInt64("token-expiration")
+	assert.Equal(t, int64(7200), expiration)
+	namespaces, _ := flags.GetStringSlice("watch-namespaces")
+	assert.Equal(t, []string{"default"}, namespaces)
+}
+
+func TestFileLoadErrors(t *testing.T) {
+	cases := []struct {
+		caseName string
+		content  string
+	}{
+		{"UnknownFlag", "unknown: true"},
+		{"InvalidValue", "token-expiration: forever"},
+		{"UnsupportedValue", "token-audience: {a: b}"},
+		{"InvalidYAML", "token-audience: [a"},
+	}
+	for _, c := range cases {
+		t.Run(c.caseName, func(t *testing.T) {
+			assert.Error(t, New(newFlagSet(t)).Load([]byte(c.content)))
+		})
+	}
+}
+
+func TestFileReload(t *testing.T) {
+	flags := newFlagSet(t)
+	f := New(flags, "v")
+	assert.NoError(t, f.Load([]byte("v: 2\ntoken-expiration: 3600\n")))
+
+	// Only reloadable flags are set again
+	assert.NoError(t, f.Reload([]byte("v: 4\ntoken-expiration: 7200\n")))
+	verbosity, _ := flags.GetInt("v")
+	assert.Equal(t, 4, verbosity)
+	expiration, _ := flags.GetInt64("token-expiration")
+	assert.Equal(t, int64(3600), expiration)
+
+	// Reloadable flags removed from the file are reverted to their default
+	assert.NoError(t, f.Reload([]byte("token-expiration: 7200\n")))
+	verbosity, _ = flags.GetInt("v")
+	assert.Equal(t, 0, verbosity)
+
+	// Invalid files are not applied
+	assert.Error(t, f.Reload([]byte("v: loud\n")))
+	verbosity, _ = flags.GetInt("v")
+	assert.Equal(t, 0, verbosity)
+}
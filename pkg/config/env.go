@@ -0,0 +1,64 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"k8s.io/klog/v2"
+)
+
+// EnvPrefix is the prefix of the environment variables setting flags
+const EnvPrefix = "POD_IDENTITY_WEBHOOK_"
+
+// EnvName returns the name of the environment variable setting a flag: the
+// flag name in upper case, with dashes replaced by underscores, after
+// EnvPrefix, e.g. POD_IDENTITY_WEBHOOK_TOKEN_EXPIRATION for token-expiration
+func EnvName(flag string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
+
+// LoadEnv sets the flags not set on the command line from the environment
+// variables named by EnvName, given as KEY=value like os.Environ returns them.
+// It must be called before New, so that the config file does not override
+// them.
+func LoadEnv(flags *pflag.FlagSet, environ []string) error {
+	names := map[string]string{}
+	flags.VisitAll(func(flag *pflag.Flag) {
+		names[EnvName(flag.Name)] = flag.Name
+	})
+	for _, variable := range environ {
+		key, value, _ := strings.Cut(variable, "=")
+		if !strings.HasPrefix(key, EnvPrefix) {
+			continue
+		}
+		name, ok := names[key]
+		if !ok {
+			klog.InfoS("Ignoring environment variable, which does not match any flag", "env", key)
+			continue
+		}
+		if flags.Changed(name) {
+			continue
+		}
+		// Set like on the command line, so that New sees the flag as set
+		if err := flags.Set(name, value); err != nil {
+			return fmt.Errorf("invalid value %q for %s: %v", value, key, err)
+		}
+	}
+	return nil
+}
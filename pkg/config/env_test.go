@@ -0,0 +1,55 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnvName(t *testing.T) {
+	assert.Equal(t, "POD_IDENTITY_WEBHOOK_TOKEN_EXPIRATION", EnvName("token-expiration"))
+	assert.Equal(t, "POD_IDENTITY_WEBHOOK_V", EnvName("v"))
+}
+
+func TestLoadEnv(t *testing.T) {
+	flags := newFlagSet(t, "--token-audience=sts.example.com")
+	err := LoadEnv(flags, []string{
+		"HOME=/root",
+		"POD_IDENTITY_WEBHOOK_TOKEN_AUDIENCE=ignored",
+		"POD_IDENTITY_WEBHOOK_TOKEN_EXPIRATION=3600",
+		"POD_IDENTITY_WEBHOOK_WATCH_NAMESPACES=default,kube-system",
+		"POD_IDENTITY_WEBHOOK_UNKNOWN=true",
+	})
+	assert.NoError(t, err)
+
+	audience, _ := flags.GetString("token-audience")
+	assert.Equal(t, "sts.example.com", audience, "the command line takes precedence")
+	expiration, _ := flags.GetInt64("token-expiration")
+	assert.Equal(t, int64(3600), expiration)
+	namespaces, _ := flags.GetStringSlice("watch-namespaces")
+	assert.Equal(t, []string{"default", "kube-system"}, namespaces)
+
+	// The environment takes precedence over the config file
+	assert.NoError(t, New(flags).Load([]byte("token-expiration: 7200\nin-cluster: false\n")))
+	expiration, _ = flags.GetInt64("token-expiration")
+	assert.Equal(t, int64(3600), expiration)
+	inCluster, _ := flags.GetBool("in-cluster")
+	assert.False(t, inCluster)
+
+	assert.Error(t, LoadEnv(newFlagSet(t), []string{"POD_IDENTITY_WEBHOOK_IN_CLUSTER=maybe"}))
+}
@@ -20,8 +20,18 @@ const (
 	DefaultTokenExpiration = int64(86400)
 	// 10mins is min for kube-apiserver
 	MinTokenExpiration = int64(600)
+	// 24hrs is max for EKS, longer tokens may be capped by the API server
+	MaxTokenExpiration = int64(86400)
 
 	// AWS SDK defined environment variables.
 	AwsEnvVarContainerCredentialsFullUri     = "AWS_CONTAINER_CREDENTIALS_FULL_URI"
 	AwsEnvVarContainerAuthorizationTokenFile = "AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"
+	AwsEnvVarContainerCredentialsRelativeUri = "AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"
+	AwsEnvVarSDKUAAppID                      = "AWS_SDK_UA_APP_ID"
+	AwsEnvVarUseFIPSEndpoint                 = "AWS_USE_FIPS_ENDPOINT"
+	AwsEnvVarConfigFile                      = "AWS_CONFIG_FILE"
+
+	// MaxSDKUAAppIDLength is the maximum length of the application ID the
+	// AWS SDKs add to their User-Agent
+	MaxSDKUAAppIDLength = 50
 )
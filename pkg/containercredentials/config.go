@@ -20,10 +20,28 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/aws/amazon-eks-pod-identity-webhook/pkg/filesystem"
+	"github.com/aws/amazon-eks-pod-identity-webhook/pkg/httppoller"
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/klog/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
 	"sync"
+	"time"
 )
 
+var cacheSize = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "pod_identity_webhook_container_credentials_cache_entries",
+	Help: "Number of identities in the container credentials config cache",
+})
+
+func init() {
+	prometheus.MustRegister(cacheSize)
+}
+
 type Config interface {
 	Get(namespace string, serviceAccount string) *PatchConfig
 }
@@ -34,89 +52,391 @@ type FileConfig struct {
 	volumeName string
 	tokenPath  string
 	fullUri    string
+	// tokenExpiration is the token expiration of identities not overriding
+	// it, 0 to use the one of the service account
+	tokenExpiration int64
+	// relativeUri is the relative URI of all identities, empty to use the
+	// path of their full URI
+	relativeUri string
 
 	watcher              *filesystem.FileWatcher
+	poller               *httppoller.Poller
 	identityConfigObject *IdentityConfigObject
-	cache                map[Identity]bool
-	mu                   sync.RWMutex // guards cache
+	cache                map[identityKey]*PatchConfig
+	selectors            []selectorIdentity
+	exclusions           []ExcludedIdentity
+	loaded               bool
+	mu                   sync.RWMutex // guards cache, selectors, exclusions and loaded
+	namespaceLabels      func(namespace string) (map[string]string, error)
+}
+
+// selectorIdentity is an Identity selecting namespaces by label
+type selectorIdentity struct {
+	selector       labels.Selector
+	serviceAccount string
+	patchConfig    *PatchConfig
+}
+
+// FileConfigOpt is an option type for setting up a FileConfig
+type FileConfigOpt func(*FileConfig)
+
+// WithNamespaceLabels sets the function returning the labels of a namespace,
+// required for identities with a namespace selector to match
+func WithNamespaceLabels(namespaceLabels func(namespace string) (map[string]string, error)) FileConfigOpt {
+	return func(f *FileConfig) { f.namespaceLabels = namespaceLabels }
+}
+
+// WithRelativeUri sets the relative URI of all identities, injected instead of
+// or along with their full URI for SDKs that only support relative URIs.
+// Defaults to the path of the full URI of each identity.
+func WithRelativeUri(relativeUri string) FileConfigOpt {
+	return func(f *FileConfig) { f.relativeUri = relativeUri }
 }
 
+// WithTokenExpiration sets the token expiration of the identities that do not
+// override it. Defaults to 0, to use the token expiration of the service account.
+func WithTokenExpiration(tokenExpiration int64) FileConfigOpt {
+	return func(f *FileConfig) { f.tokenExpiration = tokenExpiration }
+}
+
+// UnixSocketScheme is the scheme of full URIs of credentials endpoints served
+// over a unix domain socket
+const UnixSocketScheme = "unix"
+
 type PatchConfig struct {
 	Audience   string
 	MountPath  string
 	VolumeName string
 	TokenPath  string
 	FullUri    string
+	// RelativeUri is the value of AWS_CONTAINER_CREDENTIALS_RELATIVE_URI, when
+	// it is injected
+	RelativeUri string
+	// TokenExpiration overrides the token expiration of the service account
+	// when non-zero
+	TokenExpiration int64
 }
 
-func NewFileConfig(audience, mountPath, volumeName, tokenPath, fullUri string) *FileConfig {
-	return &FileConfig{
+// SocketPath returns the path of the unix domain socket of FullUri, if it uses
+// the unix scheme
+func (p *PatchConfig) SocketPath() (string, bool) {
+	u, err := url.Parse(p.FullUri)
+	if err != nil || u.Scheme != UnixSocketScheme {
+		return "", false
+	}
+	return u.Path, true
+}
+
+// relativeUri returns the path and query of the full URI, empty if it is not
+// a valid HTTP URI
+func relativeUri(fullUri string) string {
+	u, err := url.Parse(fullUri)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+	return u.RequestURI()
+}
+
+// ValidateFullUri returns an error if the full URI uses the unix scheme
+// without an absolute socket path
+func ValidateFullUri(fullUri string) error {
+	u, err := url.Parse(fullUri)
+	if err != nil || u.Scheme != UnixSocketScheme {
+		return nil
+	}
+	if !path.IsAbs(u.Path) || u.Host != "" {
+		return fmt.Errorf("unix URI %q must have an absolute socket path, e.g. unix:///var/run/agent.sock", fullUri)
+	}
+	return nil
+}
+
+func NewFileConfig(audience, mountPath, volumeName, tokenPath, fullUri string, opts ...FileConfigOpt) *FileConfig {
+	f := &FileConfig{
 		audience:             audience,
 		mountPath:            mountPath,
 		volumeName:           volumeName,
 		tokenPath:            tokenPath,
 		fullUri:              fullUri,
 		identityConfigObject: nil,
-		cache:                make(map[Identity]bool),
+		cache:                make(map[identityKey]*PatchConfig),
+	}
+	for _, opt := range opts {
+		opt(f)
 	}
+	return f
 }
 
 // StartWatcher creates and starts a fsnotify watcher on the target config file.
 // The watcher runs continuously until the context is cancelled.  When the file is updated,
-// Load will be invoked, and thus will refresh the cache.
+// Load will be invoked, and thus will refresh the cache. When filePath is a
+// directory, its *.json files are watched and merged by LoadFragments instead.
 func (f *FileConfig) StartWatcher(ctx context.Context, filePath string) error {
-	f.watcher = filesystem.NewFileWatcher("container-credential-config", filePath, f.Load)
+	if info, err := os.Stat(filePath); err == nil && info.IsDir() {
+		f.watcher = filesystem.NewDirectoryWatcher("container-credential-config", filePath, f.LoadFragments)
+	} else {
+		f.watcher = filesystem.NewFileWatcher("container-credential-config", filePath, f.Load)
+	}
 	return f.watcher.Watch(ctx)
 }
 
-func (f *FileConfig) Load(content []byte) error {
-	f.mu.Lock()
-	defer f.mu.Unlock()
+// StartPoller starts fetching the config from url every interval, using client.
+// The poller runs continuously until the context is cancelled.  When the
+// content changes, Load will be invoked, and thus will refresh the cache.
+func (f *FileConfig) StartPoller(ctx context.Context, url string, interval time.Duration, client *http.Client) {
+	f.poller = httppoller.NewPoller("container-credential-config", url, interval, client, f.Load)
+	f.poller.Poll(ctx)
+}
 
+func (f *FileConfig) Load(content []byte) error {
 	if content == nil || len(content) == 0 {
 		klog.Info("Container credentials config file is empty, clearing cache")
-		f.identityConfigObject = nil
-		f.cache = nil
+		f.setConfig(nil)
 		return nil
 	}
 
+	configObject, err := parseConfig(content)
+	if err != nil {
+		return err
+	}
+	f.setConfig(configObject)
+	klog.Info("Successfully loaded container credentials config file")
+
+	return nil
+}
+
+// LoadFragments merges the identities of the config fragments, in their order.
+// Invalid fragments are logged and ignored, so that they do not prevent the
+// others from being loaded.
+func (f *FileConfig) LoadFragments(fragments []filesystem.Fragment) error {
+	merged := &IdentityConfigObject{}
+	for _, fragment := range fragments {
+		if len(fragment.Content) == 0 {
+			continue
+		}
+		configObject, err := parseConfig(fragment.Content)
+		if err != nil {
+			klog.ErrorS(err, "Ignoring container credentials config fragment", "fragment", fragment.Name)
+			continue
+		}
+		merged.Identities = append(merged.Identities, configObject.Identities...)
+		merged.ExcludeIdentities = append(merged.ExcludeIdentities, configObject.ExcludeIdentities...)
+	}
+	f.setConfig(merged)
+	klog.InfoS("Successfully loaded container credentials config fragments", "count", len(fragments))
+
+	return nil
+}
+
+// parseConfig parses and validates a config
+func parseConfig(content []byte) (*IdentityConfigObject, error) {
 	var configObject IdentityConfigObject
 	if err := json.Unmarshal(content, &configObject); err != nil {
-		return fmt.Errorf("error Unmarshalling container credentials config file: %v", err)
+		return nil, fmt.Errorf("error Unmarshalling container credentials config file: %v", err)
+	}
+
+	for _, item := range configObject.ExcludeIdentities {
+		for _, pattern := range []string{item.Namespace, item.ServiceAccount} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern %q in excludeIdentities of container credentials config file: %v", pattern, err)
+			}
+		}
+	}
+
+	for _, item := range configObject.Identities {
+		if item.TokenExpiration < 0 {
+			return nil, fmt.Errorf("identity %s/%s of container credentials config file has a negative tokenExpiration", item.Namespace, item.ServiceAccount)
+		}
+		if err := ValidateFullUri(item.FullUri); err != nil {
+			return nil, fmt.Errorf("invalid fullUri of identity %s/%s in container credentials config file: %v", item.Namespace, item.ServiceAccount, err)
+		}
+		if item.IPFamily != "" {
+			if _, err := DefaultFullUri(item.IPFamily); err != nil {
+				return nil, fmt.Errorf("invalid ipFamily of identity %s/%s in container credentials config file: %v", item.Namespace, item.ServiceAccount, err)
+			}
+		}
+		if item.NamespaceSelector != nil {
+			if item.Namespace != "" {
+				return nil, fmt.Errorf("identity %s/%s of container credentials config file sets both namespace and namespaceSelector", item.Namespace, item.ServiceAccount)
+			}
+			if _, err := metav1.LabelSelectorAsSelector(item.NamespaceSelector); err != nil {
+				return nil, fmt.Errorf("invalid namespaceSelector of identity %s in container credentials config file: %v", item.ServiceAccount, err)
+			}
+		}
+	}
+	return &configObject, nil
+}
+
+// setConfig replaces the cache with the identities of a validated config, or
+// clears it when nil
+func (f *FileConfig) setConfig(configObject *IdentityConfigObject) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if configObject == nil {
+		f.identityConfigObject = nil
+		f.cache = nil
+		f.selectors = nil
+		f.exclusions = nil
+		f.loaded = true
+		cacheSize.Set(0)
+		return
 	}
 
-	newCache := make(map[Identity]bool)
+	newCache := make(map[identityKey]*PatchConfig)
+	var newSelectors []selectorIdentity
 	for _, item := range configObject.Identities {
-		klog.V(5).Infof("Adding SA %s/%s to container credentials config cache", item.Namespace, item.ServiceAccount)
-		newCache[item] = true
+		if item.NamespaceSelector != nil {
+			// Validated by parseConfig
+			selector, _ := metav1.LabelSelectorAsSelector(item.NamespaceSelector)
+			klog.V(5).InfoS("Adding service account in namespaces matching selector to container credentials config cache", "serviceAccount", item.ServiceAccount, "namespaceSelector", selector)
+			newSelectors = append(newSelectors, selectorIdentity{
+				selector:       selector,
+				serviceAccount: item.ServiceAccount,
+				patchConfig:    f.patchConfig(item),
+			})
+			continue
+		}
+		key := identityKey{namespace: item.Namespace, serviceAccount: item.ServiceAccount}
+		if _, ok := newCache[key]; ok {
+			klog.InfoS("Ignoring duplicate service account in container credentials config file", "namespace", item.Namespace, "serviceAccount", item.ServiceAccount)
+			continue
+		}
+		klog.V(5).InfoS("Adding service account to container credentials config cache", "namespace", item.Namespace, "serviceAccount", item.ServiceAccount)
+		newCache[key] = f.patchConfig(item)
 	}
-	f.identityConfigObject = &configObject
+	f.identityConfigObject = configObject
 	f.cache = newCache
-	klog.Info("Successfully loaded container credentials config file")
+	f.selectors = newSelectors
+	f.exclusions = configObject.ExcludeIdentities
+	f.loaded = true
+	cacheSize.Set(float64(len(newCache) + len(newSelectors)))
+}
 
-	return nil
+// Loaded returns true once the config file has been successfully loaded at least once
+func (f *FileConfig) Loaded() bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.loaded
 }
 
+// ToJSON returns the loaded config as JSON string, with the identities and
+// exclusions of all fragments when watching a directory
+func (f *FileConfig) ToJSON() string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	contents, err := json.MarshalIndent(f.identityConfigObject, "", " ")
+	if err != nil {
+		klog.ErrorS(err, "Json marshal error")
+		return ""
+	}
+	return string(contents)
+}
+
+// Get returns the patch config of the most specific identity matching the
+// service account: an exact match, then a wildcard service account in the
+// namespace, a wildcard namespace, a namespace selector, in the order of the
+// config file, and finally a wildcard namespace and service account. Excluded
+// service accounts never match.
 func (f *FileConfig) Get(namespace string, serviceAccount string) *PatchConfig {
-	key := Identity{
-		Namespace:      namespace,
-		ServiceAccount: serviceAccount,
-	}
-	if f.getCacheItem(key) {
-		return &PatchConfig{
-			Audience:   f.audience,
-			MountPath:  f.mountPath,
-			VolumeName: f.volumeName,
-			TokenPath:  f.tokenPath,
-			FullUri:    f.fullUri,
-		}
+	if patchConfig := f.getCacheItem(namespace, serviceAccount); patchConfig != nil {
+		// Return a copy, callers may modify it
+		patchConfigCopy := *patchConfig
+		return &patchConfigCopy
 	}
 
 	return nil
 }
 
-func (f *FileConfig) getCacheItem(identity Identity) bool {
+func (f *FileConfig) getCacheItem(namespace, serviceAccount string) *PatchConfig {
 	f.mu.RLock()
 	defer f.mu.RUnlock()
-	return f.cache[identity]
+	if f.isExcluded(namespace, serviceAccount) {
+		klog.V(5).InfoS("Service account is excluded from the container credentials method", "namespace", namespace, "serviceAccount", serviceAccount)
+		return nil
+	}
+	for _, key := range []identityKey{
+		{namespace: namespace, serviceAccount: serviceAccount},
+		{namespace: namespace, serviceAccount: Wildcard},
+		{namespace: Wildcard, serviceAccount: serviceAccount},
+	} {
+		if patchConfig, ok := f.cache[key]; ok {
+			return patchConfig
+		}
+	}
+	if patchConfig := f.matchSelectors(namespace, serviceAccount); patchConfig != nil {
+		return patchConfig
+	}
+	return f.cache[identityKey{namespace: Wildcard, serviceAccount: Wildcard}]
+}
+
+func (f *FileConfig) isExcluded(namespace, serviceAccount string) bool {
+	for _, item := range f.exclusions {
+		if matchPattern(item.Namespace, namespace) && matchPattern(item.ServiceAccount, serviceAccount) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPattern returns true if name matches the pattern, which was validated
+// when loading the config. An empty pattern matches any name.
+func matchPattern(pattern, name string) bool {
+	if pattern == "" {
+		return true
+	}
+	matched, _ := path.Match(pattern, name)
+	return matched
+}
+
+func (f *FileConfig) matchSelectors(namespace, serviceAccount string) *PatchConfig {
+	if len(f.selectors) == 0 || f.namespaceLabels == nil {
+		return nil
+	}
+	namespaceLabels, err := f.namespaceLabels(namespace)
+	if err != nil {
+		klog.V(4).InfoS("Not matching container credentials namespace selectors, could not get labels of namespace", "namespace", namespace, "err", err)
+		return nil
+	}
+	for _, item := range f.selectors {
+		if (item.serviceAccount == serviceAccount || item.serviceAccount == Wildcard) && item.selector.Matches(labels.Set(namespaceLabels)) {
+			return item.patchConfig
+		}
+	}
+	return nil
+}
+
+// patchConfig returns the patch config of the identity, using the flag values
+// for the fields it does not override
+func (f *FileConfig) patchConfig(identity Identity) *PatchConfig {
+	patchConfig := &PatchConfig{
+		Audience:        f.audience,
+		MountPath:       f.mountPath,
+		VolumeName:      f.volumeName,
+		TokenPath:       f.tokenPath,
+		FullUri:         f.fullUri,
+		TokenExpiration: f.tokenExpiration,
+	}
+	if identity.TokenExpiration != 0 {
+		patchConfig.TokenExpiration = identity.TokenExpiration
+	}
+	if identity.Audience != "" {
+		patchConfig.Audience = identity.Audience
+	}
+	if identity.MountPath != "" {
+		patchConfig.MountPath = identity.MountPath
+	}
+	if identity.TokenPath != "" {
+		patchConfig.TokenPath = identity.TokenPath
+	}
+	if identity.FullUri != "" {
+		patchConfig.FullUri = identity.FullUri
+	} else if identity.IPFamily != "" {
+		// Validated when loading the config
+		patchConfig.FullUri, _ = DefaultFullUri(identity.IPFamily)
+	}
+	patchConfig.RelativeUri = f.relativeUri
+	if patchConfig.RelativeUri == "" {
+		patchConfig.RelativeUri = relativeUri(patchConfig.FullUri)
+	}
+	return patchConfig
 }
@@ -19,6 +19,10 @@ import (
 	"context"
 	"encoding/json"
 	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -68,6 +72,71 @@ func TestFileConfig_Watcher(t *testing.T) {
 	verifyConfigObject(t, fileConfig, newConfigObject)
 }
 
+func TestFileConfig_DirectoryWatcher(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dirPath := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dirPath, "10-foo.json"), []byte(`{"identities":[{"namespace":"foo","serviceAccount":"sa"}]}`), 0666))
+	assert.NoError(t, os.WriteFile(filepath.Join(dirPath, "20-invalid.json"), []byte(`{"identities":[{"namespace":"foo","serviceAccount":"sa","tokenExpiration":-1}]}`), 0666))
+	assert.NoError(t, os.WriteFile(filepath.Join(dirPath, "README.md"), []byte(`not a fragment`), 0666))
+
+	fileConfig := NewFileConfig(audience, mountPath, volumeName, tokenName, fullUri)
+	assert.NoError(t, fileConfig.StartWatcher(ctx, dirPath))
+	verifyConfigObject(t, fileConfig, &IdentityConfigObject{
+		Identities: []Identity{{Namespace: "foo", ServiceAccount: "sa"}},
+	})
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dirPath, "05-exclusions.json"), []byte(`{"excludeIdentities":[{"namespace":"kube-*"}],"identities":[{"namespace":"bar","serviceAccount":"sa","audience":"first"}]}`), 0666))
+	assert.NoError(t, os.WriteFile(filepath.Join(dirPath, "30-bar.json"), []byte(`{"identities":[{"namespace":"bar","serviceAccount":"sa","audience":"ignored"}]}`), 0666))
+	verifyConfigObject(t, fileConfig, &IdentityConfigObject{
+		Identities: []Identity{
+			{Namespace: "bar", ServiceAccount: "sa", Audience: "first"},
+			{Namespace: "foo", ServiceAccount: "sa"},
+			{Namespace: "bar", ServiceAccount: "sa", Audience: "ignored"},
+		},
+		ExcludeIdentities: []ExcludedIdentity{{Namespace: "kube-*"}},
+	})
+	assert.Equal(t, "first", fileConfig.Get("bar", "sa").Audience, "the first fragment should win")
+	assert.Nil(t, fileConfig.Get("kube-system", "sa"))
+}
+
+func TestFileConfig_ConfigMapWatcher(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cm := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "container-credentials", Namespace: "kube-system"},
+		Data:       map[string]string{ConfigMapKey: string(defaultConfigObjectBytes())},
+	}
+	fakeClient := fake.NewSimpleClientset(cm, &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "kube-system"},
+		Data:       map[string]string{ConfigMapKey: "invalid"},
+	})
+	informerFactory := informers.NewSharedInformerFactoryWithOptions(fakeClient, 0, informers.WithNamespace("kube-system"))
+
+	fileConfig := NewFileConfig(audience, mountPath, volumeName, tokenName, fullUri)
+	assert.NoError(t, fileConfig.StartConfigMapWatcher(informerFactory.Core().V1().ConfigMaps(), "container-credentials"))
+	informerFactory.Start(ctx.Done())
+	verifyConfigObject(t, fileConfig, defaultConfigObject())
+	assert.True(t, fileConfig.Loaded())
+
+	newConfigObject := defaultConfigObject()
+	newConfigObject.Identities = append(newConfigObject.Identities, Identity{
+		Namespace:      "new-ns",
+		ServiceAccount: "new-sa",
+	})
+	newConfigObjectBytes, err := json.Marshal(newConfigObject)
+	assert.NoError(t, err)
+	cm.Data[ConfigMapKey] = string(newConfigObjectBytes)
+	_, err = fakeClient.CoreV1().ConfigMaps("kube-system").Update(ctx, cm, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+	verifyConfigObject(t, fileConfig, newConfigObject)
+
+	assert.NoError(t, fakeClient.CoreV1().ConfigMaps("kube-system").Delete(ctx, cm.Name, metav1.DeleteOptions{}))
+	verifyConfigObject(t, fileConfig, nil)
+}
+
 func TestFileConfig_WatcherNotStarted(t *testing.T) {
 	fileConfig := NewFileConfig(audience, mountPath, volumeName, tokenName, fullUri)
 	patchConfig := fileConfig.Get("non-existent", "non-existent")
@@ -113,6 +182,10 @@ func TestFileConfig_Load(t *testing.T) {
 			} else {
 				assert.NoError(t, err)
 				verifyConfigObject(t, fileConfig, tc.expectedConfigObject)
+
+				var dumped *IdentityConfigObject
+				assert.NoError(t, json.Unmarshal([]byte(fileConfig.ToJSON()), &dumped))
+				assert.Equal(t, tc.expectedConfigObject, dumped)
 			}
 		})
 	}
@@ -136,6 +209,150 @@ func TestFileConfig_Get(t *testing.T) {
 	assert.Nil(t, patchConfig)
 }
 
+func TestFileConfig_GetOverrides(t *testing.T) {
+	fileConfig := NewFileConfig(audience, mountPath, volumeName, tokenName, fullUri)
+	err := fileConfig.Load([]byte(`{"identities":[
+		{"namespace":"foo","serviceAccount":"overridden","audience":"other-audience","fullUri":"other-uri","mountPath":"other-mount-path","tokenPath":"other-token-path","tokenExpiration":3600},
+		{"namespace":"foo","serviceAccount":"overridden","audience":"duplicate"},
+		{"namespace":"foo","serviceAccount":"partial","fullUri":"other-uri"}
+	]}`))
+	assert.NoError(t, err)
+
+	assert.Equal(t, &PatchConfig{
+		Audience:        "other-audience",
+		MountPath:       "other-mount-path",
+		VolumeName:      volumeName,
+		TokenPath:       "other-token-path",
+		FullUri:         "other-uri",
+		TokenExpiration: 3600,
+	}, fileConfig.Get("foo", "overridden"), "the first entry of an identity should be used")
+	assert.Equal(t, &PatchConfig{
+		Audience:   audience,
+		MountPath:  mountPath,
+		VolumeName: volumeName,
+		TokenPath:  tokenName,
+		FullUri:    "other-uri",
+	}, fileConfig.Get("foo", "partial"))
+}
+
+func TestFileConfig_GetTokenExpiration(t *testing.T) {
+	fileConfig := NewFileConfig(audience, mountPath, volumeName, tokenName, fullUri, WithTokenExpiration(7200))
+	assert.NoError(t, fileConfig.Load([]byte(`{"identities":[
+		{"namespace":"foo","serviceAccount":"default"},
+		{"namespace":"foo","serviceAccount":"overridden","tokenExpiration":3600}
+	]}`)))
+	assert.Equal(t, int64(7200), fileConfig.Get("foo", "default").TokenExpiration)
+	assert.Equal(t, int64(3600), fileConfig.Get("foo", "overridden").TokenExpiration)
+
+	assert.Error(t, fileConfig.Load([]byte(`{"identities":[{"namespace":"foo","serviceAccount":"negative","tokenExpiration":-1}]}`)))
+}
+
+func TestFileConfig_GetIPFamily(t *testing.T) {
+	fileConfig := NewFileConfig(audience, mountPath, volumeName, tokenName, fullUri)
+	assert.NoError(t, fileConfig.Load([]byte(`{"identities":[
+		{"namespace":"foo","serviceAccount":"ipv6","ipFamily":"IPv6"},
+		{"namespace":"foo","serviceAccount":"both","ipFamily":"IPv6","fullUri":"other-uri"},
+		{"namespace":"foo","serviceAccount":"default"}
+	]}`)))
+	assert.Equal(t, DefaultFullUriIPv6, fileConfig.Get("foo", "ipv6").FullUri)
+	assert.Equal(t, "other-uri", fileConfig.Get("foo", "both").FullUri, "fullUri should take precedence over ipFamily")
+	assert.Equal(t, fullUri, fileConfig.Get("foo", "default").FullUri)
+
+	assert.Error(t, fileConfig.Load([]byte(`{"identities":[{"namespace":"foo","serviceAccount":"bar","ipFamily":"IPv5"}]}`)))
+}
+
+func TestFileConfig_GetRelativeUri(t *testing.T) {
+	fileConfig := NewFileConfig(audience, mountPath, volumeName, tokenName, "http://169.254.170.23/v1/credentials")
+	assert.NoError(t, fileConfig.Load([]byte(`{"identities":[
+		{"namespace":"foo","serviceAccount":"default"},
+		{"namespace":"foo","serviceAccount":"other","fullUri":"http://169.254.170.24/v2/credentials?role=foo"}
+	]}`)))
+	assert.Equal(t, "/v1/credentials", fileConfig.Get("foo", "default").RelativeUri)
+	assert.Equal(t, "/v2/credentials?role=foo", fileConfig.Get("foo", "other").RelativeUri)
+
+	fileConfig = NewFileConfig(audience, mountPath, volumeName, tokenName, fullUri, WithRelativeUri("/creds"))
+	assert.NoError(t, fileConfig.Load([]byte(`{"identities":[{"namespace":"foo","serviceAccount":"default"}]}`)))
+	assert.Equal(t, "/creds", fileConfig.Get("foo", "default").RelativeUri)
+}
+
+func TestPatchConfig_SocketPath(t *testing.T) {
+	socketPath, ok := (&PatchConfig{FullUri: "unix:///var/run/agent/agent.sock"}).SocketPath()
+	assert.True(t, ok)
+	assert.Equal(t, "/var/run/agent/agent.sock", socketPath)
+	_, ok = (&PatchConfig{FullUri: "http://169.254.170.23/v1/credentials"}).SocketPath()
+	assert.False(t, ok)
+
+	assert.NoError(t, ValidateFullUri("unix:///var/run/agent/agent.sock"))
+	assert.NoError(t, ValidateFullUri("http://169.254.170.23/v1/credentials"))
+	assert.Error(t, ValidateFullUri("unix://agent.sock"))
+
+	fileConfig := NewFileConfig(audience, mountPath, volumeName, tokenName, fullUri)
+	assert.Error(t, fileConfig.Load([]byte(`{"identities":[{"namespace":"foo","serviceAccount":"bar","fullUri":"unix:agent.sock"}]}`)))
+}
+
+func TestFileConfig_GetWildcardsAndSelectors(t *testing.T) {
+	namespaceLabels := map[string]map[string]string{
+		"team-a-1": {"team": "a"},
+		"team-b-1": {"team": "b"},
+	}
+	fileConfig := NewFileConfig(audience, mountPath, volumeName, tokenName, fullUri,
+		WithNamespaceLabels(func(namespace string) (map[string]string, error) {
+			return namespaceLabels[namespace], nil
+		}))
+	err := fileConfig.Load([]byte(`{"identities":[
+		{"namespace":"exact","serviceAccount":"sa","audience":"exact"},
+		{"namespace":"exact","serviceAccount":"*","audience":"namespace-wildcard"},
+		{"namespace":"*","serviceAccount":"sa","audience":"service-account-in-any-namespace"},
+		{"namespaceSelector":{"matchLabels":{"team":"a"}},"serviceAccount":"*","audience":"team-a"},
+		{"namespaceSelector":{"matchExpressions":[{"key":"team","operator":"In","values":["a","b"]}]},"serviceAccount":"builder","audience":"team-a-or-b"},
+		{"namespace":"*","serviceAccount":"*","audience":"everything"}
+	]}`))
+	assert.NoError(t, err)
+	assert.Len(t, fileConfig.selectors, 2)
+
+	for _, tc := range []struct {
+		namespace, serviceAccount, audience string
+	}{
+		{"exact", "sa", "exact"},
+		{"exact", "other", "namespace-wildcard"},
+		{"other", "sa", "service-account-in-any-namespace"},
+		{"team-a-1", "builder", "team-a"},
+		{"team-b-1", "builder", "team-a-or-b"},
+		{"team-b-1", "other", "everything"},
+		{"other", "other", "everything"},
+	} {
+		patchConfig := fileConfig.Get(tc.namespace, tc.serviceAccount)
+		if assert.NotNil(t, patchConfig, "%s/%s", tc.namespace, tc.serviceAccount) {
+			assert.Equal(t, tc.audience, patchConfig.Audience, "%s/%s", tc.namespace, tc.serviceAccount)
+		}
+	}
+
+	assert.Error(t, fileConfig.Load([]byte(`{"identities":[{"namespace":"ns","namespaceSelector":{},"serviceAccount":"sa"}]}`)))
+	assert.Error(t, fileConfig.Load([]byte(`{"identities":[{"namespaceSelector":{"matchExpressions":[{"key":"team","operator":"Bad"}]},"serviceAccount":"sa"}]}`)))
+}
+
+func TestFileConfig_GetExclusions(t *testing.T) {
+	fileConfig := NewFileConfig(audience, mountPath, volumeName, tokenName, fullUri)
+	err := fileConfig.Load([]byte(`{
+		"identities":[
+			{"namespace":"*","serviceAccount":"*"},
+			{"namespace":"app","serviceAccount":"irsa"}
+		],
+		"excludeIdentities":[
+			{"namespace":"kube-*"},
+			{"namespace":"app","serviceAccount":"irsa"}
+		]
+	}`))
+	assert.NoError(t, err)
+
+	assert.NotNil(t, fileConfig.Get("app", "other"))
+	assert.Nil(t, fileConfig.Get("app", "irsa"), "exclusions should win over identities")
+	assert.Nil(t, fileConfig.Get("kube-system", "coredns"))
+	assert.NotNil(t, fileConfig.Get("kubernetes", "sa"))
+
+	assert.Error(t, fileConfig.Load([]byte(`{"excludeIdentities":[{"namespace":"["}]}`)))
+}
+
 func defaultConfigObject() *IdentityConfigObject {
 	return &IdentityConfigObject{
 		Identities: []Identity{
@@ -0,0 +1,74 @@
+/*
+  Copyright 2023 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package containercredentials
+
+import (
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// ConfigMapKey is the key of the ConfigMap data holding the config
+const ConfigMapKey = "config"
+
+// StartConfigMapWatcher loads the config from the ConfigMap with the given
+// name watched by the informer, which must be started by the caller. The cache
+// is refreshed when the ConfigMap is updated, and cleared when it is deleted.
+func (f *FileConfig) StartConfigMapWatcher(informer coreinformers.ConfigMapInformer, name string) error {
+	load := func(cm *v1.ConfigMap) {
+		if cm.Name != name {
+			return
+		}
+		klog.V(5).InfoS("Loading container credentials config from ConfigMap", "namespace", cm.Namespace, "configMap", cm.Name)
+		if err := f.Load([]byte(cm.Data[ConfigMapKey])); err != nil {
+			utilruntime.HandleError(fmt.Errorf("error loading container credentials config from ConfigMap %s/%s: %v", cm.Namespace, cm.Name, err))
+		}
+	}
+	_, err := informer.Informer().AddEventHandler(
+		cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				load(obj.(*v1.ConfigMap))
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				load(newObj.(*v1.ConfigMap))
+			},
+			DeleteFunc: func(obj interface{}) {
+				cm, ok := obj.(*v1.ConfigMap)
+				if !ok {
+					tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+					if !ok {
+						utilruntime.HandleError(fmt.Errorf("couldn't get object from tombstone %+v", obj))
+						return
+					}
+					cm, ok = tombstone.Obj.(*v1.ConfigMap)
+					if !ok {
+						utilruntime.HandleError(fmt.Errorf("tombstone contained object that is not a ConfigMap %#v", obj))
+						return
+					}
+				}
+				if cm.Name != name {
+					return
+				}
+				klog.InfoS("Container credentials config ConfigMap was deleted", "namespace", cm.Namespace, "configMap", cm.Name)
+				f.Load(nil)
+			},
+		},
+	)
+	return err
+}
@@ -16,12 +16,15 @@
 package containercredentials
 
 type FakeConfig struct {
-	Audience   string
-	MountPath  string
-	VolumeName string
-	TokenPath  string
-	FullUri    string
-	Identities map[Identity]bool
+	Audience    string
+	MountPath   string
+	VolumeName  string
+	TokenPath   string
+	FullUri     string
+	RelativeUri string
+	Identities  map[Identity]bool
+	// TokenExpiration is returned as the token expiration override of all identities
+	TokenExpiration int64
 }
 
 func (f *FakeConfig) Get(namespace string, serviceAccount string) *PatchConfig {
@@ -35,11 +38,13 @@ func (f *FakeConfig) Get(namespace string, serviceAccount string) *PatchConfig {
 	}
 	if _, ok := f.Identities[key]; ok {
 		return &PatchConfig{
-			Audience:   f.Audience,
-			MountPath:  f.MountPath,
-			VolumeName: f.VolumeName,
-			TokenPath:  f.TokenPath,
-			FullUri:    f.FullUri,
+			Audience:        f.Audience,
+			MountPath:       f.MountPath,
+			VolumeName:      f.VolumeName,
+			TokenPath:       f.TokenPath,
+			FullUri:         f.FullUri,
+			RelativeUri:     f.RelativeUri,
+			TokenExpiration: f.TokenExpiration,
 		}
 	}
 
@@ -0,0 +1,66 @@
+/*
+  Copyright 2023 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package containercredentials
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"net"
+)
+
+const (
+	// DefaultFullUriIPv4 is the default credentials endpoint in IPv4 clusters
+	DefaultFullUriIPv4 = "http://169.254.170.23/v1/credentials"
+	// DefaultFullUriIPv6 is the default credentials endpoint in IPv6 clusters
+	DefaultFullUriIPv6 = "http://[fd00:ec2::23]/v1/credentials"
+)
+
+// DefaultFullUri returns the default credentials endpoint for the IP family
+func DefaultFullUri(family v1.IPFamily) (string, error) {
+	switch family {
+	case v1.IPv4Protocol:
+		return DefaultFullUriIPv4, nil
+	case v1.IPv6Protocol:
+		return DefaultFullUriIPv6, nil
+	default:
+		return "", fmt.Errorf("unsupported IP family %q, expected %q or %q", family, v1.IPv4Protocol, v1.IPv6Protocol)
+	}
+}
+
+// DetectIPFamily returns the primary IP family of the cluster, the one of the
+// kubernetes Service in the default namespace
+func DetectIPFamily(ctx context.Context, client kubernetes.Interface) (v1.IPFamily, error) {
+	svc, err := client.CoreV1().Services(metav1.NamespaceDefault).Get(ctx, "kubernetes", metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	if len(svc.Spec.IPFamilies) > 0 {
+		return svc.Spec.IPFamilies[0], nil
+	}
+	// IPFamilies is only set since Kubernetes 1.20
+	ip := net.ParseIP(svc.Spec.ClusterIP)
+	switch {
+	case ip == nil:
+		return "", fmt.Errorf("kubernetes Service has no valid cluster IP %q", svc.Spec.ClusterIP)
+	case ip.To4() != nil:
+		return v1.IPv4Protocol, nil
+	default:
+		return v1.IPv6Protocol, nil
+	}
+}
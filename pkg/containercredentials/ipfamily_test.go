@@ -0,0 +1,63 @@
+/*
+  Copyright 2023 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package containercredentials
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"testing"
+)
+
+func TestDetectIPFamily(t *testing.T) {
+	testcases := []struct {
+		name     string
+		spec     v1.ServiceSpec
+		expected v1.IPFamily
+	}{
+		{
+			name:     "ipv6 single stack",
+			spec:     v1.ServiceSpec{ClusterIP: "fd00::1", IPFamilies: []v1.IPFamily{v1.IPv6Protocol}},
+			expected: v1.IPv6Protocol,
+		},
+		{
+			name:     "dual stack, ipv4 primary",
+			spec:     v1.ServiceSpec{ClusterIP: "10.100.0.1", IPFamilies: []v1.IPFamily{v1.IPv4Protocol, v1.IPv6Protocol}},
+			expected: v1.IPv4Protocol,
+		},
+		{
+			name:     "no ip families",
+			spec:     v1.ServiceSpec{ClusterIP: "fd00::1"},
+			expected: v1.IPv6Protocol,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(&v1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "kubernetes", Namespace: metav1.NamespaceDefault},
+				Spec:       tc.spec,
+			})
+			family, err := DetectIPFamily(context.Background(), client)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, family)
+		})
+	}
+
+	_, err := DetectIPFamily(context.Background(), fake.NewSimpleClientset())
+	assert.Error(t, err)
+}
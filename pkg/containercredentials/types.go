@@ -15,11 +15,50 @@
 
 package containercredentials
 
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Wildcard matches any namespace or service account in an Identity
+const Wildcard = "*"
+
 type IdentityConfigObject struct {
 	Identities []Identity `json:"identities,omitempty"`
+	// ExcludeIdentities lists service accounts never using the container
+	// credentials method, even when they match Identities
+	ExcludeIdentities []ExcludedIdentity `json:"excludeIdentities,omitempty"`
 }
 
+// Identity selects the service accounts using the container credentials
+// method. Namespace and ServiceAccount may be Wildcard, and NamespaceSelector
+// can be set instead of Namespace to select namespaces by label.
 type Identity struct {
-	Namespace      string `json:"namespace"`
-	ServiceAccount string `json:"serviceAccount"`
+	Namespace         string                `json:"namespace,omitempty"`
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	ServiceAccount    string                `json:"serviceAccount"`
+
+	// Optional overrides of the flag values for this identity
+	Audience        string `json:"audience,omitempty"`
+	FullUri         string `json:"fullUri,omitempty"`
+	MountPath       string `json:"mountPath,omitempty"`
+	TokenPath       string `json:"tokenPath,omitempty"`
+	TokenExpiration int64  `json:"tokenExpiration,omitempty"`
+	// IPFamily selects the default full URI of the IP family, IPv4 or IPv6,
+	// when FullUri is not set
+	IPFamily v1.IPFamily `json:"ipFamily,omitempty"`
+}
+
+// identityKey identifies the service account an Identity applies to
+type identityKey struct {
+	namespace      string
+	serviceAccount string
+}
+
+// ExcludedIdentity matches service accounts by namespace and name patterns
+// with the syntax of path.Match, e.g. "kube-*". An empty pattern matches any
+// namespace or service account.
+type ExcludedIdentity struct {
+	Namespace      string `json:"namespace,omitempty"`
+	ServiceAccount string `json:"serviceAccount,omitempty"`
 }
@@ -16,14 +16,19 @@
 package filesystem
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"errors"
+	"fmt"
 	"github.com/fsnotify/fsnotify"
+	"github.com/prometheus/client_golang/prometheus"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 )
 
@@ -32,12 +37,48 @@ const (
 	workerPollInterval = 1 * time.Second
 	workqueueBaseDelay = 10 * time.Millisecond
 	workqueueMaxDelay  = 5 * time.Minute
+
+	// fragmentPattern matches the files of a watched directory
+	fragmentPattern = "*.json"
+
+	// atomicWriterDataDir is the symlink swapped by the kubelet to update the
+	// files of mounted ConfigMaps and Secrets, which are symlinks into it
+	atomicWriterDataDir = "..data"
+)
+
+var (
+	reloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pod_identity_webhook_file_watcher_reloads_total",
+		Help: "Number of attempts to reload a watched file",
+	}, []string{"purpose"})
+	reloadErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pod_identity_webhook_file_watcher_reload_errors_total",
+		Help: "Number of failed attempts to reload a watched file, because it could not be read or its content was rejected",
+	}, []string{"purpose"})
+	skippedReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pod_identity_webhook_file_watcher_skipped_reloads_total",
+		Help: "Number of times a watched file was reloaded with unchanged content, which was not handled again",
+	}, []string{"purpose"})
+	lastSuccessfulLoad = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pod_identity_webhook_file_watcher_last_successful_load_timestamp_seconds",
+		Help: "Unix timestamp of the last successful reload of a watched file",
+	}, []string{"purpose"})
 )
 
-// FileWatcher watches a single file and trigger the given handler function
+func init() {
+	prometheus.MustRegister(reloads)
+	prometheus.MustRegister(reloadErrors)
+	prometheus.MustRegister(skippedReloads)
+	prometheus.MustRegister(lastSuccessfulLoad)
+}
+
+// FileWatcher watches a single file, or the fragments of a directory, and
+// trigger the given handler function
 type FileWatcher struct {
-	path    string
-	handler FileContentHandler
+	purpose          string
+	path             string
+	handler          FileContentHandler
+	fragmentsHandler FragmentsHandler
 
 	watcher *fsnotify.Watcher
 
@@ -51,19 +92,50 @@ type FileWatcher struct {
 	// pattern is borrowed from
 	// https://github.com/kubernetes/kubernetes/blob/3d67e162a03d0d724dc5a15a0617c5e8572c7b4a/staging/src/k8s.io/apiserver/pkg/server/dynamiccertificates/dynamic_serving_content.go
 	queue workqueue.RateLimitingInterface
+
+	// realPath is the path the file resolves to once symlinks are evaluated,
+	// whose directory is watched too when it differs from the file's one.
+	mu       sync.Mutex
+	realPath string
+
+	// checksum is the checksum of the content last handled successfully, or
+	// nil before the first load. It is only accessed by the worker.
+	checksum []byte
 }
 
 type FileContentHandler func(content []byte) error
 
+// Fragment is a file of a watched directory
+type Fragment struct {
+	Name    string
+	Content []byte
+}
+
+// FragmentsHandler is invoked with the fragments of a watched directory, in
+// the order of their names
+type FragmentsHandler func(fragments []Fragment) error
+
 // NewFileWatcher creates a FileWatcher
 func NewFileWatcher(purpose string, path string, handler FileContentHandler) *FileWatcher {
 	return &FileWatcher{
+		purpose: purpose,
 		path:    path,
 		handler: handler,
 		queue:   workqueue.NewNamedRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(workqueueBaseDelay, workqueueMaxDelay), purpose),
 	}
 }
 
+// NewDirectoryWatcher creates a FileWatcher watching the *.json files of a
+// directory, which are handled together as fragments of a single config
+func NewDirectoryWatcher(purpose string, path string, handler FragmentsHandler) *FileWatcher {
+	return &FileWatcher{
+		purpose:          purpose,
+		path:             path,
+		fragmentsHandler: handler,
+		queue:            workqueue.NewNamedRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(workqueueBaseDelay, workqueueMaxDelay), purpose),
+	}
+}
+
 // Watch sets up the fsnotify watcher and add the file that we are interested in.  The file watcher
 // and worker run in goroutines.  The goroutines are stopped when the ctx is cancelled.
 func (f *FileWatcher) Watch(ctx context.Context) error {
@@ -96,6 +168,9 @@ func (f *FileWatcher) Watch(ctx context.Context) error {
 	}()
 
 	dir := filepath.Dir(f.path)
+	if f.fragmentsHandler != nil {
+		dir = f.path
+	}
 	err = f.watcher.Add(dir)
 	if err != nil {
 		klog.Fatal(err)
@@ -104,13 +179,60 @@ func (f *FileWatcher) Watch(ctx context.Context) error {
 	return nil
 }
 
-// processEvent adds an item to the workqueue.
+// processEvent adds an item to the workqueue when the file, the target of its
+// symlinks, or the kubelet's data directory symlink changes. The latter is
+// the only change of a ConfigMap or Secret mounted in a volume, as the file
+// itself is a symlink which is left untouched.
 func (f *FileWatcher) processEvent(event fsnotify.Event) {
-	if event.Name == f.path {
+	if f.fragmentsHandler != nil {
+		name := filepath.Base(event.Name)
+		if match, _ := filepath.Match(fragmentPattern, name); match || name == atomicWriterDataDir {
+			f.queue.Add(workItemKey)
+		}
+		return
+	}
+	f.mu.Lock()
+	realPath := f.realPath
+	f.mu.Unlock()
+	switch event.Name {
+	case f.path, filepath.Join(filepath.Dir(f.path), atomicWriterDataDir), realPath:
 		f.queue.Add(workItemKey)
 	}
 }
 
+// watchRealPath evaluates the symlinks of the file, and watches the directory
+// of their target when it is not the file's one
+func (f *FileWatcher) watchRealPath() error {
+	realPath, err := filepath.EvalSymlinks(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		realPath = ""
+	} else if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	dir := filepath.Dir(f.path)
+	var oldRealDir, realDir string
+	if f.realPath != "" {
+		oldRealDir = filepath.Dir(f.realPath)
+	}
+	if realPath != "" {
+		realDir = filepath.Dir(realPath)
+	}
+	if oldRealDir != "" && oldRealDir != dir && oldRealDir != realDir {
+		// The previous target directory may already be removed
+		_ = f.watcher.Remove(oldRealDir)
+	}
+	if realDir != "" && realDir != dir && realDir != oldRealDir {
+		if err := f.watcher.Add(realDir); err != nil {
+			return err
+		}
+	}
+	f.realPath = realPath
+	return nil
+}
+
 func (f *FileWatcher) runWorker(ctx context.Context) {
 	for f.processNextWorkItem(ctx) {
 	}
@@ -123,24 +245,101 @@ func (f *FileWatcher) processNextWorkItem(ctx context.Context) (continuePoll boo
 	}
 	defer f.queue.Done(k)
 
+	reloads.WithLabelValues(f.purpose).Inc()
 	if err := f.loadFile(); err != nil {
 		klog.ErrorS(err, "failed processing files")
+		reloadErrors.WithLabelValues(f.purpose).Inc()
 		f.queue.AddRateLimited(k)
 		return true
 	}
+	lastSuccessfulLoad.WithLabelValues(f.purpose).SetToCurrentTime()
 
 	f.queue.Forget(k)
 	return true
 }
 
 func (f *FileWatcher) loadFile() error {
-	if _, err := os.Stat(f.path); errors.Is(err, os.ErrNotExist) {
-		return f.handler(nil)
+	if f.fragmentsHandler != nil {
+		return f.loadDirectory()
+	}
+	if err := f.watchRealPath(); err != nil {
+		return err
+	}
+	var content []byte
+	if _, err := os.Stat(f.path); !errors.Is(err, os.ErrNotExist) {
+		content, err = os.ReadFile(f.path)
+		if err != nil {
+			return err
+		}
+	}
+
+	checksum := contentChecksum(content)
+	if f.unchanged(checksum) {
+		return nil
+	}
+	if err := f.handler(content); err != nil {
+		return err
 	}
+	f.checksum = checksum
+	return nil
+}
 
-	content, err := os.ReadFile(f.path)
+func (f *FileWatcher) loadDirectory() error {
+	// Entries are sorted by name
+	entries, err := os.ReadDir(f.path)
 	if err != nil {
 		return err
 	}
-	return f.handler(content)
+	var fragments []Fragment
+	h := sha256.New()
+	for _, entry := range entries {
+		if match, _ := filepath.Match(fragmentPattern, entry.Name()); !match || entry.IsDir() {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(f.path, entry.Name()))
+		if errors.Is(err, os.ErrNotExist) {
+			// Removed since the directory was read
+			continue
+		} else if err != nil {
+			return err
+		}
+		fragments = append(fragments, Fragment{Name: entry.Name(), Content: content})
+		fmt.Fprintf(h, "%s\x00%d\x00", entry.Name(), len(content))
+		h.Write(content)
+	}
+
+	checksum := h.Sum(nil)
+	if f.unchanged(checksum) {
+		return nil
+	}
+	if err := f.fragmentsHandler(fragments); err != nil {
+		return err
+	}
+	f.checksum = checksum
+	return nil
+}
+
+// unchanged returns true if the checksum of the content is the one of the
+// content last handled. Files are often written again with the same content,
+// e.g. by config syncers, which is not handled again.
+func (f *FileWatcher) unchanged(checksum []byte) bool {
+	if !bytes.Equal(checksum, f.checksum) {
+		return false
+	}
+	klog.V(5).InfoS("File content unchanged, skipping reload", "purpose", f.purpose, "path", f.path)
+	skippedReloads.WithLabelValues(f.purpose).Inc()
+	return true
+}
+
+// contentChecksum returns the checksum of the content of a file, which is
+// prefixed so that a missing file is told apart from an empty one
+func contentChecksum(content []byte) []byte {
+	h := sha256.New()
+	if content == nil {
+		h.Write([]byte{0})
+	} else {
+		h.Write([]byte{1})
+		h.Write(content)
+	}
+	return h.Sum(nil)
 }
@@ -18,9 +18,11 @@ package filesystem
 import (
 	"context"
 	"fmt"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 )
@@ -151,7 +153,7 @@ func TestFileWatch_RetryOnHandlerError(t *testing.T) {
 	filePath := filepath.Join(dirPath, "file")
 	writeFile(t, filePath, invalidContent)
 
-	fileWatcher := NewFileWatcher("testing", filePath, handler)
+	fileWatcher := NewFileWatcher("testing-retry", filePath, handler)
 	err = fileWatcher.Watch(ctx)
 	assert.NoError(t, err)
 
@@ -159,10 +161,141 @@ func TestFileWatch_RetryOnHandlerError(t *testing.T) {
 		return errCount > 1 && processedContent == ""
 	}, defaultTimeout, defaultPollInterval)
 
+	assert.Zero(t, testutil.ToFloat64(lastSuccessfulLoad.WithLabelValues("testing-retry")))
+
 	writeFile(t, filePath, validContent)
 	assert.Eventually(t, func() bool {
 		return processedContent == validContent
 	}, defaultTimeout, defaultPollInterval)
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(lastSuccessfulLoad.WithLabelValues("testing-retry")) > 0
+	}, defaultTimeout, defaultPollInterval)
+	assert.GreaterOrEqual(t, testutil.ToFloat64(reloadErrors.WithLabelValues("testing-retry")), float64(2))
+	assert.Greater(t, testutil.ToFloat64(reloads.WithLabelValues("testing-retry")), testutil.ToFloat64(reloadErrors.WithLabelValues("testing-retry")))
+}
+
+func TestFileWatcher_SkipsUnchangedContent(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	filePath := filepath.Join(t.TempDir(), "file")
+	writeFile(t, filePath, "foo")
+
+	var handled []string
+	var mu sync.Mutex
+	fileWatcher := NewFileWatcher("testing-unchanged", filePath, func(content []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		handled = append(handled, string(content))
+		return nil
+	})
+	assert.NoError(t, fileWatcher.Watch(ctx))
+	getHandled := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, handled...)
+	}
+	assert.Eventually(t, func() bool {
+		return len(getHandled()) == 1
+	}, defaultTimeout, defaultPollInterval)
+
+	// Written atomically, so that the truncated file is never read
+	renameFile(t, filePath, "foo")
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(skippedReloads.WithLabelValues("testing-unchanged")) > 0
+	}, defaultTimeout, defaultPollInterval)
+
+	renameFile(t, filePath, "bar")
+	assert.Eventually(t, func() bool {
+		return len(getHandled()) == 2
+	}, defaultTimeout, defaultPollInterval)
+	assert.Equal(t, []string{"foo", "bar"}, getHandled())
+}
+
+func TestFileWatcher_Symlinks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dirPath := t.TempDir()
+	targetDirPath := t.TempDir()
+
+	// A file symlinked to a file in another directory
+	targetPath := filepath.Join(targetDirPath, "target")
+	writeFile(t, targetPath, "foo")
+	linkPath := filepath.Join(dirPath, "link")
+	assert.NoError(t, os.Symlink(targetPath, linkPath))
+
+	linkRecorder := fileContentRecorder{}
+	assert.NoError(t, NewFileWatcher("testing", linkPath, linkRecorder.record).Watch(ctx))
+	assert.Eventually(t, func() bool {
+		return linkRecorder.content == "foo"
+	}, defaultTimeout, defaultPollInterval)
+	writeFile(t, targetPath, "bar")
+	assert.Eventually(t, func() bool {
+		return linkRecorder.content == "bar"
+	}, defaultTimeout, defaultPollInterval)
+
+	// A file updated like the kubelet updates mounted ConfigMaps, by swapping
+	// the ..data symlink
+	writeVersion := func(version, content string) {
+		versionPath := filepath.Join(dirPath, version)
+		assert.NoError(t, os.Mkdir(versionPath, 0755))
+		writeFile(t, filepath.Join(versionPath, "config"), content)
+		tmpPath := filepath.Join(dirPath, "..data_tmp")
+		assert.NoError(t, os.Symlink(version, tmpPath))
+		assert.NoError(t, os.Rename(tmpPath, filepath.Join(dirPath, "..data")))
+	}
+	writeVersion("..v1", "foo")
+	configPath := filepath.Join(dirPath, "config")
+	assert.NoError(t, os.Symlink(filepath.Join("..data", "config"), configPath))
+
+	configRecorder := fileContentRecorder{}
+	assert.NoError(t, NewFileWatcher("testing", configPath, configRecorder.record).Watch(ctx))
+	assert.Eventually(t, func() bool {
+		return configRecorder.content == "foo"
+	}, defaultTimeout, defaultPollInterval)
+	writeVersion("..v2", "bar")
+	assert.NoError(t, os.RemoveAll(filepath.Join(dirPath, "..v1")))
+	assert.Eventually(t, func() bool {
+		return configRecorder.content == "bar"
+	}, defaultTimeout, defaultPollInterval)
+}
+
+func TestDirectoryWatcher(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dirPath := t.TempDir()
+	writeFile(t, filepath.Join(dirPath, "b.json"), "b")
+	writeFile(t, filepath.Join(dirPath, "a.json"), "a")
+	writeFile(t, filepath.Join(dirPath, "ignored.txt"), "ignored")
+
+	var handled [][]Fragment
+	var mu sync.Mutex
+	fileWatcher := NewDirectoryWatcher("testing-directory", dirPath, func(fragments []Fragment) error {
+		mu.Lock()
+		defer mu.Unlock()
+		handled = append(handled, fragments)
+		return nil
+	})
+	assert.NoError(t, fileWatcher.Watch(ctx))
+	lastHandled := func() []Fragment {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(handled) == 0 {
+			return nil
+		}
+		return handled[len(handled)-1]
+	}
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]Fragment{{"a.json", []byte("a")}, {"b.json", []byte("b")}}, lastHandled())
+	}, defaultTimeout, defaultPollInterval)
+
+	writeFile(t, filepath.Join(dirPath, "c.json"), "c")
+	assert.NoError(t, os.Remove(filepath.Join(dirPath, "a.json")))
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]Fragment{{"b.json", []byte("b")}, {"c.json", []byte("c")}}, lastHandled())
+	}, defaultTimeout, defaultPollInterval)
 }
 
 func writeFile(t *testing.T, filePath string, content string) {
@@ -170,6 +303,12 @@ func writeFile(t *testing.T, filePath string, content string) {
 	assert.NoError(t, err)
 }
 
+func renameFile(t *testing.T, filePath string, content string) {
+	tmpPath := filePath + ".tmp"
+	writeFile(t, tmpPath, content)
+	assert.NoError(t, os.Rename(tmpPath, filePath))
+}
+
 func appendToFile(t *testing.T, filePath string, newContent string) {
 	f, err := os.OpenFile(filePath, os.O_RDWR|os.O_APPEND, 0666)
 	assert.NoError(t, err)
@@ -0,0 +1,87 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// Authorize is a middleware authenticating requests with a TokenReview of the
+// bearer token of their Authorization header, and authorizing them with a
+// SubjectAccessReview of their path as a non-resource URL, with the lowercase
+// HTTP method as verb, the same way the API server authorizes its own /debug
+// paths.
+func Authorize(client kubernetes.Interface) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || token == "" {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			review, err := client.AuthenticationV1().TokenReviews().Create(r.Context(), &authenticationv1.TokenReview{
+				Spec: authenticationv1.TokenReviewSpec{Token: token},
+			}, metav1.CreateOptions{})
+			if err != nil {
+				klog.ErrorS(err, "Error reviewing token of request", "path", r.URL.Path)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if !review.Status.Authenticated {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			user := review.Status.User
+			extra := make(map[string]authorizationv1.ExtraValue, len(user.Extra))
+			for key, value := range user.Extra {
+				extra[key] = authorizationv1.ExtraValue(value)
+			}
+			access, err := client.AuthorizationV1().SubjectAccessReviews().Create(r.Context(), &authorizationv1.SubjectAccessReview{
+				Spec: authorizationv1.SubjectAccessReviewSpec{
+					NonResourceAttributes: &authorizationv1.NonResourceAttributes{
+						Path: r.URL.Path,
+						Verb: strings.ToLower(r.Method),
+					},
+					User:   user.Username,
+					Groups: user.Groups,
+					Extra:  extra,
+					UID:    user.UID,
+				},
+			}, metav1.CreateOptions{})
+			if err != nil {
+				klog.ErrorS(err, "Error reviewing access of request", "path", r.URL.Path, "user", user.Username)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if !access.Status.Allowed {
+				klog.V(4).InfoS("Denied request", "method", r.Method, "path", r.URL.Path, "user", user.Username, "reason", access.Status.Reason)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			h.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,83 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestAuthorize(t *testing.T) {
+	client := fakeclientset.NewSimpleClientset()
+	client.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		switch review.Spec.Token {
+		case "admin", "viewer":
+			review.Status.Authenticated = true
+			review.Status.User = authenticationv1.UserInfo{Username: review.Spec.Token}
+		case "error":
+			return true, nil, fmt.Errorf("unavailable")
+		}
+		return true, review, nil
+	})
+	client.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		attributes := review.Spec.NonResourceAttributes
+		review.Status.Allowed = review.Spec.User == "admin" || (attributes.Verb == "get" && attributes.Path == "/debug/alpha/cache")
+		return true, review, nil
+	})
+	h := Apply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "ok")
+	}), Authorize(client))
+
+	cases := []struct {
+		caseName     string
+		method       string
+		path         string
+		header       string
+		expectedCode int
+	}{
+		{"NoToken", http.MethodGet, "/debug/alpha/cache", "", http.StatusUnauthorized},
+		{"NotBearer", http.MethodGet, "/debug/alpha/cache", "Basic YWRtaW46YWRtaW4=", http.StatusUnauthorized},
+		{"InvalidToken", http.MethodGet, "/debug/alpha/cache", "Bearer invalid", http.StatusUnauthorized},
+		{"ReviewError", http.MethodGet, "/debug/alpha/cache", "Bearer error", http.StatusInternalServerError},
+		{"Allowed", http.MethodGet, "/debug/alpha/cache", "Bearer viewer", http.StatusOK},
+		{"ForbiddenPath", http.MethodGet, "/debug/alpha/cache/all", "Bearer viewer", http.StatusForbidden},
+		{"ForbiddenVerb", http.MethodPost, "/debug/alpha/cache", "Bearer viewer", http.StatusForbidden},
+		{"Admin", http.MethodPost, "/debug/alpha/simulate", "Bearer admin", http.StatusOK},
+	}
+
+	for _, c := range cases {
+		t.Run(c.caseName, func(t *testing.T) {
+			r := httptest.NewRequest(c.method, c.path, nil)
+			if c.header != "" {
+				r.Header.Set("Authorization", c.header)
+			}
+			recorder := httptest.NewRecorder()
+			h.ServeHTTP(recorder, r)
+			assert.Equal(t, c.expectedCode, recorder.Code)
+		})
+	}
+}
@@ -0,0 +1,43 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package handler
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Reasons of the Events emitted when a pod could not be mutated
+const (
+	reasonServiceAccountNotFound      = "ServiceAccountNotFound"
+	reasonServiceAccountLookupTimeout = "ServiceAccountLookupTimeout"
+	reasonInvalidRoleARN              = "InvalidRoleARN"
+)
+
+// recordServiceAccountEvent emits a warning Event on the given service account
+// so that mutation issues show up in the namespace of the pod. The service
+// account is referenced by name, as it may not exist.
+func (m *Modifier) recordServiceAccountEvent(namespace, name, reason, messageFmt string, args ...interface{}) {
+	if m.recorder == nil {
+		return
+	}
+	ref := &corev1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "ServiceAccount",
+		Namespace:  namespace,
+		Name:       name,
+	}
+	m.recorder.Eventf(ref, corev1.EventTypeWarning, reason, messageFmt, args...)
+}
@@ -0,0 +1,132 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package handler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// ExtraEnv holds the env variables configured by the cluster operator, e.g.
+// HTTPS_PROXY, which are injected into every mutated container along with the
+// AWS env variables. The variables given as flags take precedence over the ones
+// loaded from the file.
+type ExtraEnv struct {
+	mu    sync.RWMutex
+	flags []corev1.EnvVar
+	// env is flags merged with the variables of the file
+	env []corev1.EnvVar
+	// loaded is set once the file is loaded
+	loaded bool
+}
+
+// NewExtraEnv returns an ExtraEnv with the variables given as NAME=value
+func NewExtraEnv(variables []string) (*ExtraEnv, error) {
+	e := &ExtraEnv{}
+	values := map[string]string{}
+	for _, variable := range variables {
+		name, value, ok := strings.Cut(variable, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid env variable %q, expected NAME=value", variable)
+		}
+		values[name] = value
+	}
+	env, err := envVars(values)
+	if err != nil {
+		return nil, err
+	}
+	e.flags = env
+	e.env = env
+	return e, nil
+}
+
+// Load replaces the variables of the file, a YAML or JSON map of variable
+// names to values
+func (e *ExtraEnv) Load(content []byte) error {
+	values := map[string]string{}
+	if err := yaml.Unmarshal(content, &values); err != nil {
+		return fmt.Errorf("error parsing extra env file: %v", err)
+	}
+	env, err := envVars(values)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.env = append([]corev1.EnvVar{}, e.flags...)
+	for _, envVar := range env {
+		if !hasEnvVar(e.flags, envVar.Name) {
+			e.env = append(e.env, envVar)
+		}
+	}
+	sort.Slice(e.env, func(i, j int) bool { return e.env[i].Name < e.env[j].Name })
+	e.loaded = true
+	klog.InfoS("Loaded extra env variables", "count", len(e.env))
+	return nil
+}
+
+// Loaded returns an error until the file is loaded, for the readiness check of
+// webhooks given an extra env file
+func (e *ExtraEnv) Loaded() error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if !e.loaded {
+		return fmt.Errorf("extra env file not loaded")
+	}
+	return nil
+}
+
+// Get returns the variables to inject, sorted by name
+func (e *ExtraEnv) Get() []corev1.EnvVar {
+	if e == nil {
+		return nil
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.env
+}
+
+// envVars returns the variables of the values, sorted by name, or an error if a
+// name is invalid
+func envVars(values map[string]string) ([]corev1.EnvVar, error) {
+	env := make([]corev1.EnvVar, 0, len(values))
+	for name, value := range values {
+		if errs := validation.IsEnvVarName(name); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid env variable name %q: %s", name, strings.Join(errs, ", "))
+		}
+		env = append(env, corev1.EnvVar{Name: name, Value: value})
+	}
+	sort.Slice(env, func(i, j int) bool { return env[i].Name < env[j].Name })
+	return env, nil
+}
+
+// hasEnvVar returns true if env has a variable with the given name
+func hasEnvVar(env []corev1.EnvVar, name string) bool {
+	for _, envVar := range env {
+		if envVar.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package handler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestExtraEnv(t *testing.T) {
+	_, err := NewExtraEnv([]string{"HTTPS_PROXY"})
+	assert.Error(t, err)
+	_, err = NewExtraEnv([]string{"1PROXY=http://proxy:3128"})
+	assert.Error(t, err)
+
+	extraEnv, err := NewExtraEnv([]string{"HTTPS_PROXY=http://proxy:3128", "AWS_CA_BUNDLE=/etc/ca.pem"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Error(t, extraEnv.Loaded())
+	assert.Equal(t, []corev1.EnvVar{
+		{Name: "AWS_CA_BUNDLE", Value: "/etc/ca.pem"},
+		{Name: "HTTPS_PROXY", Value: "http://proxy:3128"},
+	}, extraEnv.Get())
+
+	assert.NoError(t, extraEnv.Load([]byte("NO_PROXY: 169.254.170.23,.svc\nHTTPS_PROXY: http://other:3128\n")))
+	assert.NoError(t, extraEnv.Loaded())
+	assert.Equal(t, []corev1.EnvVar{
+		{Name: "AWS_CA_BUNDLE", Value: "/etc/ca.pem"},
+		{Name: "HTTPS_PROXY", Value: "http://proxy:3128"},
+		{Name: "NO_PROXY", Value: "169.254.170.23,.svc"},
+	}, extraEnv.Get())
+
+	assert.Error(t, extraEnv.Load([]byte("1NO_PROXY: .svc\n")))
+	assert.Len(t, extraEnv.Get(), 3)
+}
+
+func TestAddEnvToContainer_ExtraEnv(t *testing.T) {
+	extraEnv, err := NewExtraEnv([]string{"HTTPS_PROXY=http://proxy:3128", "NO_PROXY=.svc"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	modifier := NewModifier(WithExtraEnv(extraEnv))
+	patchConfig := &podPatchConfig{
+		MountPath:              "/var/run/secrets/eks.amazonaws.com/serviceaccount",
+		VolumeName:             "aws-iam-token",
+		WebIdentityPatchConfig: &webIdentityPatchConfig{RoleArn: "arn:aws:iam::111122223333:role/s3-reader"},
+	}
+	container := &corev1.Container{
+		Env: []corev1.EnvVar{
+			{Name: "AWS_ROLE_ARN", Value: "arn:aws:iam::111122223333:role/s3-reader"},
+			{Name: "AWS_REGION", Value: "us-west-2"},
+			{Name: "AWS_STS_REGIONAL_ENDPOINTS", Value: "regional"},
+			{Name: "NO_PROXY", Value: "localhost"},
+		},
+	}
+
+	assert.True(t, modifier.addEnvToContainer(container, "/token", patchConfig))
+	assert.Equal(t, []corev1.EnvVar{
+		{Name: "AWS_ROLE_ARN", Value: "arn:aws:iam::111122223333:role/s3-reader"},
+		{Name: "AWS_REGION", Value: "us-west-2"},
+		{Name: "AWS_STS_REGIONAL_ENDPOINTS", Value: "regional"},
+		{Name: "NO_PROXY", Value: "localhost"},
+		{Name: "HTTPS_PROXY", Value: "http://proxy:3128"},
+	}, container.Env)
+}
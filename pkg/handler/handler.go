@@ -16,19 +16,29 @@
 package handler
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"maps"
+	"math/rand"
 	"net/http"
+	"path"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/amazon-eks-pod-identity-webhook/pkg/containercredentials"
 
 	"github.com/aws/amazon-eks-pod-identity-webhook/pkg"
+	"github.com/aws/amazon-eks-pod-identity-webhook/pkg/auditlog"
 	"github.com/aws/amazon-eks-pod-identity-webhook/pkg/cache"
 	"k8s.io/api/admission/v1beta1"
 	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
@@ -36,20 +46,101 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/runtime/serializer/protobuf"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 )
 
 func init() {
 	_ = corev1.AddToScheme(runtimeScheme)
 	_ = admissionregistrationv1beta1.AddToScheme(runtimeScheme)
+	_ = v1beta1.AddToScheme(runtimeScheme)
 }
 
 var (
 	runtimeScheme = runtime.NewScheme()
 	codecs        = serializer.NewCodecFactory(runtimeScheme)
 	deserializer  = codecs.UniversalDeserializer()
+
+	protobufEncoder = codecs.EncoderForVersion(protobuf.NewSerializer(runtimeScheme, runtimeScheme), v1beta1.SchemeGroupVersion)
+)
+
+// DefaultMaxRequestBodyBytes is the default maximum size of admission request
+// bodies, twice the default maximum size of an object in etcd as the
+// AdmissionReview may contain both the object and the old object.
+const DefaultMaxRequestBodyBytes = 3 * 1024 * 1024
+
+// Credential method precedences, deciding which credential method is injected
+// into pods whose service account has both a role ARN and a container
+// credentials identity
+const (
+	// CredentialMethodPrecedenceContainerCredentials injects the container credentials method only
+	CredentialMethodPrecedenceContainerCredentials = "container-credentials"
+	// CredentialMethodPrecedenceSTSWebIdentity injects the STS web identity method only
+	CredentialMethodPrecedenceSTSWebIdentity = "sts-web-identity"
+	// CredentialMethodPrecedenceBoth injects both methods, and lets the
+	// credential provider chain of the AWS SDK pick one
+	CredentialMethodPrecedenceBoth = "both"
+)
+
+// Container credentials URI modes, deciding which of the full and relative
+// URI env variables are injected with the container credentials method
+const (
+	// ContainerCredentialsURIModeFull injects AWS_CONTAINER_CREDENTIALS_FULL_URI
+	ContainerCredentialsURIModeFull = "full"
+	// ContainerCredentialsURIModeRelative injects AWS_CONTAINER_CREDENTIALS_RELATIVE_URI,
+	// for SDKs and tools that do not support the full URI
+	ContainerCredentialsURIModeRelative = "relative"
+	// ContainerCredentialsURIModeBoth injects both env variables
+	ContainerCredentialsURIModeBoth = "both"
+)
+
+// Env variable positions, deciding where the injected env variables are
+// placed in the env of containers
+const (
+	// EnvVarPositionAppend places them after the env variables of the container
+	EnvVarPositionAppend = "append"
+	// EnvVarPositionPrepend places them before the env variables of the
+	// container, which can then reference them with $(VAR)
+	EnvVarPositionPrepend = "prepend"
+	// EnvVarPositionBeforeReference places them before the first env variable
+	// of the container referencing one of them with $(VAR), or after the env
+	// variables of the container if none does
+	EnvVarPositionBeforeReference = "before-reference"
 )
 
+// Native sidecar modes, deciding how initContainers with restartPolicy Always
+// are treated
+const (
+	// NativeSidecarsContainer treats native sidecars like containers, as they
+	// run for the lifetime of the pod
+	NativeSidecarsContainer = "container"
+	// NativeSidecarsInitContainer treats native sidecars like the other
+	// initContainers
+	NativeSidecarsInitContainer = "init-container"
+)
+
+// Container types of the mutated containers metric
+const (
+	containerTypeContainer     = "container"
+	containerTypeInitContainer = "init_container"
+	containerTypeSidecar       = "sidecar"
+)
+
+// containerCredentialsSocketVolumeName is the name of the hostPath volume of
+// the directory of the container credentials endpoint socket, for full URIs
+// using the unix scheme
+const containerCredentialsSocketVolumeName = "eks-pod-identity-agent-socket"
+
+// tokenWaitContainerName is the name of the init container waiting until STS
+// accepts the token of the pod
+const tokenWaitContainerName = "aws-iam-token-wait"
+
+// awsConfigFileName is the file name of the injected AWS config file in the
+// token volume
+const awsConfigFileName = "config"
+
 // ModifierOpt is an option type for setting up a Modifier
 type ModifierOpt func(*Modifier)
 
@@ -68,6 +159,38 @@ func WithMountPath(mountpath string) ModifierOpt {
 	return func(m *Modifier) { m.MountPath = mountpath }
 }
 
+// WithTokenVolumeName sets the name of the projected volume of the token
+func WithTokenVolumeName(name string) ModifierOpt {
+	return func(m *Modifier) { m.volName = name }
+}
+
+// WithTokenFileName sets the file name of the token in its volume
+func WithTokenFileName(name string) ModifierOpt {
+	return func(m *Modifier) { m.tokenName = name }
+}
+
+// WithTokenExpirationJitter sets the maximum number of seconds randomly
+// subtracted from the token expiration of each pod
+func WithTokenExpirationJitter(jitter int64) ModifierOpt {
+	return func(m *Modifier) { m.tokenExpirationJitter = jitter }
+}
+
+// WithTokenWaitInitContainer sets the image of the init container injected
+// to wait until STS accepts the token of the pod, up to the timeout. No init
+// container is injected when the image is empty.
+func WithTokenWaitInitContainer(image string, timeout time.Duration) ModifierOpt {
+	return func(m *Modifier) {
+		m.tokenWaitImage = image
+		m.tokenWaitTimeout = timeout
+	}
+}
+
+// WithReinvocationMarker sets whether mutated pods are annotated with the
+// hash of their spec, so that reinvocations skip pods not changed since
+func WithReinvocationMarker(enabled bool) ModifierOpt {
+	return func(m *Modifier) { m.reinvocationMarker = enabled }
+}
+
 // WithRegion sets the modifier region
 func WithRegion(region string) ModifierOpt {
 	return func(m *Modifier) { m.Region = region }
@@ -78,19 +201,139 @@ func WithAnnotationDomain(domain string) ModifierOpt {
 	return func(m *Modifier) { m.AnnotationDomain = domain }
 }
 
+// WithFallbackAnnotationDomains sets the annotation domains, in order of
+// precedence, with which pod annotations missing with AnnotationDomain are read
+func WithFallbackAnnotationDomains(domains ...string) ModifierOpt {
+	return func(m *Modifier) { m.fallbackAnnotationDomains = domains }
+}
+
 // WithSALookupGraceTime sets the grace time to wait for service accounts to appear in cache
 func WithSALookupGraceTime(saLookupGraceTime time.Duration) ModifierOpt {
 	return func(m *Modifier) { m.saLookupGraceTime = saLookupGraceTime }
 
 }
 
+// WithSynchronousSALookup sets whether the service account cache looks service
+// accounts up synchronously, in which case lookups are bounded by the grace
+// time instead of waiting to be notified of service accounts missing from cache
+func WithSynchronousSALookup(synchronous bool) ModifierOpt {
+	return func(m *Modifier) { m.synchronousSALookup = synchronous }
+}
+
+// WithFailOnMissingServiceAccount sets whether pods are denied when their service account is not found in cache
+func WithFailOnMissingServiceAccount(failOnMissingServiceAccount bool) ModifierOpt {
+	return func(m *Modifier) { m.failOnMissingServiceAccount = failOnMissingServiceAccount }
+}
+
+// WithSkipInitContainers sets whether initContainers are skipped, unless overridden by pod annotation
+func WithSkipInitContainers(skipInitContainers bool) ModifierOpt {
+	return func(m *Modifier) { m.skipInitContainers = skipInitContainers }
+}
+
+// WithNativeSidecars sets how initContainers with restartPolicy Always are
+// treated: like containers or like the other initContainers
+func WithNativeSidecars(mode string) ModifierOpt {
+	return func(m *Modifier) { m.nativeSidecars = mode }
+}
+
+// WithVersion sets the webhook version recorded in audit annotations
+func WithVersion(version string) ModifierOpt {
+	return func(m *Modifier) { m.version = version }
+}
+
+// WithEventRecorder sets the recorder used to emit Events when pods can not be mutated
+func WithEventRecorder(recorder record.EventRecorder) ModifierOpt {
+	return func(m *Modifier) { m.recorder = recorder }
+}
+
+// WithMaxRequestBodyBytes sets the maximum size of admission request bodies, 0 disables the limit
+func WithMaxRequestBodyBytes(maxRequestBodyBytes int64) ModifierOpt {
+	return func(m *Modifier) { m.maxRequestBodyBytes = maxRequestBodyBytes }
+}
+
+// WithNamespaceFilter sets a filter restricting mutation to pods in namespaces it returns true for
+func WithNamespaceFilter(filter func(namespace string) bool) ModifierOpt {
+	return func(m *Modifier) { m.namespaceFilter = filter }
+}
+
+// WithCredentialMethodPrecedence sets which credential method is injected into
+// pods whose service account has both a role ARN and a container credentials
+// identity, unless overridden by service account annotation
+func WithCredentialMethodPrecedence(precedence string) ModifierOpt {
+	return func(m *Modifier) { m.credentialMethodPrecedence = precedence }
+}
+
+// WithContainerCredentialsURIMode sets which of the full and relative container
+// credentials URI env variables are injected, unless overridden by pod annotation
+func WithContainerCredentialsURIMode(mode string) ModifierOpt {
+	return func(m *Modifier) { m.containerCredentialsURIMode = mode }
+}
+
+// WithEnvVarPosition sets where the injected env variables are placed in the
+// env of containers
+func WithEnvVarPosition(position string) ModifierOpt {
+	return func(m *Modifier) { m.envVarPosition = position }
+}
+
+// WithSkipTokenVolume sets whether only the env variables are injected, without
+// the token volume and volumeMount, unless overridden by service account
+// annotation
+func WithSkipTokenVolume(skipTokenVolume bool) ModifierOpt {
+	return func(m *Modifier) { m.skipTokenVolume = skipTokenVolume }
+}
+
+// WithInjectAWSConfigFile sets whether an AWS config file with the role ARN
+// and token file is also injected for the STS web identity method
+func WithInjectAWSConfigFile(inject bool) ModifierOpt {
+	return func(m *Modifier) { m.injectAWSConfigFile = inject }
+}
+
+// WithExtraEnv sets the env variables configured by the cluster operator,
+// which are injected into every mutated container
+func WithExtraEnv(extraEnv *ExtraEnv) ModifierOpt {
+	return func(m *Modifier) { m.extraEnv = extraEnv }
+}
+
+// WithSDKUAAppID sets the template of the AWS_SDK_UA_APP_ID env variable, in
+// which {namespace} and {serviceaccount} are replaced with the namespace and
+// service account of the pod, unless overridden by service account or pod
+// annotation. An empty template injects no app ID.
+func WithSDKUAAppID(template string) ModifierOpt {
+	return func(m *Modifier) { m.sdkUAAppID = template }
+}
+
+// WithUseFIPSEndpoint sets whether AWS_USE_FIPS_ENDPOINT is injected, along with
+// AWS_STS_REGIONAL_ENDPOINTS, unless overridden by service account annotation
+func WithUseFIPSEndpoint(useFIPSEndpoint bool) ModifierOpt {
+	return func(m *Modifier) { m.useFIPSEndpoint = useFIPSEndpoint }
+}
+
+// WithRejectInvalidRoleARN sets whether the STS web identity method is left out
+// of pods whose service account has an invalid role ARN, instead of injecting
+// the ARN along with a warning
+func WithRejectInvalidRoleARN(rejectInvalidRoleARN bool) ModifierOpt {
+	return func(m *Modifier) { m.rejectInvalidRoleARN = rejectInvalidRoleARN }
+}
+
+// WithAuditLogger sets the logger recording the admission decision of every
+// pod
+func WithAuditLogger(logger *auditlog.Logger) ModifierOpt {
+	return func(m *Modifier) { m.auditLogger = logger }
+}
+
 // NewModifier returns a Modifier with default values
 func NewModifier(opts ...ModifierOpt) *Modifier {
 	mod := &Modifier{
-		AnnotationDomain: "eks.amazonaws.com",
-		MountPath:        "/var/run/secrets/eks.amazonaws.com/serviceaccount",
-		volName:          "aws-iam-token",
-		tokenName:        "token",
+		AnnotationDomain:    "eks.amazonaws.com",
+		MountPath:           "/var/run/secrets/eks.amazonaws.com/serviceaccount",
+		volName:             "aws-iam-token",
+		tokenName:           "token",
+		maxRequestBodyBytes: DefaultMaxRequestBodyBytes,
+
+		credentialMethodPrecedence:  CredentialMethodPrecedenceContainerCredentials,
+		containerCredentialsURIMode: ContainerCredentialsURIModeFull,
+		envVarPosition:              EnvVarPositionAppend,
+		nativeSidecars:              NativeSidecarsContainer,
 	}
 	for _, opt := range opts {
 		opt(mod)
@@ -101,14 +344,54 @@ func NewModifier(opts ...ModifierOpt) *Modifier {
 
 // Modifier holds configuration values for pod modifications
 type Modifier struct {
-	AnnotationDomain           string
-	MountPath                  string
-	Region                     string
-	Cache                      cache.ServiceAccountCache
-	ContainerCredentialsConfig containercredentials.Config
-	volName                    string
-	tokenName                  string
-	saLookupGraceTime          time.Duration
+	AnnotationDomain            string
+	MountPath                   string
+	Region                      string
+	Cache                       cache.ServiceAccountCache
+	ContainerCredentialsConfig  containercredentials.Config
+	volName                     string
+	tokenName                   string
+	tokenExpirationJitter       int64
+	tokenWaitImage              string
+	tokenWaitTimeout            time.Duration
+	reinvocationMarker          bool
+	saLookupGraceTime           time.Duration
+	synchronousSALookup         bool
+	failOnMissingServiceAccount bool
+	skipInitContainers          bool
+	nativeSidecars              string
+	version                     string
+	recorder                    record.EventRecorder
+	maxRequestBodyBytes         int64
+	namespaceFilter             func(namespace string) bool
+	fallbackAnnotationDomains   []string
+	credentialMethodPrecedence  string
+	containerCredentialsURIMode string
+	envVarPosition              string
+	skipTokenVolume             bool
+	injectAWSConfigFile         bool
+	extraEnv                    *ExtraEnv
+	sdkUAAppID                  string
+	useFIPSEndpoint             bool
+	rejectInvalidRoleARN        bool
+	auditLogger                 *auditlog.Logger
+	// simulation is set on the copies of the Modifier simulating mutations,
+	// which neither record metrics nor emit Events
+	simulation bool
+}
+
+// podAnnotation returns the key and value of the pod annotation with the given
+// name, read with the first annotation domain it is set with
+func (m *Modifier) podAnnotation(pod *corev1.Pod, name string) (key, value string, ok bool) {
+	domains := append([]string{m.AnnotationDomain}, m.fallbackAnnotationDomains...)
+	return pkg.LookupAnnotation(pod.Annotations, name, domains...)
+}
+
+// podLabel returns the key and value of the pod label with the given name,
+// read with the first annotation domain it is set with
+func (m *Modifier) podLabel(pod *corev1.Pod, name string) (key, value string, ok bool) {
+	domains := append([]string{m.AnnotationDomain}, m.fallbackAnnotationDomains...)
+	return pkg.LookupAnnotation(pod.Labels, name, domains...)
 }
 
 type patchOperation struct {
@@ -118,47 +401,195 @@ type patchOperation struct {
 }
 
 type podPatchConfig struct {
-	ContainersToSkip                map[string]bool
-	TokenExpiration                 int64
-	UseRegionalSTS                  bool
+	ContainersToSkip map[string]bool
+	Warnings         []string
+	TokenExpiration  int64
+	UseRegionalSTS   bool
+	// UseFIPSEndpoint injects AWS_USE_FIPS_ENDPOINT, and the regional STS
+	// endpoints as FIPS endpoints are regional
+	UseFIPSEndpoint                 bool
 	Audience                        string
 	MountPath                       string
 	VolumeName                      string
 	TokenPath                       string
 	WebIdentityPatchConfig          *webIdentityPatchConfig
 	ContainerCredentialsPatchConfig *containercredentials.PatchConfig
+	// AdditionalPatchConfig is injected along with this one, when both
+	// credential methods are injected
+	AdditionalPatchConfig *podPatchConfig
+	// ContainerCredentialsURIMode selects the container credentials URI env
+	// variables injected
+	ContainerCredentialsURIMode string
+	// SkipTokenVolume is set when the pod projects the token itself at
+	// MountPath/TokenPath, only the env variables are injected
+	SkipTokenVolume bool
+	// SDKUAAppID is the value of AWS_SDK_UA_APP_ID, not injected when empty
+	SDKUAAppID string
+	// EnvVarPosition is where the env variables are placed in the env of
+	// containers
+	EnvVarPosition string
+	// SkipRegion is set when AWS_REGION and AWS_DEFAULT_REGION must not be
+	// injected
+	SkipRegion bool
+	// AWSConfigFilePath is the path of the injected AWS config file in the
+	// containers, empty when it is not injected
+	AWSConfigFilePath string
+	// SkipReason is set when nothing must be injected, the pod is admitted
+	// unchanged with the warnings
+	SkipReason string
+}
+
+// containerCredentialsSocketDir returns the directory of the unix domain socket
+// of the container credentials endpoint, if it is served over one
+func (p *podPatchConfig) containerCredentialsSocketDir() (string, bool) {
+	if p.ContainerCredentialsPatchConfig == nil {
+		return "", false
+	}
+	socketPath, ok := p.ContainerCredentialsPatchConfig.SocketPath()
+	if !ok {
+		return "", false
+	}
+	return path.Dir(socketPath), true
+}
+
+// patchConfigs returns the patch config and its additional patch config, if any
+func (p *podPatchConfig) patchConfigs() []*podPatchConfig {
+	if p.AdditionalPatchConfig == nil {
+		return []*podPatchConfig{p}
+	}
+	return []*podPatchConfig{p, p.AdditionalPatchConfig}
 }
 
 type webIdentityPatchConfig struct {
 	RoleArn string
 }
 
-func logContext(podName, podGenerateName, serviceAccountName, namespace string) string {
-	name := podName
-	if len(podName) == 0 {
-		name = podGenerateName
+// auditAnnotations returns the annotations recorded in the API server audit
+// log describing the identity injected into the pod. When both credential
+// methods are injected, their audiences are recorded separately.
+func (m *Modifier) auditAnnotations(patchConfig *podPatchConfig) map[string]string {
+	annotations := map[string]string{}
+	patchConfigs := patchConfig.patchConfigs()
+	if len(patchConfigs) == 1 {
+		annotations["audience"] = patchConfig.Audience
+	}
+	var methods []string
+	for _, p := range patchConfigs {
+		if p.ContainerCredentialsPatchConfig != nil {
+			methods = append(methods, "container_credentials")
+			if len(patchConfigs) > 1 {
+				annotations["container-credentials-audience"] = p.Audience
+			}
+		} else if p.WebIdentityPatchConfig != nil {
+			methods = append(methods, "sts_web_identity")
+			annotations["role-arn"] = p.WebIdentityPatchConfig.RoleArn
+			if len(patchConfigs) > 1 {
+				annotations["web-identity-audience"] = p.Audience
+			}
+		}
+	}
+	if len(methods) > 0 {
+		annotations["credential-method"] = strings.Join(methods, ",")
+	}
+	if m.version != "" {
+		annotations["webhook-version"] = m.version
+	}
+	return annotations
+}
+
+// auditRecord returns the audit log record of the admission of the pod,
+// describing the identity injected with the audit annotations of the response
+func auditRecord(req *v1beta1.AdmissionRequest, pod *corev1.Pod, response *v1beta1.AdmissionResponse, outcome, reason string) auditlog.Record {
+	record := auditlog.Record{
+		Time:                         time.Now(),
+		UID:                          string(req.UID),
+		Namespace:                    pod.Namespace,
+		Pod:                          podName(pod),
+		ServiceAccount:               serviceAccountName(pod),
+		DryRun:                       req.DryRun != nil && *req.DryRun,
+		Outcome:                      outcome,
+		Reason:                       reason,
+		CredentialMethod:             response.AuditAnnotations["credential-method"],
+		RoleARN:                      response.AuditAnnotations["role-arn"],
+		Audience:                     response.AuditAnnotations["audience"],
+		WebIdentityAudience:          response.AuditAnnotations["web-identity-audience"],
+		ContainerCredentialsAudience: response.AuditAnnotations["container-credentials-audience"],
+		Warnings:                     response.Warnings,
+	}
+	var patch []patchOperation
+	if err := json.Unmarshal(response.Patch, &patch); err == nil {
+		for _, op := range patch {
+			record.Patch = append(record.Patch, op.Op+" "+op.Path)
+		}
+	}
+	return record
+}
+
+// podName returns the name of the pod, or its generateName if the name is not
+// yet assigned.
+func podName(pod *corev1.Pod) string {
+	if len(pod.Name) == 0 {
+		return pod.GenerateName
+	}
+	return pod.Name
+}
+
+// logContext returns the structured logging key/value pairs identifying an
+// admission request and its pod, so that log entries can be correlated with
+// API server audit entries by uid.
+func logContext(uid types.UID, pod *corev1.Pod) []interface{} {
+	return append([]interface{}{"uid", uid}, podKeys(pod)...)
+}
+
+// podKeys returns the structured logging key/value pairs identifying a pod
+func podKeys(pod *corev1.Pod) []interface{} {
+	return []interface{}{
+		"pod", pod.Name,
+		"generateName", pod.GenerateName,
+		"namespace", pod.Namespace,
+		"serviceAccount", serviceAccountName(pod),
 	}
-	return fmt.Sprintf("Pod=%s, "+
-		"ServiceAccount=%s, "+
-		"Namespace=%s", name, serviceAccountName, namespace)
 }
 
-// getContainersToSkip returns the containers of a pod to skip mutating
-func getContainersToSkip(annotationDomain string, pod *corev1.Pod) map[string]bool {
+// getContainersToSkip returns the containers of a pod to skip mutating: those
+// listed in the skip-containers annotation, the initContainers when skipped,
+// unless they are native sidecars treated like containers, and, when the
+// inject-containers annotation is set, those not listed in it
+func (m *Modifier) getContainersToSkip(pod *corev1.Pod) map[string]bool {
 	skippedNames := map[string]bool{}
-	skipContainersKey := annotationDomain + "/" + pkg.SkipContainersAnnotation
-	if value, ok := pod.Annotations[skipContainersKey]; ok {
+	if _, value, ok := m.podAnnotation(pod, pkg.SkipContainersAnnotation); ok {
 		r := csv.NewReader(strings.NewReader(value))
 		// error means we don't skip any
 		podNames, err := r.Read()
 		if err != nil {
-			klog.Infof("Could not parse skip containers annotation on pod %s/%s: %v", pod.Namespace, pod.Name, err)
+			klog.InfoS("Could not parse skip containers annotation", append(podKeys(pod), "err", err)...)
 			return skippedNames
 		}
 		for _, name := range podNames {
 			skippedNames[name] = true
 		}
 	}
+	if m.shouldSkipInitContainers(pod) {
+		for _, container := range pod.Spec.InitContainers {
+			if m.containerType(container, true) == containerTypeInitContainer {
+				skippedNames[container.Name] = true
+			}
+		}
+	}
+	if _, value, ok := m.podAnnotation(pod, pkg.InjectContainersAnnotation); ok {
+		r := csv.NewReader(strings.NewReader(value))
+		// error means we inject all of them
+		injectedNames, err := r.Read()
+		if err != nil {
+			klog.InfoS("Could not parse inject containers annotation", append(podKeys(pod), "err", err)...)
+			return skippedNames
+		}
+		for _, container := range append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...) {
+			if !slices.Contains(injectedNames, container.Name) {
+				skippedNames[container.Name] = true
+			}
+		}
+	}
 	return skippedNames
 }
 
@@ -168,6 +599,7 @@ func (m *Modifier) addEnvToContainer(container *corev1.Container, tokenFilePath
 		containerCredentialsKeysDefined bool
 		regionKeyDefined                bool
 		regionalStsKeyDefined           bool
+		fipsKeyDefined                  bool
 	)
 	webIdentityKeys := map[string]string{
 		"AWS_ROLE_ARN":                "",
@@ -175,6 +607,7 @@ func (m *Modifier) addEnvToContainer(container *corev1.Container, tokenFilePath
 	}
 	containerCredentialsKeys := map[string]string{
 		pkg.AwsEnvVarContainerCredentialsFullUri:     "",
+		pkg.AwsEnvVarContainerCredentialsRelativeUri: "",
 		pkg.AwsEnvVarContainerAuthorizationTokenFile: "",
 	}
 	awsRegionKeys := map[string]string{
@@ -184,35 +617,60 @@ func (m *Modifier) addEnvToContainer(container *corev1.Container, tokenFilePath
 	stsKey := "AWS_STS_REGIONAL_ENDPOINTS"
 	for _, env := range container.Env {
 		if _, ok := webIdentityKeys[env.Name]; ok {
-			klog.V(4).Infof("Web identity env variable %s is already defined in the pod spec", env)
+			klog.V(4).InfoS("Web identity env variable is already defined in the pod spec", "container", container.Name, "env", env.Name)
 			webIdentityKeysDefined = true
 		}
 		if _, ok := containerCredentialsKeys[env.Name]; ok {
-			klog.V(4).Infof("Container credential env variable %s is already defined in the pod spec", env)
+			klog.V(4).InfoS("Container credential env variable is already defined in the pod spec", "container", container.Name, "env", env.Name)
 			containerCredentialsKeysDefined = true
 		}
 		if _, ok := awsRegionKeys[env.Name]; ok {
 			// Don't set both region keys if any region key is already set
-			klog.V(4).Infof("AWS Region env variable %s is already defined in the pod spec", env)
+			klog.V(4).InfoS("AWS Region env variable is already defined in the pod spec", "container", container.Name, "env", env.Name)
 			regionKeyDefined = true
 		}
 		if env.Name == stsKey {
-			klog.V(4).Infof("AWS STS env variable %s is already defined in the pod spec", env)
+			klog.V(4).InfoS("AWS STS env variable is already defined in the pod spec", "container", container.Name, "env", env.Name)
 			regionalStsKeyDefined = true
 		}
+		if env.Name == pkg.AwsEnvVarUseFIPSEndpoint {
+			klog.V(4).InfoS("AWS FIPS env variable is already defined in the pod spec", "container", container.Name, "env", env.Name)
+			fipsKeyDefined = true
+		}
+	}
+	if !patchConfig.UseFIPSEndpoint {
+		fipsKeyDefined = true
+	}
+	if patchConfig.SkipRegion {
+		regionKeyDefined = true
+	}
+
+	var extraEnv []corev1.EnvVar
+	if patchConfig.AWSConfigFilePath != "" && !hasEnvVar(container.Env, pkg.AwsEnvVarConfigFile) {
+		extraEnv = append(extraEnv, corev1.EnvVar{Name: pkg.AwsEnvVarConfigFile, Value: patchConfig.AWSConfigFilePath})
+	}
+	if patchConfig.SDKUAAppID != "" && !hasEnvVar(container.Env, pkg.AwsEnvVarSDKUAAppID) {
+		extraEnv = append(extraEnv, corev1.EnvVar{Name: pkg.AwsEnvVarSDKUAAppID, Value: patchConfig.SDKUAAppID})
+	}
+	for _, envVar := range m.extraEnv.Get() {
+		if hasEnvVar(container.Env, envVar.Name) || hasEnvVar(extraEnv, envVar.Name) {
+			klog.V(4).InfoS("Extra env variable is already defined in the pod spec", "container", container.Name, "env", envVar.Name)
+			continue
+		}
+		extraEnv = append(extraEnv, envVar)
 	}
 
 	if ((patchConfig.WebIdentityPatchConfig != nil && webIdentityKeysDefined) ||
 		(patchConfig.ContainerCredentialsPatchConfig != nil && containerCredentialsKeysDefined)) &&
-		regionKeyDefined && regionalStsKeyDefined {
-		klog.V(4).Infof("Container %s has necessary env variables already present", container.Name)
+		regionKeyDefined && regionalStsKeyDefined && fipsKeyDefined && len(extraEnv) == 0 {
+		klog.V(4).InfoS("Container has necessary env variables already present", "container", container.Name)
 		return false
 	}
 
 	changed := false
-	env := container.Env
+	var env []corev1.EnvVar
 
-	if !regionalStsKeyDefined && patchConfig.UseRegionalSTS {
+	if !regionalStsKeyDefined && (patchConfig.UseRegionalSTS || patchConfig.UseFIPSEndpoint) {
 		env = append(env, corev1.EnvVar{
 			Name:  stsKey,
 			Value: "regional",
@@ -220,6 +678,14 @@ func (m *Modifier) addEnvToContainer(container *corev1.Container, tokenFilePath
 		changed = true
 	}
 
+	if !fipsKeyDefined {
+		env = append(env, corev1.EnvVar{
+			Name:  pkg.AwsEnvVarUseFIPSEndpoint,
+			Value: "true",
+		})
+		changed = true
+	}
+
 	if !regionKeyDefined && m.Region != "" {
 		env = append(env, corev1.EnvVar{
 			Name:  "AWS_DEFAULT_REGION",
@@ -233,10 +699,19 @@ func (m *Modifier) addEnvToContainer(container *corev1.Container, tokenFilePath
 
 	if patchConfig.ContainerCredentialsPatchConfig != nil {
 		if !containerCredentialsKeysDefined {
-			env = append(env, corev1.EnvVar{
-				Name:  pkg.AwsEnvVarContainerCredentialsFullUri,
-				Value: patchConfig.ContainerCredentialsPatchConfig.FullUri,
-			})
+			if patchConfig.ContainerCredentialsURIMode != ContainerCredentialsURIModeRelative {
+				env = append(env, corev1.EnvVar{
+					Name:  pkg.AwsEnvVarContainerCredentialsFullUri,
+					Value: patchConfig.ContainerCredentialsPatchConfig.FullUri,
+				})
+			}
+			if patchConfig.ContainerCredentialsURIMode == ContainerCredentialsURIModeRelative ||
+				patchConfig.ContainerCredentialsURIMode == ContainerCredentialsURIModeBoth {
+				env = append(env, corev1.EnvVar{
+					Name:  pkg.AwsEnvVarContainerCredentialsRelativeUri,
+					Value: patchConfig.ContainerCredentialsPatchConfig.RelativeUri,
+				})
+			}
 			env = append(env, corev1.EnvVar{
 				Name:  pkg.AwsEnvVarContainerAuthorizationTokenFile,
 				Value: tokenFilePath,
@@ -257,9 +732,14 @@ func (m *Modifier) addEnvToContainer(container *corev1.Container, tokenFilePath
 		}
 	}
 
-	container.Env = env
+	if len(extraEnv) > 0 {
+		env = append(env, extraEnv...)
+		changed = true
+	}
+
+	container.Env = insertEnv(container.Env, env, patchConfig.EnvVarPosition)
 
-	volExists := false
+	volExists := patchConfig.SkipTokenVolume
 	for _, vol := range container.VolumeMounts {
 		if vol.Name == patchConfig.VolumeName {
 			volExists = true
@@ -274,115 +754,343 @@ func (m *Modifier) addEnvToContainer(container *corev1.Container, tokenFilePath
 		})
 		changed = true
 	}
+
+	// Mount the directory of the credentials endpoint socket, rather than the
+	// socket itself, so that the agent can re-create it
+	if socketDir, ok := patchConfig.containerCredentialsSocketDir(); ok {
+		socketVolExists := false
+		for _, vol := range container.VolumeMounts {
+			if vol.Name == containerCredentialsSocketVolumeName {
+				socketVolExists = true
+			}
+		}
+		if !socketVolExists {
+			container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+				Name:      containerCredentialsSocketVolumeName,
+				ReadOnly:  true,
+				MountPath: socketDir,
+			})
+			changed = true
+		}
+	}
 	return changed
 }
 
+// insertEnv inserts the injected env variables in env at the given position.
+// The env of the pod is copied rather than modified.
+func insertEnv(env, injected []corev1.EnvVar, position string) []corev1.EnvVar {
+	if len(injected) == 0 {
+		return env
+	}
+	index := len(env)
+	switch position {
+	case EnvVarPositionPrepend:
+		index = 0
+	case EnvVarPositionBeforeReference:
+		index = slices.IndexFunc(env, func(envVar corev1.EnvVar) bool {
+			return slices.ContainsFunc(injected, func(injectedVar corev1.EnvVar) bool {
+				return strings.Contains(envVar.Value, "$("+injectedVar.Name+")")
+			})
+		})
+		if index < 0 {
+			index = len(env)
+		}
+	}
+	return slices.Insert(slices.Clip(env), index, injected...)
+}
+
+// getEnvVarPosition returns where the env variables are placed in the env of
+// the containers of the pod, read from its annotation or defaulting to the
+// flag, and a warning if the annotation is invalid
+func (m *Modifier) getEnvVarPosition(pod *corev1.Pod) (string, []string) {
+	key, value, ok := m.podAnnotation(pod, pkg.EnvVarPositionAnnotation)
+	if !ok {
+		return m.envVarPosition, nil
+	}
+	switch value {
+	case EnvVarPositionAppend, EnvVarPositionPrepend, EnvVarPositionBeforeReference:
+		return value, nil
+	default:
+		klog.V(4).InfoS("Ignoring invalid value for annotation", append(podKeys(pod), "annotation", key, "value", value)...)
+		return m.envVarPosition, []string{fmt.Sprintf("annotation %s has invalid value %q, using %q", key, value, m.envVarPosition)}
+	}
+}
+
 // parsePodAnnotations parses the pod annotations that can influence mutation:
 // - tokenExpiration. Overrides the given service account annotation/flag-level
 // setting.
 // - containersToSkip. A Pod specific setting since certain containers within a
 // specific pod might need to be opted-out of mutation
-func (m *Modifier) parsePodAnnotations(pod *corev1.Pod, serviceAccountTokenExpiration int64) (int64, map[string]bool) {
+// Any misconfiguration found is returned as a warning for the user.
+func (m *Modifier) parsePodAnnotations(pod *corev1.Pod, serviceAccountTokenExpiration int64) (int64, map[string]bool, []string) {
+	var warnings []string
+
 	// override serviceaccount annotation/flag token expiration with pod
 	// annotation if present
 	tokenExpiration := serviceAccountTokenExpiration
-	expirationKey := m.AnnotationDomain + "/" + pkg.TokenExpirationAnnotation
-	if expirationStr, ok := pod.Annotations[expirationKey]; ok {
+	if expirationKey, expirationStr, ok := m.podAnnotation(pod, pkg.TokenExpirationAnnotation); ok {
 		if expiration, err := strconv.ParseInt(expirationStr, 10, 64); err != nil {
-			klog.V(4).Infof("Found invalid value for token expiration, using %d seconds as default: %v", serviceAccountTokenExpiration, err)
+			klog.V(4).InfoS("Ignoring invalid value for annotation", append(podKeys(pod), "annotation", expirationKey, "default", serviceAccountTokenExpiration, "err", err)...)
+			warnings = append(warnings, fmt.Sprintf("annotation %s has invalid value %q, using %d seconds", expirationKey, expirationStr, serviceAccountTokenExpiration))
 		} else {
-			tokenExpiration = pkg.ValidateMinTokenExpiration(expiration)
+			var expirationWarnings []string
+			tokenExpiration, expirationWarnings = clampTokenExpiration(expiration, tokenExpirationSourcePodAnnotation, "annotation "+expirationKey)
+			warnings = append(warnings, expirationWarnings...)
 		}
 	}
 
-	containersToSkip := getContainersToSkip(m.AnnotationDomain, pod)
+	containersToSkip := m.getContainersToSkip(pod)
 
-	return tokenExpiration, containersToSkip
+	return tokenExpiration, containersToSkip, warnings
 }
 
-// getPodSpecPatch gets the patch operation to be applied to the given Pod
-func (m *Modifier) getPodSpecPatch(pod *corev1.Pod, patchConfig *podPatchConfig) ([]patchOperation, bool) {
-	tokenFilePath := filepath.Join(patchConfig.MountPath, patchConfig.TokenPath)
+// Sources of the token expirations clamped by clampTokenExpiration
+const (
+	tokenExpirationSourcePodAnnotation        = "pod_annotation"
+	tokenExpirationSourceServiceAccount       = "service_account"
+	tokenExpirationSourceContainerCredentials = "container_credentials"
+)
 
-	betaNodeSelector, _ := pod.Spec.NodeSelector["beta.kubernetes.io/os"]
-	nodeSelector, _ := pod.Spec.NodeSelector["kubernetes.io/os"]
-	if (betaNodeSelector == "windows") || nodeSelector == "windows" {
-		// Convert the unix file path to a windows file path
-		// Eg. /var/run/secrets/eks.amazonaws.com/serviceaccount/token to
-		//     C:\var\run\secrets\eks.amazonaws.com\serviceaccount\token
-		tokenFilePath = "C:" + strings.Replace(tokenFilePath, `/`, `\`, -1)
+// clampTokenExpiration returns the token expiration within the range accepted
+// by the API server, and a warning naming the setting when it is out of range
+func clampTokenExpiration(expiration int64, source, setting string) (int64, []string) {
+	clamped := pkg.ValidateTokenExpiration(expiration)
+	if clamped == expiration {
+		return expiration, nil
+	}
+	tokenExpirationClampedCounter.WithLabelValues(source).Inc()
+	bound := "below the minimum"
+	if expiration > clamped {
+		bound = "above the maximum"
+	}
+	return clamped, []string{fmt.Sprintf("%s value %d is %s, using %d seconds", setting, expiration, bound, clamped)}
+}
+
+// serviceAccountTokenExpirationSetting names the setting the token expiration
+// of the service account was read from in warnings
+func (m *Modifier) serviceAccountTokenExpirationSetting(request cache.Request, source string) string {
+	if source == cache.SourceConfigMap {
+		return fmt.Sprintf("config map entry %s tokenExpiration", request.CacheKey())
+	}
+	return fmt.Sprintf("service account %s annotation %s/%s", request.CacheKey(), m.AnnotationDomain, pkg.TokenExpirationAnnotation)
+}
+
+// jitterTokenExpiration returns the token expiration of a pod, reduced by a
+// random number of seconds up to the jitter so that the tokens of pods created
+// together are not all refreshed at the same time, but not below the minimum
+func (m *Modifier) jitterTokenExpiration(tokenExpiration int64) int64 {
+	if m.tokenExpirationJitter <= 0 {
+		return tokenExpiration
+	}
+	return pkg.ValidateMinTokenExpiration(tokenExpiration - rand.Int63n(m.tokenExpirationJitter+1))
+}
+
+// conflictingEnvWarnings returns a warning for every container that already
+// defines the credential env variables with a value different from the one the
+// webhook would inject, as the existing value is kept.
+func conflictingEnvWarnings(pod *corev1.Pod, patchConfig *podPatchConfig) []string {
+	var warnings []string
+	for _, p := range patchConfig.patchConfigs() {
+		var name, value string
+		switch {
+		case p.ContainerCredentialsPatchConfig != nil:
+			name, value = pkg.AwsEnvVarContainerCredentialsFullUri, p.ContainerCredentialsPatchConfig.FullUri
+		case p.WebIdentityPatchConfig != nil:
+			name, value = "AWS_ROLE_ARN", p.WebIdentityPatchConfig.RoleArn
+		default:
+			continue
+		}
+
+		containers := append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+		for _, container := range containers {
+			if patchConfig.ContainersToSkip[container.Name] {
+				continue
+			}
+			for _, env := range container.Env {
+				if env.Name == name && env.Value != value {
+					warnings = append(warnings, fmt.Sprintf("container %s already sets %s to %q, the webhook did not inject %q", container.Name, name, env.Value, value))
+				}
+			}
+		}
 	}
+	return warnings
+}
+
+// shouldSkipPod returns whether the pod opted out of mutation with the
+// skip-pod-identity annotation
+func (m *Modifier) shouldSkipPod(pod *corev1.Pod) bool {
+	skipKey, skipStr, ok := m.podAnnotation(pod, pkg.SkipPodIdentityAnnotation)
+	if !ok {
+		return false
+	}
+	skip, err := strconv.ParseBool(skipStr)
+	if err != nil {
+		klog.V(4).InfoS("Ignoring invalid value for annotation", append(podKeys(pod), "annotation", skipKey, "err", err)...)
+		return false
+	}
+	return skip
+}
 
+// containerType returns whether the container, found in the initContainers of
+// the pod or not, is treated as a container, an initContainer or a native
+// sidecar
+func (m *Modifier) containerType(container corev1.Container, initContainer bool) string {
+	if !initContainer {
+		return containerTypeContainer
+	}
+	if m.nativeSidecars == NativeSidecarsContainer && container.RestartPolicy != nil && *container.RestartPolicy == corev1.ContainerRestartPolicyAlways {
+		return containerTypeSidecar
+	}
+	return containerTypeInitContainer
+}
+
+// shouldSkipInitContainers returns whether the initContainers of the pod are
+// not mutated. The pod annotation overrides the flag.
+func (m *Modifier) shouldSkipInitContainers(pod *corev1.Pod) bool {
+	if skipKey, skipStr, ok := m.podAnnotation(pod, pkg.SkipInitContainersAnnotation); ok {
+		skip, err := strconv.ParseBool(skipStr)
+		if err != nil {
+			klog.V(4).InfoS("Ignoring invalid value for annotation", append(podKeys(pod), "annotation", skipKey, "err", err)...)
+		} else {
+			return skip
+		}
+	}
+	return m.skipInitContainers
+}
+
+// shouldFailOnMissingServiceAccount returns whether the pod must be denied when
+// its service account is not found. The pod annotation overrides the flag.
+func (m *Modifier) shouldFailOnMissingServiceAccount(pod *corev1.Pod) bool {
+	if failKey, failStr, ok := m.podAnnotation(pod, pkg.FailOnMissingServiceAccountAnnotation); ok {
+		fail, err := strconv.ParseBool(failStr)
+		if err != nil {
+			klog.V(4).InfoS("Ignoring invalid value for annotation", append(podKeys(pod), "annotation", failKey, "err", err)...)
+		} else {
+			return fail
+		}
+	}
+	return m.failOnMissingServiceAccount
+}
+
+// getPodSpecPatch gets the patch operation to be applied to the given Pod
+func (m *Modifier) getPodSpecPatch(pod *corev1.Pod, patchConfig *podPatchConfig) ([]patchOperation, bool) {
 	var changed bool
 
 	var initContainers = []corev1.Container{}
 	for i := range pod.Spec.InitContainers {
 		container := pod.Spec.InitContainers[i]
 		if _, ok := patchConfig.ContainersToSkip[container.Name]; ok {
-			klog.V(4).Infof("Container %s was annotated to be skipped", container.Name)
-		} else if m.addEnvToContainer(&container, tokenFilePath, patchConfig) {
+			klog.V(4).InfoS("Container was annotated to be skipped", append(podKeys(pod), "container", container.Name)...)
+		} else if m.addEnvToContainerForEachPatchConfig(pod, &container, patchConfig) {
+			m.countContainer(m.containerType(container, true))
 			changed = true
 		}
 		initContainers = append(initContainers, container)
 	}
+	if container, ok := m.tokenWaitContainer(pod, patchConfig); ok {
+		initContainers = append([]corev1.Container{container}, initContainers...)
+		changed = true
+	}
 
 	var containers = []corev1.Container{}
 	for i := range pod.Spec.Containers {
 		container := pod.Spec.Containers[i]
 		if _, ok := patchConfig.ContainersToSkip[container.Name]; ok {
-			klog.V(4).Infof("Container %s was annotated to be skipped", container.Name)
-		} else if m.addEnvToContainer(&container, tokenFilePath, patchConfig) {
+			klog.V(4).InfoS("Container was annotated to be skipped", append(podKeys(pod), "container", container.Name)...)
+		} else if m.addEnvToContainerForEachPatchConfig(pod, &container, patchConfig) {
+			m.countContainer(containerTypeContainer)
 			changed = true
 		}
 		containers = append(containers, container)
 	}
 
-	volume := corev1.Volume{
-		Name: patchConfig.VolumeName,
-		VolumeSource: corev1.VolumeSource{
-			Projected: &corev1.ProjectedVolumeSource{
-				Sources: []corev1.VolumeProjection{
-					{
-						ServiceAccountToken: &corev1.ServiceAccountTokenProjection{
-							Audience:          patchConfig.Audience,
-							ExpirationSeconds: &patchConfig.TokenExpiration,
-							Path:              patchConfig.TokenPath,
-						},
+	var volumes []corev1.Volume
+	// annotations are the pod annotations added along with the volumes
+	annotations := map[string]string{}
+	for _, p := range patchConfig.patchConfigs() {
+		// skip adding volumes if they already exist
+		if !p.SkipTokenVolume && !podHasVolume(pod, p.VolumeName) {
+			sources := []corev1.VolumeProjection{
+				{
+					ServiceAccountToken: &corev1.ServiceAccountTokenProjection{
+						Audience:          p.Audience,
+						ExpirationSeconds: &p.TokenExpiration,
+						Path:              p.TokenPath,
 					},
 				},
-			},
-		},
+			}
+			// The AWS config file is written in a pod annotation, projected
+			// in the token volume with the downward API
+			if p.AWSConfigFilePath != "" && p.WebIdentityPatchConfig != nil {
+				key := m.AnnotationDomain + "/" + pkg.AWSConfigAnnotation
+				annotations[key] = awsConfig(p.WebIdentityPatchConfig.RoleArn, tokenFilePath(pod, p))
+				sources = append(sources, corev1.VolumeProjection{
+					DownwardAPI: &corev1.DownwardAPIProjection{
+						Items: []corev1.DownwardAPIVolumeFile{{
+							Path:     awsConfigFileName,
+							FieldRef: &corev1.ObjectFieldSelector{FieldPath: fmt.Sprintf("metadata.annotations['%s']", key)},
+						}},
+					},
+				})
+			}
+			volumes = append(volumes, corev1.Volume{
+				Name: p.VolumeName,
+				VolumeSource: corev1.VolumeSource{
+					Projected: &corev1.ProjectedVolumeSource{Sources: sources},
+				},
+			})
+		}
+		if socketDir, ok := p.containerCredentialsSocketDir(); ok && !podHasVolume(pod, containerCredentialsSocketVolumeName) {
+			volumes = append(volumes, corev1.Volume{
+				Name: containerCredentialsSocketVolumeName,
+				VolumeSource: corev1.VolumeSource{
+					HostPath: &corev1.HostPathVolumeSource{Path: socketDir},
+				},
+			})
+		}
 	}
 
 	patch := []patchOperation{}
 
-	// skip adding volume if it already exists
-	volExists := false
-	for _, vol := range pod.Spec.Volumes {
-		if vol.Name == patchConfig.VolumeName {
-			volExists = true
+	if pod.Spec.Volumes == nil && len(volumes) > 0 {
+		patch = append(patch, patchOperation{
+			Op:    "add",
+			Path:  "/spec/volumes",
+			Value: volumes,
+		})
+		changed = true
+	} else {
+		for _, volume := range volumes {
+			patch = append(patch, patchOperation{
+				Op:    "add",
+				Path:  "/spec/volumes/0",
+				Value: volume,
+			})
+			changed = true
 		}
 	}
 
-	if !volExists {
-		volPatch := patchOperation{
-			Op:    "add",
-			Path:  "/spec/volumes/0",
-			Value: volume,
-		}
+	if m.reinvocationMarker && changed {
+		mutated := pod.Spec.DeepCopy()
+		mutated.InitContainers, mutated.Containers = initContainers, containers
+		mutated.Volumes = slices.Concat(volumes, pod.Spec.Volumes)
+		annotations[m.AnnotationDomain+"/"+pkg.InjectedAnnotation] = injectionHash(mutated)
+	}
 
-		if pod.Spec.Volumes == nil {
-			volPatch = patchOperation{
-				Op:   "add",
-				Path: "/spec/volumes",
-				Value: []corev1.Volume{
-					volume,
-				},
-			}
+	if pod.Annotations == nil && len(annotations) > 0 {
+		patch = append(patch, patchOperation{
+			Op:    "add",
+			Path:  "/metadata/annotations",
+			Value: annotations,
+		})
+	} else {
+		for _, key := range slices.Sorted(maps.Keys(annotations)) {
+			patch = append(patch, patchOperation{
+				Op:    "add",
+				Path:  "/metadata/annotations/" + jsonPointerEscaper.Replace(key),
+				Value: annotations[key],
+			})
 		}
-
-		patch = append(patch, volPatch)
-		changed = true
 	}
 
 	patch = append(patch, patchOperation{
@@ -401,6 +1109,149 @@ func (m *Modifier) getPodSpecPatch(pod *corev1.Pod, patchConfig *podPatchConfig)
 	return patch, changed
 }
 
+// injectionHash returns the hash of the names of the volumes, containers, and
+// env variables and volume mounts of the containers of the pod spec, written
+// in the reinvocation marker annotation. Names are not changed by the defaults
+// the API server sets after each mutation.
+func injectionHash(spec *corev1.PodSpec) string {
+	hash := sha256.New()
+	volumes := make([]string, 0, len(spec.Volumes))
+	for _, volume := range spec.Volumes {
+		volumes = append(volumes, volume.Name)
+	}
+	slices.Sort(volumes)
+	fmt.Fprintf(hash, "volumes:%s\n", strings.Join(volumes, ","))
+	for _, containers := range [][]corev1.Container{spec.InitContainers, spec.Containers} {
+		for _, container := range containers {
+			fmt.Fprintf(hash, "container:%s\n", container.Name)
+			for _, env := range container.Env {
+				fmt.Fprintf(hash, "env:%s\n", env.Name)
+			}
+			for _, mount := range container.VolumeMounts {
+				fmt.Fprintf(hash, "mount:%s:%s\n", mount.Name, mount.MountPath)
+			}
+		}
+		fmt.Fprintln(hash, "---")
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+// jsonPointerEscaper escapes a map key in the path of a patch operation
+var jsonPointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// awsConfig returns the content of the AWS config file of the STS web identity
+// method, read by SDKs and tools ignoring the env variables
+func awsConfig(roleARN, tokenFilePath string) string {
+	return fmt.Sprintf("[default]\nrole_arn = %s\nweb_identity_token_file = %s\n", roleARN, tokenFilePath)
+}
+
+// tokenWaitContainer returns the init container waiting until STS accepts the
+// token of the pod, run before the other containers, when it is enabled and
+// the pod gets a role ARN. Windows pods do not get it.
+func (m *Modifier) tokenWaitContainer(pod *corev1.Pod, patchConfig *podPatchConfig) (corev1.Container, bool) {
+	if m.tokenWaitImage == "" || isWindowsPod(pod) {
+		return corev1.Container{}, false
+	}
+	if !slices.ContainsFunc(patchConfig.patchConfigs(), func(p *podPatchConfig) bool { return p.WebIdentityPatchConfig != nil }) {
+		return corev1.Container{}, false
+	}
+	if slices.ContainsFunc(pod.Spec.InitContainers, func(container corev1.Container) bool { return container.Name == tokenWaitContainerName }) {
+		klog.V(4).InfoS("Token wait init container is already defined in the pod spec", podKeys(pod)...)
+		return corev1.Container{}, false
+	}
+	container := corev1.Container{
+		Name:    tokenWaitContainerName,
+		Image:   m.tokenWaitImage,
+		Command: []string{"/webhook", "wait-for-token", fmt.Sprintf("--timeout=%s", m.tokenWaitTimeout)},
+	}
+	m.addEnvToContainerForEachPatchConfig(pod, &container, patchConfig)
+	return container, true
+}
+
+// podHasVolume returns true if the pod has a volume with the given name
+func podHasVolume(pod *corev1.Pod, name string) bool {
+	for _, vol := range pod.Spec.Volumes {
+		if vol.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// addEnvToContainerForEachPatchConfig adds the env variables and volume mount
+// of the patch config and its additional patch config, if any, to the container
+func (m *Modifier) addEnvToContainerForEachPatchConfig(pod *corev1.Pod, container *corev1.Container, patchConfig *podPatchConfig) bool {
+	var changed bool
+	for _, p := range patchConfig.patchConfigs() {
+		if m.addEnvToContainer(container, tokenFilePath(pod, p), p) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// tokenFilePath returns the path of the token file of the patch config in the
+// containers of the pod
+func tokenFilePath(pod *corev1.Pod, patchConfig *podPatchConfig) string {
+	return podFilePath(pod, filepath.Join(patchConfig.MountPath, patchConfig.TokenPath))
+}
+
+// podFilePath returns the given unix file path as seen by the containers of
+// the pod
+func podFilePath(pod *corev1.Pod, path string) string {
+	if isWindowsPod(pod) {
+		// Convert the unix file path to a windows file path
+		// Eg. /var/run/secrets/eks.amazonaws.com/serviceaccount/token to
+		//     C:\var\run\secrets\eks.amazonaws.com\serviceaccount\token
+		path = "C:" + strings.Replace(path, `/`, `\`, -1)
+	}
+	return path
+}
+
+// osLabels are the node labels pods select Windows nodes with
+var osLabels = []string{"kubernetes.io/os", "beta.kubernetes.io/os"}
+
+// isWindowsPod returns whether the pod runs on Windows nodes, as set by its OS
+// or, for pods not setting it, by its node selector or required node affinity
+func isWindowsPod(pod *corev1.Pod) bool {
+	if pod.Spec.OS != nil {
+		return pod.Spec.OS.Name == corev1.Windows
+	}
+	for _, label := range osLabels {
+		if pod.Spec.NodeSelector[label] == string(corev1.Windows) {
+			return true
+		}
+	}
+	if pod.Spec.Affinity == nil || pod.Spec.Affinity.NodeAffinity == nil || pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return false
+	}
+	// The pod only runs on Windows nodes if every term, any of which a node
+	// must match, requires them
+	terms := pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	if len(terms) == 0 {
+		return false
+	}
+	for _, term := range terms {
+		if !slices.ContainsFunc(term.MatchExpressions, func(requirement corev1.NodeSelectorRequirement) bool {
+			return slices.Contains(osLabels, requirement.Key) && requirement.Operator == corev1.NodeSelectorOpIn &&
+				len(requirement.Values) == 1 && requirement.Values[0] == string(corev1.Windows)
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+// serviceAccountName returns the name of the service account of the pod, which
+// defaults to "default" like the ServiceAccount admission plugin sets it, for
+// API servers not running the plugin before webhooks
+func serviceAccountName(pod *corev1.Pod) string {
+	if pod.Spec.ServiceAccountName == "" {
+		return "default"
+	}
+	return pod.Spec.ServiceAccountName
+}
+
 // buildPodPatchConfig reads configurations from multiples data sources and builds a merged podPatchConfig.
 // Data sources include: Cache, ContainerCredentialsConfig, and pod's annotations.
 //
@@ -408,188 +1259,670 @@ func (m *Modifier) getPodSpecPatch(pod *corev1.Pod, patchConfig *podPatchConfig)
 // annotations. The serviceaccount cache already parsed the serviceaccount
 // annotations and flags such that annotations take precedence.
 // audience:        serviceaccount annotation > flag
-// regionalSTS:     serviceaccount annotation > flag
-// tokenExpiration: pod annotation > serviceaccount annotation > flag
-func (m *Modifier) buildPodPatchConfig(pod *corev1.Pod) *podPatchConfig {
-	// Container credentials method takes precedence
-	containerCredentialsPatchConfig := m.ContainerCredentialsConfig.Get(pod.Namespace, pod.Spec.ServiceAccountName)
+// regionalSTS:     pod annotation > serviceaccount annotation > flag
+// tokenExpiration: pod annotation > container credentials identity > container credentials flag > serviceaccount annotation > flag
+// precedence:      serviceaccount annotation > flag
+// skipTokenVolume: serviceaccount annotation > flag
+// useFIPSEndpoint: serviceaccount annotation > flag (STS web identity method only)
+func (m *Modifier) buildPodPatchConfig(ctx context.Context, pod *corev1.Pod) (*podPatchConfig, error) {
+	// Caches fetching service accounts synchronously must not hold the
+	// admission past the grace period
+	if m.saLookupGraceTime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.saLookupGraceTime)
+		defer cancel()
+	}
+	// Container credentials method takes precedence, unless the service
+	// account also has a role ARN and the credential method precedence says
+	// otherwise
+	containerCredentialsPatchConfig := m.ContainerCredentialsConfig.Get(pod.Namespace, serviceAccountName(pod))
 	if containerCredentialsPatchConfig != nil {
-		regionalSTS, tokenExpiration := m.Cache.GetCommonConfigurations(pod.Spec.ServiceAccountName, pod.Namespace)
-		tokenExpiration, containersToSkip := m.parsePodAnnotations(pod, tokenExpiration)
-
-		webhookPodCount.WithLabelValues("container_credentials").Inc()
+		request := cache.Request{Namespace: pod.Namespace, Name: serviceAccountName(pod), RequestNotification: false, Context: ctx}
+		response := m.Cache.Get(request)
+		if response.RoleARN == "" {
+			m.countPod("container_credentials")
+			return m.containerCredentialsPodPatchConfig(pod, request, response, containerCredentialsPatchConfig), nil
+		}
 
-		return &podPatchConfig{
-			ContainersToSkip:                containersToSkip,
-			TokenExpiration:                 tokenExpiration,
-			UseRegionalSTS:                  regionalSTS,
-			Audience:                        containerCredentialsPatchConfig.Audience,
-			MountPath:                       containerCredentialsPatchConfig.MountPath,
-			VolumeName:                      containerCredentialsPatchConfig.VolumeName,
-			TokenPath:                       containerCredentialsPatchConfig.TokenPath,
-			WebIdentityPatchConfig:          nil,
-			ContainerCredentialsPatchConfig: containerCredentialsPatchConfig,
+		precedence, precedenceWarnings := m.getCredentialMethodPrecedence(request, response)
+		var patchConfig *podPatchConfig
+		switch precedence {
+		case CredentialMethodPrecedenceSTSWebIdentity:
+			patchConfig = m.webIdentityPodPatchConfig(pod, request, response)
+			if patchConfig.SkipReason == "" {
+				m.countPod("sts_web_identity")
+				break
+			}
+			// The role ARN was rejected, fall back to container credentials
+			skippedWarnings := patchConfig.Warnings
+			patchConfig = m.containerCredentialsPodPatchConfig(pod, request, response, containerCredentialsPatchConfig)
+			for _, warning := range skippedWarnings {
+				if !slices.Contains(patchConfig.Warnings, warning) {
+					patchConfig.Warnings = append(patchConfig.Warnings, warning)
+				}
+			}
+			m.countPod("container_credentials")
+		case CredentialMethodPrecedenceBoth:
+			patchConfig = m.containerCredentialsPodPatchConfig(pod, request, response, containerCredentialsPatchConfig)
+			additionalPatchConfig := m.webIdentityPodPatchConfig(pod, request, response)
+			// Both configs parsed the same pod annotations
+			for _, warning := range additionalPatchConfig.Warnings {
+				if !slices.Contains(patchConfig.Warnings, warning) {
+					patchConfig.Warnings = append(patchConfig.Warnings, warning)
+				}
+			}
+			m.countPod("container_credentials")
+			if additionalPatchConfig.SkipReason == "" {
+				patchConfig.AdditionalPatchConfig = additionalPatchConfig
+				m.countPod("sts_web_identity")
+			}
+		default:
+			patchConfig = m.containerCredentialsPodPatchConfig(pod, request, response, containerCredentialsPatchConfig)
+			m.countPod("container_credentials")
 		}
+		patchConfig.Warnings = append(patchConfig.Warnings, precedenceWarnings...)
+		return patchConfig, nil
 	}
 
 	// Use the STS WebIdentity method if set
-	gracePeriodEnabled := m.saLookupGraceTime > 0
-	request := cache.Request{Namespace: pod.Namespace, Name: pod.Spec.ServiceAccountName, RequestNotification: gracePeriodEnabled}
+	gracePeriodEnabled := m.saLookupGraceTime > 0 && !m.synchronousSALookup
+	request := cache.Request{Namespace: pod.Namespace, Name: serviceAccountName(pod), RequestNotification: gracePeriodEnabled, Context: ctx}
 	response := m.Cache.Get(request)
 	if !response.FoundInCache && !gracePeriodEnabled {
-		missingSACounter.WithLabelValues().Inc()
+		m.countMissingServiceAccount()
+		m.recordServiceAccountEvent(pod.Namespace, serviceAccountName(pod), reasonServiceAccountNotFound,
+			"Service account %s not found in the cache, pod %s was not mutated", request.CacheKey(), podName(pod))
+		if err := m.missingServiceAccountError(pod, request); err != nil {
+			return nil, err
+		}
 	}
 	if !response.FoundInCache && gracePeriodEnabled {
-		klog.Warningf("Service account %s not found in the cache. Waiting up to %s to be notified", request.CacheKey(), m.saLookupGraceTime)
+		klog.InfoS("Service account not found in the cache, waiting to be notified", append(podKeys(pod), "gracePeriod", m.saLookupGraceTime)...)
+		waitStart := time.Now()
 		select {
 		case <-response.Notifier:
-			request = cache.Request{Namespace: pod.Namespace, Name: pod.Spec.ServiceAccountName, RequestNotification: false}
+			request = cache.Request{Namespace: pod.Namespace, Name: serviceAccountName(pod), RequestNotification: false, Context: ctx}
 			response = m.Cache.Get(request)
 			if !response.FoundInCache {
-				klog.Warningf("Service account %s not found in the cache after being notified. Not mutating.", request.CacheKey())
-				missingSACounter.WithLabelValues().Inc()
-				return nil
+				monitorSALookupWait("not_found", waitStart)
+				klog.InfoS("Service account not found in the cache after being notified, not mutating", podKeys(pod)...)
+				m.countMissingServiceAccount()
+				m.recordServiceAccountEvent(pod.Namespace, serviceAccountName(pod), reasonServiceAccountNotFound,
+					"Service account %s not found in the cache, pod %s was not mutated", request.CacheKey(), podName(pod))
+				return nil, m.missingServiceAccountError(pod, request)
 			}
+			monitorSALookupWait("found", waitStart)
 		case <-time.After(m.saLookupGraceTime):
-			klog.Warningf("Service account %s not found in the cache after %s. Not mutating.", request.CacheKey(), m.saLookupGraceTime)
-			missingSACounter.WithLabelValues().Inc()
-			return nil
+			monitorSALookupWait("timeout", waitStart)
+			klog.InfoS("Service account not found in the cache after the grace period, not mutating", append(podKeys(pod), "gracePeriod", m.saLookupGraceTime)...)
+			m.countMissingServiceAccount()
+			m.recordServiceAccountEvent(pod.Namespace, serviceAccountName(pod), reasonServiceAccountLookupTimeout,
+				"Service account %s not found in the cache after %s, pod %s was not mutated", request.CacheKey(), m.saLookupGraceTime, podName(pod))
+			return nil, m.missingServiceAccountError(pod, request)
 		}
 	}
-	klog.V(5).Infof("Value of roleArn after after cache retrieval for service account %s: %s", request.CacheKey(), response.RoleARN)
+	klog.V(5).InfoS("Retrieved service account from the cache", append(podKeys(pod), "roleARN", response.RoleARN)...)
 	if response.RoleARN != "" {
-		tokenExpiration, containersToSkip := m.parsePodAnnotations(pod, response.TokenExpiration)
+		patchConfig := m.webIdentityPodPatchConfig(pod, request, response)
+		if patchConfig.SkipReason == "" {
+			m.countPod("sts_web_identity")
+		}
+		return patchConfig, nil
+	}
+
+	// No mutations needed
+	return nil, nil
+}
+
+// countPod counts a pod mutated with the credential method
+func (m *Modifier) countPod(method string) {
+	if !m.simulation {
+		webhookPodCount.WithLabelValues(method).Inc()
+	}
+}
+
+// countContainer counts a container mutated by the webhook, by container type
+func (m *Modifier) countContainer(containerType string) {
+	if !m.simulation {
+		mutatedContainerCount.WithLabelValues(containerType).Inc()
+	}
+}
+
+// countMissingServiceAccount counts a pod whose service account was not found
+func (m *Modifier) countMissingServiceAccount() {
+	if !m.simulation {
+		missingSACounter.WithLabelValues().Inc()
+	}
+}
 
-		webhookPodCount.WithLabelValues("sts_web_identity").Inc()
+// containerCredentialsPodPatchConfig builds the podPatchConfig of the
+// container credentials method for the service account of the request
+func (m *Modifier) containerCredentialsPodPatchConfig(pod *corev1.Pod, request cache.Request, response cache.Response, containerCredentialsPatchConfig *containercredentials.PatchConfig) *podPatchConfig {
+	regionalSTS, tokenExpiration := m.Cache.GetCommonConfigurations(serviceAccountName(pod), pod.Namespace)
+	var expirationWarnings []string
+	if containerCredentialsPatchConfig.TokenExpiration != 0 {
+		tokenExpiration, expirationWarnings = clampTokenExpiration(containerCredentialsPatchConfig.TokenExpiration, tokenExpirationSourceContainerCredentials,
+			fmt.Sprintf("container credentials identity %s/%s tokenExpiration", pod.Namespace, serviceAccountName(pod)))
+	} else {
+		tokenExpiration, expirationWarnings = clampTokenExpiration(tokenExpiration, tokenExpirationSourceServiceAccount, m.serviceAccountTokenExpirationSetting(request, ""))
+	}
+	tokenExpiration, containersToSkip, warnings := m.parsePodAnnotations(pod, tokenExpiration)
+	warnings = append(expirationWarnings, warnings...)
+
+	uriMode := m.containerCredentialsURIMode
+	if uriModeKey, uriModeStr, ok := m.podAnnotation(pod, pkg.ContainerCredentialsURIModeAnnotation); ok {
+		switch uriModeStr {
+		case ContainerCredentialsURIModeFull, ContainerCredentialsURIModeRelative, ContainerCredentialsURIModeBoth:
+			uriMode = uriModeStr
+		default:
+			klog.V(4).InfoS("Ignoring invalid value for annotation", append(podKeys(pod), "annotation", uriModeKey, "value", uriModeStr)...)
+			warnings = append(warnings, fmt.Sprintf("annotation %s has invalid value %q, using %q", uriModeKey, uriModeStr, uriMode))
+		}
+	}
+
+	appID, appIDWarnings := m.getSDKUAAppID(pod, "")
+	warnings = append(warnings, appIDWarnings...)
+	envVarPosition, envVarPositionWarnings := m.getEnvVarPosition(pod)
+	warnings = append(warnings, envVarPositionWarnings...)
+	skipRegion, skipRegionWarnings := m.getSkipRegion(pod, request, "")
+	warnings = append(warnings, skipRegionWarnings...)
+	regionalSTS, regionalSTSWarnings := m.getUseRegionalSTS(pod, regionalSTS)
+	warnings = append(warnings, regionalSTSWarnings...)
+	skipTokenVolume, skipTokenVolumeWarnings := m.serviceAccountBool(request, pkg.SkipTokenVolumeAnnotation, response.SkipTokenVolume, m.skipTokenVolume)
+	warnings = append(warnings, skipTokenVolumeWarnings...)
+
+	return &podPatchConfig{
+		ContainersToSkip:                containersToSkip,
+		Warnings:                        warnings,
+		TokenExpiration:                 m.jitterTokenExpiration(tokenExpiration),
+		UseRegionalSTS:                  regionalSTS,
+		UseFIPSEndpoint:                 m.useFIPSEndpoint,
+		Audience:                        containerCredentialsPatchConfig.Audience,
+		MountPath:                       containerCredentialsPatchConfig.MountPath,
+		VolumeName:                      containerCredentialsPatchConfig.VolumeName,
+		TokenPath:                       containerCredentialsPatchConfig.TokenPath,
+		WebIdentityPatchConfig:          nil,
+		ContainerCredentialsPatchConfig: containerCredentialsPatchConfig,
+		ContainerCredentialsURIMode:     uriMode,
+		SkipTokenVolume:                 skipTokenVolume,
+		SDKUAAppID:                      appID,
+		EnvVarPosition:                  envVarPosition,
+		SkipRegion:                      skipRegion,
+	}
+}
 
-		return &podPatchConfig{
-			ContainersToSkip:                containersToSkip,
-			TokenExpiration:                 tokenExpiration,
-			UseRegionalSTS:                  response.UseRegionalSTS,
-			Audience:                        response.Audience,
-			MountPath:                       m.MountPath,
-			VolumeName:                      m.volName,
-			TokenPath:                       m.tokenName,
-			WebIdentityPatchConfig:          &webIdentityPatchConfig{RoleArn: response.RoleARN},
-			ContainerCredentialsPatchConfig: nil,
+// webIdentityPodPatchConfig builds the podPatchConfig of the STS web identity
+// method for a service account found with a role ARN. If the role ARN is
+// invalid and must be rejected, the podPatchConfig only has warnings and a
+// SkipReason.
+func (m *Modifier) webIdentityPodPatchConfig(pod *corev1.Pod, request cache.Request, response cache.Response) *podPatchConfig {
+	tokenExpiration, expirationWarnings := clampTokenExpiration(response.TokenExpiration, tokenExpirationSourceServiceAccount, m.serviceAccountTokenExpirationSetting(request, response.Source))
+	tokenExpiration, containersToSkip, warnings := m.parsePodAnnotations(pod, tokenExpiration)
+	warnings = append(expirationWarnings, warnings...)
+	if !pkg.ValidateRoleARN(response.RoleARN) {
+		if m.rejectInvalidRoleARN {
+			m.recordServiceAccountEvent(pod.Namespace, serviceAccountName(pod), reasonInvalidRoleARN,
+				"Service account %s has invalid role ARN %q, it was not injected into pod %s", request.CacheKey(), response.RoleARN, podName(pod))
+			return &podPatchConfig{
+				Warnings:   append(warnings, fmt.Sprintf("service account %s has invalid role ARN %q, the webhook did not inject it", request.CacheKey(), response.RoleARN)),
+				SkipReason: "Service account has an invalid role ARN",
+			}
 		}
+		warnings = append(warnings, fmt.Sprintf("service account %s has invalid role ARN %q", request.CacheKey(), response.RoleARN))
+		m.recordServiceAccountEvent(pod.Namespace, serviceAccountName(pod), reasonInvalidRoleARN,
+			"Service account %s has invalid role ARN %q, pod %s may fail to assume it", request.CacheKey(), response.RoleARN, podName(pod))
 	}
+	skipTokenVolume, skipTokenVolumeWarnings := m.serviceAccountBool(request, pkg.SkipTokenVolumeAnnotation, response.SkipTokenVolume, m.skipTokenVolume)
+	warnings = append(warnings, skipTokenVolumeWarnings...)
+	appID, appIDWarnings := m.getSDKUAAppID(pod, response.SDKUAAppID)
+	warnings = append(warnings, appIDWarnings...)
+	useFIPSEndpoint, useFIPSEndpointWarnings := m.serviceAccountBool(request, pkg.UseFIPSEndpointAnnotation, response.UseFIPSEndpoint, m.useFIPSEndpoint)
+	warnings = append(warnings, useFIPSEndpointWarnings...)
+	envVarPosition, envVarPositionWarnings := m.getEnvVarPosition(pod)
+	warnings = append(warnings, envVarPositionWarnings...)
+	skipRegion, skipRegionWarnings := m.getSkipRegion(pod, request, response.SkipRegion)
+	warnings = append(warnings, skipRegionWarnings...)
+	useRegionalSTS, useRegionalSTSWarnings := m.getUseRegionalSTS(pod, response.UseRegionalSTS)
+	warnings = append(warnings, useRegionalSTSWarnings...)
+	tokenFileName, tokenFileNameWarnings := m.getTokenFileName(request, response.TokenFileName)
+	warnings = append(warnings, tokenFileNameWarnings...)
+	awsConfigFilePath, awsConfigFileWarnings := m.getAWSConfigFilePath(pod, skipTokenVolume, tokenFileName)
+	warnings = append(warnings, awsConfigFileWarnings...)
+
+	return &podPatchConfig{
+		ContainersToSkip:                containersToSkip,
+		Warnings:                        warnings,
+		TokenExpiration:                 m.jitterTokenExpiration(tokenExpiration),
+		UseRegionalSTS:                  useRegionalSTS,
+		UseFIPSEndpoint:                 useFIPSEndpoint,
+		Audience:                        response.Audience,
+		MountPath:                       m.MountPath,
+		VolumeName:                      m.volName,
+		TokenPath:                       tokenFileName,
+		AWSConfigFilePath:               awsConfigFilePath,
+		WebIdentityPatchConfig:          &webIdentityPatchConfig{RoleArn: response.RoleARN},
+		ContainerCredentialsPatchConfig: nil,
+		SkipTokenVolume:                 skipTokenVolume,
+		SDKUAAppID:                      appID,
+		EnvVarPosition:                  envVarPosition,
+		SkipRegion:                      skipRegion,
+	}
+}
 
-	// No mutations needed
-	return nil
+// getCredentialMethodPrecedence returns the credential method precedence of
+// the service account, read from its annotation or defaulting to the flag,
+// and a warning if the annotation is invalid
+func (m *Modifier) getCredentialMethodPrecedence(request cache.Request, response cache.Response) (string, []string) {
+	switch response.CredentialMethodPrecedence {
+	case "":
+	case CredentialMethodPrecedenceContainerCredentials, CredentialMethodPrecedenceSTSWebIdentity, CredentialMethodPrecedenceBoth:
+		return response.CredentialMethodPrecedence, nil
+	default:
+		klog.V(4).InfoS("Ignoring invalid value for service account annotation", append(request.LogKeys(), "annotation", pkg.CredentialMethodPrecedenceAnnotation, "value", response.CredentialMethodPrecedence)...)
+		return m.credentialMethodPrecedence, []string{fmt.Sprintf("service account %s has invalid credential method precedence %q, using %q", request.CacheKey(), response.CredentialMethodPrecedence, m.credentialMethodPrecedence)}
+	}
+	return m.credentialMethodPrecedence, nil
+}
+
+// serviceAccountBool returns the true/false value of the service account
+// annotation with the given name, or defaultValue, the flag, when it is not
+// set, and a warning if the annotation is invalid
+func (m *Modifier) serviceAccountBool(request cache.Request, name, value string, defaultValue bool) (bool, []string) {
+	if value == "" {
+		return defaultValue, nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		klog.V(4).InfoS("Ignoring invalid value for service account annotation", append(request.LogKeys(), "annotation", name, "value", value)...)
+		return defaultValue, []string{fmt.Sprintf("service account %s has invalid %s annotation %q, using %t", request.CacheKey(), name, value, defaultValue)}
+	}
+	return parsed, nil
+}
+
+// getTokenFileName returns the file name of the token in its volume, read from
+// the given service account annotation or defaulting to the flag, and a
+// warning if the annotation is not a file name
+func (m *Modifier) getTokenFileName(request cache.Request, value string) (string, []string) {
+	switch {
+	case value == "":
+		return m.tokenName, nil
+	case value == ".", value == "..", strings.Contains(value, "/"):
+		klog.V(4).InfoS("Ignoring invalid value for service account annotation", append(request.LogKeys(), "annotation", pkg.TokenFileNameAnnotation, "value", value)...)
+		return m.tokenName, []string{fmt.Sprintf("service account %s has invalid token file name %q, using %q", request.CacheKey(), value, m.tokenName)}
+	}
+	return value, nil
+}
+
+// getAWSConfigFilePath returns the path of the AWS config file injected in the
+// pod, or an empty path when it is not injected, read from its annotation or
+// defaulting to the flag, and warnings for invalid values. The file is only
+// injected along with the token volume.
+func (m *Modifier) getAWSConfigFilePath(pod *corev1.Pod, skipTokenVolume bool, tokenFileName string) (string, []string) {
+	inject := m.injectAWSConfigFile
+	var warnings []string
+	if injectKey, injectStr, ok := m.podAnnotation(pod, pkg.InjectAWSConfigFileAnnotation); ok {
+		if parsed, err := strconv.ParseBool(injectStr); err != nil {
+			klog.V(4).InfoS("Ignoring invalid value for annotation", append(podKeys(pod), "annotation", injectKey, "value", injectStr)...)
+			warnings = append(warnings, fmt.Sprintf("annotation %s has invalid value %q, using %t", injectKey, injectStr, inject))
+		} else {
+			inject = parsed
+		}
+	}
+	switch {
+	case !inject, skipTokenVolume, podHasVolume(pod, m.volName):
+		return "", warnings
+	case tokenFileName == awsConfigFileName:
+		return "", append(warnings, fmt.Sprintf("token file name %q is the name of the AWS config file, which was not injected", tokenFileName))
+	}
+	return podFilePath(pod, filepath.Join(m.MountPath, awsConfigFileName)), warnings
+}
+
+// getUseRegionalSTS returns whether AWS_STS_REGIONAL_ENDPOINTS is injected in
+// the pod, read from its annotation, or else the given setting of its service
+// account, and a warning if the annotation is invalid
+func (m *Modifier) getUseRegionalSTS(pod *corev1.Pod, serviceAccountUseRegionalSTS bool) (bool, []string) {
+	regionalKey, regionalStr, ok := m.podAnnotation(pod, pkg.UseRegionalSTSAnnotation)
+	if !ok {
+		return serviceAccountUseRegionalSTS, nil
+	}
+	useRegionalSTS, err := strconv.ParseBool(regionalStr)
+	if err != nil {
+		klog.V(4).InfoS("Ignoring invalid value for annotation", append(podKeys(pod), "annotation", regionalKey, "value", regionalStr)...)
+		return serviceAccountUseRegionalSTS, []string{fmt.Sprintf("annotation %s has invalid value %q, using %t", regionalKey, regionalStr, serviceAccountUseRegionalSTS)}
+	}
+	return useRegionalSTS, nil
+}
+
+// getSkipRegion returns whether the region env variables are not injected in
+// the pod, read from its annotation, or else from the given service account
+// annotation, and warnings for invalid values
+func (m *Modifier) getSkipRegion(pod *corev1.Pod, request cache.Request, serviceAccountSkipRegion string) (bool, []string) {
+	skipRegion, warnings := m.serviceAccountBool(request, pkg.SkipRegionAnnotation, serviceAccountSkipRegion, false)
+	if skipKey, skipStr, ok := m.podAnnotation(pod, pkg.SkipRegionAnnotation); ok {
+		skip, err := strconv.ParseBool(skipStr)
+		if err != nil {
+			klog.V(4).InfoS("Ignoring invalid value for annotation", append(podKeys(pod), "annotation", skipKey, "value", skipStr)...)
+			return skipRegion, append(warnings, fmt.Sprintf("annotation %s has invalid value %q, using %t", skipKey, skipStr, skipRegion))
+		}
+		skipRegion = skip
+	}
+	return skipRegion, warnings
+}
+
+// getSDKUAAppID returns the AWS_SDK_UA_APP_ID of the pod, read from its
+// annotation, the given service account annotation or the template flag, and
+// a warning if it is longer than the SDKs accept
+func (m *Modifier) getSDKUAAppID(pod *corev1.Pod, serviceAccountAppID string) (string, []string) {
+	appID := strings.NewReplacer("{namespace}", pod.Namespace, "{serviceaccount}", serviceAccountName(pod)).Replace(m.sdkUAAppID)
+	source := "flag sdk-ua-app-id"
+	if serviceAccountAppID != "" {
+		appID = serviceAccountAppID
+		source = fmt.Sprintf("service account %s/%s annotation", pod.Namespace, serviceAccountName(pod))
+	}
+	if appIDKey, appIDStr, ok := m.podAnnotation(pod, pkg.SDKUAAppIDAnnotation); ok {
+		appID = appIDStr
+		source = "annotation " + appIDKey
+	}
+	if len(appID) > pkg.MaxSDKUAAppIDLength {
+		return appID, []string{fmt.Sprintf("%s sets %s to %q, which is longer than the %d characters the AWS SDKs accept", source, pkg.AwsEnvVarSDKUAAppID, appID, pkg.MaxSDKUAAppIDLength)}
+	}
+	return appID, nil
+}
+
+// missingServiceAccountError returns an error if the pod must be denied
+// because its service account could not be found, nil otherwise.
+func (m *Modifier) missingServiceAccountError(pod *corev1.Pod, request cache.Request) error {
+	if !m.shouldFailOnMissingServiceAccount(pod) {
+		return nil
+	}
+	return fmt.Errorf("service account %s was not found, refusing to admit pod without AWS credentials", request.CacheKey())
 }
 
 // MutatePod takes a AdmissionReview, mutates the pod, and returns an AdmissionResponse
 func (m *Modifier) MutatePod(ar *v1beta1.AdmissionReview) *v1beta1.AdmissionResponse {
-	badRequest := &v1beta1.AdmissionResponse{
-		Result: &metav1.Status{
-			Message: "bad content",
-		},
-	}
-	if ar == nil {
-		return badRequest
+	if ar == nil || ar.Request == nil {
+		return badRequestResponse()
 	}
 	req := ar.Request
-	if req == nil {
-		return badRequest
-	}
 
 	var pod corev1.Pod
 	if err := json.Unmarshal(req.Object.Raw, &pod); err != nil {
-		klog.Errorf("Could not unmarshal raw object: %v", err)
-		klog.Errorf("Object: %v", string(req.Object.Raw))
+		klog.ErrorS(err, "Could not unmarshal raw object", "uid", req.UID, "object", string(req.Object.Raw))
 		return &v1beta1.AdmissionResponse{
 			Result: &metav1.Status{
 				Message: err.Error(),
 			},
 		}
 	}
+	return m.mutatePod(context.Background(), req, &pod)
+}
+
+func badRequestResponse() *v1beta1.AdmissionResponse {
+	return &v1beta1.AdmissionResponse{
+		Result: &metav1.Status{
+			Message: "bad content",
+		},
+	}
+}
+
+// Outcomes of the admission of a pod
+const (
+	outcomeSkipped   = "skipped"
+	outcomeDenied    = "denied"
+	outcomeMutated   = "mutated"
+	outcomeUnchanged = "unchanged"
+	outcomeError     = "error"
+)
+
+// mutatePod computes the AdmissionResponse for an already decoded pod
+func (m *Modifier) mutatePod(ctx context.Context, req *v1beta1.AdmissionRequest, pod *corev1.Pod) *v1beta1.AdmissionResponse {
+	start := time.Now()
+	defer func() {
+		if !m.simulation {
+			podMutationDuration.Observe(time.Since(start).Seconds())
+		}
+	}()
+
+	response, outcome, reason := m.admitPod(ctx, req, pod)
+	if !m.simulation {
+		m.auditLogger.Log(auditRecord(req, pod, response, outcome, reason))
+	}
+	switch outcome {
+	case outcomeSkipped:
+		klog.V(4).InfoS("Pod was not mutated", append(logContext(req.UID, pod), "outcome", outcome, "reason", reason)...)
+	case outcomeDenied:
+		klog.InfoS("Pod was denied", append(logContext(req.UID, pod), "outcome", outcome, "reason", reason)...)
+	case outcomeMutated:
+		klog.V(3).InfoS("Pod was mutated", append(logContext(req.UID, pod), "outcome", outcome)...)
+	case outcomeUnchanged:
+		klog.V(3).InfoS("Pod was not mutated", append(logContext(req.UID, pod), "outcome", outcome, "reason", reason)...)
+	}
+	return response
+}
 
+// admitPod computes the AdmissionResponse for an already decoded pod, along
+// with the outcome of the admission and the reason for it
+func (m *Modifier) admitPod(ctx context.Context, req *v1beta1.AdmissionRequest, pod *corev1.Pod) (*v1beta1.AdmissionResponse, string, string) {
 	pod.Namespace = req.Namespace
 
-	patchConfig := m.buildPodPatchConfig(&pod)
+	if m.namespaceFilter != nil && !m.namespaceFilter(pod.Namespace) {
+		return &v1beta1.AdmissionResponse{
+			Allowed: true,
+		}, outcomeSkipped, "Namespace is not watched by this webhook"
+	}
+
+	if key, value, ok := m.podLabel(pod, pkg.PodIdentityLabel); ok && value == pkg.PodIdentityDisabled {
+		return &v1beta1.AdmissionResponse{
+			Allowed: true,
+		}, outcomeSkipped, fmt.Sprintf("Pod opted out with the %s=%s label", key, value)
+	}
+	if m.shouldSkipPod(pod) {
+		return &v1beta1.AdmissionResponse{
+			Allowed: true,
+		}, outcomeSkipped, "Pod opted out with the skip-pod-identity annotation"
+	}
+	// On reinvocation, pods whose spec no other mutator changed since they
+	// were mutated are admitted as is
+	if _, hash, ok := m.podAnnotation(pod, pkg.InjectedAnnotation); ok && m.reinvocationMarker && hash == injectionHash(&pod.Spec) {
+		return &v1beta1.AdmissionResponse{
+			Allowed: true,
+		}, outcomeUnchanged, "Pod was already mutated and its containers did not change since"
+	}
+
+	patchConfig, err := m.buildPodPatchConfig(ctx, pod)
+	if err != nil {
+		return &v1beta1.AdmissionResponse{
+			Allowed: false,
+			Result: &metav1.Status{
+				Message: err.Error(),
+			},
+		}, outcomeDenied, err.Error()
+	}
 	if patchConfig == nil {
-		klog.V(4).Infof("Pod was not mutated. Reason: "+
-			"Service account did not have the right annotations or was not found in the cache. %s", logContext(pod.Name, pod.GenerateName, pod.Spec.ServiceAccountName, pod.Namespace))
 		return &v1beta1.AdmissionResponse{
 			Allowed: true,
-		}
+		}, outcomeSkipped, "Service account did not have the right annotations or was not found in the cache"
+	}
+	if patchConfig.SkipReason != "" {
+		return &v1beta1.AdmissionResponse{
+			Allowed:  true,
+			Warnings: patchConfig.Warnings,
+		}, outcomeSkipped, patchConfig.SkipReason
 	}
 
-	patch, changed := m.getPodSpecPatch(&pod, patchConfig)
+	warnings := append(patchConfig.Warnings, conflictingEnvWarnings(pod, patchConfig)...)
+	patch, changed := m.getPodSpecPatch(pod, patchConfig)
 	patchBytes, err := json.Marshal(patch)
 	if err != nil {
-		klog.Errorf("Error marshaling pod update: %v", err.Error())
+		klog.ErrorS(err, "Error marshaling pod update", logContext(req.UID, pod)...)
 		return &v1beta1.AdmissionResponse{
 			Result: &metav1.Status{
 				Message: err.Error(),
 			},
-		}
+		}, outcomeError, err.Error()
 	}
 
-	// TODO: klog structured logging can make this better
-	if changed {
-		klog.V(3).Infof("Pod was mutated. %s", logContext(pod.Name, pod.GenerateName, pod.Spec.ServiceAccountName, pod.Namespace))
-	} else {
-		klog.V(3).Infof("Pod was not mutated. Reason: "+
-			"Required volume mounts and env variables were already present. %s", logContext(pod.Name, pod.GenerateName, pod.Spec.ServiceAccountName, pod.Namespace))
+	if !m.simulation {
+		podMutationPatchSize.Observe(float64(len(patchBytes)))
+	}
+
+	outcome, reason := outcomeMutated, "Credentials were injected"
+	if !changed {
+		outcome, reason = outcomeUnchanged, "Required volume mounts and env variables were already present"
 	}
 
 	return &v1beta1.AdmissionResponse{
-		Allowed: true,
-		Patch:   patchBytes,
+		Allowed:          true,
+		AuditAnnotations: m.auditAnnotations(patchConfig),
+		Warnings:         warnings,
+		Patch:            patchBytes,
 		PatchType: func() *v1beta1.PatchType {
 			pt := v1beta1.PatchTypeJSONPatch
 			return &pt
 		}(),
-	}
+	}, outcome, reason
 }
 
 // Handle handles pod modification requests
 func (m *Modifier) Handle(w http.ResponseWriter, r *http.Request) {
-	var body []byte
+	body := getBuffer()
+	defer putBuffer(body)
 	if r.Body != nil {
-		if data, err := ioutil.ReadAll(r.Body); err == nil {
-			body = data
+		if m.maxRequestBodyBytes > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, m.maxRequestBodyBytes)
+		}
+		_, err := body.ReadFrom(r.Body)
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			klog.ErrorS(err, "Request body is too large", "limit", maxBytesErr.Limit)
+			http.Error(w, fmt.Sprintf("request body exceeds %d bytes", maxBytesErr.Limit), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			body.Reset()
 		}
 	}
 
 	// verify the content type is accurate
 	contentType := r.Header.Get("Content-Type")
-	if contentType != "application/json" {
-		klog.Errorf("Content-Type=%s, expected application/json", contentType)
-		http.Error(w, "Invalid Content-Type, expected `application/json`", http.StatusUnsupportedMediaType)
+	if contentType != runtime.ContentTypeJSON && contentType != runtime.ContentTypeProtobuf {
+		klog.ErrorS(nil, "Unsupported Content-Type", "contentType", contentType, "expected", []string{runtime.ContentTypeJSON, runtime.ContentTypeProtobuf})
+		http.Error(w, "Invalid Content-Type, expected `application/json` or `application/vnd.kubernetes.protobuf`", http.StatusUnsupportedMediaType)
 		return
 	}
 
 	var admissionResponse *v1beta1.AdmissionResponse
-	ar := v1beta1.AdmissionReview{}
-	if _, _, err := deserializer.Decode(body, nil, &ar); err != nil {
-		klog.Errorf("Can't decode body: %v", err)
+	req, pod, err := decodeAdmissionRequest(contentType, body.Bytes())
+	switch {
+	case err != nil:
+		klog.ErrorS(err, "Can't decode body")
 		admissionResponse = &v1beta1.AdmissionResponse{
 			Result: &metav1.Status{
 				Message: err.Error(),
 			},
 		}
-	} else {
-		admissionResponse = m.MutatePod(&ar)
+	case req == nil:
+		admissionResponse = badRequestResponse()
+	default:
+		admissionResponse = m.mutatePod(r.Context(), req, pod)
+		admissionResponse.UID = req.UID
 	}
 
-	admissionReview := v1beta1.AdmissionReview{}
-	if admissionResponse != nil {
-		admissionReview.Response = admissionResponse
-		if ar.Request != nil {
-			admissionReview.Response.UID = ar.Request.UID
-		}
-	}
+	admissionReview := v1beta1.AdmissionReview{Response: admissionResponse}
 
-	resp, err := json.Marshal(admissionReview)
-	if err != nil {
-		klog.Errorf("Can't encode response: %v", err)
+	resp := getBuffer()
+	defer putBuffer(resp)
+	if err := encodeAdmissionReview(resp, contentType, &admissionReview); err != nil {
+		klog.ErrorS(err, "Can't encode response")
 		http.Error(w, fmt.Sprintf("could not encode response: %v", err), http.StatusInternalServerError)
+		return
 	}
-	if _, err := w.Write(resp); err != nil {
-		klog.Errorf("Can't write response: %v", err)
+	w.Header().Set("Content-Type", contentType)
+	if _, err := w.Write(resp.Bytes()); err != nil {
+		klog.ErrorS(err, "Can't write response")
 		http.Error(w, fmt.Sprintf("could not write response: %v", err), http.StatusInternalServerError)
 	}
 }
+
+// podAdmissionReview is what JSON request bodies are decoded into instead of
+// v1beta1.AdmissionReview. Shadowing the request's object field with a Pod
+// lets encoding/json decode the review and the pod in a single pass rather
+// than unmarshalling req.Object.Raw a second time.
+type podAdmissionReview struct {
+	Request *podAdmissionRequest `json:"request,omitempty"`
+}
+
+type podAdmissionRequest struct {
+	v1beta1.AdmissionRequest
+	Object corev1.Pod `json:"object"`
+}
+
+// decodeAdmissionRequest decodes the request and the pod it carries. A nil
+// request with a nil error means the body did not contain a request.
+func decodeAdmissionRequest(contentType string, body []byte) (*v1beta1.AdmissionRequest, *corev1.Pod, error) {
+	if len(body) == 0 {
+		return nil, nil, nil
+	}
+	if contentType != runtime.ContentTypeProtobuf {
+		review := podAdmissionReview{}
+		if err := json.Unmarshal(body, &review); err != nil {
+			return nil, nil, err
+		}
+		if review.Request == nil {
+			return nil, nil, nil
+		}
+		return &review.Request.AdmissionRequest, &review.Request.Object, nil
+	}
+
+	ar := v1beta1.AdmissionReview{}
+	if _, _, err := deserializer.Decode(body, nil, &ar); err != nil {
+		return nil, nil, err
+	}
+	if ar.Request == nil {
+		return nil, nil, nil
+	}
+	pod := &corev1.Pod{}
+	if err := json.Unmarshal(ar.Request.Object.Raw, pod); err != nil {
+		return nil, nil, err
+	}
+	return ar.Request, pod, nil
+}
+
+// encodeAdmissionReview serializes the review into buf in the same media type
+// the API server used for the request. Protobuf responses are wrapped in the
+// Kubernetes protobuf envelope, which requires the group/version/kind to be
+// set.
+func encodeAdmissionReview(buf *bytes.Buffer, contentType string, review *v1beta1.AdmissionReview) error {
+	if contentType == runtime.ContentTypeProtobuf {
+		return protobufEncoder.Encode(review, buf)
+	}
+	if err := json.NewEncoder(buf).Encode(review); err != nil {
+		return err
+	}
+	// json.Encoder terminates each value with a newline, json.Marshal doesn't
+	buf.Truncate(buf.Len() - 1)
+	return nil
+}
+
+// maxPooledBufferBytes keeps buffers grown by unusually large requests from
+// being retained by the pool.
+const maxPooledBufferBytes = 1 << 20
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferBytes {
+		return
+	}
+	bufferPool.Put(buf)
+}
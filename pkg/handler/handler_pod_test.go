@@ -17,6 +17,7 @@ package handler
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/aws/amazon-eks-pod-identity-webhook/pkg/cache"
@@ -44,6 +45,10 @@ const (
 	audienceAnnotation                = "testing.eks.amazonaws.com/serviceAccount/audience"
 	saInjectSTSAnnotation             = "testing.eks.amazonaws.com/serviceAccount/sts-regional-endpoints"
 	saInjectTokenExpirationAnnotation = "testing.eks.amazonaws.com/serviceAccount/token-expiration"
+	saCredentialMethodPrecedence      = "testing.eks.amazonaws.com/serviceAccount/credential-method-precedence"
+	saSkipTokenVolume                 = "testing.eks.amazonaws.com/serviceAccount/skip-token-volume"
+	saSDKUAAppID                      = "testing.eks.amazonaws.com/serviceAccount/sdk-ua-app-id"
+	saUseFIPSEndpoint                 = "testing.eks.amazonaws.com/serviceAccount/use-fips-endpoint"
 
 	// Container credentials annotation values
 	containerCredentialsFullURIAnnotation    = "testing.eks.amazonaws.com/containercredentials/uri"
@@ -51,12 +56,19 @@ const (
 	containerCredentialsMountPathAnnotation  = "testing.eks.amazonaws.com/containercredentials/mountPath"
 	containerCredentialsVolumeNameAnnotation = "testing.eks.amazonaws.com/containercredentials/volumeName"
 	containerCredentialsTokenPathAnnotation  = "testing.eks.amazonaws.com/containercredentials/tokenPath"
+	// Per-identity token expiration override
+	containerCredentialsTokenExpirationAnnotation = "testing.eks.amazonaws.com/containercredentials/tokenExpiration"
 
 	// Handler values
 	handlerMountPathAnnotation  = "testing.eks.amazonaws.com/handler/mountPath"
 	handlerExpirationAnnotation = "testing.eks.amazonaws.com/handler/expiration"
 	handlerRegionAnnotation     = "testing.eks.amazonaws.com/handler/region"
 	handlerSTSAnnotation        = "testing.eks.amazonaws.com/handler/injectSTS"
+	handlerSkipInitContainers   = "testing.eks.amazonaws.com/handler/skipInitContainers"
+	handlerNativeSidecars       = "testing.eks.amazonaws.com/handler/nativeSidecars"
+	handlerSkipTokenVolume      = "testing.eks.amazonaws.com/handler/skipTokenVolume"
+	handlerSDKUAAppID           = "testing.eks.amazonaws.com/handler/sdkUAAppID"
+	handlerUseFIPSEndpoint      = "testing.eks.amazonaws.com/handler/useFIPSEndpoint"
 )
 
 // buildModifierFromPod gets values to set up test case environments with as if
@@ -73,6 +85,26 @@ func buildModifierFromPod(pod *corev1.Pod) *Modifier {
 		modifierOpts = append(modifierOpts, WithRegion(region))
 	}
 
+	if skip, ok := pod.Annotations[handlerSkipInitContainers]; ok {
+		modifierOpts = append(modifierOpts, WithSkipInitContainers(skip == "true"))
+	}
+
+	if mode, ok := pod.Annotations[handlerNativeSidecars]; ok {
+		modifierOpts = append(modifierOpts, WithNativeSidecars(mode))
+	}
+
+	if skip, ok := pod.Annotations[handlerSkipTokenVolume]; ok {
+		modifierOpts = append(modifierOpts, WithSkipTokenVolume(skip == "true"))
+	}
+
+	if template, ok := pod.Annotations[handlerSDKUAAppID]; ok {
+		modifierOpts = append(modifierOpts, WithSDKUAAppID(template))
+	}
+
+	if useFIPS, ok := pod.Annotations[handlerUseFIPSEndpoint]; ok {
+		modifierOpts = append(modifierOpts, WithUseFIPSEndpoint(useFIPS == "true"))
+	}
+
 	modifierOpts = append(modifierOpts, WithServiceAccountCache(buildFakeCacheFromPod(pod)))
 	modifierOpts = append(modifierOpts, WithContainerCredentialsConfig(buildFakeConfigFromPod(pod)))
 
@@ -107,6 +139,22 @@ func buildFakeCacheFromPod(pod *corev1.Pod) *cache.FakeServiceAccountCache {
 		}
 	}
 
+	if precedence, ok := pod.Annotations[saCredentialMethodPrecedence]; ok {
+		testServiceAccount.Annotations["eks.amazonaws.com/credential-method-precedence"] = precedence
+	}
+
+	if skip, ok := pod.Annotations[saSkipTokenVolume]; ok {
+		testServiceAccount.Annotations["eks.amazonaws.com/skip-token-volume"] = skip
+	}
+
+	if appID, ok := pod.Annotations[saSDKUAAppID]; ok {
+		testServiceAccount.Annotations["eks.amazonaws.com/sdk-ua-app-id"] = appID
+	}
+
+	if useFIPS, ok := pod.Annotations[saUseFIPSEndpoint]; ok {
+		testServiceAccount.Annotations["eks.amazonaws.com/use-fips-endpoint"] = useFIPS
+	}
+
 	return cache.NewFakeServiceAccountCache(testServiceAccount)
 }
 
@@ -117,6 +165,10 @@ func buildFakeConfigFromPod(pod *corev1.Pod) *containercredentials.FakeConfig {
 			Namespace:      "default",
 			ServiceAccount: "default",
 		}
+		var tokenExpiration int64
+		if value, ok := pod.Annotations[containerCredentialsTokenExpirationAnnotation]; ok {
+			tokenExpiration, _ = strconv.ParseInt(value, 10, 64)
+		}
 		return &containercredentials.FakeConfig{
 			Audience:   pod.Annotations[containerCredentialsAudienceAnnotation],
 			MountPath:  pod.Annotations[containerCredentialsMountPathAnnotation],
@@ -126,6 +178,7 @@ func buildFakeConfigFromPod(pod *corev1.Pod) *containercredentials.FakeConfig {
 			Identities: map[containercredentials.Identity]bool{
 				identity: true,
 			},
+			TokenExpiration: tokenExpiration,
 		}
 	}
 	return &containercredentials.FakeConfig{}
@@ -158,7 +211,10 @@ func TestUpdatePodSpec(t *testing.T) {
 
 			t.Run(fmt.Sprintf("Pod %s in file %s", pod.Name, path), func(t *testing.T) {
 				modifier := buildModifierFromPod(pod)
-				patchConfig := modifier.buildPodPatchConfig(pod)
+				patchConfig, err := modifier.buildPodPatchConfig(context.TODO(), pod)
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
 				patch, _ := modifier.getPodSpecPatch(pod, patchConfig)
 				patchBytes, err := json.Marshal(patch)
 				if err != nil {
@@ -17,6 +17,7 @@ package handler
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"github.com/aws/amazon-eks-pod-identity-webhook/pkg/containercredentials"
 	"github.com/stretchr/testify/assert"
@@ -26,15 +27,21 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/aws/amazon-eks-pod-identity-webhook/pkg/auditlog"
 	"github.com/aws/amazon-eks-pod-identity-webhook/pkg/cache"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"k8s.io/api/admission/v1beta1"
 	authenticationv1 "k8s.io/api/authentication/v1"
 	"k8s.io/api/core/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 )
 
 const uuid = "918ef1dc-928f-4525-99ef-988389f263c3"
@@ -103,15 +110,1226 @@ func TestMutatePod(t *testing.T) {
 	}
 }
 
+func TestMutatePod_AuditAnnotations(t *testing.T) {
+	modifier := NewModifier(
+		WithServiceAccountCache(cache.NewFakeServiceAccountCache()),
+		WithContainerCredentialsConfig(&containercredentials.FakeConfig{
+			Audience:   "pods.eks.amazonaws.com",
+			MountPath:  "/var/run/secrets/pods.eks.amazonaws.com/serviceaccount",
+			VolumeName: "eks-pod-identity-token",
+			TokenPath:  "eks-pod-identity-token",
+			FullUri:    "http://169.254.170.23/v1/credentials",
+			Identities: map[containercredentials.Identity]bool{
+				{Namespace: "default", ServiceAccount: "default"}: true,
+			},
+		}),
+		WithVersion("v0.1.0"),
+	)
+	response := modifier.MutatePod(getValidReview(rawPodWithoutVolume))
+	assert.True(t, response.Allowed)
+	assert.Equal(t, map[string]string{
+		"audience":          "pods.eks.amazonaws.com",
+		"credential-method": "container_credentials",
+		"webhook-version":   "v0.1.0",
+	}, response.AuditAnnotations)
+}
+
+func TestMutatePod_CredentialMethodPrecedence(t *testing.T) {
+	testServiceAccount := &corev1.ServiceAccount{}
+	testServiceAccount.Name = "default"
+	testServiceAccount.Namespace = "default"
+	testServiceAccount.Annotations = map[string]string{
+		"eks.amazonaws.com/role-arn": "arn:aws:iam::111122223333:role/s3-reader",
+	}
+	containerCredentialsConfig := &containercredentials.FakeConfig{
+		Audience:   "pods.eks.amazonaws.com",
+		MountPath:  "/var/run/secrets/pods.eks.amazonaws.com/serviceaccount",
+		VolumeName: "eks-pod-identity-token",
+		TokenPath:  "eks-pod-identity-token",
+		FullUri:    "http://169.254.170.23/v1/credentials",
+		Identities: map[containercredentials.Identity]bool{
+			{Namespace: "default", ServiceAccount: "default"}: true,
+		},
+	}
+
+	for _, tc := range []struct {
+		precedence   string
+		saPrecedence string
+		method       string
+		warnings     []string
+	}{
+		{precedence: CredentialMethodPrecedenceContainerCredentials, method: "container_credentials"},
+		{precedence: CredentialMethodPrecedenceSTSWebIdentity, method: "sts_web_identity"},
+		{precedence: CredentialMethodPrecedenceBoth, method: "container_credentials,sts_web_identity"},
+		{precedence: CredentialMethodPrecedenceContainerCredentials, saPrecedence: CredentialMethodPrecedenceSTSWebIdentity, method: "sts_web_identity"},
+		{
+			precedence:   CredentialMethodPrecedenceSTSWebIdentity,
+			saPrecedence: "invalid",
+			method:       "sts_web_identity",
+			warnings:     []string{`service account default/default has invalid credential method precedence "invalid", using "sts-web-identity"`},
+		},
+	} {
+		t.Run(tc.precedence+"/"+tc.saPrecedence, func(t *testing.T) {
+			sa := testServiceAccount.DeepCopy()
+			if tc.saPrecedence != "" {
+				sa.Annotations["eks.amazonaws.com/credential-method-precedence"] = tc.saPrecedence
+			}
+			modifier := NewModifier(
+				WithServiceAccountCache(cache.NewFakeServiceAccountCache(sa)),
+				WithContainerCredentialsConfig(containerCredentialsConfig),
+				WithCredentialMethodPrecedence(tc.precedence),
+			)
+			response := modifier.MutatePod(getValidReview(rawPodWithoutVolume))
+			assert.True(t, response.Allowed)
+			assert.Equal(t, tc.method, response.AuditAnnotations["credential-method"])
+			if tc.precedence == CredentialMethodPrecedenceBoth {
+				assert.NotContains(t, response.AuditAnnotations, "audience")
+				assert.Equal(t, "pods.eks.amazonaws.com", response.AuditAnnotations["container-credentials-audience"])
+				assert.Equal(t, "sts.amazonaws.com", response.AuditAnnotations["web-identity-audience"])
+				assert.Equal(t, "arn:aws:iam::111122223333:role/s3-reader", response.AuditAnnotations["role-arn"])
+			}
+			assert.Equal(t, tc.warnings, response.Warnings)
+		})
+	}
+}
+
+func TestContainerCredentialsURIMode(t *testing.T) {
+	containerCredentialsConfig := &containercredentials.FakeConfig{
+		FullUri:     "http://169.254.170.23/v1/credentials",
+		RelativeUri: "/v1/credentials",
+		Identities: map[containercredentials.Identity]bool{
+			{Namespace: "default", ServiceAccount: "default"}: true,
+		},
+	}
+
+	for _, tc := range []struct {
+		name          string
+		mode          string
+		podAnnotation string
+		expected      map[string]string
+		warnings      []string
+	}{
+		{
+			name:     "full",
+			mode:     ContainerCredentialsURIModeFull,
+			expected: map[string]string{"AWS_CONTAINER_CREDENTIALS_FULL_URI": "http://169.254.170.23/v1/credentials"},
+		},
+		{
+			name:     "relative",
+			mode:     ContainerCredentialsURIModeRelative,
+			expected: map[string]string{"AWS_CONTAINER_CREDENTIALS_RELATIVE_URI": "/v1/credentials"},
+		},
+		{
+			name:          "pod annotation",
+			mode:          ContainerCredentialsURIModeFull,
+			podAnnotation: ContainerCredentialsURIModeBoth,
+			expected: map[string]string{
+				"AWS_CONTAINER_CREDENTIALS_FULL_URI":     "http://169.254.170.23/v1/credentials",
+				"AWS_CONTAINER_CREDENTIALS_RELATIVE_URI": "/v1/credentials",
+			},
+		},
+		{
+			name:          "invalid pod annotation",
+			mode:          ContainerCredentialsURIModeFull,
+			podAnnotation: "absolute",
+			expected:      map[string]string{"AWS_CONTAINER_CREDENTIALS_FULL_URI": "http://169.254.170.23/v1/credentials"},
+			warnings:      []string{`annotation eks.amazonaws.com/container-credentials-uri-mode has invalid value "absolute", using "full"`},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			modifier := NewModifier(
+				WithServiceAccountCache(cache.NewFakeServiceAccountCache()),
+				WithContainerCredentialsConfig(containerCredentialsConfig),
+				WithContainerCredentialsURIMode(tc.mode),
+			)
+			pod := &corev1.Pod{}
+			pod.Namespace = "default"
+			pod.Spec.ServiceAccountName = "default"
+			if tc.podAnnotation != "" {
+				pod.Annotations = map[string]string{"eks.amazonaws.com/container-credentials-uri-mode": tc.podAnnotation}
+			}
+
+			patchConfig, err := modifier.buildPodPatchConfig(context.TODO(), pod)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.warnings, patchConfig.Warnings)
+			container := &corev1.Container{}
+			assert.True(t, modifier.addEnvToContainer(container, "/token", patchConfig))
+			uris := map[string]string{}
+			for _, env := range container.Env {
+				if strings.HasSuffix(env.Name, "_URI") {
+					uris[env.Name] = env.Value
+				}
+			}
+			assert.Equal(t, tc.expected, uris)
+		})
+	}
+}
+
+func TestSkipTokenVolume(t *testing.T) {
+	containerCredentialsConfig := &containercredentials.FakeConfig{
+		FullUri: "http://169.254.170.23/v1/credentials",
+		Identities: map[containercredentials.Identity]bool{
+			{Namespace: "default", ServiceAccount: "credentials"}:           true,
+			{Namespace: "default", ServiceAccount: "annotated-credentials"}: true,
+		},
+	}
+	credentialsServiceAccount := &corev1.ServiceAccount{}
+	credentialsServiceAccount.Name = "annotated-credentials"
+	credentialsServiceAccount.Namespace = "default"
+	credentialsServiceAccount.Annotations = map[string]string{
+		"eks.amazonaws.com/skip-token-volume": "invalid",
+	}
+	roleServiceAccount := &corev1.ServiceAccount{}
+	roleServiceAccount.Name = "default"
+	roleServiceAccount.Namespace = "default"
+	roleServiceAccount.Annotations = map[string]string{
+		"eks.amazonaws.com/role-arn":          "arn:aws:iam::111122223333:role/s3-reader",
+		"eks.amazonaws.com/skip-token-volume": "invalid",
+	}
+
+	for _, tc := range []struct {
+		name            string
+		serviceAccount  string
+		skipTokenVolume bool
+		warnings        []string
+	}{
+		{
+			name:            "container credentials",
+			serviceAccount:  "credentials",
+			skipTokenVolume: true,
+		},
+		{
+			name:            "container credentials with invalid service account annotation",
+			serviceAccount:  "annotated-credentials",
+			skipTokenVolume: true,
+			warnings:        []string{`service account default/annotated-credentials has invalid skip-token-volume annotation "invalid", using true`},
+		},
+		{
+			name:            "invalid service account annotation",
+			serviceAccount:  "default",
+			skipTokenVolume: true,
+			warnings:        []string{`service account default/default has invalid skip-token-volume annotation "invalid", using true`},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			modifier := NewModifier(
+				WithServiceAccountCache(cache.NewFakeServiceAccountCache(roleServiceAccount, credentialsServiceAccount)),
+				WithContainerCredentialsConfig(containerCredentialsConfig),
+				WithSkipTokenVolume(true),
+			)
+			pod := &corev1.Pod{}
+			pod.Namespace = "default"
+			pod.Spec.ServiceAccountName = tc.serviceAccount
+			pod.Spec.Containers = []corev1.Container{{Name: "app"}}
+
+			patchConfig, err := modifier.buildPodPatchConfig(context.TODO(), pod)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.skipTokenVolume, patchConfig.SkipTokenVolume)
+			assert.Equal(t, tc.warnings, patchConfig.Warnings)
+			patch, changed := modifier.getPodSpecPatch(pod, patchConfig)
+			assert.True(t, changed)
+			for _, op := range patch {
+				assert.NotEqual(t, "/spec/volumes", op.Path)
+			}
+			container := &corev1.Container{}
+			modifier.addEnvToContainer(container, "/token", patchConfig)
+			assert.Empty(t, container.VolumeMounts)
+		})
+	}
+}
+
+func TestSkipRegion(t *testing.T) {
+	serviceAccount := &corev1.ServiceAccount{}
+	serviceAccount.Name = "default"
+	serviceAccount.Namespace = "default"
+	serviceAccount.Annotations = map[string]string{
+		"eks.amazonaws.com/role-arn":    "arn:aws:iam::111122223333:role/s3-reader",
+		"eks.amazonaws.com/skip-region": "true",
+	}
+
+	for _, tc := range []struct {
+		name          string
+		podAnnotation string
+		skipRegion    bool
+		warnings      []string
+	}{
+		{name: "service account annotation", skipRegion: true},
+		{name: "pod annotation override", podAnnotation: "false", skipRegion: false},
+		{
+			name:          "invalid pod annotation",
+			podAnnotation: "maybe",
+			skipRegion:    true,
+			warnings:      []string{`annotation eks.amazonaws.com/skip-region has invalid value "maybe", using true`},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			modifier := NewModifier(
+				WithServiceAccountCache(cache.NewFakeServiceAccountCache(serviceAccount)),
+				WithContainerCredentialsConfig(&containercredentials.FakeConfig{}),
+				WithRegion("us-west-2"),
+			)
+			pod := &corev1.Pod{}
+			pod.Namespace = "default"
+			pod.Spec.ServiceAccountName = "default"
+			if tc.podAnnotation != "" {
+				pod.Annotations = map[string]string{"eks.amazonaws.com/skip-region": tc.podAnnotation}
+			}
+
+			patchConfig, err := modifier.buildPodPatchConfig(context.TODO(), pod)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.skipRegion, patchConfig.SkipRegion)
+			assert.Equal(t, tc.warnings, patchConfig.Warnings)
+			container := &corev1.Container{}
+			assert.True(t, modifier.addEnvToContainer(container, "/token", patchConfig))
+			assert.Equal(t, !tc.skipRegion, hasEnvVar(container.Env, "AWS_REGION"))
+			assert.Equal(t, !tc.skipRegion, hasEnvVar(container.Env, "AWS_DEFAULT_REGION"))
+			assert.True(t, hasEnvVar(container.Env, "AWS_ROLE_ARN"))
+		})
+	}
+}
+
+func TestGetUseRegionalSTS(t *testing.T) {
+	modifier := NewModifier()
+	for _, tc := range []struct {
+		name           string
+		annotation     string
+		serviceAccount bool
+		expected       bool
+		warnings       []string
+	}{
+		{name: "service account", serviceAccount: true, expected: true},
+		{name: "pod opt in", annotation: "true", expected: true},
+		{name: "pod opt out", annotation: "false", serviceAccount: true, expected: false},
+		{
+			name:           "invalid pod annotation",
+			annotation:     "regional",
+			serviceAccount: true,
+			expected:       true,
+			warnings:       []string{`annotation eks.amazonaws.com/sts-regional-endpoints has invalid value "regional", using true`},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pod := &corev1.Pod{}
+			if tc.annotation != "" {
+				pod.Annotations = map[string]string{"eks.amazonaws.com/sts-regional-endpoints": tc.annotation}
+			}
+			useRegionalSTS, warnings := modifier.getUseRegionalSTS(pod, tc.serviceAccount)
+			assert.Equal(t, tc.expected, useRegionalSTS)
+			assert.Equal(t, tc.warnings, warnings)
+		})
+	}
+}
+
+func TestGetSDKUAAppID(t *testing.T) {
+	pod := &corev1.Pod{}
+	pod.Namespace = "orders"
+	pod.Spec.ServiceAccountName = "api"
+
+	modifier := NewModifier(WithSDKUAAppID("{namespace}/{serviceaccount}"))
+	appID, warnings := modifier.getSDKUAAppID(pod, "")
+	assert.Equal(t, "orders/api", appID)
+	assert.Empty(t, warnings)
+
+	appID, warnings = modifier.getSDKUAAppID(pod, "s3-reader")
+	assert.Equal(t, "s3-reader", appID)
+	assert.Empty(t, warnings)
+
+	longAppID := strings.Repeat("a", 51)
+	pod.Annotations = map[string]string{"eks.amazonaws.com/sdk-ua-app-id": longAppID}
+	appID, warnings = modifier.getSDKUAAppID(pod, "s3-reader")
+	assert.Equal(t, longAppID, appID)
+	assert.Equal(t, []string{`annotation eks.amazonaws.com/sdk-ua-app-id sets AWS_SDK_UA_APP_ID to "` + longAppID + `", which is longer than the 50 characters the AWS SDKs accept`}, warnings)
+
+	appID, _ = NewModifier().getSDKUAAppID(&corev1.Pod{}, "")
+	assert.Empty(t, appID)
+}
+
 func TestMutatePod_MutationNotNeeded(t *testing.T) {
 	modifier := NewModifier(
 		WithServiceAccountCache(cache.NewFakeServiceAccountCache()),
 		WithContainerCredentialsConfig(&containercredentials.FakeConfig{}),
 	)
 	response := modifier.MutatePod(getValidReview(rawPodWithoutVolume))
-	assert.NotNil(t, response)
+	assert.NotNil(t, response)
+	assert.True(t, response.Allowed)
+	assert.Nil(t, response.Patch)
+}
+
+func TestMutatePod_NamespaceFilter(t *testing.T) {
+	testServiceAccount := &v1.ServiceAccount{}
+	testServiceAccount.Name = "default"
+	testServiceAccount.Namespace = "default"
+	testServiceAccount.Annotations = map[string]string{
+		"eks.amazonaws.com/role-arn": "arn:aws:iam::111122223333:role/s3-reader",
+	}
+
+	cases := []struct {
+		caseName    string
+		namespace   string
+		wantMutated bool
+	}{
+		{caseName: "WatchedNamespace", namespace: "default", wantMutated: true},
+		{caseName: "UnwatchedNamespace", namespace: "other", wantMutated: false},
+	}
+	for _, c := range cases {
+		t.Run(c.caseName, func(t *testing.T) {
+			modifier := NewModifier(
+				WithServiceAccountCache(cache.NewFakeServiceAccountCache(testServiceAccount)),
+				WithContainerCredentialsConfig(&containercredentials.FakeConfig{}),
+				WithFailOnMissingServiceAccount(true),
+				WithNamespaceFilter(func(namespace string) bool { return namespace == "default" }),
+			)
+			review := getValidReview(rawPodWithoutVolume)
+			review.Request.Namespace = c.namespace
+			response := modifier.MutatePod(review)
+			assert.True(t, response.Allowed)
+			assert.Equal(t, c.wantMutated, response.Patch != nil)
+		})
+	}
+}
+
+func TestMutatePod_PodIdentityLabel(t *testing.T) {
+	testServiceAccount := &corev1.ServiceAccount{}
+	testServiceAccount.Name = "default"
+	testServiceAccount.Namespace = "default"
+	testServiceAccount.Annotations = map[string]string{
+		"eks.amazonaws.com/role-arn": "arn:aws:iam::111122223333:role/s3-reader",
+	}
+
+	cases := []struct {
+		caseName    string
+		podLabels   map[string]string
+		wantMutated bool
+	}{
+		{caseName: "NoLabel", wantMutated: true},
+		{caseName: "Disabled", podLabels: map[string]string{"eks.amazonaws.com/pod-identity": "disabled"}, wantMutated: false},
+		{caseName: "FallbackDomainDisabled", podLabels: map[string]string{"example.com/pod-identity": "disabled"}, wantMutated: false},
+		{caseName: "OtherValue", podLabels: map[string]string{"eks.amazonaws.com/pod-identity": "enabled"}, wantMutated: true},
+	}
+	for _, c := range cases {
+		t.Run(c.caseName, func(t *testing.T) {
+			modifier := NewModifier(
+				WithServiceAccountCache(cache.NewFakeServiceAccountCache(testServiceAccount)),
+				WithContainerCredentialsConfig(&containercredentials.FakeConfig{}),
+				WithFallbackAnnotationDomains("example.com"),
+			)
+
+			pod := &corev1.Pod{}
+			if err := json.Unmarshal(rawPodWithoutVolume, pod); err != nil {
+				t.Fatalf("Failed to unmarshal pod: %v", err)
+			}
+			pod.Labels = c.podLabels
+			podBytes, err := json.Marshal(pod)
+			if err != nil {
+				t.Fatalf("Failed to marshal pod: %v", err)
+			}
+
+			response := modifier.MutatePod(getValidReview(podBytes))
+			assert.True(t, response.Allowed)
+			assert.Equal(t, c.wantMutated, response.Patch != nil)
+		})
+	}
+}
+
+func TestMutatePod_SkipPodIdentityAnnotation(t *testing.T) {
+	testServiceAccount := &corev1.ServiceAccount{}
+	testServiceAccount.Name = "default"
+	testServiceAccount.Namespace = "default"
+	testServiceAccount.Annotations = map[string]string{
+		"eks.amazonaws.com/role-arn": "arn:aws:iam::111122223333:role/s3-reader",
+	}
+
+	cases := []struct {
+		caseName       string
+		podAnnotations map[string]string
+		wantMutated    bool
+	}{
+		{caseName: "NoAnnotation", wantMutated: true},
+		{caseName: "Skipped", podAnnotations: map[string]string{"eks.amazonaws.com/skip-pod-identity": "true"}, wantMutated: false},
+		{caseName: "NotSkipped", podAnnotations: map[string]string{"eks.amazonaws.com/skip-pod-identity": "false"}, wantMutated: true},
+		{caseName: "Invalid", podAnnotations: map[string]string{"eks.amazonaws.com/skip-pod-identity": "maybe"}, wantMutated: true},
+	}
+	for _, c := range cases {
+		t.Run(c.caseName, func(t *testing.T) {
+			modifier := NewModifier(
+				WithServiceAccountCache(cache.NewFakeServiceAccountCache(testServiceAccount)),
+				WithContainerCredentialsConfig(&containercredentials.FakeConfig{}),
+			)
+
+			pod := &corev1.Pod{}
+			if err := json.Unmarshal(rawPodWithoutVolume, pod); err != nil {
+				t.Fatalf("Failed to unmarshal pod: %v", err)
+			}
+			pod.Annotations = c.podAnnotations
+			podBytes, err := json.Marshal(pod)
+			if err != nil {
+				t.Fatalf("Failed to marshal pod: %v", err)
+			}
+
+			response := modifier.MutatePod(getValidReview(podBytes))
+			assert.True(t, response.Allowed)
+			assert.Equal(t, c.wantMutated, response.Patch != nil)
+		})
+	}
+}
+
+func TestMutatePod_FailOnMissingServiceAccount(t *testing.T) {
+	cases := []struct {
+		caseName       string
+		failOnMissing  bool
+		podAnnotations map[string]string
+		expectAllowed  bool
+	}{
+		{
+			caseName:      "FlagDisabled",
+			failOnMissing: false,
+			expectAllowed: true,
+		},
+		{
+			caseName:      "FlagEnabled",
+			failOnMissing: true,
+			expectAllowed: false,
+		},
+		{
+			caseName:       "AnnotationEnabled",
+			failOnMissing:  false,
+			podAnnotations: map[string]string{"eks.amazonaws.com/fail-on-missing-service-account": "true"},
+			expectAllowed:  false,
+		},
+		{
+			caseName:       "AnnotationDisabled",
+			failOnMissing:  true,
+			podAnnotations: map[string]string{"eks.amazonaws.com/fail-on-missing-service-account": "false"},
+			expectAllowed:  true,
+		},
+		{
+			caseName:       "AnnotationInvalid",
+			failOnMissing:  true,
+			podAnnotations: map[string]string{"eks.amazonaws.com/fail-on-missing-service-account": "maybe"},
+			expectAllowed:  false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.caseName, func(t *testing.T) {
+			modifier := NewModifier(
+				WithServiceAccountCache(cache.NewFakeServiceAccountCache()),
+				WithContainerCredentialsConfig(&containercredentials.FakeConfig{}),
+				WithFailOnMissingServiceAccount(c.failOnMissing),
+			)
+
+			pod := &corev1.Pod{}
+			if err := json.Unmarshal(rawPodWithoutVolume, pod); err != nil {
+				t.Fatalf("Failed to unmarshal pod: %v", err)
+			}
+			pod.Annotations = c.podAnnotations
+			podBytes, err := json.Marshal(pod)
+			if err != nil {
+				t.Fatalf("Failed to marshal pod: %v", err)
+			}
+
+			response := modifier.MutatePod(getValidReview(podBytes))
+			assert.NotNil(t, response)
+			assert.Equal(t, c.expectAllowed, response.Allowed)
+			assert.Nil(t, response.Patch)
+			if !c.expectAllowed {
+				assert.Contains(t, response.Result.Message, "default/default")
+			}
+		})
+	}
+}
+
+func TestMutatePod_Warnings(t *testing.T) {
+	testServiceAccount := &corev1.ServiceAccount{}
+	testServiceAccount.Name = "default"
+	testServiceAccount.Namespace = "default"
+	testServiceAccount.Annotations = map[string]string{
+		"eks.amazonaws.com/role-arn": "s3-reader",
+	}
+
+	modifier := NewModifier(
+		WithServiceAccountCache(cache.NewFakeServiceAccountCache(testServiceAccount)),
+		WithContainerCredentialsConfig(&containercredentials.FakeConfig{}),
+	)
+
+	pod := &corev1.Pod{}
+	if err := json.Unmarshal(rawPodWithoutVolume, pod); err != nil {
+		t.Fatalf("Failed to unmarshal pod: %v", err)
+	}
+	pod.Annotations = map[string]string{"eks.amazonaws.com/token-expiration": "100"}
+	pod.Spec.Containers[0].Env = []corev1.EnvVar{{Name: "AWS_ROLE_ARN", Value: "arn:aws:iam::111122223333:role/other"}}
+	podBytes, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatalf("Failed to marshal pod: %v", err)
+	}
+
+	response := modifier.MutatePod(getValidReview(podBytes))
+	assert.True(t, response.Allowed)
+	assert.Equal(t, []string{
+		`annotation eks.amazonaws.com/token-expiration value 100 is below the minimum, using 600 seconds`,
+		`service account default/default has invalid role ARN "s3-reader"`,
+		`container balajilovesoreos already sets AWS_ROLE_ARN to "arn:aws:iam::111122223333:role/other", the webhook did not inject "s3-reader"`,
+	}, response.Warnings)
+}
+
+func TestMutatePod_ClampTokenExpiration(t *testing.T) {
+	testServiceAccount := &corev1.ServiceAccount{}
+	testServiceAccount.Name = "default"
+	testServiceAccount.Namespace = "default"
+	testServiceAccount.Annotations = map[string]string{
+		"eks.amazonaws.com/role-arn":         "arn:aws:iam::111122223333:role/s3-reader",
+		"eks.amazonaws.com/token-expiration": "100",
+	}
+
+	modifier := NewModifier(
+		WithServiceAccountCache(cache.NewFakeServiceAccountCache(testServiceAccount)),
+		WithContainerCredentialsConfig(&containercredentials.FakeConfig{}),
+	)
+
+	response := modifier.MutatePod(getValidReview(rawPodWithoutVolume))
+	assert.True(t, response.Allowed)
+	assert.Equal(t, []string{
+		`service account default/default annotation eks.amazonaws.com/token-expiration value 100 is below the minimum, using 600 seconds`,
+	}, response.Warnings)
+	assert.Contains(t, string(response.Patch), `"expirationSeconds":600`)
+}
+
+func TestClampTokenExpiration(t *testing.T) {
+	clamped := testutil.ToFloat64(tokenExpirationClampedCounter.WithLabelValues(tokenExpirationSourcePodAnnotation))
+
+	expiration, warnings := clampTokenExpiration(3600, tokenExpirationSourcePodAnnotation, "annotation eks.amazonaws.com/token-expiration")
+	assert.Equal(t, int64(3600), expiration)
+	assert.Empty(t, warnings)
+
+	expiration, warnings = clampTokenExpiration(604800, tokenExpirationSourcePodAnnotation, "annotation eks.amazonaws.com/token-expiration")
+	assert.Equal(t, int64(86400), expiration)
+	assert.Equal(t, []string{"annotation eks.amazonaws.com/token-expiration value 604800 is above the maximum, using 86400 seconds"}, warnings)
+	assert.Equal(t, clamped+1, testutil.ToFloat64(tokenExpirationClampedCounter.WithLabelValues(tokenExpirationSourcePodAnnotation)))
+}
+
+func TestMutatePod_RejectInvalidRoleARN(t *testing.T) {
+	testServiceAccount := &corev1.ServiceAccount{}
+	testServiceAccount.Name = "default"
+	testServiceAccount.Namespace = "default"
+	testServiceAccount.Annotations = map[string]string{
+		"eks.amazonaws.com/role-arn": "s3-reader",
+	}
+	recorder := record.NewFakeRecorder(10)
+
+	modifier := NewModifier(
+		WithServiceAccountCache(cache.NewFakeServiceAccountCache(testServiceAccount)),
+		WithContainerCredentialsConfig(&containercredentials.FakeConfig{}),
+		WithRejectInvalidRoleARN(true),
+		WithEventRecorder(recorder),
+	)
+
+	response := modifier.MutatePod(getValidReview(rawPodWithoutVolume))
+	assert.True(t, response.Allowed)
+	assert.Nil(t, response.Patch)
+	assert.Equal(t, []string{
+		`service account default/default has invalid role ARN "s3-reader", the webhook did not inject it`,
+	}, response.Warnings)
+	assert.Equal(t, `Warning InvalidRoleARN Service account default/default has invalid role ARN "s3-reader", it was not injected into pod balajilovesoreos`, <-recorder.Events)
+
+	testServiceAccount.Annotations["eks.amazonaws.com/role-arn"] = "arn:aws:iam::111122223333:role/s3-reader"
+	modifier.Cache = cache.NewFakeServiceAccountCache(testServiceAccount)
+	response = modifier.MutatePod(getValidReview(rawPodWithoutVolume))
+	assert.True(t, response.Allowed)
+	assert.NotNil(t, response.Patch)
+	assert.Empty(t, response.Warnings)
+}
+
+func TestMutatePod_TokenVolumeAndFileName(t *testing.T) {
+	testServiceAccount := &corev1.ServiceAccount{}
+	testServiceAccount.Name = "default"
+	testServiceAccount.Namespace = "default"
+	testServiceAccount.Annotations = map[string]string{
+		"eks.amazonaws.com/role-arn": "arn:aws:iam::111122223333:role/s3-reader",
+	}
+
+	modifier := NewModifier(
+		WithServiceAccountCache(cache.NewFakeServiceAccountCache(testServiceAccount)),
+		WithContainerCredentialsConfig(&containercredentials.FakeConfig{}),
+		WithTokenVolumeName("irsa-token"),
+		WithTokenFileName("web-identity-token"),
+	)
+
+	response := modifier.MutatePod(getValidReview(rawPodWithoutVolume))
+	assert.True(t, response.Allowed)
+	patch := string(response.Patch)
+	assert.Contains(t, patch, `"name":"irsa-token"`)
+	assert.Contains(t, patch, `"path":"web-identity-token"`)
+	assert.Contains(t, patch, `"value":"/var/run/secrets/eks.amazonaws.com/serviceaccount/web-identity-token"`)
+	assert.NotContains(t, patch, "aws-iam-token")
+}
+
+func TestMutatePod_TokenFileNameAnnotation(t *testing.T) {
+	testServiceAccount := &corev1.ServiceAccount{}
+	testServiceAccount.Name = "default"
+	testServiceAccount.Namespace = "default"
+	testServiceAccount.Annotations = map[string]string{
+		"eks.amazonaws.com/role-arn":        "arn:aws:iam::111122223333:role/s3-reader",
+		"eks.amazonaws.com/token-file-name": "web-identity-token",
+	}
+
+	modifier := NewModifier(
+		WithServiceAccountCache(cache.NewFakeServiceAccountCache(testServiceAccount)),
+		WithContainerCredentialsConfig(&containercredentials.FakeConfig{}),
+	)
+
+	response := modifier.MutatePod(getValidReview(rawPodWithoutVolume))
+	assert.True(t, response.Allowed)
+	patch := string(response.Patch)
+	assert.Contains(t, patch, `"path":"web-identity-token"`)
+	assert.Contains(t, patch, `"value":"/var/run/secrets/eks.amazonaws.com/serviceaccount/web-identity-token"`)
+}
+
+func TestMutatePod_AWSConfigFile(t *testing.T) {
+	testServiceAccount := &corev1.ServiceAccount{}
+	testServiceAccount.Name = "default"
+	testServiceAccount.Namespace = "default"
+	testServiceAccount.Annotations = map[string]string{
+		"eks.amazonaws.com/role-arn": "arn:aws:iam::111122223333:role/s3-reader",
+	}
+
+	modifier := NewModifier(
+		WithServiceAccountCache(cache.NewFakeServiceAccountCache(testServiceAccount)),
+		WithContainerCredentialsConfig(&containercredentials.FakeConfig{}),
+		WithInjectAWSConfigFile(true),
+	)
+
+	response := modifier.MutatePod(getValidReview(rawPodWithoutVolume))
+	assert.True(t, response.Allowed)
+	var patch []patchOperation
+	assert.NoError(t, json.Unmarshal(response.Patch, &patch))
+	assert.Contains(t, patch, patchOperation{
+		Op:   "add",
+		Path: "/metadata/annotations",
+		Value: map[string]interface{}{
+			"eks.amazonaws.com/aws-config": "[default]\nrole_arn = arn:aws:iam::111122223333:role/s3-reader\nweb_identity_token_file = /var/run/secrets/eks.amazonaws.com/serviceaccount/token\n",
+		},
+	})
+	assert.Contains(t, string(response.Patch), `"fieldRef":{"fieldPath":"metadata.annotations['eks.amazonaws.com/aws-config']"}`)
+	assert.Contains(t, string(response.Patch), `{"name":"AWS_CONFIG_FILE","value":"/var/run/secrets/eks.amazonaws.com/serviceaccount/config"}`)
+}
+
+func TestMutatePod_TokenWaitInitContainer(t *testing.T) {
+	testServiceAccount := &corev1.ServiceAccount{}
+	testServiceAccount.Name = "default"
+	testServiceAccount.Namespace = "default"
+	testServiceAccount.Annotations = map[string]string{
+		"eks.amazonaws.com/role-arn": "arn:aws:iam::111122223333:role/s3-reader",
+	}
+
+	modifier := NewModifier(
+		WithServiceAccountCache(cache.NewFakeServiceAccountCache(testServiceAccount)),
+		WithContainerCredentialsConfig(&containercredentials.FakeConfig{}),
+		WithTokenWaitInitContainer("webhook:latest", time.Minute),
+	)
+
+	response := modifier.MutatePod(getValidReview(rawPodWithoutVolume))
+	assert.True(t, response.Allowed)
+	var patch []struct {
+		Path  string
+		Value json.RawMessage
+	}
+	assert.NoError(t, json.Unmarshal(response.Patch, &patch))
+	var initContainers []corev1.Container
+	for _, operation := range patch {
+		if operation.Path == "/spec/initContainers" {
+			assert.NoError(t, json.Unmarshal(operation.Value, &initContainers))
+		}
+	}
+	if assert.Len(t, initContainers, 1) {
+		container := initContainers[0]
+		assert.Equal(t, "aws-iam-token-wait", container.Name)
+		assert.Equal(t, "webhook:latest", container.Image)
+		assert.Equal(t, []string{"/webhook", "wait-for-token", "--timeout=1m0s"}, container.Command)
+		assert.Contains(t, container.Env, corev1.EnvVar{Name: "AWS_ROLE_ARN", Value: "arn:aws:iam::111122223333:role/s3-reader"})
+		assert.Contains(t, container.Env, corev1.EnvVar{Name: "AWS_WEB_IDENTITY_TOKEN_FILE", Value: "/var/run/secrets/eks.amazonaws.com/serviceaccount/token"})
+		assert.Equal(t, "aws-iam-token", container.VolumeMounts[0].Name)
+	}
+}
+
+func TestMutatePod_ReinvocationMarker(t *testing.T) {
+	testServiceAccount := &corev1.ServiceAccount{}
+	testServiceAccount.Name = "default"
+	testServiceAccount.Namespace = "default"
+	testServiceAccount.Annotations = map[string]string{
+		"eks.amazonaws.com/role-arn": "arn:aws:iam::111122223333:role/s3-reader",
+	}
+
+	modifier := NewModifier(
+		WithServiceAccountCache(cache.NewFakeServiceAccountCache(testServiceAccount)),
+		WithContainerCredentialsConfig(&containercredentials.FakeConfig{}),
+		WithReinvocationMarker(true),
+	)
+
+	response := modifier.MutatePod(getValidReview(rawPodWithoutVolume))
+	assert.True(t, response.Allowed)
+	var patch []struct {
+		Path  string
+		Value json.RawMessage
+	}
+	assert.NoError(t, json.Unmarshal(response.Patch, &patch))
+
+	// Apply the patch like the API server would
+	pod := &corev1.Pod{}
+	assert.NoError(t, json.Unmarshal(rawPodWithoutVolume, pod))
+	for _, operation := range patch {
+		switch operation.Path {
+		case "/spec/volumes":
+			assert.NoError(t, json.Unmarshal(operation.Value, &pod.Spec.Volumes))
+		case "/spec/containers":
+			assert.NoError(t, json.Unmarshal(operation.Value, &pod.Spec.Containers))
+		case "/metadata/annotations":
+			assert.NoError(t, json.Unmarshal(operation.Value, &pod.Annotations))
+		}
+	}
+	assert.Len(t, pod.Annotations["eks.amazonaws.com/pod-identity-injected"], 16)
+	pod.Spec.Containers[0].ImagePullPolicy = corev1.PullIfNotPresent
+	podBytes, err := json.Marshal(pod)
+	assert.NoError(t, err)
+
+	response = modifier.MutatePod(getValidReview(podBytes))
+	assert.True(t, response.Allowed)
+	assert.Empty(t, response.Patch, "reinvocation of an unchanged pod")
+
+	pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: "sidecar", Image: "sidecar"})
+	podBytes, err = json.Marshal(pod)
+	assert.NoError(t, err)
+
+	response = modifier.MutatePod(getValidReview(podBytes))
+	assert.True(t, response.Allowed)
+	assert.Contains(t, string(response.Patch), `"name":"sidecar"`)
+	assert.Contains(t, string(response.Patch), `"path":"/metadata/annotations/eks.amazonaws.com~1pod-identity-injected"`)
+}
+
+func TestGetAWSConfigFilePath(t *testing.T) {
+	modifier := NewModifier(WithInjectAWSConfigFile(true))
+	for _, tc := range []struct {
+		name            string
+		annotation      string
+		skipTokenVolume bool
+		tokenFileName   string
+		expected        string
+		warnings        []string
+	}{
+		{name: "flag", tokenFileName: "token", expected: "/var/run/secrets/eks.amazonaws.com/serviceaccount/config"},
+		{name: "pod opt out", annotation: "false", tokenFileName: "token"},
+		{name: "skip token volume", skipTokenVolume: true, tokenFileName: "token"},
+		{
+			name:          "token file name conflict",
+			tokenFileName: "config",
+			warnings:      []string{`token file name "config" is the name of the AWS config file, which was not injected`},
+		},
+		{
+			name:          "invalid annotation",
+			annotation:    "yes",
+			tokenFileName: "token",
+			expected:      "/var/run/secrets/eks.amazonaws.com/serviceaccount/config",
+			warnings:      []string{`annotation eks.amazonaws.com/inject-aws-config-file has invalid value "yes", using true`},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pod := &corev1.Pod{}
+			if tc.annotation != "" {
+				pod.Annotations = map[string]string{"eks.amazonaws.com/inject-aws-config-file": tc.annotation}
+			}
+			path, warnings := modifier.getAWSConfigFilePath(pod, tc.skipTokenVolume, tc.tokenFileName)
+			assert.Equal(t, tc.expected, path)
+			assert.Equal(t, tc.warnings, warnings)
+		})
+	}
+}
+
+func TestGetTokenFileName(t *testing.T) {
+	modifier := NewModifier(WithTokenFileName("jwt"))
+	request := cache.Request{Namespace: "default", Name: "default"}
+	for _, tc := range []struct {
+		value    string
+		expected string
+		warnings []string
+	}{
+		{value: "", expected: "jwt"},
+		{value: "web-identity-token", expected: "web-identity-token"},
+		{value: "..", expected: "jwt", warnings: []string{`service account default/default has invalid token file name "..", using "jwt"`}},
+		{value: "dir/token", expected: "jwt", warnings: []string{`service account default/default has invalid token file name "dir/token", using "jwt"`}},
+	} {
+		t.Run(tc.value, func(t *testing.T) {
+			tokenFileName, warnings := modifier.getTokenFileName(request, tc.value)
+			assert.Equal(t, tc.expected, tokenFileName)
+			assert.Equal(t, tc.warnings, warnings)
+		})
+	}
+}
+
+func TestJitterTokenExpiration(t *testing.T) {
+	modifier := NewModifier()
+	assert.Equal(t, int64(3600), modifier.jitterTokenExpiration(3600))
+
+	modifier = NewModifier(WithTokenExpirationJitter(600))
+	for i := 0; i < 100; i++ {
+		expiration := modifier.jitterTokenExpiration(3600)
+		assert.GreaterOrEqual(t, expiration, int64(3000))
+		assert.LessOrEqual(t, expiration, int64(3600))
+		assert.Equal(t, int64(600), modifier.jitterTokenExpiration(600))
+	}
+}
+
+func TestInsertEnv(t *testing.T) {
+	injected := []corev1.EnvVar{{Name: "AWS_ROLE_ARN", Value: "arn"}, {Name: "AWS_WEB_IDENTITY_TOKEN_FILE", Value: "token"}}
+	env := []corev1.EnvVar{{Name: "A", Value: "a"}, {Name: "ROLE", Value: "$(AWS_ROLE_ARN)"}, {Name: "C", Value: "$(AWS_WEB_IDENTITY_TOKEN_FILE)"}}
+	names := func(env []corev1.EnvVar) []string {
+		var names []string
+		for _, envVar := range env {
+			names = append(names, envVar.Name)
+		}
+		return names
+	}
+
+	cases := []struct {
+		position string
+		env      []corev1.EnvVar
+		expected []string
+	}{
+		{EnvVarPositionAppend, env, []string{"A", "ROLE", "C", "AWS_ROLE_ARN", "AWS_WEB_IDENTITY_TOKEN_FILE"}},
+		{EnvVarPositionPrepend, env, []string{"AWS_ROLE_ARN", "AWS_WEB_IDENTITY_TOKEN_FILE", "A", "ROLE", "C"}},
+		{EnvVarPositionBeforeReference, env, []string{"A", "AWS_ROLE_ARN", "AWS_WEB_IDENTITY_TOKEN_FILE", "ROLE", "C"}},
+		{EnvVarPositionBeforeReference, env[:1], []string{"A", "AWS_ROLE_ARN", "AWS_WEB_IDENTITY_TOKEN_FILE"}},
+		{EnvVarPositionPrepend, nil, []string{"AWS_ROLE_ARN", "AWS_WEB_IDENTITY_TOKEN_FILE"}},
+	}
+	for _, c := range cases {
+		t.Run(c.position, func(t *testing.T) {
+			original := slices.Clone(c.env)
+			assert.Equal(t, c.expected, names(insertEnv(c.env, injected, c.position)))
+			assert.Equal(t, original, c.env)
+		})
+	}
+	assert.Equal(t, env, insertEnv(env, nil, EnvVarPositionPrepend))
+}
+
+func TestGetEnvVarPosition(t *testing.T) {
+	modifier := NewModifier(WithEnvVarPosition(EnvVarPositionPrepend))
+	pod := &corev1.Pod{}
+	position, warnings := modifier.getEnvVarPosition(pod)
+	assert.Equal(t, EnvVarPositionPrepend, position)
+	assert.Empty(t, warnings)
+
+	pod.Annotations = map[string]string{"eks.amazonaws.com/env-var-position": EnvVarPositionBeforeReference}
+	position, warnings = modifier.getEnvVarPosition(pod)
+	assert.Equal(t, EnvVarPositionBeforeReference, position)
+	assert.Empty(t, warnings)
+
+	pod.Annotations["eks.amazonaws.com/env-var-position"] = "first"
+	position, warnings = modifier.getEnvVarPosition(pod)
+	assert.Equal(t, EnvVarPositionPrepend, position)
+	assert.Equal(t, []string{`annotation eks.amazonaws.com/env-var-position has invalid value "first", using "prepend"`}, warnings)
+}
+
+type fakeAuditLogWriter struct {
+	records []auditlog.Record
+}
+
+func (w *fakeAuditLogWriter) Write(records []auditlog.Record) error {
+	w.records = append(w.records, records...)
+	return nil
+}
+
+func (w *fakeAuditLogWriter) Close() error {
+	return nil
+}
+
+func TestMutatePod_AuditLog(t *testing.T) {
+	testServiceAccount := &corev1.ServiceAccount{}
+	testServiceAccount.Name = "default"
+	testServiceAccount.Namespace = "default"
+	testServiceAccount.Annotations = map[string]string{
+		"eks.amazonaws.com/role-arn": "arn:aws:iam::111122223333:role/s3-reader",
+		"eks.amazonaws.com/audience": "sts.amazonaws.com",
+	}
+	writer := &fakeAuditLogWriter{}
+	logger := auditlog.NewLogger(writer, auditlog.DefaultBufferSize)
+	logger.Start()
+
+	modifier := NewModifier(
+		WithServiceAccountCache(cache.NewFakeServiceAccountCache(testServiceAccount)),
+		WithContainerCredentialsConfig(&containercredentials.FakeConfig{}),
+		WithAuditLogger(logger),
+	)
+	response := modifier.MutatePod(getValidReview(rawPodWithoutVolume))
+	assert.True(t, response.Allowed)
+
+	pod := &corev1.Pod{}
+	if err := json.Unmarshal(rawPodWithoutVolume, pod); err != nil {
+		t.Fatalf("Failed to unmarshal pod: %v", err)
+	}
+	pod.Spec.ServiceAccountName = "missing"
+	podBytes, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatalf("Failed to marshal pod: %v", err)
+	}
+	response = modifier.MutatePod(getValidReview(podBytes))
+	assert.True(t, response.Allowed)
+
+	assert.NoError(t, logger.Close())
+	if !assert.Len(t, writer.records, 2) {
+		return
+	}
+	mutated, skipped := writer.records[0], writer.records[1]
+	assert.False(t, mutated.Time.IsZero())
+	assert.Equal(t, "default", mutated.Namespace)
+	assert.Equal(t, "balajilovesoreos", mutated.Pod)
+	assert.Equal(t, "default", mutated.ServiceAccount)
+	assert.Equal(t, "mutated", mutated.Outcome)
+	assert.Equal(t, "sts_web_identity", mutated.CredentialMethod)
+	assert.Equal(t, "arn:aws:iam::111122223333:role/s3-reader", mutated.RoleARN)
+	assert.Equal(t, "sts.amazonaws.com", mutated.Audience)
+	assert.Contains(t, mutated.Patch, "add /spec/volumes")
+
+	assert.Equal(t, "missing", skipped.ServiceAccount)
+	assert.Equal(t, "skipped", skipped.Outcome)
+	assert.Equal(t, "Service account did not have the right annotations or was not found in the cache", skipped.Reason)
+	assert.Empty(t, skipped.RoleARN)
+	assert.Empty(t, skipped.Patch)
+}
+
+func TestParsePodAnnotations_FallbackDomains(t *testing.T) {
+	modifier := NewModifier(
+		WithAnnotationDomain("eks.amazonaws.com"),
+		WithFallbackAnnotationDomains("iam.example.com"),
+	)
+	pod := &corev1.Pod{}
+	pod.Annotations = map[string]string{
+		"eks.amazonaws.com/token-expiration": "3600",
+		"iam.example.com/token-expiration":   "7200",
+		"iam.example.com/skip-containers":    "sidecar",
+	}
+
+	tokenExpiration, containersToSkip, warnings := modifier.parsePodAnnotations(pod, 86400)
+	assert.Equal(t, int64(3600), tokenExpiration, "the primary domain should take precedence")
+	assert.Equal(t, map[string]bool{"sidecar": true}, containersToSkip)
+	assert.Empty(t, warnings)
+
+	pod.Annotations = map[string]string{"iam.example.com/fail-on-missing-service-account": "true"}
+	assert.True(t, modifier.shouldFailOnMissingServiceAccount(pod))
+}
+
+func TestMutatePod_Events(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	modifier := NewModifier(
+		WithServiceAccountCache(cache.NewFakeServiceAccountCache()),
+		WithContainerCredentialsConfig(&containercredentials.FakeConfig{}),
+		WithEventRecorder(recorder),
+	)
+
+	response := modifier.MutatePod(getValidReview(rawPodWithoutVolume))
+	assert.True(t, response.Allowed)
+	select {
+	case event := <-recorder.Events:
+		assert.Equal(t, "Warning ServiceAccountNotFound Service account default/default not found in the cache, pod balajilovesoreos was not mutated", event)
+	default:
+		t.Error("Expected an event to be recorded")
+	}
+}
+
+func TestMutatePod_SALookupGracePeriodTimeout(t *testing.T) {
+	modifier := NewModifier(
+		WithServiceAccountCache(cache.NewFakeServiceAccountCache()),
+		WithContainerCredentialsConfig(&containercredentials.FakeConfig{}),
+		WithSALookupGraceTime(10*time.Millisecond),
+	)
+	timeouts := testutil.ToFloat64(saLookupWaitCounter.WithLabelValues("timeout"))
+
+	response := modifier.MutatePod(getValidReview(rawPodWithoutVolume))
+	assert.True(t, response.Allowed)
+	assert.Nil(t, response.Patch)
+	assert.Equal(t, timeouts+1, testutil.ToFloat64(saLookupWaitCounter.WithLabelValues("timeout")))
+}
+
+// recordingServiceAccountCache records the requests of the lookups
+type recordingServiceAccountCache struct {
+	*cache.FakeServiceAccountCache
+	requests []cache.Request
+}
+
+func (c *recordingServiceAccountCache) Get(req cache.Request) cache.Response {
+	c.requests = append(c.requests, req)
+	return c.FakeServiceAccountCache.Get(req)
+}
+
+func TestMutatePod_SynchronousSALookup(t *testing.T) {
+	saCache := &recordingServiceAccountCache{FakeServiceAccountCache: cache.NewFakeServiceAccountCache()}
+	modifier := NewModifier(
+		WithServiceAccountCache(saCache),
+		WithContainerCredentialsConfig(&containercredentials.FakeConfig{}),
+		WithSALookupGraceTime(time.Minute),
+		WithSynchronousSALookup(true),
+	)
+	timeouts := testutil.ToFloat64(saLookupWaitCounter.WithLabelValues("timeout"))
+
+	response := modifier.MutatePod(getValidReview(rawPodWithoutVolume))
 	assert.True(t, response.Allowed)
 	assert.Nil(t, response.Patch)
+	assert.Equal(t, timeouts, testutil.ToFloat64(saLookupWaitCounter.WithLabelValues("timeout")), "synchronous lookups should not wait to be notified")
+	if assert.NotEmpty(t, saCache.requests) {
+		req := saCache.requests[0]
+		assert.False(t, req.RequestNotification)
+		_, ok := req.Context.Deadline()
+		assert.True(t, ok, "synchronous lookups should be bounded by the grace period")
+	}
+}
+
+func TestMutatePod_NativeSidecars(t *testing.T) {
+	testServiceAccount := &corev1.ServiceAccount{}
+	testServiceAccount.Name = "default"
+	testServiceAccount.Namespace = "default"
+	testServiceAccount.Annotations = map[string]string{
+		"eks.amazonaws.com/role-arn": "arn:aws:iam::111122223333:role/s3-reader",
+	}
+	always := corev1.ContainerRestartPolicyAlways
+	pod := &corev1.Pod{}
+	if err := json.Unmarshal(rawPodWithoutVolume, pod); err != nil {
+		t.Fatalf("Failed to unmarshal pod: %v", err)
+	}
+	pod.Spec.InitContainers = []corev1.Container{
+		{Name: "init", Image: "amazonlinux"},
+		{Name: "sidecar", Image: "amazonlinux", RestartPolicy: &always},
+	}
+	podBytes, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatalf("Failed to marshal pod: %v", err)
+	}
+
+	cases := []struct {
+		caseName       string
+		nativeSidecars string
+		expected       map[string]float64
+	}{
+		{
+			caseName:       "Container",
+			nativeSidecars: NativeSidecarsContainer,
+			expected:       map[string]float64{containerTypeContainer: 1, containerTypeInitContainer: 0, containerTypeSidecar: 1},
+		},
+		{
+			caseName:       "InitContainer",
+			nativeSidecars: NativeSidecarsInitContainer,
+			expected:       map[string]float64{containerTypeContainer: 1, containerTypeInitContainer: 0, containerTypeSidecar: 0},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.caseName, func(t *testing.T) {
+			modifier := NewModifier(
+				WithServiceAccountCache(cache.NewFakeServiceAccountCache(testServiceAccount)),
+				WithContainerCredentialsConfig(&containercredentials.FakeConfig{}),
+				WithSkipInitContainers(true),
+				WithNativeSidecars(c.nativeSidecars),
+			)
+			before := map[string]float64{}
+			for containerType := range c.expected {
+				before[containerType] = testutil.ToFloat64(mutatedContainerCount.WithLabelValues(containerType))
+			}
+
+			response := modifier.MutatePod(getValidReview(podBytes))
+			assert.True(t, response.Allowed)
+			for containerType, count := range c.expected {
+				assert.Equal(t, before[containerType]+count, testutil.ToFloat64(mutatedContainerCount.WithLabelValues(containerType)), containerType)
+			}
+		})
+	}
+}
+
+func TestMutatePod_EmptyServiceAccountName(t *testing.T) {
+	testServiceAccount := &corev1.ServiceAccount{}
+	testServiceAccount.Name = "default"
+	testServiceAccount.Namespace = "default"
+	testServiceAccount.Annotations = map[string]string{
+		"eks.amazonaws.com/role-arn": "arn:aws:iam::111122223333:role/s3-reader",
+	}
+	pod := &corev1.Pod{}
+	if err := json.Unmarshal(rawPodWithoutVolume, pod); err != nil {
+		t.Fatalf("Failed to unmarshal pod: %v", err)
+	}
+	pod.Spec.ServiceAccountName = ""
+	podBytes, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatalf("Failed to marshal pod: %v", err)
+	}
+
+	cases := []struct {
+		caseName                   string
+		serviceAccountCache        cache.ServiceAccountCache
+		containerCredentialsConfig containercredentials.Config
+		expectedEnv                string
+	}{
+		{
+			caseName:                   "WebIdentity",
+			serviceAccountCache:        cache.NewFakeServiceAccountCache(testServiceAccount),
+			containerCredentialsConfig: &containercredentials.FakeConfig{},
+			expectedEnv:                "AWS_ROLE_ARN",
+		},
+		{
+			caseName:            "ContainerCredentials",
+			serviceAccountCache: cache.NewFakeServiceAccountCache(),
+			containerCredentialsConfig: &containercredentials.FakeConfig{
+				Audience:   "pods.eks.amazonaws.com",
+				MountPath:  "/var/run/secrets/pods.eks.amazonaws.com/serviceaccount",
+				VolumeName: "eks-pod-identity-token",
+				TokenPath:  "eks-pod-identity-token",
+				FullUri:    "http://169.254.170.23/v1/credentials",
+				Identities: map[containercredentials.Identity]bool{
+					{Namespace: "default", ServiceAccount: "default"}: true,
+				},
+			},
+			expectedEnv: "AWS_CONTAINER_CREDENTIALS_FULL_URI",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.caseName, func(t *testing.T) {
+			modifier := NewModifier(
+				WithServiceAccountCache(c.serviceAccountCache),
+				WithContainerCredentialsConfig(c.containerCredentialsConfig),
+			)
+			response := modifier.MutatePod(getValidReview(podBytes))
+			assert.True(t, response.Allowed)
+			assert.Contains(t, string(response.Patch), c.expectedEnv)
+		})
+	}
+}
+
+func TestIsWindowsPod(t *testing.T) {
+	windowsTerm := corev1.NodeSelectorTerm{MatchExpressions: []corev1.NodeSelectorRequirement{
+		{Key: "kubernetes.io/arch", Operator: corev1.NodeSelectorOpIn, Values: []string{"amd64"}},
+		{Key: "kubernetes.io/os", Operator: corev1.NodeSelectorOpIn, Values: []string{"windows"}},
+	}}
+	linuxTerm := corev1.NodeSelectorTerm{MatchExpressions: []corev1.NodeSelectorRequirement{
+		{Key: "kubernetes.io/os", Operator: corev1.NodeSelectorOpIn, Values: []string{"linux"}},
+	}}
+	affinity := func(terms ...corev1.NodeSelectorTerm) *corev1.Affinity {
+		return &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: terms},
+		}}
+	}
+
+	cases := []struct {
+		caseName string
+		spec     corev1.PodSpec
+		expected bool
+	}{
+		{"Default", corev1.PodSpec{}, false},
+		{"OSWindows", corev1.PodSpec{OS: &corev1.PodOS{Name: corev1.Windows}}, true},
+		{"OSLinux", corev1.PodSpec{OS: &corev1.PodOS{Name: corev1.Linux}, NodeSelector: map[string]string{"kubernetes.io/os": "windows"}}, false},
+		{"NodeSelector", corev1.PodSpec{NodeSelector: map[string]string{"kubernetes.io/os": "windows"}}, true},
+		{"BetaNodeSelector", corev1.PodSpec{NodeSelector: map[string]string{"beta.kubernetes.io/os": "windows"}}, true},
+		{"NodeAffinity", corev1.PodSpec{Affinity: affinity(windowsTerm)}, true},
+		{"NodeAffinityAnyOS", corev1.PodSpec{Affinity: affinity(windowsTerm, linuxTerm)}, false},
+		{"NodeAffinityNoTerms", corev1.PodSpec{Affinity: affinity()}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.caseName, func(t *testing.T) {
+			assert.Equal(t, c.expected, isWindowsPod(&corev1.Pod{Spec: c.spec}))
+		})
+	}
 }
 
 var jsonPatchType = v1beta1.PatchType("JSONPatch")
@@ -140,8 +1358,13 @@ var validPatchIfNoVolumesPresent = []byte(`[{"op":"add","path":"/spec/volumes","
 
 func getValidHandlerResponse(uuid string) *v1beta1.AdmissionResponse {
 	return &v1beta1.AdmissionResponse{
-		UID:       types.UID(uuid),
-		Allowed:   true,
+		UID:     types.UID(uuid),
+		Allowed: true,
+		AuditAnnotations: map[string]string{
+			"audience":          "sts.amazonaws.com",
+			"credential-method": "sts_web_identity",
+			"role-arn":          "arn:aws:iam::111122223333:role/s3-reader",
+		},
 		Patch:     validPatchIfNoVolumesPresent,
 		PatchType: &jsonPatchType,
 	}
@@ -225,19 +1448,13 @@ func TestModifierHandler(t *testing.T) {
 			"BadContentType",
 			serializeAdmissionReview(t, &v1beta1.AdmissionReview{Request: nil}),
 			"application/xml",
-			[]byte("Invalid Content-Type, expected `application/json`\n"),
+			[]byte("Invalid Content-Type, expected `application/json` or `application/vnd.kubernetes.protobuf`\n"),
 		},
 		{
 			"InvalidJSON",
 			[]byte(`{"request": {"object": "\"metadata\":{\"name\":\"fake\""}`),
 			"application/json",
-			[]byte(`{"response":{"uid":"","allowed":false,"status":{"metadata":{},"message":"couldn't get version/kind; json parse error: unexpected end of JSON input"}}}`),
-		},
-		{
-			"InvalidPodBytes",
-			[]byte(`{"request": {"object": "\"metadata\":{\"name\":\"fake\""}}`),
-			"application/json",
-			[]byte(`{"response":{"uid":"","allowed":false,"status":{"metadata":{},"message":"json: cannot unmarshal string into Go value of type v1.Pod"}}}`),
+			[]byte(`{"response":{"uid":"","allowed":false,"status":{"metadata":{},"message":"unexpected end of JSON input"}}}`),
 		},
 		{
 			"ValidRequestSuccessWithoutVolumes",
@@ -274,3 +1491,79 @@ func TestModifierHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestModifierHandler_InvalidPod(t *testing.T) {
+	modifier := NewModifier(
+		WithServiceAccountCache(cache.NewFakeServiceAccountCache()),
+		WithContainerCredentialsConfig(&containercredentials.FakeConfig{}),
+	)
+	ts := httptest.NewServer(http.HandlerFunc(modifier.Handle))
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL, "application/json", bytes.NewBufferString(`{"request": {"object": "\"metadata\":{\"name\":\"fake\""}}`))
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+	review := &v1beta1.AdmissionReview{}
+	if err := json.NewDecoder(resp.Body).Decode(review); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if review.Response == nil || review.Response.Result == nil {
+		t.Fatalf("Expected a response with a result, got %+v", review)
+	}
+	assert.False(t, review.Response.Allowed)
+	// The rest of the message is worded by encoding/json, and changes between
+	// Go releases
+	assert.Contains(t, review.Response.Result.Message, "cannot unmarshal string")
+}
+
+func TestModifierHandler_MaxRequestBodyBytes(t *testing.T) {
+	modifier := NewModifier(
+		WithServiceAccountCache(cache.NewFakeServiceAccountCache()),
+		WithContainerCredentialsConfig(&containercredentials.FakeConfig{}),
+		WithMaxRequestBodyBytes(10),
+	)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewBuffer(serializeAdmissionReview(t, getValidReview(rawPodWithoutVolume))))
+	request.Header.Set("Content-Type", "application/json")
+	modifier.Handle(recorder, request)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code)
+	assert.Equal(t, "request body exceeds 10 bytes\n", recorder.Body.String())
+}
+
+func TestModifierHandler_Protobuf(t *testing.T) {
+	testServiceAccount := &corev1.ServiceAccount{}
+	testServiceAccount.Name = "default"
+	testServiceAccount.Namespace = "default"
+	testServiceAccount.Annotations = map[string]string{
+		"eks.amazonaws.com/role-arn":         "arn:aws:iam::111122223333:role/s3-reader",
+		"eks.amazonaws.com/token-expiration": "3600",
+	}
+
+	modifier := NewModifier(
+		WithServiceAccountCache(cache.NewFakeServiceAccountCache(testServiceAccount)),
+		WithContainerCredentialsConfig(&containercredentials.FakeConfig{}),
+	)
+
+	body := &bytes.Buffer{}
+	if err := encodeAdmissionReview(body, runtime.ContentTypeProtobuf, getValidReview(rawPodWithoutVolume)); err != nil {
+		t.Fatalf("Failed to encode request: %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/mutate", body)
+	request.Header.Set("Content-Type", runtime.ContentTypeProtobuf)
+	modifier.Handle(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, runtime.ContentTypeProtobuf, recorder.Header().Get("Content-Type"))
+
+	got := v1beta1.AdmissionReview{}
+	if _, _, err := deserializer.Decode(recorder.Body.Bytes(), nil, &got); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	assert.Equal(t, getValidHandlerResponse(uuid), got.Response)
+}
@@ -0,0 +1,51 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// HealthCheck is a named check returning an error when the webhook is not
+// healthy
+type HealthCheck struct {
+	Name  string
+	Check func() error
+}
+
+// HealthHandler returns a handler responding "ok" if all the given checks
+// pass, or 503 with the failed checks otherwise. A handler without checks
+// always responds "ok".
+func HealthHandler(checks ...HealthCheck) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var failures []string
+		for _, check := range checks {
+			if err := check.Check(); err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %v", check.Name, err))
+			}
+		}
+		if len(failures) > 0 {
+			klog.V(4).InfoS("Health check failed", "path", r.URL.Path, "failures", failures)
+			http.Error(w, strings.Join(failures, "\n"), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintf(w, "ok")
+	}
+}
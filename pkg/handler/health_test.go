@@ -0,0 +1,50 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthHandler(t *testing.T) {
+	passing := HealthCheck{Name: "passing", Check: func() error { return nil }}
+	failing := HealthCheck{Name: "failing", Check: func() error { return fmt.Errorf("not ready") }}
+
+	cases := []struct {
+		caseName     string
+		checks       []HealthCheck
+		expectedCode int
+		expectedBody string
+	}{
+		{"NoChecks", nil, http.StatusOK, "ok"},
+		{"Passing", []HealthCheck{passing}, http.StatusOK, "ok"},
+		{"Failing", []HealthCheck{passing, failing}, http.StatusServiceUnavailable, "failing: not ready\n"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.caseName, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			HealthHandler(c.checks...)(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			assert.Equal(t, c.expectedCode, recorder.Code)
+			assert.Equal(t, c.expectedBody, recorder.Body.String())
+		})
+	}
+}
@@ -41,21 +41,53 @@ var (
 		},
 		[]string{"verb", "path"},
 	)
+	// Deprecated: use requestDuration instead. Only registered when legacy
+	// latency metrics are enabled.
 	requestLatenciesSummary = prometheus.NewSummaryVec(
 		prometheus.SummaryOpts{
 			Name: "http_request_duration_microseconds",
-			Help: "Response latency summary in microseconds for each verb and path.",
+			Help: "(Deprecated) Response latency summary in microseconds for each verb and path.",
 			// Make the sliding window of 1h.
 			MaxAge: time.Hour,
 		},
 		[]string{"verb", "path"},
 	)
+	requestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Response latency distribution in seconds for each verb and path",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"verb", "path"},
+	)
+	podMutationDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name: "pod_mutation_duration_seconds",
+			Help: "Time spent computing the mutation of a pod, including service account lookup grace periods",
+			// Use buckets ranging from 0.5 ms to ~4 seconds.
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2.0, 14),
+		},
+	)
+	podMutationPatchSize = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name: "pod_mutation_patch_size_bytes",
+			Help: "Size in bytes of the JSON patches returned for mutated pods",
+			// Use buckets ranging from 256 bytes to 128 KiB.
+			Buckets: prometheus.ExponentialBuckets(256, 2.0, 10),
+		},
+	)
 	webhookPodCount = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "pod_identity_webhook_pod_count",
 			Help: "Indicator to how many pods are using sts web identity or container credentials",
 		}, []string{"method"},
 	)
+	mutatedContainerCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "pod_identity_webhook_mutated_container_count",
+			Help: "Number of containers credentials were injected into, broken out by type: container, init_container or sidecar for native sidecars",
+		}, []string{"type"},
+	)
 	missingSACounter = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "pod_identity_webhook_missing_sa_count",
@@ -63,22 +95,84 @@ var (
 		},
 		[]string{},
 	)
+	inFlightRequests = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "pod_identity_webhook_in_flight_requests",
+			Help: "Number of admission requests currently being served",
+		},
+	)
+	shedRequestCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "pod_identity_webhook_shed_requests_total",
+			Help: "Number of admission requests rejected with 429 because the in-flight limit was reached",
+		},
+	)
+	saLookupWaitCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "pod_identity_webhook_sa_lookup_grace_period_waits_total",
+			Help: "Number of times a pod mutation waited for its service account to appear in the cache, broken out by outcome: found, not_found or timeout",
+		},
+		[]string{"outcome"},
+	)
+	tokenExpirationClampedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "pod_identity_webhook_token_expiration_clamped_total",
+			Help: "Number of out of range token expirations raised to the minimum or lowered to the maximum, broken out by source: pod_annotation, service_account or container_credentials",
+		},
+		[]string{"source"},
+	)
+	saLookupWaitDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "pod_identity_webhook_sa_lookup_grace_period_wait_duration_seconds",
+			Help: "Time a pod mutation waited for its service account to appear in the cache, broken out by outcome: found, not_found or timeout",
+			// Use buckets ranging from 1 ms to ~4 seconds.
+			Buckets: prometheus.ExponentialBuckets(0.001, 2.0, 13),
+		},
+		[]string{"outcome"},
+	)
 )
 
+var legacyLatencyMetricsEnabled bool
+
 func register() {
 	prometheus.MustRegister(requestCounter)
 	prometheus.MustRegister(requestLatencies)
-	prometheus.MustRegister(requestLatenciesSummary)
+	prometheus.MustRegister(requestDuration)
+	prometheus.MustRegister(podMutationDuration)
+	prometheus.MustRegister(podMutationPatchSize)
 	prometheus.MustRegister(webhookPodCount)
+	prometheus.MustRegister(mutatedContainerCount)
 	prometheus.MustRegister(missingSACounter)
+	prometheus.MustRegister(inFlightRequests)
+	prometheus.MustRegister(shedRequestCounter)
+	prometheus.MustRegister(saLookupWaitCounter)
+	prometheus.MustRegister(saLookupWaitDuration)
+	prometheus.MustRegister(tokenExpirationClampedCounter)
+}
+
+func monitorSALookupWait(outcome string, waitStart time.Time) {
+	saLookupWaitCounter.WithLabelValues(outcome).Inc()
+	saLookupWaitDuration.WithLabelValues(outcome).Observe(time.Since(waitStart).Seconds())
+}
+
+// EnableLegacyLatencyMetrics registers the deprecated
+// http_request_duration_microseconds summary. It must be called before
+// serving any request.
+func EnableLegacyLatencyMetrics() {
+	prometheus.MustRegister(requestLatenciesSummary)
+	legacyLatencyMetricsEnabled = true
 }
 
 func monitor(verb, path string, httpCode int, reqStart time.Time) {
-	elapsed := float64((time.Since(reqStart)) / time.Microsecond)
+	duration := time.Since(reqStart)
+	elapsed := float64(duration / time.Microsecond)
 
 	requestCounter.WithLabelValues(verb, path, strconv.Itoa(httpCode)).Inc()
 	requestLatencies.WithLabelValues(verb, path).Observe(elapsed)
-	requestLatenciesSummary.WithLabelValues(verb, path).Observe(elapsed)
+	requestDuration.WithLabelValues(verb, path).Observe(duration.Seconds())
+	if legacyLatencyMetricsEnabled {
+		requestLatenciesSummary.WithLabelValues(verb, path).Observe(elapsed)
+	}
 }
 
 func init() {
@@ -119,7 +213,8 @@ func (w *statusLoggingResponseWriter) Write(data []byte) (int, error) {
 //	http_request_count{"verb", "path", "code}
 //	# Histogram
 //	http_request_latencies{"verb", "path"}
-//	# Summary
+//	http_request_duration_seconds{"verb", "path"}
+//	# Summary, only if legacy latency metrics are enabled
 //	http_request_duration_microseconds{"verb", "path", "code}
 func InstrumentRoute() Middleware {
 	return func(h http.Handler) http.Handler {
@@ -143,18 +238,18 @@ func Logging() Middleware {
 			wrappedWriter := &statusLoggingResponseWriter{w, http.StatusOK, 0}
 
 			defer func() {
-				klog.V(4).Infof("path=%s method=%s status=%d user_agent=%s body_bytes=%d",
-					r.URL.Path,
-					r.Method,
-					wrappedWriter.status,
-					r.Header.Get("User-Agent"),
-					wrappedWriter.bodyBytes,
+				klog.V(4).InfoS("Served request",
+					"path", r.URL.Path,
+					"method", r.Method,
+					"status", wrappedWriter.status,
+					"userAgent", r.Header.Get("User-Agent"),
+					"bodyBytes", wrappedWriter.bodyBytes,
 				)
 			}()
 
 			err := r.ParseForm()
 			if err != nil {
-				klog.Errorf("Error parsing form: %v", err)
+				klog.ErrorS(err, "Error parsing form", "path", r.URL.Path)
 				http.Error(w, `{"error": "error parsing form"}`, http.StatusBadRequest)
 				return
 			}
@@ -163,3 +258,34 @@ func Logging() Middleware {
 		})
 	}
 }
+
+// MaxInFlight is a middleware limiting the number of requests served
+// concurrently. Requests over the limit are rejected immediately with 429 so
+// that a burst of pod creations can not exhaust the webhook's memory. A limit
+// of 0 or less disables limiting.
+func MaxInFlight(limit int) Middleware {
+	return func(h http.Handler) http.Handler {
+		if limit <= 0 {
+			return h
+		}
+		sem := make(chan struct{}, limit)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case sem <- struct{}{}:
+			default:
+				shedRequestCounter.Inc()
+				klog.InfoS("Rejecting request, too many requests in flight", "path", r.URL.Path, "limit", limit)
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "too many requests", http.StatusTooManyRequests)
+				return
+			}
+			inFlightRequests.Inc()
+			defer func() {
+				inFlightRequests.Dec()
+				<-sem
+			}()
+
+			h.ServeHTTP(w, r)
+		})
+	}
+}
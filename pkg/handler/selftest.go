@@ -0,0 +1,154 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/amazon-eks-pod-identity-webhook/pkg"
+	"github.com/aws/amazon-eks-pod-identity-webhook/pkg/cache"
+	"github.com/aws/amazon-eks-pod-identity-webhook/pkg/containercredentials"
+	"k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+// The synthetic service account the self-test pod uses
+const (
+	selfTestNamespace      = "pod-identity-webhook-selftest"
+	selfTestServiceAccount = "selftest"
+	selfTestRoleARN        = "arn:aws:iam::111122223333:role/pod-identity-webhook-selftest"
+)
+
+// SelfTestResponse is the result of a self-test
+type SelfTestResponse struct {
+	Passed bool
+	// Duration is how long the mutation of the self-test pod took
+	Duration string
+	// Error explains why the self-test failed
+	Error string `json:",omitempty"`
+}
+
+// selfTestCache returns the synthetic service account of the self-test pod
+type selfTestCache struct {
+	cache.ServiceAccountCache
+}
+
+func (selfTestCache) Get(request cache.Request) cache.Response {
+	return cache.Response{
+		RoleARN:         selfTestRoleARN,
+		Audience:        "sts.amazonaws.com",
+		TokenExpiration: pkg.DefaultTokenExpiration,
+		FoundInCache:    true,
+		Source:          cache.SourceServiceAccount,
+	}
+}
+
+// selfTestContainerCredentialsConfig gives the self-test pod no container
+// credentials identity
+type selfTestContainerCredentialsConfig struct{}
+
+func (selfTestContainerCredentialsConfig) Get(namespace, serviceAccount string) *containercredentials.PatchConfig {
+	return nil
+}
+
+// SelfTest handles requests to run a canned AdmissionReview through MutatePod,
+// with a synthetic service account instead of the cache, and responds 200
+// when the pod was mutated with its role ARN, 503 otherwise. Like
+// simulations, the self-test neither records metrics nor emits Events.
+func (m *Modifier) SelfTest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	resp := m.selfTest()
+	contents, err := json.MarshalIndent(resp, "", " ")
+	if err != nil {
+		klog.ErrorS(err, "Can't encode response")
+		http.Error(w, fmt.Sprintf("could not encode response: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !resp.Passed {
+		klog.ErrorS(nil, "Self-test failed", "reason", resp.Error)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if _, err := w.Write(contents); err != nil {
+		klog.ErrorS(err, "Can't write response")
+	}
+}
+
+// selfTest mutates the self-test pod with a copy of the Modifier
+func (m *Modifier) selfTest() *SelfTestResponse {
+	tester := *m
+	tester.simulation = true
+	tester.recorder = nil
+	tester.saLookupGraceTime = 0
+	tester.namespaceFilter = nil
+	tester.rejectInvalidRoleARN = false
+	tester.Cache = selfTestCache{m.Cache}
+	tester.ContainerCredentialsConfig = selfTestContainerCredentialsConfig{}
+
+	pod := &corev1.Pod{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "selftest",
+			Namespace: selfTestNamespace,
+		},
+		Spec: corev1.PodSpec{
+			ServiceAccountName: selfTestServiceAccount,
+			Containers: []corev1.Container{{
+				Name:  "selftest",
+				Image: "public.ecr.aws/amazonlinux/amazonlinux",
+			}},
+		},
+	}
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		return &SelfTestResponse{Error: fmt.Sprintf("could not encode pod: %v", err)}
+	}
+
+	start := time.Now()
+	response := tester.MutatePod(&v1beta1.AdmissionReview{
+		Request: &v1beta1.AdmissionRequest{
+			UID:       types.UID("selftest"),
+			Namespace: selfTestNamespace,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	})
+	resp := &SelfTestResponse{Duration: time.Since(start).String()}
+	switch {
+	case !response.Allowed:
+		message := ""
+		if response.Result != nil {
+			message = response.Result.Message
+		}
+		resp.Error = fmt.Sprintf("pod was denied: %s", message)
+	case !bytes.Contains(response.Patch, []byte(selfTestRoleARN)):
+		resp.Error = "pod was not mutated with the role ARN of its service account"
+	default:
+		resp.Passed = true
+	}
+	return resp
+}
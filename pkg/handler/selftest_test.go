@@ -0,0 +1,60 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/amazon-eks-pod-identity-webhook/pkg/cache"
+	"github.com/aws/amazon-eks-pod-identity-webhook/pkg/containercredentials"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestSelfTest(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	modifier := NewModifier(
+		// The self-test service account is neither in the cache nor in a
+		// namespace the webhook serves
+		WithServiceAccountCache(cache.NewFakeServiceAccountCache()),
+		WithContainerCredentialsConfig(&containercredentials.FakeConfig{}),
+		WithFailOnMissingServiceAccount(true),
+		WithNamespaceFilter(func(string) bool { return false }),
+		WithEventRecorder(recorder),
+	)
+
+	rec := httptest.NewRecorder()
+	modifier.SelfTest(rec, httptest.NewRequest(http.MethodGet, "/debug/alpha/selftest", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	resp := SelfTestResponse{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.True(t, resp.Passed)
+	assert.NotEmpty(t, resp.Duration)
+	assert.Empty(t, resp.Error)
+
+	rec = httptest.NewRecorder()
+	modifier.SelfTest(rec, httptest.NewRequest(http.MethodPost, "/debug/alpha/selftest", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	select {
+	case event := <-recorder.Events:
+		t.Errorf("Unexpected event %s", event)
+	default:
+	}
+}
@@ -31,10 +31,27 @@ func ShutdownFromContext(ctx context.Context, server *http.Server, timeout time.
 		defer cancel()
 
 		if err := server.Shutdown(shutdownCtx); err != nil {
-			klog.Errorf("Error shutting server down: %v", err)
+			klog.ErrorS(err, "Error shutting server down", "addr", server.Addr)
 			if err := server.Close(); err != nil {
 				klog.Fatalf("Error closing server: %v", err)
 			}
 		}
 	}()
 }
+
+// DelayContext returns a context done delay after ctx is done, to keep
+// serving for a while after the signal to shut down, e.g. until the pod is
+// removed from the endpoints of the Service
+func DelayContext(ctx context.Context, delay time.Duration) context.Context {
+	if delay <= 0 {
+		return ctx
+	}
+	delayed, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-ctx.Done()
+		klog.InfoS("Delaying shutdown", "delay", delay)
+		time.Sleep(delay)
+		cancel()
+	}()
+	return delayed
+}
@@ -0,0 +1,38 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package handler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDelayContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.Equal(t, ctx, DelayContext(ctx, 0))
+
+	delayed := DelayContext(ctx, 50*time.Millisecond)
+	cancel()
+	assert.NoError(t, delayed.Err())
+	select {
+	case <-delayed.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("delayed context is not done")
+	}
+}
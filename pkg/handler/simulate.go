@@ -0,0 +1,118 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// SimulateResponse is the result of a simulated pod mutation
+type SimulateResponse struct {
+	// Allowed is false when the pod would be denied
+	Allowed bool
+	// Outcome is one of skipped, denied, mutated, unchanged or error, and
+	// Reason explains it
+	Outcome string
+	Reason  string
+	// Warnings are returned to the client creating the pod
+	Warnings []string `json:",omitempty"`
+	// AuditAnnotations are recorded in the API server audit log
+	AuditAnnotations map[string]string `json:",omitempty"`
+	// Patch is the JSON patch applied to the pod
+	Patch json.RawMessage `json:",omitempty"`
+}
+
+// Simulate handles requests to compute the mutation of the pod posted in the
+// request body, as JSON or YAML, without admitting it. The namespace and
+// serviceAccountName query parameters override the ones of the pod. The
+// simulation does not wait for service accounts missing from the cache, and
+// neither records metrics nor emits Events.
+func (m *Modifier) Simulate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	body := r.Body
+	if m.maxRequestBodyBytes > 0 {
+		body = http.MaxBytesReader(w, r.Body, m.maxRequestBodyBytes)
+	}
+	content, err := io.ReadAll(body)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		http.Error(w, fmt.Sprintf("request body exceeds %d bytes", maxBytesErr.Limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("could not read request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	pod := &corev1.Pod{}
+	if err := yaml.Unmarshal(content, pod); err != nil {
+		http.Error(w, fmt.Sprintf("could not decode pod: %v", err), http.StatusBadRequest)
+		return
+	}
+	if namespace := r.URL.Query().Get("namespace"); namespace != "" {
+		pod.Namespace = namespace
+	}
+	if serviceAccountName := r.URL.Query().Get("serviceAccountName"); serviceAccountName != "" {
+		pod.Spec.ServiceAccountName = serviceAccountName
+	}
+	if pod.Namespace == "" {
+		http.Error(w, "the pod namespace or the namespace query parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	resp := m.simulate(r.Context(), pod)
+	contents, err := json.MarshalIndent(resp, "", " ")
+	if err != nil {
+		klog.ErrorS(err, "Can't encode response")
+		http.Error(w, fmt.Sprintf("could not encode response: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(contents); err != nil {
+		klog.ErrorS(err, "Can't write response")
+	}
+}
+
+// simulate computes the mutation of the pod with a copy of the Modifier
+func (m *Modifier) simulate(ctx context.Context, pod *corev1.Pod) *SimulateResponse {
+	simulator := *m
+	simulator.simulation = true
+	simulator.recorder = nil
+	simulator.saLookupGraceTime = 0
+
+	response, outcome, reason := simulator.admitPod(ctx, &v1beta1.AdmissionRequest{Namespace: pod.Namespace}, pod)
+	return &SimulateResponse{
+		Allowed:          response.Allowed,
+		Outcome:          outcome,
+		Reason:           reason,
+		Warnings:         response.Warnings,
+		AuditAnnotations: response.AuditAnnotations,
+		Patch:            response.Patch,
+	}
+}
@@ -0,0 +1,112 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-eks-pod-identity-webhook/pkg/cache"
+	"github.com/aws/amazon-eks-pod-identity-webhook/pkg/containercredentials"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const simulatedPod = `
+apiVersion: v1
+kind: Pod
+metadata:
+  name: app
+  namespace: default
+spec:
+  serviceAccountName: s3-reader
+  containers:
+  - name: app
+    image: amazonlinux
+`
+
+func TestSimulate(t *testing.T) {
+	sa := &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "s3-reader",
+			Namespace: "default",
+			Annotations: map[string]string{
+				"eks.amazonaws.com/role-arn": "arn:aws:iam::111122223333:role/s3-reader",
+			},
+		},
+	}
+	recorder := record.NewFakeRecorder(10)
+	modifier := NewModifier(
+		WithServiceAccountCache(cache.NewFakeServiceAccountCache(sa)),
+		WithContainerCredentialsConfig(&containercredentials.FakeConfig{}),
+		WithFailOnMissingServiceAccount(true),
+		WithSALookupGraceTime(time.Minute),
+		WithEventRecorder(recorder),
+	)
+
+	cases := []struct {
+		caseName        string
+		method          string
+		query           string
+		body            string
+		expectedCode    int
+		expectedAllowed bool
+		expectedOutcome string
+	}{
+		{"Mutated", http.MethodPost, "", simulatedPod, http.StatusOK, true, outcomeMutated},
+		{"ServiceAccountOverride", http.MethodPost, "?serviceAccountName=missing", simulatedPod, http.StatusOK, false, outcomeDenied},
+		{"NamespaceOverride", http.MethodPost, "?namespace=other", simulatedPod, http.StatusOK, false, outcomeDenied},
+		{"NoNamespace", http.MethodPost, "", strings.Replace(simulatedPod, "namespace: default", "", 1), http.StatusBadRequest, false, ""},
+		{"InvalidPod", http.MethodPost, "", "spec: [", http.StatusBadRequest, false, ""},
+		{"NotPost", http.MethodGet, "", "", http.StatusMethodNotAllowed, false, ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.caseName, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			modifier.Simulate(rec, httptest.NewRequest(c.method, "/debug/alpha/simulate"+c.query, strings.NewReader(c.body)))
+			assert.Equal(t, c.expectedCode, rec.Code)
+			if c.expectedCode != http.StatusOK {
+				return
+			}
+
+			resp := SimulateResponse{}
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+			assert.Equal(t, c.expectedAllowed, resp.Allowed)
+			assert.Equal(t, c.expectedOutcome, resp.Outcome)
+			assert.NotEmpty(t, resp.Reason)
+			if c.expectedOutcome == outcomeMutated {
+				assert.Contains(t, string(resp.Patch), "AWS_ROLE_ARN")
+				assert.Equal(t, "sts_web_identity", resp.AuditAnnotations["credential-method"])
+			} else {
+				assert.Empty(t, resp.Patch)
+			}
+		})
+	}
+
+	// Simulations neither wait for missing service accounts nor emit Events
+	select {
+	case event := <-recorder.Events:
+		t.Errorf("Unexpected event %s", event)
+	default:
+	}
+}
@@ -0,0 +1,148 @@
+/*
+  Copyright 2023 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package httppoller
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+// maxContentBytes is the maximum size of the polled content
+const maxContentBytes = 10 << 20
+
+// ContentHandler is invoked with the polled content when it changes
+type ContentHandler func(content []byte) error
+
+// Poller periodically fetches a URL and triggers the given handler when its
+// content changes. The ETag returned by the server, if any, is sent back in
+// the If-None-Match header so that unchanged content is not transferred.
+type Poller struct {
+	purpose  string
+	url      string
+	interval time.Duration
+	client   *http.Client
+	handler  ContentHandler
+
+	// etag is only accessed by the polling goroutine
+	etag string
+}
+
+// NewPoller creates a Poller
+func NewPoller(purpose, url string, interval time.Duration, client *http.Client, handler ContentHandler) *Poller {
+	return &Poller{
+		purpose:  purpose,
+		url:      url,
+		interval: interval,
+		client:   client,
+		handler:  handler,
+	}
+}
+
+// Poll fetches the URL right away and then every interval, in a goroutine
+// stopped when ctx is cancelled. Errors are logged and the URL is fetched
+// again at the next interval.
+func (p *Poller) Poll(ctx context.Context) {
+	go wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := p.poll(ctx); err != nil {
+			klog.ErrorS(err, "Error polling", "purpose", p.purpose, "url", p.url)
+		}
+	}, p.interval)
+}
+
+func (p *Poller) poll(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url, nil)
+	if err != nil {
+		return err
+	}
+	if p.etag != "" {
+		req.Header.Set("If-None-Match", p.etag)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		klog.V(5).InfoS("Content not modified", "purpose", p.purpose, "url", p.url)
+		return nil
+	case http.StatusOK:
+	default:
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxContentBytes+1))
+	if err != nil {
+		return err
+	}
+	if len(content) > maxContentBytes {
+		return fmt.Errorf("content exceeds %d bytes", maxContentBytes)
+	}
+	if err := p.handler(content); err != nil {
+		// Fetch the content again at the next interval
+		p.etag = ""
+		return err
+	}
+	p.etag = resp.Header.Get("ETag")
+	return nil
+}
+
+// NewClient returns an HTTP client verifying servers with the CA certificates
+// in caFile, or the system ones when empty, and authenticating with the client
+// certificate in certFile and keyFile when set. The client certificate is read
+// again on every handshake so that it can be rotated.
+func NewClient(caFile, certFile, keyFile string, timeout time.Duration) (*http.Client, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		caPEM, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificate found in %s", caFile)
+		}
+	}
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("both a client certificate and key are required")
+		}
+		// Fail early on invalid files
+		if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+			return nil, err
+		}
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+			if err != nil {
+				return nil, err
+			}
+			return &cert, nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport, Timeout: timeout}, nil
+}
@@ -0,0 +1,106 @@
+/*
+  Copyright 2023 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package httppoller
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPoller(t *testing.T) {
+	content := "foo"
+	etag := `"1"`
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(content))
+	}))
+	defer server.Close()
+
+	var handled []string
+	var handlerErr error
+	p := NewPoller("test", server.URL, time.Minute, server.Client(), func(c []byte) error {
+		handled = append(handled, string(c))
+		return handlerErr
+	})
+	ctx := context.Background()
+
+	assert.NoError(t, p.poll(ctx))
+	assert.NoError(t, p.poll(ctx))
+	assert.Equal(t, []string{"foo"}, handled, "unchanged content should not be handled again")
+
+	content, etag = "bar", `"2"`
+	handlerErr = errors.New("invalid content")
+	assert.Error(t, p.poll(ctx))
+	handlerErr = nil
+	assert.NoError(t, p.poll(ctx))
+	assert.Equal(t, []string{"foo", "bar", "bar"}, handled, "content should be handled again after an error")
+	assert.Equal(t, 4, requests)
+}
+
+func TestPoller_Errors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not found", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	handled := false
+	p := NewPoller("test", server.URL, time.Minute, server.Client(), func([]byte) error {
+		handled = true
+		return nil
+	})
+	assert.ErrorContains(t, p.poll(context.Background()), "unexpected status 404 Not Found")
+	assert.False(t, handled)
+}
+
+func TestNewClient(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	assert.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+
+	client, err := NewClient(caFile, "", "", time.Second)
+	assert.NoError(t, err)
+	resp, err := client.Get(server.URL)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+	}
+
+	client, err = NewClient("", "", "", time.Second)
+	assert.NoError(t, err)
+	_, err = client.Get(server.URL)
+	assert.Error(t, err, "the test server should not be trusted without its CA")
+
+	_, err = NewClient("", "client.crt", "", time.Second)
+	assert.Error(t, err)
+}
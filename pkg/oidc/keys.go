@@ -0,0 +1,212 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+// KeySet is the JSON Web Key Set of the service account signing keys
+type KeySet struct {
+	Keys []jose.JSONWebKey `json:"keys"`
+}
+
+// Discovery is the OIDC discovery document of the issuer, served at
+// <issuer>/.well-known/openid-configuration
+type Discovery struct {
+	Issuer                           string   `json:"issuer"`
+	JWKSURI                          string   `json:"jwks_uri"`
+	AuthorizationEndpoint            string   `json:"authorization_endpoint"`
+	ResponseTypesSupported           []string `json:"response_types_supported"`
+	SubjectTypesSupported            []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported []string `json:"id_token_signing_alg_values_supported"`
+	ClaimsSupported                  []string `json:"claims_supported"`
+}
+
+// KeyID returns the key ID the API server sets in the tokens signed with the
+// key, copied from kubernetes/kubernetes#78502
+func KeyID(publicKey interface{}) (string, error) {
+	publicKeyDERBytes, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize public key to DER format: %v", err)
+	}
+
+	hasher := crypto.SHA256.New()
+	hasher.Write(publicKeyDERBytes)
+	publicKeyDERHash := hasher.Sum(nil)
+
+	keyID := base64.RawURLEncoding.EncodeToString(publicKeyDERHash)
+
+	return keyID, nil
+}
+
+// SignatureAlgorithm returns the algorithm the API server signs service
+// account tokens with for the public key
+func SignatureAlgorithm(publicKey interface{}) (jose.SignatureAlgorithm, error) {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		return jose.RS256, nil
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P256():
+			return jose.ES256, nil
+		case elliptic.P384():
+			return jose.ES384, nil
+		case elliptic.P521():
+			return jose.ES512, nil
+		default:
+			return "", fmt.Errorf("unsupported ECDSA curve %s", key.Curve.Params().Name)
+		}
+	case ed25519.PublicKey:
+		return jose.EdDSA, nil
+	default:
+		return "", fmt.Errorf("invalid public key type %T, must be *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey", publicKey)
+	}
+}
+
+// JSONWebKey returns the JSON Web Key verifying the tokens signed with the
+// private key of the public key
+func JSONWebKey(publicKey interface{}) (jose.JSONWebKey, error) {
+	alg, err := SignatureAlgorithm(publicKey)
+	if err != nil {
+		return jose.JSONWebKey{}, err
+	}
+	kid, err := KeyID(publicKey)
+	if err != nil {
+		return jose.JSONWebKey{}, err
+	}
+	return jose.JSONWebKey{
+		Key:       publicKey,
+		KeyID:     kid,
+		Algorithm: string(alg),
+		Use:       "sig",
+	}, nil
+}
+
+// ParsePublicKeys returns the JSON Web Keys of the PEM encoded PKIX public
+// keys, or of the public keys of the PEM encoded private keys, like the API
+// server reads from --service-account-key-file
+func ParsePublicKeys(data []byte) ([]jose.JSONWebKey, error) {
+	var keys []jose.JSONWebKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		publicKey, err := parsePublicKey(block)
+		if err != nil {
+			return nil, err
+		}
+		if publicKey == nil {
+			continue
+		}
+		key, err := JSONWebKey(publicKey)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no PEM encoded public or private key found")
+	}
+	return keys, nil
+}
+
+// parsePublicKey returns the public key of the PEM block, or nil if it holds
+// no key
+func parsePublicKey(block *pem.Block) (interface{}, error) {
+	var privateKey interface{}
+	var err error
+	switch block.Type {
+	case "PUBLIC KEY":
+		publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing public key: %v", err)
+		}
+		return publicKey, nil
+	case "PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		privateKey, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing private key: %v", err)
+	}
+	signer, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("invalid private key type %T", privateKey)
+	}
+	return signer.Public(), nil
+}
+
+// ValidateIssuer returns an error if the issuer is not a valid https URL
+func ValidateIssuer(issuer string) error {
+	u, err := url.Parse(issuer)
+	if err != nil || u.Scheme != "https" || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("issuer %q must be an https URL without query or fragment", issuer)
+	}
+	return nil
+}
+
+// NewDiscovery returns the discovery document of the issuer publishing the
+// keys at jwksURI, the same as the API server serves with
+// --service-account-issuer
+func NewDiscovery(issuer, jwksURI string, keys []jose.JSONWebKey) (*Discovery, error) {
+	if err := ValidateIssuer(issuer); err != nil {
+		return nil, err
+	}
+	algorithms := []string{}
+	for _, key := range keys {
+		if !slices.Contains(algorithms, key.Algorithm) {
+			algorithms = append(algorithms, key.Algorithm)
+		}
+	}
+	sort.Strings(algorithms)
+
+	return &Discovery{
+		Issuer:                           issuer,
+		JWKSURI:                          jwksURI,
+		AuthorizationEndpoint:            "urn:kubernetes:programmatic_authorization",
+		ResponseTypesSupported:           []string{"id_token"},
+		SubjectTypesSupported:            []string{"public"},
+		IDTokenSigningAlgValuesSupported: algorithms,
+		ClaimsSupported:                  []string{"sub", "iss"},
+	}, nil
+}
+
+// IssuerURL returns the URL of the path under the issuer
+func IssuerURL(issuer, path string) string {
+	return strings.TrimSuffix(issuer, "/") + path
+}
@@ -0,0 +1,77 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePublicKeys(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatalf("Error marshalling key: %v", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	edDER, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatalf("Error marshalling key: %v", err)
+	}
+
+	var data []byte
+	data = append(data, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})...)
+	data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("ignored")})...)
+	data = append(data, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER})...)
+	data = append(data, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: edDER})...)
+	data = append(data, publicKeyPEM(t, &rsaKey.PublicKey)...)
+
+	keys, err := ParsePublicKeys(data)
+	assert.NoError(t, err)
+	if assert.Len(t, keys, 4) {
+		rsaKID, _ := KeyID(&rsaKey.PublicKey)
+		ecKID, _ := KeyID(&ecKey.PublicKey)
+		assert.Equal(t, rsaKID, keys[0].KeyID)
+		assert.Equal(t, "RS256", keys[0].Algorithm)
+		assert.Equal(t, ecKID, keys[1].KeyID)
+		assert.Equal(t, "ES384", keys[1].Algorithm)
+		assert.Equal(t, "EdDSA", keys[2].Algorithm)
+		assert.Equal(t, rsaKID, keys[3].KeyID)
+	}
+
+	_, err = ParsePublicKeys([]byte("not a key"))
+	assert.Error(t, err)
+	_, err = ParsePublicKeys(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: []byte("invalid")}))
+	assert.Error(t, err)
+}
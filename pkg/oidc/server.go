@@ -0,0 +1,153 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package oidc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"k8s.io/api/core/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// DiscoveryPath is the path of the discovery document under the issuer
+	DiscoveryPath = "/.well-known/openid-configuration"
+	// JWKSPath is the path of the key set under the issuer, the same as the
+	// API server serves it at
+	JWKSPath = "/openid/v1/jwks"
+)
+
+// Server serves the discovery document and key set of a service account
+// issuer, for clusters whose issuer can not be hosted elsewhere
+type Server struct {
+	issuer string
+
+	mu        sync.RWMutex // guards discovery and jwks
+	discovery []byte
+	jwks      []byte
+}
+
+// NewServer returns a Server for the issuer, which serves no keys until
+// signing keys are loaded
+func NewServer(issuer string) (*Server, error) {
+	if err := ValidateIssuer(issuer); err != nil {
+		return nil, err
+	}
+	return &Server{issuer: issuer}, nil
+}
+
+// Load loads the PEM encoded signing public keys. Invalid keys are not
+// loaded, so that the last valid ones keep being served.
+func (s *Server) Load(content []byte) error {
+	keys, err := ParsePublicKeys(content)
+	if err != nil {
+		return err
+	}
+	discovery, err := NewDiscovery(s.issuer, IssuerURL(s.issuer, JWKSPath), keys)
+	if err != nil {
+		return err
+	}
+	discoveryJSON, err := json.Marshal(discovery)
+	if err != nil {
+		return err
+	}
+	jwksJSON, err := json.Marshal(KeySet{Keys: keys})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.discovery = discoveryJSON
+	s.jwks = jwksJSON
+	klog.InfoS("Loaded service account signing keys", "issuer", s.issuer, "count", len(keys))
+	return nil
+}
+
+// WatchSecret loads the signing public keys from the key of the Secret with
+// the given name watched by the informer, which must be started by the caller
+func (s *Server) WatchSecret(informer coreinformers.SecretInformer, name, key string) error {
+	load := func(secret *v1.Secret) {
+		if secret.Name != name {
+			return
+		}
+		if err := s.Load(secret.Data[key]); err != nil {
+			utilruntime.HandleError(fmt.Errorf("error loading signing keys from key %s of Secret %s/%s: %v", key, secret.Namespace, secret.Name, err))
+		}
+	}
+	_, err := informer.Informer().AddEventHandler(
+		cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				load(obj.(*v1.Secret))
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				load(newObj.(*v1.Secret))
+			},
+		},
+	)
+	return err
+}
+
+// Loaded returns an error until signing keys are loaded, and is meant to be
+// used as a readiness check
+func (s *Server) Loaded() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.jwks == nil {
+		return fmt.Errorf("no service account signing keys loaded")
+	}
+	return nil
+}
+
+// Register registers the discovery document and key set handlers on the mux,
+// under the path of the issuer
+func (s *Server) Register(mux *http.ServeMux) {
+	// Validated by NewServer
+	u, _ := url.Parse(s.issuer)
+	prefix := u.Path
+	if prefix == "/" {
+		prefix = ""
+	}
+	mux.HandleFunc(prefix+DiscoveryPath, func(w http.ResponseWriter, r *http.Request) {
+		s.serve(w, "application/json", func() []byte { return s.discovery })
+	})
+	mux.HandleFunc(prefix+JWKSPath, func(w http.ResponseWriter, r *http.Request) {
+		s.serve(w, "application/jwk-set+json", func() []byte { return s.jwks })
+	})
+}
+
+func (s *Server) serve(w http.ResponseWriter, contentType string, document func() []byte) {
+	s.mu.RLock()
+	content := document()
+	s.mu.RUnlock()
+	if content == nil {
+		http.Error(w, "no service account signing keys loaded", http.StatusServiceUnavailable)
+		return
+	}
+	// Same caching as the API server
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Header().Set("Content-Type", contentType)
+	if _, err := w.Write(content); err != nil {
+		klog.ErrorS(err, "Can't write response")
+	}
+}
@@ -0,0 +1,132 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+)
+
+func publicKeyPEM(t *testing.T, publicKey interface{}) []byte {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		t.Fatalf("Error marshalling public key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func TestServer(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+
+	s, err := NewServer("https://oidc.example.com/cluster")
+	assert.NoError(t, err)
+	mux := http.NewServeMux()
+	s.Register(mux)
+	get := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		return recorder
+	}
+
+	assert.Error(t, s.Loaded())
+	assert.Equal(t, http.StatusServiceUnavailable, get("/cluster"+JWKSPath).Code)
+
+	assert.NoError(t, s.Load(append(publicKeyPEM(t, &rsaKey.PublicKey), publicKeyPEM(t, &ecKey.PublicKey)...)))
+	assert.NoError(t, s.Loaded())
+
+	recorder := get("/cluster" + DiscoveryPath)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	discovery := Discovery{}
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &discovery))
+	assert.Equal(t, "https://oidc.example.com/cluster", discovery.Issuer)
+	assert.Equal(t, "https://oidc.example.com/cluster/openid/v1/jwks", discovery.JWKSURI)
+	assert.Equal(t, []string{"ES256", "RS256"}, discovery.IDTokenSigningAlgValuesSupported)
+
+	recorder = get("/cluster" + JWKSPath)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	keySet := KeySet{}
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &keySet))
+	assert.Len(t, keySet.Keys, 2)
+	kid, _ := KeyID(&rsaKey.PublicKey)
+	assert.Equal(t, kid, keySet.Keys[0].KeyID)
+
+	// Invalid keys are not loaded
+	assert.Error(t, s.Load([]byte("invalid")))
+	recorder = get("/cluster" + JWKSPath)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &keySet))
+	assert.Len(t, keySet.Keys, 2)
+}
+
+func TestServerWatchSecret(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	client := fakeclientset.NewSimpleClientset(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "sa-signer", Namespace: "kube-system"},
+		Data:       map[string][]byte{"sa.pub": publicKeyPEM(t, &ecKey.PublicKey)},
+	})
+	informerFactory := informers.NewSharedInformerFactoryWithOptions(client, 0, informers.WithNamespace("kube-system"))
+
+	s, err := NewServer("https://oidc.example.com")
+	assert.NoError(t, err)
+	assert.NoError(t, s.WatchSecret(informerFactory.Core().V1().Secrets(), "sa-signer", "sa.pub"))
+	stop := make(chan struct{})
+	defer close(stop)
+	informerFactory.Start(stop)
+	informerFactory.WaitForCacheSync(stop)
+
+	assert.Eventually(t, func() bool { return s.Loaded() == nil }, 5*time.Second, 10*time.Millisecond)
+
+	mux := http.NewServeMux()
+	s.Register(mux)
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, DiscoveryPath, nil).WithContext(context.TODO()))
+	discovery := Discovery{}
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &discovery))
+	assert.Equal(t, []string{"ES384"}, discovery.IDTokenSigningAlgValuesSupported)
+}
+
+func TestValidateIssuer(t *testing.T) {
+	assert.NoError(t, ValidateIssuer("https://oidc.example.com"))
+	assert.NoError(t, ValidateIssuer("https://s3.us-west-2.amazonaws.com/bucket"))
+	assert.Error(t, ValidateIssuer("http://oidc.example.com"))
+	assert.Error(t, ValidateIssuer("https://oidc.example.com?query"))
+	assert.Error(t, ValidateIssuer("oidc.example.com"))
+}
@@ -0,0 +1,128 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package oidc
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+
+	"github.com/go-jose/go-jose/v4"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/rest"
+)
+
+// maxDocumentBytes limits the size of the fetched documents
+const maxDocumentBytes = 1 << 20
+
+// ClusterIssuer returns the issuer and signing keys of the cluster, as served
+// by the API server to the clients bound to the
+// system:service-account-issuer-discovery ClusterRole
+func ClusterIssuer(ctx context.Context, client rest.Interface) (string, []jose.JSONWebKey, error) {
+	content, err := client.Get().AbsPath(DiscoveryPath).DoRaw(ctx)
+	if err != nil {
+		return "", nil, fmt.Errorf("error getting the issuer discovery document of the API server, make sure the API server is started with --service-account-issuer and the caller is bound to the system:service-account-issuer-discovery ClusterRole: %v", err)
+	}
+	discovery := Discovery{}
+	if err := json.Unmarshal(content, &discovery); err != nil {
+		return "", nil, fmt.Errorf("error parsing the issuer discovery document of the API server: %v", err)
+	}
+	content, err = client.Get().AbsPath(JWKSPath).DoRaw(ctx)
+	if err != nil {
+		return "", nil, fmt.Errorf("error getting the signing keys of the API server: %v", err)
+	}
+	keySet := KeySet{}
+	if err := json.Unmarshal(content, &keySet); err != nil {
+		return "", nil, fmt.Errorf("error parsing the signing keys of the API server: %v", err)
+	}
+	return discovery.Issuer, keySet.Keys, nil
+}
+
+// Verify fetches the discovery document and keys of the issuer the way STS
+// does, and returns the problems preventing STS from verifying the tokens
+// signed with the signing keys of the cluster
+func Verify(ctx context.Context, client *http.Client, issuer string, signingKeys []jose.JSONWebKey) error {
+	if err := ValidateIssuer(issuer); err != nil {
+		return fmt.Errorf("%v, STS only fetches the discovery document over https", err)
+	}
+
+	discovery := Discovery{}
+	if err := fetch(ctx, client, IssuerURL(issuer, DiscoveryPath), &discovery); err != nil {
+		return err
+	}
+	var errs []error
+	if discovery.Issuer != issuer {
+		errs = append(errs, fmt.Errorf("the discovery document issuer %q does not match the issuer %q of the cluster, set it to the --service-account-issuer of the API server", discovery.Issuer, issuer))
+	}
+	if err := ValidateIssuer(discovery.JWKSURI); err != nil {
+		return utilerrors.NewAggregate(append(errs, fmt.Errorf("the jwks_uri %q of the discovery document must be an https URL", discovery.JWKSURI)))
+	}
+
+	keySet := KeySet{}
+	if err := fetch(ctx, client, discovery.JWKSURI, &keySet); err != nil {
+		return utilerrors.NewAggregate(append(errs, err))
+	}
+	for _, signingKey := range signingKeys {
+		i := slices.IndexFunc(keySet.Keys, func(key jose.JSONWebKey) bool {
+			return key.KeyID == signingKey.KeyID
+		})
+		if i < 0 {
+			errs = append(errs, fmt.Errorf("the signing key %s of the cluster is missing from %s, tokens signed with it are rejected by STS. Publish the keys given to the API server with --service-account-key-file, e.g. with hack/self-hosted", signingKey.KeyID, discovery.JWKSURI))
+			continue
+		}
+		if key := keySet.Keys[i]; key.Algorithm != "" && key.Algorithm != signingKey.Algorithm {
+			errs = append(errs, fmt.Errorf("the key %s of %s has algorithm %s, but the cluster signs tokens with %s", key.KeyID, discovery.JWKSURI, key.Algorithm, signingKey.Algorithm))
+		}
+		if !slices.Contains(discovery.IDTokenSigningAlgValuesSupported, signingKey.Algorithm) {
+			errs = append(errs, fmt.Errorf("the algorithm %s of the signing key %s is missing from the id_token_signing_alg_values_supported of the discovery document", signingKey.Algorithm, signingKey.KeyID))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// fetch gets the JSON document at the URL into v, explaining the errors STS
+// would run into
+func fetch(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		var certErr *tls.CertificateVerificationError
+		if errors.As(err, &certErr) {
+			return fmt.Errorf("the certificate of %s is not trusted: %v. STS only trusts certificates issued by public CAs for the host of the URL", url, certErr)
+		}
+		return fmt.Errorf("%s is not reachable: %v. It must be reachable from the internet for STS to fetch it", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s responded %s, it must be publicly readable without authentication, e.g. with a public-read ACL in S3", url, resp.Status)
+	}
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxDocumentBytes))
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", url, err)
+	}
+	if err := json.Unmarshal(content, v); err != nil {
+		return fmt.Errorf("%s is not a valid JSON document: %v", url, err)
+	}
+	return nil
+}
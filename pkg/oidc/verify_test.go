@@ -0,0 +1,137 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerify(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	signingKey, err := JSONWebKey(&ecKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Error creating key: %v", err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	otherSigningKey, _ := JSONWebKey(&otherKey.PublicKey)
+
+	var discovery *Discovery
+	var keys []jose.JSONWebKey
+	var discoveryStatus int
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case DiscoveryPath:
+			if discoveryStatus != http.StatusOK {
+				w.WriteHeader(discoveryStatus)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(discovery)
+		case "/keys.json":
+			_ = json.NewEncoder(w).Encode(KeySet{Keys: keys})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	issuer := server.URL
+
+	cases := []struct {
+		caseName      string
+		issuer        string
+		status        int
+		keys          []jose.JSONWebKey
+		signingKeys   []jose.JSONWebKey
+		client        *http.Client
+		expectedError string
+	}{
+		{
+			caseName:    "Valid",
+			keys:        []jose.JSONWebKey{otherSigningKey, signingKey},
+			signingKeys: []jose.JSONWebKey{signingKey},
+		},
+		{
+			caseName:      "MissingKey",
+			keys:          []jose.JSONWebKey{otherSigningKey},
+			signingKeys:   []jose.JSONWebKey{signingKey},
+			expectedError: "the signing key " + signingKey.KeyID + " of the cluster is missing from " + issuer + "/keys.json",
+		},
+		{
+			caseName:      "IssuerMismatch",
+			issuer:        issuer + "/",
+			keys:          []jose.JSONWebKey{signingKey},
+			signingKeys:   []jose.JSONWebKey{signingKey},
+			expectedError: "the discovery document issuer \"" + issuer + "/\" does not match the issuer \"" + issuer + "\" of the cluster",
+		},
+		{
+			caseName:      "Forbidden",
+			status:        http.StatusForbidden,
+			signingKeys:   []jose.JSONWebKey{signingKey},
+			expectedError: issuer + DiscoveryPath + " responded 403 Forbidden, it must be publicly readable",
+		},
+		{
+			caseName:      "UntrustedCertificate",
+			signingKeys:   []jose.JSONWebKey{signingKey},
+			client:        &http.Client{},
+			expectedError: "the certificate of " + issuer + DiscoveryPath + " is not trusted",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.caseName, func(t *testing.T) {
+			discovery, err = NewDiscovery(issuer, issuer+"/keys.json", c.signingKeys)
+			if err != nil {
+				t.Fatalf("Error creating discovery document: %v", err)
+			}
+			if c.issuer != "" {
+				discovery.Issuer = c.issuer
+			}
+			keys = c.keys
+			discoveryStatus = http.StatusOK
+			if c.status != 0 {
+				discoveryStatus = c.status
+			}
+			client := server.Client()
+			if c.client != nil {
+				client = c.client
+			}
+
+			err := Verify(context.TODO(), client, issuer, c.signingKeys)
+			if c.expectedError == "" {
+				assert.NoError(t, err)
+				return
+			}
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), c.expectedError)
+			}
+		})
+	}
+}
@@ -14,9 +14,78 @@
 */
 package pkg
 
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/arn"
+)
+
+// roleNameRegexp matches the path and name of a role, the resource of its ARN
+// after the role/ prefix
+var roleNameRegexp = regexp.MustCompile(`^[\w+=,.@/-]+$`)
+
+var accountIDRegexp = regexp.MustCompile(`^\d{12}$`)
+
+var (
+	roleARNPatternMu sync.RWMutex
+	// roleARNPattern is matched by role ARNs on top of the ARN format, if set
+	roleARNPattern *regexp.Regexp
+)
+
+// SetRoleARNPattern sets a regular expression that role ARNs must match to be
+// valid, on top of being well-formed IAM role ARNs. An empty pattern only
+// checks the ARN format.
+func SetRoleARNPattern(pattern string) error {
+	var re *regexp.Regexp
+	if pattern != "" {
+		var err error
+		if re, err = regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid role ARN pattern %q: %v", pattern, err)
+		}
+	}
+	roleARNPatternMu.Lock()
+	defer roleARNPatternMu.Unlock()
+	roleARNPattern = re
+	return nil
+}
+
+// ValidateRoleARN returns whether the given string is a well-formed IAM role
+// ARN, matching the role ARN pattern if set
+func ValidateRoleARN(roleARN string) bool {
+	parsed, err := arn.Parse(roleARN)
+	if err != nil {
+		return false
+	}
+	if parsed.Service != "iam" || parsed.Region != "" || !ValidateAccountID(parsed.AccountID) {
+		return false
+	}
+	name, ok := strings.CutPrefix(parsed.Resource, "role/")
+	if !ok || !roleNameRegexp.MatchString(name) {
+		return false
+	}
+
+	roleARNPatternMu.RLock()
+	defer roleARNPatternMu.RUnlock()
+	return roleARNPattern == nil || roleARNPattern.MatchString(roleARN)
+}
+
+// ValidateAccountID returns whether the given string is a well-formed AWS account ID
+func ValidateAccountID(accountID string) bool {
+	return accountIDRegexp.MatchString(accountID)
+}
+
 func ValidateMinTokenExpiration(expiration int64) (int64) {
 	if expiration < MinTokenExpiration {
 		return MinTokenExpiration
 	}
 	return expiration
 }
+
+// ValidateTokenExpiration returns the token expiration raised to
+// MinTokenExpiration or lowered to MaxTokenExpiration when it is out of range
+func ValidateTokenExpiration(expiration int64) int64 {
+	return min(ValidateMinTokenExpiration(expiration), MaxTokenExpiration)
+}
@@ -0,0 +1,61 @@
+/*
+  Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+package pkg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateRoleARN(t *testing.T) {
+	cases := []struct {
+		arn   string
+		valid bool
+	}{
+		{"arn:aws:iam::111122223333:role/s3-reader", true},
+		{"arn:aws-us-gov:iam::111122223333:role/path/to/s3-reader", true},
+		{"arn:aws:iam::111122223333:role/s3+reader=,.@-_", true},
+		{"s3-reader", false},
+		{"arn:aws:iam::111122223333:user/s3-reader", false},
+		{"arn:aws:iam::111122223333:role/", false},
+		{"arn:aws:iam::11112222333:role/s3-reader", false},
+		{"arn:aws:iam:us-west-2:111122223333:role/s3-reader", false},
+		{"arn:aws:sts::111122223333:role/s3-reader", false},
+		{"arn:aws:iam::111122223333:role/s3 reader", false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.valid, ValidateRoleARN(c.arn), c.arn)
+	}
+}
+
+func TestSetRoleARNPattern(t *testing.T) {
+	defer SetRoleARNPattern("")
+
+	assert.Error(t, SetRoleARNPattern("("))
+	assert.NoError(t, SetRoleARNPattern(`^arn:aws:iam::111122223333:role/`))
+	assert.True(t, ValidateRoleARN("arn:aws:iam::111122223333:role/s3-reader"))
+	assert.False(t, ValidateRoleARN("arn:aws:iam::444455556666:role/s3-reader"))
+	assert.False(t, ValidateRoleARN("s3-reader"))
+
+	assert.NoError(t, SetRoleARNPattern(""))
+	assert.True(t, ValidateRoleARN("arn:aws:iam::444455556666:role/s3-reader"))
+}
+
+func TestValidateTokenExpiration(t *testing.T) {
+	assert.Equal(t, MinTokenExpiration, ValidateTokenExpiration(100))
+	assert.Equal(t, int64(3600), ValidateTokenExpiration(3600))
+	assert.Equal(t, MaxTokenExpiration, ValidateTokenExpiration(604800))
+}
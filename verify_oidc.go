@@ -0,0 +1,127 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/amazon-eks-pod-identity-webhook/pkg/oidc"
+	"github.com/go-jose/go-jose/v4"
+	flag "github.com/spf13/pflag"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+)
+
+// verifyOIDCCommand is the name of the subcommand verifying the issuer of the
+// cluster
+const verifyOIDCCommand = "verify-oidc"
+
+// verifyOIDC runs the verify-oidc subcommand with its arguments, and returns
+// the exit code
+func verifyOIDC(args []string) int {
+	flags := flag.NewFlagSet(verifyOIDCCommand, flag.ContinueOnError)
+	kubeconfig := flags.String("kubeconfig", "", "Absolute path to the API server kubeconfig file. Defaults to the in-cluster config")
+	apiURL := flags.String("kube-api", "", "The url to the API server")
+	issuer := flags.String("issuer", "", "The issuer to verify. Defaults to the issuer of the API server")
+	signingKeyFile := flags.String("signing-key-file", "", "The file of the PEM encoded service account signing public keys the issuer must publish. Defaults to the signing keys of the API server")
+	timeout := flags.Duration("timeout", 30*time.Second, "The timeout of the verification")
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	var signingKeys []jose.JSONWebKey
+	if *signingKeyFile != "" {
+		content, err := os.ReadFile(*signingKeyFile)
+		if err == nil {
+			signingKeys, err = oidc.ParsePublicKeys(content)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading signing keys from %s: %v\n", *signingKeyFile, err)
+			return 1
+		}
+	}
+	if *issuer == "" || signingKeys == nil {
+		config, err := clientcmd.BuildConfigFromFlags(*apiURL, *kubeconfig)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating config: %v\n", err)
+			return 1
+		}
+		clientset, err := kubernetes.NewForConfig(config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating clientset: %v\n", err)
+			return 1
+		}
+		clusterIssuer, clusterKeys, err := oidc.ClusterIssuer(ctx, clientset.Discovery().RESTClient())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if *issuer == "" {
+			*issuer = clusterIssuer
+		}
+		if signingKeys == nil {
+			signingKeys = clusterKeys
+		}
+	}
+
+	fmt.Printf("Verifying issuer %s publishes %d signing keys\n", *issuer, len(signingKeys))
+	if err := oidc.Verify(ctx, http.DefaultClient, *issuer, signingKeys); err != nil {
+		fmt.Fprintf(os.Stderr, "Issuer verification failed, STS will reject the tokens of the cluster:\n%s\n", verificationErrors(err))
+		return 1
+	}
+	fmt.Println("Issuer verified")
+	return 0
+}
+
+// verifyClusterIssuer checks that the issuer of the cluster publishes its
+// signing keys, and logs the problems found
+func verifyClusterIssuer(ctx context.Context, clientset kubernetes.Interface) {
+	issuer, signingKeys, err := oidc.ClusterIssuer(ctx, clientset.Discovery().RESTClient())
+	if err == nil {
+		err = oidc.Verify(ctx, http.DefaultClient, issuer, signingKeys)
+	}
+	if err != nil {
+		klog.Errorf("OIDC issuer verification failed, STS will reject the tokens of the cluster: %v", err)
+		return
+	}
+	klog.Infof("Verified OIDC issuer %s", issuer)
+}
+
+// verificationErrors formats the problems found by oidc.Verify, one per line
+func verificationErrors(err error) string {
+	errs := []error{err}
+	if agg, ok := err.(utilerrors.Aggregate); ok {
+		errs = agg.Errors()
+	}
+	lines := make([]string, 0, len(errs))
+	for _, err := range errs {
+		lines = append(lines, "  - "+err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
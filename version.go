@@ -0,0 +1,82 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+)
+
+// webhookCommit is the git commit the webhook is built from, set with
+// -ldflags "-X main.webhookCommit=...". Defaults to the VCS revision stamped
+// by the Go toolchain, if any.
+var webhookCommit = ""
+
+// versionInfo describes the build of the webhook
+type versionInfo struct {
+	Version   string `json:"version"`
+	GoVersion string `json:"goVersion"`
+	Commit    string `json:"commit"`
+}
+
+func getVersionInfo() versionInfo {
+	info := versionInfo{
+		Version:   webhookVersion,
+		GoVersion: runtime.Version(),
+		Commit:    webhookCommit,
+	}
+	if info.Commit == "" {
+		if buildInfo, ok := debug.ReadBuildInfo(); ok {
+			for _, setting := range buildInfo.Settings {
+				if setting.Key == "vcs.revision" {
+					info.Commit = setting.Value
+				}
+			}
+		}
+	}
+	return info
+}
+
+// registerVersion exports the build info metric and serves the /version
+// endpoint on the mux
+func registerVersion(mux *http.ServeMux) {
+	info := getVersionInfo()
+	buildInfo := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "pod_identity_webhook_build_info",
+			Help: "A metric with a constant '1' value labeled by the version, Go version and git commit the webhook is built from",
+		},
+		[]string{"version", "goversion", "commit"},
+	)
+	buildInfo.WithLabelValues(info.Version, info.GoVersion, info.Commit).Set(1)
+	prometheus.MustRegister(buildInfo)
+
+	contents, err := json.MarshalIndent(info, "", " ")
+	if err != nil {
+		klog.Fatalf("Error encoding version: %v", err)
+	}
+	mux.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write(contents); err != nil {
+			klog.ErrorS(err, "Can't write response")
+		}
+	})
+}
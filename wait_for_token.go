@@ -0,0 +1,98 @@
+/*
+  Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+  or in the "license" file accompanying this file. This file is distributed
+  on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+  express or implied. See the License for the specific language governing
+  permissions and limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	flag "github.com/spf13/pflag"
+)
+
+// waitForTokenCommand is the name of the subcommand run by the init container
+// waiting until STS accepts the token of the pod
+const waitForTokenCommand = "wait-for-token"
+
+// waitForToken runs the wait-for-token subcommand with its arguments, and
+// returns the exit code. The role and token file are read from the env
+// variables injected by the webhook.
+func waitForToken(args []string) int {
+	flags := flag.NewFlagSet(waitForTokenCommand, flag.ContinueOnError)
+	timeout := flags.Duration("timeout", 2*time.Minute, "The time to wait for the token to exist and be accepted by STS")
+	interval := flags.Duration("interval", 2*time.Second, "The interval between attempts")
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+
+	roleARN, tokenFile := os.Getenv("AWS_ROLE_ARN"), os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	if roleARN == "" || tokenFile == "" {
+		fmt.Fprintln(os.Stderr, "AWS_ROLE_ARN and AWS_WEB_IDENTITY_TOKEN_FILE must be set")
+		return 2
+	}
+
+	// AssumeRoleWithWebIdentity is not signed, the token is the credential
+	sess, err := session.NewSession(aws.NewConfig().WithCredentials(credentials.AnonymousCredentials))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating AWS session: %v\n", err)
+		return 1
+	}
+	stsConfig := aws.NewConfig()
+	if aws.StringValue(sess.Config.Region) == "" {
+		// The global endpoint of the aws partition
+		stsConfig = stsConfig.WithRegion("us-east-1")
+	}
+	client := sts.New(sess, stsConfig)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	for {
+		err := assumeRoleWithToken(ctx, client, roleARN, tokenFile)
+		if err == nil {
+			fmt.Printf("Token %s accepted by STS for role %s\n", tokenFile, roleARN)
+			return 0
+		}
+		fmt.Fprintf(os.Stderr, "Waiting for token %s to be accepted by STS: %v\n", tokenFile, err)
+		select {
+		case <-ctx.Done():
+			fmt.Fprintf(os.Stderr, "Token %s was not accepted by STS for role %s within %s\n", tokenFile, roleARN, *timeout)
+			return 1
+		case <-time.After(*interval):
+		}
+	}
+}
+
+// assumeRoleWithToken reads the token file and assumes the role with it
+func assumeRoleWithToken(ctx context.Context, client *sts.STS, roleARN, tokenFile string) error {
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return err
+	}
+	_, err = client.AssumeRoleWithWebIdentityWithContext(ctx, &sts.AssumeRoleWithWebIdentityInput{
+		RoleArn:          aws.String(roleARN),
+		RoleSessionName:  aws.String(waitForTokenCommand),
+		WebIdentityToken: aws.String(string(token)),
+	})
+	return err
+}